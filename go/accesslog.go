@@ -0,0 +1,189 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// One access log entry, filled in as the request passes through auth and
+// the model
+type AccessLogEntry struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"requestId,omitempty"`
+	ClientIP     string    `json:"clientIp"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Route        string    `json:"route,omitempty"`
+	Status       int       `json:"status"`
+	Bytes        int64     `json:"bytes"`
+	LatencyMS    float64   `json:"latencyMs"`
+	APIKeyID     string    `json:"apiKeyId,omitempty"`
+	User         string    `json:"user,omitempty"`
+	Models       []string  `json:"models,omitempty"`
+	InputTokens  int       `json:"inputTokens,omitempty"`
+	OutputTokens int       `json:"outputTokens,omitempty"`
+	ModelRetries int       `json:"modelRetries,omitempty"`
+	UserAgent    string    `json:"userAgent,omitempty"`
+	Referer      string    `json:"referer,omitempty"`
+
+	proto string
+	mu    sync.Mutex
+}
+
+// Context key of the request's access log entry
+type accessLogContextKey struct{}
+
+// accessLogEntry returns the entry of the request the context belongs to,
+// or nil when the request isn't logged
+func accessLogEntry(ctx context.Context) *AccessLogEntry {
+	e, _ := ctx.Value(accessLogContextKey{}).(*AccessLogEntry)
+	return e
+}
+
+// setCredentials records who the request was authenticated as
+func (e *AccessLogEntry) setCredentials(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if key, ok := requestKey(ctx); ok {
+		e.APIKeyID = key.ID
+	}
+	if user, ok := requestUser(ctx); ok {
+		e.User = user
+	}
+}
+
+// addUsage records a model call made for the request
+func (e *AccessLogEntry) addUsage(model string, usage *ai.GenerationUsage) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !slices.Contains(e.Models, model) {
+		e.Models = append(e.Models, model)
+	}
+	if usage != nil {
+		e.InputTokens += usage.InputTokens
+		e.OutputTokens += usage.OutputTokens
+	}
+}
+
+// accessLogger writes entries in the configured format
+type accessLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// Access log writer, set from the config at startup; nil turns logging off
+var accessLog *accessLogger
+
+// newAccessLogger opens the access log: stderr when file is empty, stdout
+// for "-", otherwise the file, appended to
+func newAccessLogger(format, file string) (*accessLogger, error) {
+	if format == "off" {
+		return nil, nil
+	}
+	var w io.Writer = os.Stderr
+	switch file {
+	case "":
+	case "-":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &accessLogger{w: w, format: format}, nil
+}
+
+// write logs one entry
+func (l *accessLogger) write(e *AccessLogEntry) {
+	e.mu.Lock()
+	var line []byte
+	switch l.format {
+	case "json":
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	case "combined":
+		line = []byte(combinedLogLine(e))
+	default:
+		line = fmt.Appendf(nil, "%s %s %s %s %d %dB %s\n", e.Time.Format(time.RFC3339), e.ClientIP, e.Method, e.Path, e.Status, e.Bytes,
+			time.Duration(e.LatencyMS*float64(time.Millisecond)).Round(time.Millisecond))
+	}
+	e.mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+// combinedLogLine formats an entry in the Apache combined log format, with
+// the API key or user as the remote user
+func combinedLogLine(e *AccessLogEntry) string {
+	user := "-"
+	switch {
+	case e.User != "":
+		user = e.User
+	case e.APIKeyID != "":
+		user = "key:" + e.APIKeyID
+	}
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d \"%s\" \"%s\"\n",
+		e.ClientIP, user, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, orDash(e.Path), e.proto,
+		e.Status, e.Bytes, orDash(e.Referer), orDash(e.UserAgent))
+}
+
+// logRequests writes an access log entry for each request once it has been
+// answered. Auth and the model add the API key, models and tokens to the
+// entry through the request context
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		e := &AccessLogEntry{
+			Time:      start.UTC(),
+			RequestID: requestID(r.Context()),
+			ClientIP:  clientIP(r),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Route:     r.Pattern,
+			UserAgent: r.UserAgent(),
+			Referer:   r.Referer(),
+			proto:     r.Proto,
+		}
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, e)))
+
+		e.mu.Lock()
+		e.Status, e.Bytes = cmp.Or(sr.status, http.StatusOK), sr.bytes
+		e.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+		e.mu.Unlock()
+		accessLog.write(e)
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// An allergen in the taxonomy and the words that identify it
+type allergen struct {
+	name string
+
+	// Ingredient words and phrases that contain the allergen
+	keywords []string
+
+	// Phrases that look like a keyword but are free of the allergen
+	// (e.g. "coconut milk" for dairy); they are removed before matching.
+	// Ingredients labelled free of an alias (e.g. "gluten-free flour") are
+	// skipped entirely
+	exclusions []string
+
+	// Words used for the allergen in dietary restrictions (e.g. "no nuts")
+	aliases []string
+}
+
+// The maintained allergen taxonomy; keywords match whole words, with an
+// optional plural ending
+var allergenTaxonomy = []allergen{
+	{
+		name: "nuts",
+		keywords: []string{"nut", "almond", "walnut", "pecan", "cashew", "pistachio", "hazelnut", "macadamia",
+			"brazil nut", "pine nut", "chestnut", "peanut", "praline", "marzipan", "frangipane", "nutella", "gianduja", "satay"},
+		exclusions: []string{"nutmeg", "coconut", "water chestnut"},
+		aliases:    []string{"nut", "nuts", "tree nut", "tree nuts", "peanut", "peanuts"},
+	},
+	{
+		name: "shellfish",
+		keywords: []string{"shrimp", "prawn", "crab", "lobster", "crayfish", "crawfish", "langoustine", "scallop",
+			"clam", "mussel", "oyster", "squid", "calamari", "octopus", "krill", "cockle", "shellfish"},
+		exclusions: []string{"oyster mushroom", "oyster mushrooms", "crab apple", "crab apples", "imitation crab"},
+		aliases:    []string{"shellfish", "seafood", "crustacean", "crustaceans", "mollusc", "molluscs"},
+	},
+	{
+		name: "gluten",
+		keywords: []string{"wheat", "flour", "barley", "rye", "spelt", "semolina", "couscous", "bulgur", "farro",
+			"seitan", "bread", "breadcrumb", "panko", "pasta", "spaghetti", "linguine", "fettuccine", "penne",
+			"macaroni", "lasagna", "lasagne", "orzo", "noodle", "soy sauce", "malt", "beer", "tortilla", "cracker",
+			"biscuit", "croissant", "pastry", "dough", "pita", "naan", "bun", "baguette", "brioche", "crouton"},
+		exclusions: []string{"buckwheat", "rice flour", "almond flour", "coconut flour",
+			"chickpea flour", "corn flour", "tapioca flour", "potato flour", "cassava flour", "rice noodle",
+			"rice noodles", "glass noodles", "corn tortilla", "corn tortillas", "tamari"},
+		aliases: []string{"gluten", "wheat", "celiac", "coeliac"},
+	},
+	{
+		name: "dairy",
+		keywords: []string{"milk", "butter", "buttermilk", "cheese", "cream", "yogurt", "yoghurt", "ghee", "whey",
+			"casein", "parmesan", "mozzarella", "ricotta", "mascarpone", "cheddar", "feta", "paneer", "custard",
+			"kefir", "creme fraiche", "crème fraîche", "gruyere", "gruyère", "brie", "halloumi", "burrata"},
+		exclusions: []string{"coconut milk", "coconut cream", "almond milk", "oat milk", "soy milk",
+			"rice milk", "cashew milk", "peanut butter", "almond butter", "cashew butter", "nut butter",
+			"cocoa butter", "shea butter", "butter bean", "butter beans", "cream of tartar", "vegan butter", "vegan cheese",
+			"plant based butter", "plant based milk"},
+		aliases: []string{"dairy", "milk", "lactose"},
+	},
+	{
+		name:     "soy",
+		keywords: []string{"soy", "soya", "soybean", "tofu", "tempeh", "edamame", "miso", "tamari", "natto", "soy sauce", "soy milk", "soy lecithin"},
+		aliases:  []string{"soy", "soya"},
+	},
+	{
+		name:       "egg",
+		keywords:   []string{"egg", "egg white", "egg yolk", "yolk", "mayonnaise", "mayo", "meringue", "aioli", "eggnog"},
+		exclusions: []string{"vegan mayo", "vegan mayonnaise", "eggless"},
+		aliases:    []string{"egg", "eggs"},
+	},
+}
+
+// Diets that rule out whole allergen groups
+var dietAllergens = map[string][]string{
+	"vegan":       {"dairy", "egg", "shellfish"},
+	"vegetarian":  {"shellfish"},
+	"plant based": {"dairy", "egg", "shellfish"},
+	"celiac":      {"gluten"},
+	"coeliac":     {"gluten"},
+}
+
+// Allergens found in a single ingredient
+type IngredientAllergens struct {
+	Ingredient string   `json:"ingredient"`
+	Allergens  []string `json:"allergens"`
+}
+
+// normalizeFoodText lowercases text and turns punctuation into spaces,
+// padding it so phrases can be matched on word boundaries
+func normalizeFoodText(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	return " " + strings.Join(words, " ") + " "
+}
+
+// containsWord reports whether normalized text contains the phrase as whole
+// words, allowing a plural ending
+func containsWord(text, phrase string) bool {
+	for _, suffix := range []string{" ", "s ", "es "} {
+		if strings.Contains(text, " "+phrase+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// avoidedIngredients normalizes ingredients to leave out for matching with
+// containsWord, sorted and without duplicates or empty ones
+func avoidedIngredients(ingredients []string) []string {
+	var avoided []string
+	for _, ingredient := range ingredients {
+		if text := strings.TrimSpace(normalizeFoodText(ingredient)); text != "" {
+			avoided = append(avoided, text)
+		}
+	}
+	slices.Sort(avoided)
+	return slices.Compact(avoided)
+}
+
+// detectAllergens returns the allergens present in an ingredient, in
+// taxonomy order
+func detectAllergens(ingredient string) []string {
+	var found []string
+	for _, a := range allergenTaxonomy {
+		text := normalizeFoodText(ingredient)
+		if labelledFree(text, a) {
+			continue
+		}
+		for _, exclusion := range a.exclusions {
+			text = strings.ReplaceAll(text, " "+exclusion+" ", " ")
+		}
+		for _, keyword := range a.keywords {
+			if containsWord(text, keyword) {
+				found = append(found, a.name)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// labelledFree reports whether normalized text declares itself free of the
+// allergen, as in "dairy-free chocolate"
+func labelledFree(text string, a allergen) bool {
+	for _, alias := range a.aliases {
+		if strings.Contains(text, " "+alias+" free ") {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictedAllergens returns the allergens ruled out by free-text dietary
+// restrictions such as "nut-free, no eggs" or "vegan"
+func restrictedAllergens(restrictions string) map[string]bool {
+	text := normalizeFoodText(restrictions)
+	restricted := make(map[string]bool)
+
+	for _, a := range allergenTaxonomy {
+		for _, alias := range a.aliases {
+			for _, pattern := range []string{"%s free", "no %s", "without %s", "%s allergy", "allergic to %s", "%s intolerance", "%s intolerant", "avoid %s"} {
+				if strings.Contains(text, " "+fmt.Sprintf(pattern, alias)+" ") {
+					restricted[a.name] = true
+				}
+			}
+		}
+	}
+	for diet, allergens := range dietAllergens {
+		if strings.Contains(text, " "+diet+" ") {
+			for _, name := range allergens {
+				restricted[name] = true
+			}
+		}
+	}
+	return restricted
+}
+
+// applyAllergens flags each ingredient of the recipe, sets the recipe's
+// allergen list, and returns an error if the recipe contains an allergen
+// that the dietary restrictions rule out
+func applyAllergens(recipe *FoodRecipe, restrictions string) error {
+	present := make(map[string][]string)
+	recipe.IngredientAllergens = nil
+	for _, ingredient := range recipe.Ingredients {
+		allergens := detectAllergens(ingredient)
+		if len(allergens) == 0 {
+			continue
+		}
+		recipe.IngredientAllergens = append(recipe.IngredientAllergens, IngredientAllergens{
+			Ingredient: ingredient,
+			Allergens:  allergens,
+		})
+		for _, name := range allergens {
+			present[name] = append(present[name], ingredient)
+		}
+	}
+
+	recipe.Allergens = []string{}
+	for _, a := range allergenTaxonomy {
+		if _, ok := present[a.name]; ok {
+			recipe.Allergens = append(recipe.Allergens, a.name)
+		}
+	}
+
+	var violations []string
+	for name := range restrictedAllergens(restrictions) {
+		if ingredients, ok := present[name]; ok {
+			violations = append(violations, fmt.Sprintf("%s (%s)", name, strings.Join(ingredients, "; ")))
+		}
+	}
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return fmt.Errorf("recipe %s violates the dietary restrictions %q: contains %s",
+			recipe.Name, restrictions, strings.Join(violations, ", "))
+	}
+	return nil
+}
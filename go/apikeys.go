@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes an API key can be granted. admin covers the others and key management
+const (
+	scopeRecipe   = "recipe"
+	scopeMealPlan = "mealplan"
+	scopeAdmin    = "admin"
+)
+
+// Prefix of issued keys, so they are easy to spot in logs and secret scanners
+const apiKeyPrefix = "rk_"
+
+// Key with the admin scope set from the config at startup. Credentials are
+// only required on /api/* once it or a JWT issuer is set
+var adminAPIKey string
+
+// Define input schema for API key creation requests
+type APIKeyInput struct {
+	Name      string   `json:"name" jsonschema:"description=What the key is for,required=true"`
+	Scopes    []string `json:"scopes" jsonschema:"description=Scopes granted: recipe\\, mealplan and/or admin,required=true"`
+	ExpiresIn string   `json:"expiresIn,omitempty" jsonschema:"description=Lifetime of the key as a duration (e.g. 720h); keys don't expire by default"`
+}
+
+// An issued API key. The key itself is only returned when it is created
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Key       string     `json:"key,omitempty" jsonschema:"description=The secret key\\, only shown once"`
+	Prefix    string     `json:"prefix" jsonschema:"description=Start of the key\\, to tell keys apart"`
+	Created   time.Time  `json:"created"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	LastUsed  *time.Time `json:"lastUsed,omitempty"`
+}
+
+// apiKeyStore keeps issued keys by the SHA-256 of the secret, so a leaked
+// store doesn't leak working keys
+type apiKeyStore interface {
+	// add keeps a new key under the hash of its secret
+	add(ctx context.Context, hash string, key *APIKey) error
+	// lookup returns the key with a hash if it hasn't expired, and records
+	// that it was used
+	lookup(hash string) (APIKey, bool)
+	// list returns the keys, oldest first, without their secrets
+	list() []APIKey
+	// revoke deletes a key by id, reporting whether there was one
+	revoke(ctx context.Context, id string) (bool, error)
+}
+
+// API keys issued through /api/keys. Set at startup to keep them in the
+// recipe database when recipes are kept in SQL
+var apiKeys apiKeyStore = newMemoryAPIKeyStore()
+
+// newAPIKeyStore keeps API keys alongside the recipes: in their database for
+// the SQL stores, in memory otherwise
+func newAPIKeyStore(recipes recipeStore) apiKeyStore {
+	if s, ok := recipes.(*sqlRecipeStore); ok {
+		return &sqlAPIKeyStore{db: s.db}
+	}
+	return newMemoryAPIKeyStore()
+}
+
+// hashAPIKey returns the stored form of a key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueAPIKey creates a key, keeps it and returns it with its secret
+func issueAPIKey(ctx context.Context, name string, scopes []string, ttl time.Duration) (APIKey, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	key := APIKey{
+		ID:      newID(),
+		Name:    name,
+		Scopes:  scopes,
+		Prefix:  secret[:len(apiKeyPrefix)+6],
+		Created: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		expires := key.Created.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+	if err := apiKeys.add(ctx, hashAPIKey(secret), &key); err != nil {
+		return APIKey{}, err
+	}
+	key.Key = secret
+	return key, nil
+}
+
+// memoryAPIKeyStore keeps API keys in memory
+type memoryAPIKeyStore struct {
+	mu     sync.Mutex
+	byHash map[string]*APIKey
+}
+
+// newMemoryAPIKeyStore creates an empty in-memory API key store
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{byHash: make(map[string]*APIKey)}
+}
+
+func (s *memoryAPIKeyStore) add(ctx context.Context, hash string, key *APIKey) error {
+	stored := *key
+	s.mu.Lock()
+	s.byHash[hash] = &stored
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryAPIKeyStore) lookup(hash string) (APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.byHash[hash]
+	if !ok {
+		return APIKey{}, false
+	}
+	now := time.Now().UTC()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return APIKey{}, false
+	}
+	key.LastUsed = &now
+	return *key, true
+}
+
+func (s *memoryAPIKeyStore) list() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]APIKey, 0, len(s.byHash))
+	for _, key := range s.byHash {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys
+}
+
+func (s *memoryAPIKeyStore) revoke(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, key := range s.byHash {
+		if key.ID == id {
+			delete(s.byHash, hash)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sqlAPIKeyStore keeps API keys in the api_keys table of the recipe
+// database, with the scopes as JSON. Only the hashes of the secrets are
+// stored
+type sqlAPIKeyStore struct {
+	db *sql.DB
+}
+
+// Columns scanAPIKey reads
+const apiKeyColumns = `id, name, scopes, prefix, created_at, expires_at, last_used`
+
+func (s *sqlAPIKeyStore) add(ctx context.Context, hash string, key *APIKey) error {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	var expires sql.NullTime
+	if key.ExpiresAt != nil {
+		expires = sql.NullTime{Time: *key.ExpiresAt, Valid: true}
+	}
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO api_keys (id, hash, name, scopes, prefix, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		key.ID, hash, key.Name, string(scopes), key.Prefix, key.Created, expires)
+	if err != nil {
+		return fmt.Errorf("adding API key %s: %w", key.Name, err)
+	}
+	return nil
+}
+
+func (s *sqlAPIKeyStore) lookup(hash string) (APIKey, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx,
+		`UPDATE api_keys SET last_used = $1 WHERE hash = $2 AND (expires_at IS NULL OR expires_at > $1) RETURNING `+apiKeyColumns,
+		time.Now().UTC(), hash))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up an API key: %v", err)
+		}
+		return APIKey{}, false
+	}
+	return key, true
+}
+
+func (s *sqlAPIKeyStore) list() []APIKey {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	keys := []APIKey{}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at, id`)
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		return keys
+	}
+	defer rows.Close()
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			log.Printf("Failed to read an API key: %v", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+	}
+	return keys
+}
+
+func (s *sqlAPIKeyStore) revoke(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("revoking API key %s: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// scanAPIKey reads a row of apiKeyColumns
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	var expires, used sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &scopes, &key.Prefix, &key.Created, &expires, &used); err != nil {
+		return APIKey{}, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return APIKey{}, fmt.Errorf("reading the scopes of API key %s: %w", key.ID, err)
+	}
+	key.Created = key.Created.UTC()
+	if expires.Valid {
+		t := expires.Time.UTC()
+		key.ExpiresAt = &t
+	}
+	if used.Valid {
+		t := used.Time.UTC()
+		key.LastUsed = &t
+	}
+	return key, nil
+}
+
+// requestAPIKey reads the key from X-API-Key or an Authorization bearer token
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return key
+}
+
+// routeScope is the scope needed for an /api/* path
+func routeScope(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/keys"), strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/debug/"):
+		return scopeAdmin
+	case strings.HasPrefix(path, "/api/mealplan"),
+		strings.HasPrefix(path, "/api/shopping-list"),
+		strings.HasPrefix(path, "/api/dietplan"),
+		strings.HasPrefix(path, "/api/calendar"):
+		return scopeMealPlan
+	}
+	return scopeRecipe
+}
+
+// Context key of the API key a request was authenticated with
+type apiKeyContextKey struct{}
+
+// requestKey returns the API key a request was made with, if any
+func requestKey(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(APIKey)
+	return key, ok
+}
+
+// requestAccount names who a request is made by, for billing and
+// ownership: the API key, else the signed-in user, else "anonymous"
+func requestAccount(ctx context.Context) string {
+	if key, ok := requestKey(ctx); ok {
+		return key.ID
+	}
+	if user, ok := requestUser(ctx); ok {
+		return "user:" + user
+	}
+	return "anonymous"
+}
+
+// requireAccount returns the account of a request for a feature that keeps
+// data per account, answering 401 to anonymous callers. action says what
+// the account is needed for
+func requireAccount(w http.ResponseWriter, r *http.Request, action string) (string, bool) {
+	account := requestAccount(r.Context())
+	if account == "anonymous" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "Sign in or use an API key to "+action)
+		return "", false
+	}
+	return account, true
+}
+
+// Context key of the scopes a request's credentials grant
+type scopesContextKey struct{}
+
+// isAdmin reports whether a request's credentials have the admin scope
+func isAdmin(ctx context.Context) bool {
+	scopes, _ := ctx.Value(scopesContextKey{}).([]string)
+	return slices.Contains(scopes, scopeAdmin)
+}
+
+// canChange reports whether a request may change or delete an item saved
+// by owner: its own items, or any item for admins. Without authentication
+// every request is anonymous, so all may
+func canChange(ctx context.Context, owner string) bool {
+	return owner != "" && owner == requestAccount(ctx) || isAdmin(ctx)
+}
+
+// requireAuth checks the credentials and scopes on the routes it guards once
+// an admin key or JWT issuer is configured. Requests carry an API key, a bearer
+// JWT or a login session cookie; a missing or invalid one gets 401, and one
+// without the route's scope gets 403. Until then every request is let
+// through, with the signed-in user of a session cookie when login is on
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (adminAPIKey == "" && jwtVerifier == nil) || r.Method == http.MethodOptions {
+			// Without required credentials, signed-in users still get their
+			// own profile, favorites and history
+			if login != nil {
+				if session, ok := requestSession(r); ok {
+					ctx := context.WithValue(r.Context(), userContextKey{}, session.User)
+					accessLogEntry(ctx).setCredentials(ctx)
+					r = r.WithContext(ctx)
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := requestAPIKey(r)
+		ctx := r.Context()
+		var scopes []string
+		switch {
+		case secret == "":
+			// Pages of the hosted UI call the API with the session cookie
+			if session, ok := requestSession(r); ok {
+				scopes = []string{scopeRecipe, scopeMealPlan}
+				ctx = context.WithValue(ctx, userContextKey{}, session.User)
+			}
+		case jwtVerifier != nil && looksLikeJWT(secret):
+			user, granted, err := authenticateJWT(r, secret)
+			if err != nil {
+				if !allowRequest(w, r, "ip:"+clientIP(r)) {
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				writeError(w, http.StatusUnauthorized, "Unauthorized", "Invalid token: "+err.Error())
+				return
+			}
+			scopes = granted
+			ctx = context.WithValue(ctx, userContextKey{}, user)
+		case adminAPIKey != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(adminAPIKey)) == 1:
+			key := APIKey{ID: "admin", Name: "Configured admin key", Scopes: []string{scopeAdmin}}
+			scopes = key.Scopes
+			ctx = context.WithValue(ctx, apiKeyContextKey{}, key)
+		default:
+			if key, ok := apiKeys.lookup(hashAPIKey(secret)); ok {
+				scopes = key.Scopes
+				ctx = context.WithValue(ctx, apiKeyContextKey{}, key)
+			}
+		}
+		if scopes == nil {
+			// Failed attempts count against the client IP, so keys can't be
+			// guessed faster than the rate limit
+			if !allowRequest(w, r, "ip:"+clientIP(r)) {
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeError(w, http.StatusUnauthorized, "Unauthorized", "Provide a valid API key in the X-API-Key header or a bearer token")
+			return
+		}
+
+		scope := routeScope(r.URL.Path)
+		if !slices.Contains(scopes, scope) && !slices.Contains(scopes, scopeAdmin) {
+			writeError(w, http.StatusForbidden, "Forbidden", fmt.Sprintf("These credentials do not have the %s scope", scope))
+			return
+		}
+
+		ctx = context.WithValue(ctx, scopesContextKey{}, scopes)
+		accessLogEntry(ctx).setCredentials(ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// createAPIKeyHandler issues a key. The secret is in this response only
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input APIKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid API Key", "name is required")
+		return
+	}
+	if len(input.Scopes) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid API Key", "at least one scope is required")
+		return
+	}
+	for _, scope := range input.Scopes {
+		if scope != scopeRecipe && scope != scopeMealPlan && scope != scopeAdmin {
+			writeError(w, http.StatusUnprocessableEntity, "Invalid API Key", fmt.Sprintf("unknown scope %q", scope))
+			return
+		}
+	}
+	var ttl time.Duration
+	if input.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(input.ExpiresIn); err != nil || ttl <= 0 {
+			writeError(w, http.StatusUnprocessableEntity, "Invalid API Key", "expiresIn must be a positive duration such as 720h")
+			return
+		}
+	}
+
+	key, err := issueAPIKey(r.Context(), input.Name, slices.Compact(slices.Sorted(slices.Values(input.Scopes))), ttl)
+	if err != nil {
+		log.Printf("Error issuing API key: %v", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error", "Could not issue the API key")
+		return
+	}
+	w.Header().Set("Location", "/api/keys/"+key.ID)
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// listAPIKeysHandler lists the issued keys without their secrets
+func listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiKeys.list())
+}
+
+// revokeAPIKeyHandler revokes a key; it stops working immediately
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	revoked, err := apiKeys.revoke(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error", "Could not revoke the API key")
+		return
+	}
+	if !revoked {
+		writeError(w, http.StatusNotFound, "API Key Not Found", "No API key with this id")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"google.golang.org/genai"
+)
+
+const (
+	// Model and voice used to read instructions aloud
+	speechModel = "googleai/gemini-2.5-flash-preview-tts"
+	speechVoice = "Kore"
+
+	// Gemini returns raw 16-bit mono PCM at this rate unless stated otherwise
+	defaultSpeechSampleRate = 24000
+
+	// Most synthesized steps kept in memory
+	maxSpokenSteps = 500
+)
+
+// A spoken instruction step
+type AudioSegment struct {
+	Step int    `json:"step"`
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// Index of the spoken steps of a recipe
+type RecipeAudio struct {
+	RecipeID string         `json:"recipeId"`
+	Format   string         `json:"format"`
+	Segments []AudioSegment `json:"segments"`
+}
+
+// speechCache keeps synthesized steps so replaying a step doesn't call the
+// model again
+type speechCache struct {
+	mu       sync.Mutex
+	segments map[string][]byte
+}
+
+// Synthesized instruction steps keyed by recipe id and step
+var spokenSteps = &speechCache{segments: make(map[string][]byte)}
+
+// put stores a synthesized step, first dropping steps of expired recipes
+// when the cache is full
+func (c *speechCache) put(key string, wav []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.segments) >= maxSpokenSteps {
+		for k := range c.segments {
+			recipeID, _, _ := strings.Cut(k, "/")
+			if _, ok := savedRecipes.get(recipeID); !ok {
+				delete(c.segments, k)
+			}
+		}
+		if len(c.segments) >= maxSpokenSteps {
+			clear(c.segments)
+		}
+	}
+	c.segments[key] = wav
+}
+
+// recipeAudioHandler lists the spoken segments of a recipe's instructions
+func recipeAudioHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	recipe, ok := savedRecipes.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+
+	audio := RecipeAudio{RecipeID: id, Format: "audio/wav", Segments: []AudioSegment{}}
+	for i, step := range recipe.Instructions {
+		audio.Segments = append(audio.Segments, AudioSegment{
+			Step: i + 1,
+			Text: step,
+			URL:  fmt.Sprintf("/api/recipe/%s/audio/%d", id, i+1),
+		})
+	}
+	if notModified(w, r, contentETag(audio, "json")) {
+		return
+	}
+	writeJSON(w, http.StatusOK, audio)
+}
+
+// recipeStepAudioHandler serves one instruction step as WAV audio,
+// synthesizing it on first request
+func recipeStepAudioHandler(g *genkit.Genkit) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		recipe, ok := savedRecipes.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+			return
+		}
+
+		step, err := strconv.Atoi(r.PathValue("step"))
+		if err != nil || step < 1 || step > len(recipe.Instructions) {
+			writeError(w, http.StatusNotFound, "Step Not Found", fmt.Sprintf("The recipe has %d steps", len(recipe.Instructions)))
+			return
+		}
+
+		key := fmt.Sprintf("%s/%d", id, step)
+		spokenSteps.mu.Lock()
+		wav, ok := spokenSteps.segments[key]
+		spokenSteps.mu.Unlock()
+
+		if !ok {
+			text := fmt.Sprintf("Step %d. %s", step, recipe.Instructions[step-1])
+			wav, err = synthesizeSpeech(r.Context(), g, text)
+			if err != nil {
+				log.Printf("Error synthesizing step audio: %v", err)
+				writeError(w, http.StatusInternalServerError, "Audio Generation Failed", err.Error())
+				return
+			}
+			spokenSteps.put(key, wav)
+		}
+
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Header().Set("Content-Length", strconv.Itoa(len(wav)))
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.WriteHeader(http.StatusOK)
+		w.Write(wav)
+	}
+}
+
+// synthesizeSpeech reads text aloud with the speech model and returns it as
+// a WAV file
+func synthesizeSpeech(ctx context.Context, g *genkit.Genkit, text string) ([]byte, error) {
+	resp, err := genkit.Generate(ctx, g,
+		ai.WithModelName(speechModel),
+		ai.WithConfig(&genai.GenerateContentConfig{
+			ResponseModalities: []string{"AUDIO"},
+			SpeechConfig: &genai.SpeechConfig{
+				VoiceConfig: &genai.VoiceConfig{
+					PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{VoiceName: speechVoice},
+				},
+			},
+		}),
+		ai.WithPrompt("Read this cooking instruction clearly and calmly: %s", text),
+	)
+	if err != nil {
+		return nil, err
+	}
+	recordUsage(ctx, speechModel, resp.Usage)
+
+	for _, part := range resp.Message.Content {
+		if !part.IsMedia() {
+			continue
+		}
+		_, payload, ok := strings.Cut(part.Text, ",")
+		if !ok {
+			return nil, fmt.Errorf("unexpected audio encoding")
+		}
+		pcm, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, err
+		}
+		return pcmToWAV(pcm, sampleRate(part.ContentType)), nil
+	}
+	return nil, fmt.Errorf("the model returned no audio")
+}
+
+// sampleRate reads the rate parameter of an audio/L16 content type
+func sampleRate(contentType string) int {
+	for _, param := range strings.Split(contentType, ";") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(param), "rate="); ok {
+			if rate, err := strconv.Atoi(v); err == nil && rate > 0 {
+				return rate
+			}
+		}
+	}
+	return defaultSpeechSampleRate
+}
+
+// pcmToWAV wraps 16-bit little-endian mono PCM samples in a WAV header
+func pcmToWAV(pcm []byte, rate int) []byte {
+	const channels, bitsPerSample = 1, 16
+	blockAlign := channels * bitsPerSample / 8
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(rate))
+	binary.Write(&buf, binary.LittleEndian, uint32(rate*blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
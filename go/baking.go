@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+)
+
+// A proofing or resting stage of a bake
+type ProofingStage struct {
+	Stage       string `json:"stage" jsonschema:"description=e.g. bulk fermentation\\, final proof\\, resting"`
+	Duration    string `json:"duration"`
+	Temperature string `json:"temperature,omitempty"`
+}
+
+// Weight-based baking details appended to a recipe in baking mode
+type BakingDetails struct {
+	OvenTempC        float64         `json:"ovenTempC"`
+	OvenTempF        float64         `json:"ovenTempF"`
+	FlourG           float64         `json:"flourG,omitempty"`
+	LiquidG          float64         `json:"liquidG,omitempty"`
+	HydrationPercent float64         `json:"hydrationPercent,omitempty"`
+	Proofing         []ProofingStage `json:"proofing,omitempty"`
+	PanSize          string          `json:"panSize,omitempty"`
+}
+
+// normalize derives the Fahrenheit temperature and hydration from the
+// model's figures so the numbers are always consistent with each other
+func (b *BakingDetails) normalize() {
+	b.OvenTempC = math.Round(b.OvenTempC)
+	b.OvenTempF = math.Round(b.OvenTempC*9/5 + 32)
+
+	b.HydrationPercent = 0
+	if b.FlourG > 0 && b.LiquidG > 0 {
+		b.HydrationPercent = math.Round(b.LiquidG/b.FlourG*1000) / 10
+	}
+}
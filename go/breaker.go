@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Consecutive failed calls after which a model's circuit opens, and how
+// long it stays open before one call is let through to test it. Set from
+// the config at startup; a threshold of 0 turns the breaker off
+var (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
+// States of a model's circuit
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// Times each model's circuit opened
+var circuitOpenCount = expvar.NewMap("circuit_breaker_opens_total")
+
+func init() {
+	expvar.Publish("circuit_breaker_state", expvar.Func(func() any { return modelBreakers.states() }))
+}
+
+// circuitBreaker stops calls to a model that keeps failing, so requests
+// fail at once during an outage instead of each waiting out its retries
+// and timeout
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// A test call is running while half-open
+	probing bool
+}
+
+// allow reports whether a call may go to the model, and when it can't, how
+// long until it may be tried again
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if wait := breakerCooldown - time.Since(b.openedAt); wait > 0 {
+			return false, wait
+		}
+		b.state, b.probing = circuitHalfOpen, true
+		return true, 0
+	case circuitHalfOpen:
+		if b.probing {
+			return false, breakerCooldown
+		}
+		b.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// record counts the outcome of a call to the model, opening the circuit
+// after too many failures in a row or when the test call fails, and closing
+// it once the model answers
+func (b *circuitBreaker) record(model string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !countsAsOutage(err) {
+		if b.state != circuitClosed {
+			log.Printf("Circuit for model %s closed", model)
+		}
+		b.state, b.failures = circuitClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= breakerThreshold {
+		if b.state != circuitOpen {
+			log.Printf("Circuit for model %s opened after %d failures in a row; next try in %s: %v", model, b.failures, breakerCooldown, err)
+			circuitOpenCount.Add(model, 1)
+		}
+		b.state, b.openedAt = circuitOpen, time.Now()
+	}
+}
+
+// countsAsOutage reports whether a failed call says something about the
+// model's health: quota, server and network errors and calls that ran out
+// of time do, requests the model rejected or the client gave up on don't
+func countsAsOutage(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	reason := modelFailureReason(err)
+	return isTransient(reason) || reason == failureTimeout
+}
+
+// breakerSet holds a circuit breaker per model
+type breakerSet struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// Circuit breakers of the models the server has called
+var modelBreakers = &breakerSet{breakers: make(map[string]*circuitBreaker)}
+
+// get returns the breaker of a model, or nil when breakers are off
+func (s *breakerSet) get(model string) *circuitBreaker {
+	if breakerThreshold <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.breakers[model]
+	if !ok {
+		b = &circuitBreaker{state: circuitClosed}
+		s.breakers[model] = b
+	}
+	return b
+}
+
+// states returns the state of each model's circuit
+func (s *breakerSet) states() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make(map[string]string, len(s.breakers))
+	for model, b := range s.breakers {
+		b.mu.Lock()
+		states[model] = b.state
+		b.mu.Unlock()
+	}
+	return states
+}
+
+// circuitOpenError is a call not made because the model's circuit is open
+type circuitOpenError struct {
+	model      string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("model %s is unavailable after repeated failures; try again in %ds", e.model, e.seconds())
+}
+
+// seconds is the wait rounded up to whole seconds
+func (e *circuitOpenError) seconds() int {
+	return int((e.retryAfter + time.Second - 1) / time.Second)
+}
+
+// setRetryAfter tells the client when to try again if err is, or was
+// caused by, an open circuit
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", strconv.Itoa(open.seconds()))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/text/currency"
+)
+
+// recipeBudget is a validated spending limit for a recipe
+type recipeBudget struct {
+	Max      float64
+	Currency string
+}
+
+// newRecipeBudget validates the budget fields of a FoodInput; it returns nil
+// when no budget was requested
+func newRecipeBudget(input *FoodInput) (*recipeBudget, error) {
+	if input.MaxBudget == 0 {
+		return nil, nil
+	}
+	if input.MaxBudget < 0 {
+		return nil, newInputError("maxBudget must be a positive amount")
+	}
+
+	code := strings.TrimSpace(input.Currency)
+	if code == "" {
+		code = "USD"
+	}
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return nil, newInputError("invalid currency %q: expected an ISO 4217 code such as USD or EUR", input.Currency)
+	}
+
+	return &recipeBudget{Max: input.MaxBudget, Currency: unit.String()}, nil
+}
+
+// promptInput formats the budget for the recipe prompt
+func (b *recipeBudget) promptInput() map[string]any {
+	return map[string]any{"max": fmt.Sprintf("%.2f", b.Max), "currency": b.Currency}
+}
+
+// apply fills in the derived cost fields and rejects recipes that cannot be
+// made within the budget
+func (b *recipeBudget) apply(recipe *FoodRecipe) error {
+	if recipe.EstimatedCost <= 0 {
+		return fmt.Errorf("no cost estimate was generated for %s", recipe.Name)
+	}
+	if recipe.EstimatedCost > b.Max {
+		return newInputError("a budget of %.2f %s is not feasible for %s: the estimated cost is %.2f %s",
+			b.Max, b.Currency, recipe.Name, recipe.EstimatedCost, b.Currency)
+	}
+
+	recipe.Currency = b.Currency
+	recipe.EstimatedCost = roundCents(recipe.EstimatedCost)
+	recipe.CostPerServing = roundCents(recipe.EstimatedCost / float64(recipe.Servings))
+	return nil
+}
+
+// roundCents rounds an amount to two decimal places
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
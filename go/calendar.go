@@ -0,0 +1,642 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const (
+	// Most days one calendar request covers, and the default
+	maxCalendarDays     = 92
+	defaultCalendarDays = 7
+
+	// Days either side of a regenerated meal whose dishes it differs from
+	calendarVarietyDays = 3
+)
+
+// A meal slot in a calendar: a date and breakfast, lunch or dinner
+type CalendarSlot struct {
+	Date string `json:"date" jsonschema:"description=Date as YYYY-MM-DD,required=true"`
+	Meal string `json:"meal" jsonschema:"enum=breakfast,enum=lunch,enum=dinner,required=true"`
+}
+
+// validate checks the date and meal, under the field name given
+func (s *CalendarSlot) validate(field string) []FieldError {
+	var errs []FieldError
+	if _, err := time.Parse(time.DateOnly, s.Date); err != nil {
+		errs = append(errs, FieldError{field + ".date", "must be a date as YYYY-MM-DD"})
+	}
+	if mealIndex(s.Meal) < 0 {
+		errs = append(errs, FieldError{field + ".meal", "must be breakfast, lunch or dinner"})
+	}
+	return errs
+}
+
+// mealIndex is the position of a meal in the day, or -1 for an unknown one
+func mealIndex(meal string) int {
+	for i, m := range icalMeals {
+		if strings.EqualFold(m.name, meal) {
+			return i
+		}
+	}
+	return -1
+}
+
+// A meal planned on a date, with the recipe to cook
+type CalendarMeal struct {
+	CalendarSlot
+	Title     string         `json:"title"`
+	Recipe    MealPlanRecipe `json:"recipe"`
+	PlanID    string         `json:"planId,omitempty"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// compareCalendarMeals orders meals by date, then breakfast to dinner
+func compareCalendarMeals(a, b CalendarMeal) int {
+	return cmp.Or(strings.Compare(a.Date, b.Date), cmp.Compare(mealIndex(a.Meal), mealIndex(b.Meal)))
+}
+
+// The meals planned on one date
+type CalendarDay struct {
+	Date  string         `json:"date"`
+	Meals []CalendarMeal `json:"meals"`
+}
+
+// An account's calendar from one date to another, with every date in
+// between
+type Calendar struct {
+	From string        `json:"from"`
+	To   string        `json:"to"`
+	Days []CalendarDay `json:"days"`
+}
+
+// Meals in the slots a request changed
+type CalendarMeals struct {
+	Meals []CalendarMeal `json:"meals"`
+}
+
+// Body of POST /api/calendar
+type CalendarPlacement struct {
+	MealPlanID string `json:"mealPlanId" jsonschema:"description=Id of a generated meal plan,required=true"`
+	StartDate  string `json:"startDate,omitempty" jsonschema:"description=Date to put the plan's first day on as YYYY-MM-DD (default the plan's start date)"`
+}
+
+// validate checks the plan id and start date
+func (in *CalendarPlacement) validate() []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace(in.MealPlanID) == "" {
+		errs = append(errs, FieldError{"mealPlanId", "is required"})
+	}
+	if in.StartDate != "" {
+		if _, err := time.Parse(time.DateOnly, in.StartDate); err != nil {
+			errs = append(errs, FieldError{"startDate", "must be a date as YYYY-MM-DD"})
+		}
+	}
+	return errs
+}
+
+// Body of POST /api/calendar/move
+type CalendarMove struct {
+	From CalendarSlot `json:"from" jsonschema:"required=true"`
+	To   CalendarSlot `json:"to" jsonschema:"required=true"`
+}
+
+// validate checks both slots, which must differ
+func (in *CalendarMove) validate() []FieldError {
+	errs := append(in.From.validate("from"), in.To.validate("to")...)
+	if len(errs) == 0 && in.From.Date == in.To.Date && strings.EqualFold(in.From.Meal, in.To.Meal) {
+		errs = append(errs, FieldError{"to", "must be another slot than from"})
+	}
+	return errs
+}
+
+// Input of the meal slot flow, and the optional body of POST
+// /api/calendar/{date}/{meal}/regenerate
+type MealSlotInput struct {
+	Meal                string   `json:"meal,omitempty" jsonschema:"enum=breakfast,enum=lunch,enum=dinner"`
+	People              int      `json:"people,omitempty" jsonschema:"description=Number of people to feed (default the servings of the meal being replaced\\, or 2)"`
+	DietaryRestrictions string   `json:"dietaryRestrictions,omitempty" jsonschema:"description=Any dietary restrictions (vegetarian\\, vegan\\, gluten-free\\, etc.)"`
+	Avoid               []string `json:"avoid,omitempty" jsonschema:"description=Dishes the new meal should differ from"`
+}
+
+// Define the meal slot flow, which plans one meal to fit in a calendar
+func defineMealSlotFlow(g *genkit.Genkit) *core.Flow[*MealSlotInput, *MealPlanRecipe, struct{}] {
+	return genkit.DefineFlow(g, "mealSlotFlow", func(ctx context.Context, input *MealSlotInput) (*MealPlanRecipe, error) {
+		if mealIndex(input.Meal) < 0 {
+			return nil, newInputError("meal must be breakfast, lunch or dinner")
+		}
+
+		people := input.People
+		if people == 0 {
+			people = 2
+		}
+		if people < 0 {
+			return nil, newInputError("people must be a positive number")
+		}
+
+		dietaryRestrictions := input.DietaryRestrictions
+		if dietaryRestrictions == "" {
+			dietaryRestrictions = "none"
+		}
+
+		avoid := "none"
+		if len(input.Avoid) > 0 {
+			avoid = strings.Join(input.Avoid, "; ")
+		}
+
+		prompt := fmt.Sprintf(`Create one %s recipe for a weekly meal plan.
+
+		People: %d
+		Dietary restrictions: %s
+		Already planned around it: %s
+
+		Please provide a short id, the name, a one-sentence description, the total time, ingredients with quantities for %d people, and step-by-step instructions.
+
+		Make it clearly different from the dishes already planned and practical for home cooking.`,
+			strings.ToLower(input.Meal), people, dietaryRestrictions, avoid, people)
+
+		recipe, _, err := genkit.GenerateData[MealPlanRecipe](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate meal: %w", err)
+		}
+		if recipe.Name == "" || len(recipe.Ingredients) == 0 {
+			return nil, fmt.Errorf("generated meal is incomplete")
+		}
+		recipe.ID = newID()
+		return recipe, nil
+	})
+}
+
+// calendarStore keeps the meals each account planned on dates
+type calendarStore interface {
+	// meals returns an account's meals from one date to another, both
+	// included, by date and then breakfast to dinner
+	meals(account, from, to string) []CalendarMeal
+	// put plans meals, replacing the ones in their slots
+	put(ctx context.Context, account string, meals ...CalendarMeal) error
+	// move moves the meal in one slot to another, swapping it with the meal
+	// there if there is one. It reports false when the first slot is empty
+	move(ctx context.Context, account string, from, to CalendarSlot) (bool, error)
+	// clear empties a slot, reporting whether it held a meal
+	clear(ctx context.Context, account string, slot CalendarSlot) (bool, error)
+}
+
+// Calendars of every account. Set at startup to keep them in the recipe
+// database when recipes are kept in SQL
+var calendars calendarStore = newMemoryCalendarStore()
+
+// newCalendarStore keeps calendars alongside the recipes: in their
+// database for the SQL stores, in memory otherwise
+func newCalendarStore(recipes recipeStore) calendarStore {
+	if s, ok := recipes.(*sqlRecipeStore); ok {
+		return &sqlCalendarStore{db: s.db}
+	}
+	return newMemoryCalendarStore()
+}
+
+// memoryCalendarStore keeps calendars in memory
+type memoryCalendarStore struct {
+	mu sync.Mutex
+	// Meals by account and slot
+	slots map[string]map[CalendarSlot]CalendarMeal
+}
+
+// newMemoryCalendarStore creates an empty in-memory calendar store
+func newMemoryCalendarStore() *memoryCalendarStore {
+	return &memoryCalendarStore{slots: make(map[string]map[CalendarSlot]CalendarMeal)}
+}
+
+func (s *memoryCalendarStore) meals(account, from, to string) []CalendarMeal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meals := []CalendarMeal{}
+	for slot, meal := range s.slots[account] {
+		if slot.Date >= from && slot.Date <= to {
+			meals = append(meals, meal)
+		}
+	}
+	slices.SortFunc(meals, compareCalendarMeals)
+	return meals
+}
+
+func (s *memoryCalendarStore) put(ctx context.Context, account string, meals ...CalendarMeal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots[account] == nil {
+		s.slots[account] = make(map[CalendarSlot]CalendarMeal)
+	}
+	for _, meal := range meals {
+		s.slots[account][meal.CalendarSlot] = meal
+	}
+	return nil
+}
+
+func (s *memoryCalendarStore) move(ctx context.Context, account string, from, to CalendarSlot) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slots := s.slots[account]
+	moved, ok := slots[from]
+	if !ok {
+		return false, nil
+	}
+	now := time.Now()
+	if swapped, ok := slots[to]; ok {
+		swapped.CalendarSlot, swapped.UpdatedAt = from, now
+		slots[from] = swapped
+	} else {
+		delete(slots, from)
+	}
+	moved.CalendarSlot, moved.UpdatedAt = to, now
+	slots[to] = moved
+	return true, nil
+}
+
+func (s *memoryCalendarStore) clear(ctx context.Context, account string, slot CalendarSlot) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.slots[account][slot]
+	delete(s.slots[account], slot)
+	return ok, nil
+}
+
+// sqlCalendarStore keeps calendars in the calendar_meals table of the
+// recipe database, one row per slot with the recipe as JSON. Dates are
+// kept as YYYY-MM-DD text, which sorts by date
+type sqlCalendarStore struct {
+	db *sql.DB
+}
+
+// Columns scanCalendarMeal reads
+const calendarMealColumns = `day, meal, title, recipe, plan_id, updated_at`
+
+func (s *sqlCalendarStore) meals(account, from, to string) []CalendarMeal {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	meals := []CalendarMeal{}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+calendarMealColumns+` FROM calendar_meals WHERE account = $1 AND day >= $2 AND day <= $3`, account, from, to)
+	if err != nil {
+		log.Printf("Failed to read the calendar of %s: %v", account, err)
+		return meals
+	}
+	defer rows.Close()
+	for rows.Next() {
+		meal, err := scanCalendarMeal(rows)
+		if err != nil {
+			log.Printf("Failed to read a calendar meal: %v", err)
+			continue
+		}
+		meals = append(meals, meal)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to read the calendar of %s: %v", account, err)
+	}
+	slices.SortFunc(meals, compareCalendarMeals)
+	return meals
+}
+
+func (s *sqlCalendarStore) put(ctx context.Context, account string, meals ...CalendarMeal) error {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, meal := range meals {
+		if err := putCalendarMeal(ctx, tx, account, meal); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlCalendarStore) move(ctx context.Context, account string, from, to CalendarSlot) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	slot := func(at CalendarSlot) (CalendarMeal, error) {
+		return scanCalendarMeal(tx.QueryRowContext(ctx,
+			`SELECT `+calendarMealColumns+` FROM calendar_meals WHERE account = $1 AND day = $2 AND meal = $3`, account, at.Date, at.Meal))
+	}
+	moved, err := slot(from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading calendar slot %s %s: %w", from.Date, from.Meal, err)
+	}
+	swapped, err := slot(to)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("reading calendar slot %s %s: %w", to.Date, to.Meal, err)
+	}
+	found := err == nil
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM calendar_meals WHERE account = $1 AND day = $2 AND meal = $3`, account, from.Date, from.Meal); err != nil {
+		return false, fmt.Errorf("moving calendar slot %s %s: %w", from.Date, from.Meal, err)
+	}
+	now := time.Now()
+	moved.CalendarSlot, moved.UpdatedAt = to, now
+	if err := putCalendarMeal(ctx, tx, account, moved); err != nil {
+		return false, err
+	}
+	if found {
+		swapped.CalendarSlot, swapped.UpdatedAt = from, now
+		if err := putCalendarMeal(ctx, tx, account, swapped); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+func (s *sqlCalendarStore) clear(ctx context.Context, account string, slot CalendarSlot) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM calendar_meals WHERE account = $1 AND day = $2 AND meal = $3`, account, slot.Date, slot.Meal)
+	if err != nil {
+		return false, fmt.Errorf("clearing calendar slot %s %s: %w", slot.Date, slot.Meal, err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// putCalendarMeal writes a meal into its slot, replacing the one there
+func putCalendarMeal(ctx context.Context, tx *sql.Tx, account string, meal CalendarMeal) error {
+	recipe, err := json.Marshal(meal.Recipe)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO calendar_meals (account, day, meal, title, recipe, plan_id, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (account, day, meal) DO UPDATE SET
+			title = excluded.title, recipe = excluded.recipe, plan_id = excluded.plan_id, updated_at = excluded.updated_at`,
+		account, meal.Date, meal.Meal, meal.Title, string(recipe), meal.PlanID, meal.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("saving calendar slot %s %s: %w", meal.Date, meal.Meal, err)
+	}
+	return nil
+}
+
+// scanCalendarMeal reads a row of calendarMealColumns
+func scanCalendarMeal(row interface{ Scan(...any) error }) (CalendarMeal, error) {
+	var meal CalendarMeal
+	var recipe []byte
+	if err := row.Scan(&meal.Date, &meal.Meal, &meal.Title, &recipe, &meal.PlanID, &meal.UpdatedAt); err != nil {
+		return meal, err
+	}
+	return meal, json.Unmarshal(recipe, &meal.Recipe)
+}
+
+// calendarRange returns the account's calendar with every date from one to
+// another
+func calendarRange(account string, from, to time.Time) Calendar {
+	calendar := Calendar{From: from.Format(time.DateOnly), To: to.Format(time.DateOnly), Days: []CalendarDay{}}
+	meals := calendars.meals(account, calendar.From, calendar.To)
+	for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+		day := CalendarDay{Date: date.Format(time.DateOnly), Meals: []CalendarMeal{}}
+		for len(meals) > 0 && meals[0].Date == day.Date {
+			day.Meals, meals = append(day.Meals, meals[0]), meals[1:]
+		}
+		calendar.Days = append(calendar.Days, day)
+	}
+	return calendar
+}
+
+// calendarHandler returns the caller's calendar for ?from= to ?to=, both
+// included, a week from today by default
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a calendar")
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if v := query.Get("from"); v != "" {
+		date, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Date", "from must be a date as YYYY-MM-DD")
+			return
+		}
+		from = date
+	}
+	to := from.AddDate(0, 0, defaultCalendarDays-1)
+	if v := query.Get("to"); v != "" {
+		date, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Date", "to must be a date as YYYY-MM-DD")
+			return
+		}
+		to = date
+	}
+	if to.Before(from) || to.After(from.AddDate(0, 0, maxCalendarDays-1)) {
+		writeError(w, http.StatusBadRequest, "Invalid Range", fmt.Sprintf("to must be on or after from, at most %d days later", maxCalendarDays-1))
+		return
+	}
+	writeJSON(w, http.StatusOK, calendarRange(account, from, to))
+}
+
+// placeMealPlanHandler puts the meals of a generated meal plan into the
+// caller's calendar, replacing the meals in the slots it fills, and
+// answers with the dates it covers
+func placeMealPlanHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a calendar")
+	if !ok {
+		return
+	}
+	var input CalendarPlacement
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	plan, ok := recentMealPlans.get(input.MealPlanID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Meal Plan Not Found", "The meal plan does not exist or has expired")
+		return
+	}
+	start, err := time.Parse(time.DateOnly, cmp.Or(input.StartDate, plan.StartDate))
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Start Date Required", "The meal plan has no start date; give a startDate")
+		return
+	}
+
+	recipes := make(map[string]MealPlanRecipe, len(plan.Recipes))
+	for _, recipe := range plan.Recipes {
+		recipes[recipe.ID] = recipe
+	}
+	var meals []CalendarMeal
+	now := time.Now()
+	end := start
+	for i := range plan.Days {
+		day := &plan.Days[i]
+		date := start.AddDate(0, 0, day.Day-1)
+		if date.After(end) {
+			end = date
+		}
+		for _, meal := range icalMeals {
+			entry := meal.entry(day)
+			if entry.Title == "" {
+				continue
+			}
+			meals = append(meals, CalendarMeal{
+				CalendarSlot: CalendarSlot{Date: date.Format(time.DateOnly), Meal: strings.ToLower(meal.name)},
+				Title:        entry.Title,
+				Recipe:       recipes[entry.RecipeID],
+				PlanID:       plan.ID,
+				UpdatedAt:    now,
+			})
+		}
+	}
+	if err := calendars.put(r.Context(), account, meals...); err != nil {
+		log.Printf("Failed to place meal plan %s for %s: %v", plan.ID, account, err)
+		writeError(w, http.StatusInternalServerError, "Save Failed", "The meal plan could not be added to your calendar; try again later")
+		return
+	}
+	writeJSON(w, http.StatusOK, calendarRange(account, start, end))
+}
+
+// moveMealHandler moves a meal of the caller's to another slot, swapping
+// it with the meal there
+func moveMealHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a calendar")
+	if !ok {
+		return
+	}
+	var input CalendarMove
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	input.From.Meal, input.To.Meal = strings.ToLower(input.From.Meal), strings.ToLower(input.To.Meal)
+
+	moved, err := calendars.move(r.Context(), account, input.From, input.To)
+	if err != nil {
+		log.Printf("Failed to move a meal of %s: %v", account, err)
+		writeError(w, http.StatusInternalServerError, "Move Failed", "The meal could not be moved; try again later")
+		return
+	}
+	if !moved {
+		writeError(w, http.StatusNotFound, "Meal Not Found", "Your calendar has no meal in the slot to move")
+		return
+	}
+	writeJSON(w, http.StatusOK, CalendarMeals{Meals: slotMeals(account, input.From, input.To)})
+}
+
+// slotMeals returns the account's meals in the given slots
+func slotMeals(account string, slots ...CalendarSlot) []CalendarMeal {
+	meals := []CalendarMeal{}
+	for _, slot := range slots {
+		for _, meal := range calendars.meals(account, slot.Date, slot.Date) {
+			if meal.CalendarSlot == slot {
+				meals = append(meals, meal)
+			}
+		}
+	}
+	return meals
+}
+
+// pathSlot reads the slot in the path, answering 404 for one that can't
+// exist
+func pathSlot(w http.ResponseWriter, r *http.Request) (CalendarSlot, bool) {
+	slot := CalendarSlot{Date: r.PathValue("date"), Meal: strings.ToLower(r.PathValue("meal"))}
+	if len(slot.validate("slot")) > 0 {
+		writeError(w, http.StatusNotFound, "Slot Not Found", "Calendar slots are a date as YYYY-MM-DD and breakfast, lunch or dinner")
+		return CalendarSlot{}, false
+	}
+	return slot, true
+}
+
+// regenerateMealHandler replaces the meal in one slot of the caller's
+// calendar with a new one, different from the dishes planned in the days
+// around it
+func regenerateMealHandler(flow *core.Flow[*MealSlotInput, *MealPlanRecipe, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := requireAccount(w, r, "keep a calendar")
+		if !ok {
+			return
+		}
+		slot, ok := pathSlot(w, r)
+		if !ok {
+			return
+		}
+		var input MealSlotInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+
+		date, _ := time.Parse(time.DateOnly, slot.Date)
+		around := calendars.meals(account,
+			date.AddDate(0, 0, -calendarVarietyDays).Format(time.DateOnly),
+			date.AddDate(0, 0, calendarVarietyDays).Format(time.DateOnly))
+		input.Meal, input.Avoid = slot.Meal, nil
+		for _, meal := range around {
+			if meal.CalendarSlot == slot && input.People == 0 {
+				input.People = meal.Recipe.Servings
+			}
+			if !slices.Contains(input.Avoid, meal.Title) {
+				input.Avoid = append(input.Avoid, meal.Title)
+			}
+		}
+
+		recipe, err := flow.Run(r.Context(), &input)
+		if err != nil {
+			log.Printf("Error regenerating %s %s: %v", slot.Date, slot.Meal, err)
+			writeFlowError(w, err, "Meal Generation Failed")
+			return
+		}
+		meal := CalendarMeal{CalendarSlot: slot, Title: recipe.Name, Recipe: *recipe, UpdatedAt: time.Now()}
+		if err := calendars.put(r.Context(), account, meal); err != nil {
+			log.Printf("Failed to save %s %s of %s: %v", slot.Date, slot.Meal, account, err)
+			writeError(w, http.StatusInternalServerError, "Save Failed", "The meal could not be saved; try again later")
+			return
+		}
+		writeJSON(w, http.StatusOK, meal)
+	}
+}
+
+// clearMealHandler empties a slot of the caller's calendar
+func clearMealHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a calendar")
+	if !ok {
+		return
+	}
+	slot, ok := pathSlot(w, r)
+	if !ok {
+		return
+	}
+	cleared, err := calendars.clear(r.Context(), account, slot)
+	if err != nil {
+		log.Printf("Failed to clear %s %s of %s: %v", slot.Date, slot.Meal, account, err)
+		writeError(w, http.StatusInternalServerError, "Delete Failed", "The meal could not be removed; try again later")
+		return
+	}
+	if !cleared {
+		writeError(w, http.StatusNotFound, "Meal Not Found", "Your calendar has no meal in this slot")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Networks of the reverse proxies whose X-Forwarded-For and X-Real-IP
+// headers are believed, and whether peers on a Unix socket count as one.
+// Set from the config at startup
+var (
+	trustedProxies []netip.Prefix
+	trustUnixPeers bool
+)
+
+// parseTrustedProxies reads CIDRs, bare IPs and "unix" from the config,
+// which has already checked them
+func parseTrustedProxies(entries []string) (prefixes []netip.Prefix, unix bool) {
+	for _, entry := range entries {
+		if entry == "unix" {
+			unix = true
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return prefixes, unix
+}
+
+// trustedProxy reports whether a forwarding hop is a trusted proxy
+func trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP finds the address of the client behind any trusted
+// proxies. X-Forwarded-For is read from the right, skipping trusted hops,
+// since only the entries added by trusted proxies can be believed;
+// X-Real-IP is used when there is no X-Forwarded-For. Headers from an
+// untrusted peer are ignored
+func resolveClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	switch {
+	case err != nil && !trustUnixPeers:
+		return host
+	case err == nil && !trustedProxy(peer):
+		return peer.Unmap().String()
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return ip.Unmap().String()
+		}
+		return host
+	}
+
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = ip.Unmap().String()
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// Context key of the resolved client IP
+type clientIPContextKey struct{}
+
+// clientIP returns the address of the client that sent the request
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey{}).(string); ok {
+		return ip
+	}
+	return resolveClientIP(r)
+}
+
+// withClientIP resolves the client IP once, so rate limits and logs agree
+// on who sent the request
+func withClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPContextKey{}, resolveClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Bounds on collections
+const (
+	maxCollectionName        = 100
+	maxCollectionDescription = 500
+	maxCollectionRecipes     = 200
+	maxCollections           = 100
+)
+
+// Body of POST /api/collections and PUT /api/collections/{id}
+type CollectionInput struct {
+	Name          string   `json:"name" jsonschema:"description=Name of the cookbook (e.g. Weeknight Dinners),required=true"`
+	Description   string   `json:"description,omitempty"`
+	RecipeIDs     []string `json:"recipeIds,omitempty" jsonschema:"description=Ids of stored recipes in the order the cookbook lists them"`
+	CoverRecipeID string   `json:"coverRecipeId,omitempty" jsonschema:"description=Recipe on the cover (default the first one)"`
+}
+
+// validate checks the name, description and recipe ids
+func (in *CollectionInput) validate() []FieldError {
+	var errs []FieldError
+	switch name := strings.TrimSpace(in.Name); {
+	case name == "":
+		errs = append(errs, FieldError{"name", "is required"})
+	case utf8.RuneCountInString(name) > maxCollectionName:
+		errs = append(errs, FieldError{"name", fmt.Sprintf("must be at most %d characters", maxCollectionName)})
+	}
+	if utf8.RuneCountInString(in.Description) > maxCollectionDescription {
+		errs = append(errs, FieldError{"description", fmt.Sprintf("must be at most %d characters", maxCollectionDescription)})
+	}
+	if len(in.RecipeIDs) > maxCollectionRecipes {
+		errs = append(errs, FieldError{"recipeIds", fmt.Sprintf("must have at most %d recipes", maxCollectionRecipes)})
+	}
+	for i, id := range in.RecipeIDs {
+		if slices.Contains(in.RecipeIDs[:i], id) {
+			errs = append(errs, FieldError{fmt.Sprintf("recipeIds[%d]", i), "is already in the collection"})
+		}
+	}
+	if in.CoverRecipeID != "" && !slices.Contains(in.RecipeIDs, in.CoverRecipeID) {
+		errs = append(errs, FieldError{"coverRecipeId", "must be one of recipeIds"})
+	}
+	return errs
+}
+
+// Body of POST /api/collections/{id}/recipes
+type CollectionRecipe struct {
+	RecipeID string `json:"recipeId" jsonschema:"required=true"`
+	Position int    `json:"position,omitempty" jsonschema:"description=Place in the collection counted from 1 (default last)"`
+}
+
+// validate checks the recipe id and position
+func (in *CollectionRecipe) validate() []FieldError {
+	var errs []FieldError
+	if strings.TrimSpace(in.RecipeID) == "" {
+		errs = append(errs, FieldError{"recipeId", "is required"})
+	}
+	if in.Position < 0 {
+		errs = append(errs, FieldError{"position", "must be 1 or more"})
+	}
+	return errs
+}
+
+// A cookbook an account put together from stored recipes. Recipes lists
+// the ones that haven't been deleted or expired, in the collection's order
+type Collection struct {
+	ID            string          `json:"id"`
+	Name          string          `json:"name"`
+	Description   string          `json:"description,omitempty"`
+	RecipeIDs     []string        `json:"recipeIds"`
+	CoverRecipeID string          `json:"coverRecipeId,omitempty"`
+	Recipes       []RecipeSummary `json:"recipes"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+}
+
+// An account's collections, most recently updated first
+type Collections struct {
+	Collections []Collection `json:"collections"`
+}
+
+// cover returns the id of the cover recipe: the chosen one, else the first
+func (c *Collection) cover() string {
+	if c.CoverRecipeID != "" || len(c.RecipeIDs) == 0 {
+		return c.CoverRecipeID
+	}
+	return c.RecipeIDs[0]
+}
+
+// withRecipes returns the collection with the summaries of its recipes that
+// are still stored
+func (c Collection) withRecipes() Collection {
+	c.Recipes = []RecipeSummary{}
+	for _, id := range c.RecipeIDs {
+		if stored, ok := savedRecipes.entry(id); ok {
+			c.Recipes = append(c.Recipes, summarizeRecipe(stored))
+		}
+	}
+	c.CoverRecipeID = c.cover()
+	return c
+}
+
+// collectionStore keeps each account's collections
+type collectionStore interface {
+	// list returns an account's collections, most recently updated first
+	list(account string) []Collection
+	// get returns one of an account's collections
+	get(account, id string) (*Collection, bool)
+	// put adds a collection to an account's collections, or replaces the
+	// one with its id. It reports false when there is none to replace
+	put(ctx context.Context, account string, c *Collection) (bool, error)
+	// delete removes one of an account's collections, reporting whether
+	// there was one
+	delete(ctx context.Context, account, id string) (bool, error)
+}
+
+// Collections of every account. Set at startup to keep them in the recipe
+// database when recipes are kept in SQL
+var collections collectionStore = newMemoryCollectionStore()
+
+// newCollectionStore keeps collections alongside the recipes: in their
+// database for the SQL stores, in memory otherwise
+func newCollectionStore(recipes recipeStore) collectionStore {
+	if s, ok := recipes.(*sqlRecipeStore); ok {
+		return &sqlCollectionStore{db: s.db}
+	}
+	return newMemoryCollectionStore()
+}
+
+// compareCollections orders collections most recently updated first
+func compareCollections(a, b Collection) int {
+	return cmp.Or(b.UpdatedAt.Compare(a.UpdatedAt), strings.Compare(b.ID, a.ID))
+}
+
+// memoryCollectionStore keeps collections in memory
+type memoryCollectionStore struct {
+	mu sync.Mutex
+	// Collections by account and id
+	collections map[string]map[string]Collection
+}
+
+// newMemoryCollectionStore creates an empty in-memory collection store
+func newMemoryCollectionStore() *memoryCollectionStore {
+	return &memoryCollectionStore{collections: make(map[string]map[string]Collection)}
+}
+
+func (s *memoryCollectionStore) list(account string) []Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []Collection{}
+	for _, c := range s.collections[account] {
+		list = append(list, c)
+	}
+	slices.SortFunc(list, compareCollections)
+	return list
+}
+
+func (s *memoryCollectionStore) get(account, id string) (*Collection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.collections[account][id]
+	if !ok {
+		return nil, false
+	}
+	return &c, true
+}
+
+func (s *memoryCollectionStore) put(ctx context.Context, account string, c *Collection) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.ID == "" {
+		c.ID, c.CreatedAt = newID(), c.UpdatedAt
+		if s.collections[account] == nil {
+			s.collections[account] = make(map[string]Collection)
+		}
+	} else if old, ok := s.collections[account][c.ID]; ok {
+		c.CreatedAt = old.CreatedAt
+	} else {
+		return false, nil
+	}
+	stored := *c
+	stored.RecipeIDs, stored.Recipes = slices.Clone(c.RecipeIDs), nil
+	s.collections[account][c.ID] = stored
+	return true, nil
+}
+
+func (s *memoryCollectionStore) delete(ctx context.Context, account, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.collections[account][id]
+	delete(s.collections[account], id)
+	return ok, nil
+}
+
+// sqlCollectionStore keeps collections in the collections table of the
+// recipe database, with the ordered recipe ids as a JSON array
+type sqlCollectionStore struct {
+	db *sql.DB
+}
+
+// Columns scanCollection reads
+const collectionColumns = `id, name, description, recipe_ids, cover_recipe_id, created_at, updated_at`
+
+func (s *sqlCollectionStore) list(account string) []Collection {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	list := []Collection{}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+collectionColumns+` FROM collections WHERE account = $1 ORDER BY updated_at DESC, id DESC`, account)
+	if err != nil {
+		log.Printf("Failed to list the collections of %s: %v", account, err)
+		return list
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			log.Printf("Failed to read a collection: %v", err)
+			continue
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list the collections of %s: %v", account, err)
+	}
+	return list
+}
+
+func (s *sqlCollectionStore) get(account, id string) (*Collection, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	c, err := scanCollection(s.db.QueryRowContext(ctx,
+		`SELECT `+collectionColumns+` FROM collections WHERE account = $1 AND id = $2`, account, id))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to read collection %s: %v", id, err)
+		}
+		return nil, false
+	}
+	return &c, true
+}
+
+func (s *sqlCollectionStore) put(ctx context.Context, account string, c *Collection) (bool, error) {
+	ids, err := json.Marshal(c.RecipeIDs)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	if c.ID == "" {
+		c.ID, c.CreatedAt = newID(), c.UpdatedAt
+		_, err := s.db.ExecContext(ctx,
+			`INSERT INTO collections (id, account, name, description, recipe_ids, cover_recipe_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $7)`,
+			c.ID, account, c.Name, c.Description, string(ids), c.CoverRecipeID, c.UpdatedAt.UTC())
+		if err != nil {
+			return false, fmt.Errorf("adding collection %s: %w", c.Name, err)
+		}
+		return true, nil
+	}
+
+	err = s.db.QueryRowContext(ctx,
+		`UPDATE collections SET name = $1, description = $2, recipe_ids = $3, cover_recipe_id = $4, updated_at = $5
+		WHERE account = $6 AND id = $7 RETURNING created_at`,
+		c.Name, c.Description, string(ids), c.CoverRecipeID, c.UpdatedAt.UTC(), account, c.ID).Scan(&c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("updating collection %s: %w", c.ID, err)
+	}
+	return true, nil
+}
+
+func (s *sqlCollectionStore) delete(ctx context.Context, account, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM collections WHERE account = $1 AND id = $2`, account, id)
+	if err != nil {
+		return false, fmt.Errorf("deleting collection %s: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// scanCollection reads a row of collectionColumns
+func scanCollection(row interface{ Scan(...any) error }) (Collection, error) {
+	var c Collection
+	var ids []byte
+	if err := row.Scan(&c.ID, &c.Name, &c.Description, &ids, &c.CoverRecipeID, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return c, err
+	}
+	return c, json.Unmarshal(ids, &c.RecipeIDs)
+}
+
+// collectionPDF renders a collection as a cookbook: a cover page with the
+// cover recipe and the contents, then each recipe's card on its own page
+func collectionPDF(c *Collection, recipes []*FoodRecipe) ([]byte, error) {
+	pdf := newPDF(c.Name)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 2*pdfMargin
+
+	// Number the pages after the cover at the foot
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() > 1 {
+			pdf.SetY(-pdfMargin + 4)
+			pdf.SetFont("Helvetica", "", 8)
+			pdf.CellFormat(0, 4, fmt.Sprintf("%d", pdf.PageNo()), "", 0, "C", false, 0, "")
+		}
+	})
+
+	pdf.AddPage()
+	pdf.Ln(40)
+	pdf.SetFont("Helvetica", "B", 30)
+	pdf.MultiCell(contentWidth, 13, tr(c.Name), "", "C", false)
+	if c.Description != "" {
+		pdf.Ln(4)
+		pdf.SetFont("Helvetica", "I", 12)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.MultiCell(contentWidth, 6, tr(c.Description), "", "C", false)
+		pdf.SetTextColor(0, 0, 0)
+	}
+	for _, r := range recipes {
+		if r.ID == c.cover() {
+			pdf.Ln(10)
+			pdf.SetFont("Helvetica", "", pdfHeadingSize)
+			pdf.MultiCell(contentWidth, 7, tr("Featuring "+r.Name), "", "C", false)
+		}
+	}
+
+	pdfHeading(pdf, "Contents")
+	pdf.SetFont("Helvetica", "", pdfBodySize)
+	links := make([]int, len(recipes))
+	for i, r := range recipes {
+		links[i] = pdf.AddLink()
+		pdf.CellFormat(contentWidth, pdfLineHeight+1, tr(fmt.Sprintf("%d. %s", i+1, r.Name)), "", 1, "L", false, links[i], "")
+	}
+
+	for i, r := range recipes {
+		pdfRecipePage(pdf, r)
+		pdf.SetLink(links[i], pdfMargin, pdf.PageNo())
+	}
+	return pdfBytes(pdf)
+}
+
+// callerCollection returns the caller's account and the collection in the
+// path, answering 401 or 404 when there is none
+func callerCollection(w http.ResponseWriter, r *http.Request) (string, *Collection, bool) {
+	account, ok := requireAccount(w, r, "keep collections")
+	if !ok {
+		return "", nil, false
+	}
+	c, ok := collections.get(account, r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Collection Not Found", "You have no collection with this id")
+		return "", nil, false
+	}
+	return account, c, true
+}
+
+// saveCollection stores a changed collection of the caller's and answers
+// with it, or with 404 when it was deleted meanwhile
+func saveCollection(w http.ResponseWriter, r *http.Request, account string, c *Collection, status int) {
+	c.UpdatedAt = time.Now()
+	found, err := collections.put(r.Context(), account, c)
+	if err != nil {
+		log.Printf("Failed to save collection of %s: %v", account, err)
+		writeError(w, http.StatusInternalServerError, "Save Failed", "The collection could not be saved; try again later")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "Collection Not Found", "You have no collection with this id")
+		return
+	}
+	if status == http.StatusCreated {
+		w.Header().Set("Location", "/api/collections/"+c.ID)
+	}
+	writeJSON(w, status, c.withRecipes())
+}
+
+// missingRecipe answers 404 and returns true when one of the ids isn't a
+// stored recipe
+func missingRecipe(w http.ResponseWriter, ids []string) bool {
+	for _, id := range ids {
+		if _, ok := savedRecipes.entry(id); !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", fmt.Sprintf("Recipe %s does not exist or has expired", id))
+			return true
+		}
+	}
+	return false
+}
+
+// listCollectionsHandler lists the caller's collections, most recently
+// updated first
+func listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep collections")
+	if !ok {
+		return
+	}
+	list := Collections{Collections: []Collection{}}
+	for _, c := range collections.list(account) {
+		list.Collections = append(list.Collections, c.withRecipes())
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// putCollectionHandler creates a collection of the caller's, or replaces
+// the name, description, recipes and cover of the one in the path. The
+// order of recipeIds is the order of the cookbook
+func putCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep collections")
+	if !ok {
+		return
+	}
+	var input CollectionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	if missingRecipe(w, input.RecipeIDs) {
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" && len(collections.list(account)) >= maxCollections {
+		writeError(w, http.StatusConflict, "Too Many Collections", fmt.Sprintf("You can keep at most %d collections; delete some first", maxCollections))
+		return
+	}
+	c := &Collection{
+		ID:            id,
+		Name:          strings.TrimSpace(input.Name),
+		Description:   strings.TrimSpace(input.Description),
+		RecipeIDs:     input.RecipeIDs,
+		CoverRecipeID: input.CoverRecipeID,
+	}
+	if c.RecipeIDs == nil {
+		c.RecipeIDs = []string{}
+	}
+	status := http.StatusOK
+	if id == "" {
+		status = http.StatusCreated
+	}
+	saveCollection(w, r, account, c, status)
+}
+
+// getCollectionHandler serves one of the caller's collections
+func getCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	if _, c, ok := callerCollection(w, r); ok {
+		writeJSON(w, http.StatusOK, c.withRecipes())
+	}
+}
+
+// addCollectionRecipeHandler adds a recipe to one of the caller's
+// collections at a position, or moves it there when it is already in
+func addCollectionRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	account, c, ok := callerCollection(w, r)
+	if !ok {
+		return
+	}
+	var input CollectionRecipe
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	if missingRecipe(w, []string{input.RecipeID}) {
+		return
+	}
+
+	ids := slices.DeleteFunc(slices.Clone(c.RecipeIDs), func(id string) bool { return id == input.RecipeID })
+	if len(ids) >= maxCollectionRecipes {
+		writeError(w, http.StatusConflict, "Collection Full", fmt.Sprintf("A collection holds at most %d recipes", maxCollectionRecipes))
+		return
+	}
+	at := len(ids)
+	if input.Position > 0 {
+		at = min(input.Position-1, len(ids))
+	}
+	c.RecipeIDs = slices.Insert(ids, at, input.RecipeID)
+	saveCollection(w, r, account, c, http.StatusOK)
+}
+
+// removeCollectionRecipeHandler takes a recipe out of one of the caller's
+// collections, and off the cover
+func removeCollectionRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	account, c, ok := callerCollection(w, r)
+	if !ok {
+		return
+	}
+	recipeID := r.PathValue("recipe")
+	i := slices.Index(c.RecipeIDs, recipeID)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The collection does not have this recipe")
+		return
+	}
+	c.RecipeIDs = slices.Delete(c.RecipeIDs, i, i+1)
+	if c.CoverRecipeID == recipeID {
+		c.CoverRecipeID = ""
+	}
+	saveCollection(w, r, account, c, http.StatusOK)
+}
+
+// deleteCollectionHandler removes one of the caller's collections. The
+// recipes in it are kept
+func deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep collections")
+	if !ok {
+		return
+	}
+	deleted, err := collections.delete(r.Context(), account, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Failed to delete collection of %s: %v", account, err)
+		writeError(w, http.StatusInternalServerError, "Delete Failed", "The collection could not be deleted; try again later")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "Collection Not Found", "You have no collection with this id")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// collectionPDFHandler serves one of the caller's collections as a
+// printable cookbook
+func collectionPDFHandler(w http.ResponseWriter, r *http.Request) {
+	_, c, ok := callerCollection(w, r)
+	if !ok {
+		return
+	}
+	var recipes []*FoodRecipe
+	for _, id := range c.RecipeIDs {
+		if recipe, ok := savedRecipes.get(id); ok {
+			recipes = append(recipes, recipe)
+		}
+	}
+	if len(recipes) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Empty Collection", "The collection has no recipes to print")
+		return
+	}
+
+	if notModified(w, r, contentETag(struct {
+		Collection *Collection
+		Recipes    []*FoodRecipe
+	}{c, recipes}, "pdf")) {
+		return
+	}
+
+	data, err := collectionPDF(c, recipes)
+	if err != nil {
+		log.Printf("Error rendering collection PDF: %v", err)
+		writeError(w, http.StatusInternalServerError, "PDF Rendering Failed", err.Error())
+		return
+	}
+	writePDF(w, data, c.Name, "cookbook")
+}
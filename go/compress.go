@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Responses smaller than this aren't worth compressing
+const minCompressBytes = 1024
+
+// Encoders pooled per encoding, since each holds sizeable buffers
+var (
+	gzipWriters   = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, 5) }}
+)
+
+// A pooled compressor that can be pointed at a new destination
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+	Flush() error
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header,
+// preferring br at equal quality; it returns "" for no compression
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if coding == "*" {
+			coding = "br"
+		}
+		if (coding != "br" && coding != "gzip") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && coding == "br") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a content type is text that shrinks well;
+// event streams are left alone so events aren't held back
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/yaml",
+		"application/x-ndjson", "application/javascript", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response to decide whether it is
+// worth compressing, then streams the rest through the compressor
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      bytes.Buffer
+	decided  bool
+	cw       resettableWriter
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.decided {
+		h := c.Header()
+		if h.Get("Content-Encoding") != "" || c.status < 200 || c.status == http.StatusNoContent ||
+			c.status == http.StatusNotModified || !compressible(h.Get("Content-Type")) {
+			c.start(false)
+		} else if c.buf.Len()+len(b) < minCompressBytes {
+			return c.buf.Write(b)
+		} else {
+			c.start(true)
+		}
+	}
+	if c.cw != nil {
+		return c.cw.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// start sends the headers and anything buffered, compressed or not
+func (c *compressWriter) start(compress bool) {
+	c.decided = true
+	h := c.Header()
+	if compressible(h.Get("Content-Type")) {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		if c.encoding == "br" {
+			c.cw = brotliWriters.Get().(*brotli.Writer)
+		} else {
+			c.cw = gzipWriters.Get().(*gzip.Writer)
+		}
+		c.cw.Reset(c.ResponseWriter)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.buf.Len() > 0 {
+		if c.cw != nil {
+			c.cw.Write(c.buf.Bytes())
+		} else {
+			c.ResponseWriter.Write(c.buf.Bytes())
+		}
+		c.buf.Reset()
+	}
+}
+
+// Flush sends what has been written so far; a response flushed before it
+// reached the size threshold goes out uncompressed
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		c.start(false)
+	}
+	if c.cw != nil {
+		c.cw.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close finishes the response and returns the compressor to its pool
+func (c *compressWriter) close() {
+	if !c.decided {
+		if c.status == 0 {
+			return
+		}
+		c.start(false)
+	}
+	if c.cw == nil {
+		return
+	}
+	c.cw.Close()
+	c.cw.Reset(nil)
+	if c.encoding == "br" {
+		brotliWriters.Put(c.cw)
+	} else {
+		gzipWriters.Put(c.cw)
+	}
+}
+
+// compressResponses gzip- or brotli-encodes textual responses for clients
+// that accept it. WebSocket upgrades pass straight through
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer c.close()
+		next.ServeHTTP(c, r)
+	})
+}
@@ -0,0 +1,887 @@
+// Package config loads the server settings from defaults, an optional YAML or
+// JSON file, environment variables and command-line flags, in increasing order
+// of precedence.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Placeholder shown instead of a secret that is set
+const redacted = "[REDACTED]"
+
+// Duration is a time.Duration written as text (e.g. "24h") in config files
+// and JSON
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config holds the server settings. Secrets can only come from the file or
+// the environment, so they never show up in the process list
+type Config struct {
+	BindAddress string `yaml:"bindAddress" json:"bindAddress"`
+	Port        int    `yaml:"port" json:"port"`
+	// Where to accept connections, in place of bindAddress:port: host:port
+	// for TCP, unix:/path for a Unix socket or "systemd" for the sockets
+	// passed by systemd socket activation
+	Listen []string `yaml:"listen" json:"listen,omitempty"`
+	// Reverse proxies whose X-Forwarded-For and X-Real-IP headers are
+	// believed, as CIDRs or IPs; "unix" trusts peers on a Unix socket
+	TrustedProxies []string `yaml:"trustedProxies" json:"trustedProxies,omitempty"`
+	Model          string   `yaml:"model" json:"model"`
+	// Models clients may choose per request, as provider/name
+	AllowedModels []string `yaml:"allowedModels" json:"allowedModels"`
+	// Models tried in order when the chosen one fails with a quota or
+	// server error
+	ModelFallbacks []string `yaml:"modelFallbacks" json:"modelFallbacks,omitempty"`
+	// Times a model call failing with a quota, server or network error is
+	// retried before falling back, and the delay before the first retry,
+	// doubled for each one after
+	ModelRetries    int      `yaml:"modelRetries" json:"modelRetries"`
+	ModelRetryDelay Duration `yaml:"modelRetryDelay" json:"modelRetryDelay"`
+	// Failed calls in a row after which a model is no longer called, and
+	// how long until it is tried again; a threshold of 0 keeps calling it
+	CircuitBreakerThreshold int      `yaml:"circuitBreakerThreshold" json:"circuitBreakerThreshold"`
+	CircuitBreakerCooldown  Duration `yaml:"circuitBreakerCooldown" json:"circuitBreakerCooldown"`
+	// Ollama server of local models, and the models pulled on it to serve
+	// as ollama/<name>; local models are off without an address
+	OllamaAddress string   `yaml:"ollamaAddress" json:"ollamaAddress,omitempty"`
+	OllamaModels  []string `yaml:"ollamaModels" json:"ollamaModels,omitempty"`
+	// OpenAI-compatible API serving models as openai/<name>, and its key;
+	// api.openai.com when only the key is set, off when neither is
+	OpenAIBaseURL string `yaml:"openAIBaseURL" json:"openAIBaseURL,omitempty"`
+	OpenAIAPIKey  string `yaml:"openAIAPIKey" json:"openAIAPIKey"`
+	// Generation parameters used unless a request sets its own; the model
+	// defaults when unset
+	Temperature     *float64 `yaml:"temperature" json:"temperature,omitempty"`
+	TopP            *float64 `yaml:"topP" json:"topP,omitempty"`
+	TopK            *int     `yaml:"topK" json:"topK,omitempty"`
+	MaxOutputTokens *int     `yaml:"maxOutputTokens" json:"maxOutputTokens,omitempty"`
+	// Gemini block thresholds keyed by harm category, e.g.
+	// {"harassment": "blockOnlyHigh"}; unlisted categories keep Gemini's
+	// defaults
+	SafetySettings map[string]string `yaml:"safetySettings" json:"safetySettings,omitempty"`
+
+	IdempotencyTTL Duration `yaml:"idempotencyTTL" json:"idempotencyTTL"`
+	// Requests per minute per API key or client IP, and how many may come at
+	// once; a limit of 0 disables rate limiting
+	RateLimit      int `yaml:"rateLimit" json:"rateLimit"`
+	RateLimitBurst int `yaml:"rateLimitBurst" json:"rateLimitBurst"`
+	// Time allowed per request, with overrides keyed by route pattern such
+	// as "POST /api/mealplan"; 0 means no limit
+	RequestTimeout Duration            `yaml:"requestTimeout" json:"requestTimeout"`
+	RouteTimeouts  map[string]Duration `yaml:"routeTimeouts" json:"routeTimeouts,omitempty"`
+	// How long the result of a /healthz/deep test generation is reused
+	DeepHealthTTL Duration `yaml:"deepHealthTTL" json:"deepHealthTTL"`
+	// Largest request body accepted, except on image and import routes
+	MaxBodyBytes int64 `yaml:"maxBodyBytes" json:"maxBodyBytes"`
+	// How long shutdown waits for in-flight requests before cancelling them
+	ShutdownTimeout Duration `yaml:"shutdownTimeout" json:"shutdownTimeout"`
+
+	// Certificate and key for HTTPS, or domains to get certificates for from
+	// Let's Encrypt. Either one serves Port over TLS
+	TLSCertFile      string   `yaml:"tlsCertFile" json:"tlsCertFile,omitempty"`
+	TLSKeyFile       string   `yaml:"tlsKeyFile" json:"tlsKeyFile,omitempty"`
+	AutocertDomains  []string `yaml:"autocertDomains" json:"autocertDomains,omitempty"`
+	AutocertCacheDir string   `yaml:"autocertCacheDir" json:"autocertCacheDir"`
+	// Port redirecting plain HTTP to HTTPS and answering ACME challenges;
+	// 0 disables it
+	HTTPRedirectPort int `yaml:"httpRedirectPort" json:"httpRedirectPort,omitempty"`
+
+	// OTLP/HTTP endpoint spans are exported to; tracing is off without it
+	OTLPEndpoint string `yaml:"otlpEndpoint" json:"otlpEndpoint,omitempty"`
+
+	// Feature flags file (YAML or JSON) and a "name=on|off|NN%" list that
+	// overrides it
+	FeatureFlagsFile string `yaml:"featureFlagsFile" json:"featureFlagsFile,omitempty"`
+	FeatureFlags     string `yaml:"featureFlags" json:"featureFlags,omitempty"`
+	// Directory of the .prompt files, loaded at startup
+	PromptDir string `yaml:"promptDir" json:"promptDir"`
+	// Percentage of clients served each prompt variant, keyed by
+	// prompt.variant (recipe.v2 for recipe.v2.prompt); the rest get the
+	// prompt without a variant
+	PromptTraffic map[string]int `yaml:"promptTraffic" json:"promptTraffic,omitempty"`
+
+	// Where recipes are kept: sqlite, postgres or memory, which forgets
+	// them after a day. RecipeStoreURL is the SQLite file, recipes.db when
+	// empty, or the Postgres connection string
+	RecipeStore    string `yaml:"recipeStore" json:"recipeStore"`
+	RecipeStoreURL string `yaml:"recipeStoreURL" json:"recipeStoreURL,omitempty"`
+
+	// Where recipe embeddings are kept: memory, pgvector or pinecone.
+	// VectorStoreURL is the Postgres connection string for pgvector and the
+	// index host for Pinecone, whose API key is VectorStoreAPIKey
+	VectorStore       string `yaml:"vectorStore" json:"vectorStore"`
+	VectorStoreURL    string `yaml:"vectorStoreURL" json:"vectorStoreURL,omitempty"`
+	VectorStoreAPIKey string `yaml:"vectorStoreAPIKey" json:"vectorStoreAPIKey"`
+	// Embedder recipes and queries are embedded with, as provider/name
+	EmbeddingModel string `yaml:"embeddingModel" json:"embeddingModel"`
+	// Similarity from which a recipe request is answered with the recipe
+	// generated for an earlier one, between 0 and 1; 0 turns the cache off
+	SemanticCacheThreshold float64 `yaml:"semanticCacheThreshold" json:"semanticCacheThreshold"`
+
+	// Access log format (json, combined, text or off) and the file it is
+	// appended to; stderr when empty, stdout for "-"
+	AccessLogFormat string `yaml:"accessLogFormat" json:"accessLogFormat"`
+	AccessLogFile   string `yaml:"accessLogFile" json:"accessLogFile,omitempty"`
+
+	GeminiAPIKey  string `yaml:"geminiAPIKey" json:"geminiAPIKey"`
+	WebhookSecret string `yaml:"webhookSecret" json:"webhookSecret"`
+	// USDA FoodData Central API key for the nutrition tool; the bundled
+	// nutrient table is used without it
+	FDCAPIKey string `yaml:"fdcAPIKey" json:"fdcAPIKey"`
+	// Google Programmable Search key and search engine ID for the web
+	// search tool of grounded recipes; grounding is off without them
+	SearchAPIKey   string `yaml:"searchAPIKey" json:"searchAPIKey"`
+	SearchEngineID string `yaml:"searchEngineID" json:"searchEngineID,omitempty"`
+	// Admin API key; setting it requires API keys on /api/*
+	AdminAPIKey string `yaml:"adminAPIKey" json:"adminAPIKey"`
+
+	// Issuer of bearer JWTs accepted instead of API keys. Keys are fetched
+	// from JWKSURL, or found through the issuer's OpenID discovery document
+	JWTIssuer    string `yaml:"jwtIssuer" json:"jwtIssuer,omitempty"`
+	JWKSURL      string `yaml:"jwksURL" json:"jwksURL,omitempty"`
+	JWTAudience  string `yaml:"jwtAudience" json:"jwtAudience,omitempty"`
+	JWTUserClaim string `yaml:"jwtUserClaim" json:"jwtUserClaim"`
+
+	// OpenID Connect client for signing in to the HTML pages, enabled by
+	// setting the client id. The redirect URL defaults to /auth/callback on
+	// the host the browser used
+	OIDCIssuer       string   `yaml:"oidcIssuer" json:"oidcIssuer"`
+	OIDCClientID     string   `yaml:"oidcClientID" json:"oidcClientID,omitempty"`
+	OIDCClientSecret string   `yaml:"oidcClientSecret" json:"oidcClientSecret"`
+	OIDCRedirectURL  string   `yaml:"oidcRedirectURL" json:"oidcRedirectURL,omitempty"`
+	SessionTTL       Duration `yaml:"sessionTTL" json:"sessionTTL"`
+
+	// File the settings were read from, if any
+	File string `yaml:"-" json:"file,omitempty"`
+}
+
+// Harm categories and block thresholds accepted in SafetySettings
+var (
+	harmCategories = []string{"harassment", "hateSpeech", "sexuallyExplicit", "dangerousContent", "civicIntegrity"}
+	harmThresholds = []string{"off", "blockNone", "blockOnlyHigh", "blockMediumAndAbove", "blockLowAndAbove"}
+)
+
+// Default returns the settings used when nothing else is configured
+func Default() *Config {
+	return &Config{
+		BindAddress:             "127.0.0.1",
+		Port:                    8080,
+		Model:                   "googleai/gemini-2.0-flash",
+		AllowedModels:           []string{"googleai/gemini-2.0-flash", "googleai/gemini-2.5-flash", "googleai/gemini-2.5-pro"},
+		ModelRetries:            2,
+		ModelRetryDelay:         Duration(500 * time.Millisecond),
+		CircuitBreakerThreshold: 5,
+		CircuitBreakerCooldown:  Duration(30 * time.Second),
+		IdempotencyTTL:          Duration(24 * time.Hour),
+		ShutdownTimeout:         Duration(30 * time.Second),
+		RequestTimeout:          Duration(2 * time.Minute),
+		MaxBodyBytes:            1 << 20,
+		AccessLogFormat:         "json",
+		PromptDir:               "prompts",
+		RecipeStore:             "sqlite",
+		VectorStore:             "memory",
+		EmbeddingModel:          "googleai/text-embedding-004",
+		DeepHealthTTL:           Duration(5 * time.Minute),
+		RateLimit:               60,
+		RateLimitBurst:          10,
+		JWTUserClaim:            "sub",
+		OIDCIssuer:              "https://accounts.google.com",
+		SessionTTL:              Duration(7 * 24 * time.Hour),
+		AutocertCacheDir:        "autocert-cache",
+	}
+}
+
+// Load reads the settings for a process started with the given arguments
+// (without the program name)
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("genkit-go", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file (env CONFIG_FILE)")
+	bind := fs.String("bind", "", "address to listen on (env BIND_ADDRESS)")
+	trustedProxies := fs.String("trusted-proxies", "", "comma-separated CIDRs of proxies whose forwarding headers are believed (env TRUSTED_PROXIES)")
+	listen := fs.String("listen", "", "comma-separated host:port, unix:/path or systemd listeners (env LISTEN)")
+	port := fs.Int("port", 0, "port to listen on (env PORT)")
+	model := fs.String("model", "", "default model, as provider/name (env GENKIT_MODEL)")
+	allowedModels := fs.String("allowed-models", "", "comma-separated models clients may choose per request (env ALLOWED_MODELS)")
+	fallbacks := fs.String("model-fallbacks", "", "comma-separated models to try when the chosen one fails (env MODEL_FALLBACKS)")
+	retries := fs.Int("model-retries", 0, "times a model call failing with a transient error is retried, 0 to disable (env MODEL_RETRIES)")
+	retryDelay := fs.Duration("model-retry-delay", 0, "delay before the first retry of a model call, doubled for each one after (env MODEL_RETRY_DELAY)")
+	breakerThreshold := fs.Int("circuit-breaker-threshold", 0, "failed model calls in a row after which the model is no longer called, 0 to disable (env CIRCUIT_BREAKER_THRESHOLD)")
+	breakerCooldown := fs.Duration("circuit-breaker-cooldown", 0, "how long a model is not called after its circuit opens (env CIRCUIT_BREAKER_COOLDOWN)")
+	temperature := fs.Float64("temperature", 0, "default sampling temperature, 0 to 2 (env TEMPERATURE)")
+	topP := fs.Float64("top-p", 0, "default nucleus sampling probability, 0 to 1 (env TOP_P)")
+	topK := fs.Int("top-k", 0, "default number of likeliest tokens sampled from (env TOP_K)")
+	maxOutputTokens := fs.Int("max-output-tokens", 0, "default longest answer in tokens (env MAX_OUTPUT_TOKENS)")
+	ttl := fs.Duration("idempotency-ttl", 0, "how long idempotent responses are replayed (env IDEMPOTENCY_TTL)")
+	requestTimeout := fs.Duration("request-timeout", 0, "time allowed per request, 0 for no limit (env REQUEST_TIMEOUT)")
+	deepHealthTTL := fs.Duration("deep-health-ttl", 0, "how long a /healthz/deep result is reused (env DEEP_HEALTH_TTL)")
+	maxBody := fs.Int64("max-body-bytes", 0, "largest request body accepted (env MAX_BODY_BYTES)")
+	shutdown := fs.Duration("shutdown-timeout", 0, "how long to drain requests on shutdown (env SHUTDOWN_TIMEOUT)")
+	featureFlagsFile := fs.String("feature-flags", "", "YAML or JSON feature flags file, reloaded on SIGHUP (env FEATURE_FLAGS_FILE)")
+	promptDir := fs.String("prompt-dir", "", "directory of the .prompt files (env PROMPT_DIR)")
+	recipeStore := fs.String("recipe-store", "", "where recipes are kept: sqlite, postgres or memory (env RECIPE_STORE)")
+	recipeStoreURL := fs.String("recipe-store-url", "", "SQLite file or Postgres connection string of the recipe store (env RECIPE_STORE_URL)")
+	vectorStore := fs.String("vector-store", "", "where recipe embeddings are kept: memory, pgvector or pinecone (env VECTOR_STORE)")
+	cacheThreshold := fs.Float64("semantic-cache-threshold", 0, "similarity from which a cached recipe answers a request, 0 to disable (env SEMANTIC_CACHE_THRESHOLD)")
+	ollamaAddress := fs.String("ollama-address", "", "Ollama server of local models, e.g. http://localhost:11434 (env OLLAMA_ADDRESS)")
+	ollamaModels := fs.String("ollama-models", "", "comma-separated Ollama models to serve as ollama/<name> (env OLLAMA_MODELS)")
+	openAIBaseURL := fs.String("openai-base-url", "", "OpenAI-compatible API to serve models from as openai/<name> (env OPENAI_BASE_URL)")
+	embeddingModel := fs.String("embedding-model", "", "embedder for recipes and queries, as provider/name (env EMBEDDING_MODEL)")
+	accessLogFormat := fs.String("access-log-format", "", "access log format: json, combined, text or off (env ACCESS_LOG_FORMAT)")
+	accessLogFile := fs.String("access-log", "", "file to append the access log to, - for stdout (env ACCESS_LOG_FILE)")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "OTLP/HTTP endpoint to export traces to (env OTEL_EXPORTER_OTLP_ENDPOINT)")
+	rateLimit := fs.Int("rate-limit", 0, "requests per minute per client, 0 to disable (env RATE_LIMIT)")
+	rateLimitBurst := fs.Int("rate-limit-burst", 0, "requests a client may send at once (env RATE_LIMIT_BURST)")
+	certFile := fs.String("tls-cert", "", "TLS certificate file (env TLS_CERT_FILE)")
+	keyFile := fs.String("tls-key", "", "TLS private key file (env TLS_KEY_FILE)")
+	domains := fs.String("autocert-domains", "", "comma-separated domains to get Let's Encrypt certificates for (env AUTOCERT_DOMAINS)")
+	cacheDir := fs.String("autocert-cache", "", "directory caching Let's Encrypt certificates (env AUTOCERT_CACHE_DIR)")
+	redirectPort := fs.Int("http-redirect-port", 0, "port redirecting HTTP to HTTPS, 0 to disable (env HTTP_REDIRECT_PORT)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *file != "" {
+		if err := cfg.loadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Only flags given on the command line override the other sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bind":
+			cfg.BindAddress = *bind
+		case "listen":
+			cfg.Listen = splitList(*listen)
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*trustedProxies)
+		case "port":
+			cfg.Port = *port
+		case "model":
+			cfg.Model = *model
+		case "allowed-models":
+			cfg.AllowedModels = splitList(*allowedModels)
+		case "model-fallbacks":
+			cfg.ModelFallbacks = splitList(*fallbacks)
+		case "model-retries":
+			cfg.ModelRetries = *retries
+		case "model-retry-delay":
+			cfg.ModelRetryDelay = Duration(*retryDelay)
+		case "circuit-breaker-threshold":
+			cfg.CircuitBreakerThreshold = *breakerThreshold
+		case "circuit-breaker-cooldown":
+			cfg.CircuitBreakerCooldown = Duration(*breakerCooldown)
+		case "ollama-address":
+			cfg.OllamaAddress = *ollamaAddress
+		case "ollama-models":
+			cfg.OllamaModels = splitList(*ollamaModels)
+		case "openai-base-url":
+			cfg.OpenAIBaseURL = *openAIBaseURL
+		case "temperature":
+			cfg.Temperature = temperature
+		case "top-p":
+			cfg.TopP = topP
+		case "top-k":
+			cfg.TopK = topK
+		case "max-output-tokens":
+			cfg.MaxOutputTokens = maxOutputTokens
+		case "idempotency-ttl":
+			cfg.IdempotencyTTL = Duration(*ttl)
+		case "request-timeout":
+			cfg.RequestTimeout = Duration(*requestTimeout)
+		case "deep-health-ttl":
+			cfg.DeepHealthTTL = Duration(*deepHealthTTL)
+		case "max-body-bytes":
+			cfg.MaxBodyBytes = *maxBody
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = Duration(*shutdown)
+		case "otlp-endpoint":
+			cfg.OTLPEndpoint = *otlpEndpoint
+		case "feature-flags":
+			cfg.FeatureFlagsFile = *featureFlagsFile
+		case "recipe-store":
+			cfg.RecipeStore = *recipeStore
+		case "recipe-store-url":
+			cfg.RecipeStoreURL = *recipeStoreURL
+		case "vector-store":
+			cfg.VectorStore = *vectorStore
+		case "embedding-model":
+			cfg.EmbeddingModel = *embeddingModel
+		case "semantic-cache-threshold":
+			cfg.SemanticCacheThreshold = *cacheThreshold
+		case "access-log-format":
+			cfg.AccessLogFormat = *accessLogFormat
+		case "prompt-dir":
+			cfg.PromptDir = *promptDir
+		case "access-log":
+			cfg.AccessLogFile = *accessLogFile
+		case "rate-limit":
+			cfg.RateLimit = *rateLimit
+		case "rate-limit-burst":
+			cfg.RateLimitBurst = *rateLimitBurst
+		case "tls-cert":
+			cfg.TLSCertFile = *certFile
+		case "tls-key":
+			cfg.TLSKeyFile = *keyFile
+		case "autocert-domains":
+			cfg.AutocertDomains = splitList(*domains)
+		case "autocert-cache":
+			cfg.AutocertCacheDir = *cacheDir
+		case "http-redirect-port":
+			cfg.HTTPRedirectPort = *redirectPort
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile merges the settings in a YAML or JSON file into the config
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := yaml.UnmarshalWithOptions(data, c, yaml.Strict()); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	c.File = path
+	return nil
+}
+
+// loadEnv merges the settings set in the environment into the config
+func (c *Config) loadEnv() error {
+	if v := os.Getenv("BIND_ADDRESS"); v != "" {
+		c.BindAddress = v
+	}
+	if v := os.Getenv("PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("PORT must be a number, got %q", v)
+		}
+		c.Port = port
+	}
+	if v := os.Getenv("GENKIT_MODEL"); v != "" {
+		c.Model = v
+	}
+	if v := os.Getenv("ALLOWED_MODELS"); v != "" {
+		c.AllowedModels = splitList(v)
+	}
+	if v := os.Getenv("MODEL_FALLBACKS"); v != "" {
+		c.ModelFallbacks = splitList(v)
+	}
+	if v := os.Getenv("OLLAMA_MODELS"); v != "" {
+		c.OllamaModels = splitList(v)
+	}
+	if v := os.Getenv("MODEL_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("MODEL_RETRIES must be a number, got %q", v)
+		}
+		c.ModelRetries = n
+	}
+	if v := os.Getenv("MODEL_RETRY_DELAY"); v != "" {
+		if err := c.ModelRetryDelay.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("MODEL_RETRY_DELAY must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("CIRCUIT_BREAKER_THRESHOLD must be a number, got %q", v)
+		}
+		c.CircuitBreakerThreshold = n
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_COOLDOWN"); v != "" {
+		if err := c.CircuitBreakerCooldown.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("CIRCUIT_BREAKER_COOLDOWN must be a duration, got %q", v)
+		}
+	}
+	for name, setting := range map[string]**float64{"TEMPERATURE": &c.Temperature, "TOP_P": &c.TopP} {
+		if v := os.Getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, v)
+			}
+			*setting = &f
+		}
+	}
+	for name, setting := range map[string]**int{"TOP_K": &c.TopK, "MAX_OUTPUT_TOKENS": &c.MaxOutputTokens} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, v)
+			}
+			*setting = &n
+		}
+	}
+	if v := os.Getenv("SEMANTIC_CACHE_THRESHOLD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("SEMANTIC_CACHE_THRESHOLD must be a number, got %q", v)
+		}
+		c.SemanticCacheThreshold = f
+	}
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		if err := c.IdempotencyTTL.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("IDEMPOTENCY_TTL must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		if err := c.RequestTimeout.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("REQUEST_TIMEOUT must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("ROUTE_TIMEOUTS"); v != "" {
+		// Comma-separated pattern=duration pairs
+		c.RouteTimeouts = make(map[string]Duration)
+		for _, item := range splitList(v) {
+			pattern, duration, ok := strings.Cut(item, "=")
+			var d Duration
+			if !ok || d.UnmarshalText([]byte(strings.TrimSpace(duration))) != nil {
+				return fmt.Errorf("ROUTE_TIMEOUTS entries must look like \"POST /api/mealplan=3m\", got %q", item)
+			}
+			c.RouteTimeouts[strings.TrimSpace(pattern)] = d
+		}
+	}
+	if v := os.Getenv("SAFETY_SETTINGS"); v != "" {
+		// Comma-separated category=threshold pairs
+		c.SafetySettings = make(map[string]string)
+		for _, item := range splitList(v) {
+			category, threshold, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("SAFETY_SETTINGS entries must look like \"harassment=blockOnlyHigh\", got %q", item)
+			}
+			c.SafetySettings[strings.TrimSpace(category)] = strings.TrimSpace(threshold)
+		}
+	}
+	if v := os.Getenv("PROMPT_TRAFFIC"); v != "" {
+		// Comma-separated prompt.variant=percentage pairs
+		c.PromptTraffic = make(map[string]int)
+		for _, item := range splitList(v) {
+			key, value, ok := strings.Cut(item, "=")
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+			if !ok || err != nil {
+				return fmt.Errorf("PROMPT_TRAFFIC entries must look like \"recipe.v2=20\", got %q", item)
+			}
+			c.PromptTraffic[strings.TrimSpace(key)] = n
+		}
+	}
+	if v := os.Getenv("DEEP_HEALTH_TTL"); v != "" {
+		if err := c.DeepHealthTTL.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("DEEP_HEALTH_TTL must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("MAX_BODY_BYTES must be a number, got %q", v)
+		}
+		c.MaxBodyBytes = n
+	}
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if err := c.ShutdownTimeout.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("SHUTDOWN_TIMEOUT must be a duration, got %q", v)
+		}
+	}
+	for name, setting := range map[string]*int{"RATE_LIMIT": &c.RateLimit, "RATE_LIMIT_BURST": &c.RateLimitBurst} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, v)
+			}
+			*setting = n
+		}
+	}
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		if err := c.SessionTTL.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("SESSION_TTL must be a duration, got %q", v)
+		}
+	}
+	if v := os.Getenv("TLS_CERT_FILE"); v != "" {
+		c.TLSCertFile = v
+	}
+	if v := os.Getenv("TLS_KEY_FILE"); v != "" {
+		c.TLSKeyFile = v
+	}
+	if v := os.Getenv("LISTEN"); v != "" {
+		c.Listen = splitList(v)
+	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		c.TrustedProxies = splitList(v)
+	}
+	if v := os.Getenv("AUTOCERT_DOMAINS"); v != "" {
+		c.AutocertDomains = splitList(v)
+	}
+	if v := os.Getenv("AUTOCERT_CACHE_DIR"); v != "" {
+		c.AutocertCacheDir = v
+	}
+	if v := os.Getenv("HTTP_REDIRECT_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("HTTP_REDIRECT_PORT must be a number, got %q", v)
+		}
+		c.HTTPRedirectPort = port
+	}
+	for _, name := range []string{"GEMINI_API_KEY", "GOOGLE_API_KEY"} {
+		if v := os.Getenv(name); v != "" {
+			c.GeminiAPIKey = v
+			break
+		}
+	}
+	if v := os.Getenv("WEBHOOK_SECRET"); v != "" {
+		c.WebhookSecret = v
+	}
+	if v := os.Getenv("FDC_API_KEY"); v != "" {
+		c.FDCAPIKey = v
+	}
+	if v := os.Getenv("SEARCH_API_KEY"); v != "" {
+		c.SearchAPIKey = v
+	}
+	if v := os.Getenv("ADMIN_API_KEY"); v != "" {
+		c.AdminAPIKey = v
+	}
+	for name, setting := range map[string]*string{
+		"JWT_ISSUER":                  &c.JWTIssuer,
+		"JWT_JWKS_URL":                &c.JWKSURL,
+		"JWT_AUDIENCE":                &c.JWTAudience,
+		"JWT_USER_CLAIM":              &c.JWTUserClaim,
+		"OIDC_ISSUER":                 &c.OIDCIssuer,
+		"OIDC_CLIENT_ID":              &c.OIDCClientID,
+		"OIDC_CLIENT_SECRET":          &c.OIDCClientSecret,
+		"OIDC_REDIRECT_URL":           &c.OIDCRedirectURL,
+		"OTEL_EXPORTER_OTLP_ENDPOINT": &c.OTLPEndpoint,
+		"FEATURE_FLAGS_FILE":          &c.FeatureFlagsFile,
+		"FEATURE_FLAGS":               &c.FeatureFlags,
+		"ACCESS_LOG_FORMAT":           &c.AccessLogFormat,
+		"ACCESS_LOG_FILE":             &c.AccessLogFile,
+		"PROMPT_DIR":                  &c.PromptDir,
+		"SEARCH_ENGINE_ID":            &c.SearchEngineID,
+		"RECIPE_STORE":                &c.RecipeStore,
+		"RECIPE_STORE_URL":            &c.RecipeStoreURL,
+		"VECTOR_STORE":                &c.VectorStore,
+		"VECTOR_STORE_URL":            &c.VectorStoreURL,
+		"VECTOR_STORE_API_KEY":        &c.VectorStoreAPIKey,
+		"EMBEDDING_MODEL":             &c.EmbeddingModel,
+		"OLLAMA_ADDRESS":              &c.OllamaAddress,
+		"OPENAI_BASE_URL":             &c.OpenAIBaseURL,
+		"OPENAI_API_KEY":              &c.OpenAIAPIKey,
+	} {
+		if v := os.Getenv(name); v != "" {
+			*setting = v
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.BindAddress == "" {
+		errs = append(errs, errors.New("bind address must not be empty"))
+	} else if strings.Contains(c.BindAddress, ":") && net.ParseIP(c.BindAddress) == nil {
+		errs = append(errs, fmt.Errorf("bind address %q must be a host or IP without a port", c.BindAddress))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if provider, name, ok := strings.Cut(c.Model, "/"); !ok || provider == "" || name == "" {
+		errs = append(errs, fmt.Errorf("model must be written as provider/name, got %q", c.Model))
+	}
+	for _, m := range c.AllowedModels {
+		if provider, name, ok := strings.Cut(m, "/"); !ok || provider == "" || name == "" {
+			errs = append(errs, fmt.Errorf("allowed model must be written as provider/name, got %q", m))
+		}
+	}
+	if len(c.OllamaModels) > 0 && c.OllamaAddress == "" {
+		errs = append(errs, errors.New("Ollama models need the Ollama server address"))
+	}
+	if c.OllamaAddress != "" {
+		if u, err := url.Parse(c.OllamaAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("Ollama address must be an http(s) URL, got %q", c.OllamaAddress))
+		}
+	}
+	if c.OpenAIBaseURL != "" {
+		if u, err := url.Parse(c.OpenAIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("OpenAI base URL must be an http(s) URL, got %q", c.OpenAIBaseURL))
+		}
+	}
+	if c.Temperature != nil && (*c.Temperature < 0 || *c.Temperature > 2) {
+		errs = append(errs, fmt.Errorf("temperature must be between 0 and 2, got %g", *c.Temperature))
+	}
+	if c.TopP != nil && (*c.TopP < 0 || *c.TopP > 1) {
+		errs = append(errs, fmt.Errorf("top-p must be between 0 and 1, got %g", *c.TopP))
+	}
+	if c.TopK != nil && (*c.TopK < 1 || *c.TopK > 100) {
+		errs = append(errs, fmt.Errorf("top-k must be between 1 and 100, got %d", *c.TopK))
+	}
+	if c.MaxOutputTokens != nil && (*c.MaxOutputTokens < 1 || *c.MaxOutputTokens > 65536) {
+		errs = append(errs, fmt.Errorf("max output tokens must be between 1 and 65536, got %d", *c.MaxOutputTokens))
+	}
+	for category, threshold := range c.SafetySettings {
+		if !slices.Contains(harmCategories, category) {
+			errs = append(errs, fmt.Errorf("safety setting category %q must be one of %s", category, strings.Join(harmCategories, ", ")))
+		}
+		if !slices.Contains(harmThresholds, threshold) {
+			errs = append(errs, fmt.Errorf("safety setting threshold %q must be one of %s", threshold, strings.Join(harmThresholds, ", ")))
+		}
+	}
+	promptTotals := make(map[string]int)
+	for key, percentage := range c.PromptTraffic {
+		prompt, variant, _ := strings.Cut(key, ".")
+		if prompt == "" || variant == "" {
+			errs = append(errs, fmt.Errorf("prompt traffic key %q must be written as prompt.variant", key))
+		}
+		if percentage < 0 || percentage > 100 {
+			errs = append(errs, fmt.Errorf("prompt traffic for %s must be between 0 and 100, got %d", key, percentage))
+		}
+		promptTotals[prompt] += percentage
+	}
+	for prompt, total := range promptTotals {
+		if total > 100 {
+			errs = append(errs, fmt.Errorf("prompt traffic for the %s variants adds up to %d%%, more than 100%%", prompt, total))
+		}
+	}
+	for _, m := range c.ModelFallbacks {
+		if provider, name, ok := strings.Cut(m, "/"); !ok || provider == "" || name == "" {
+			errs = append(errs, fmt.Errorf("fallback model must be written as provider/name, got %q", m))
+		}
+	}
+	if c.ModelRetries < 0 || c.ModelRetries > 10 {
+		errs = append(errs, fmt.Errorf("model retries must be between 0 and 10, got %d", c.ModelRetries))
+	}
+	if c.ModelRetryDelay <= 0 {
+		errs = append(errs, errors.New("model retry delay must be positive"))
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		errs = append(errs, errors.New("circuit breaker threshold must not be negative"))
+	}
+	if c.CircuitBreakerCooldown <= 0 {
+		errs = append(errs, errors.New("circuit breaker cooldown must be positive"))
+	}
+	if c.IdempotencyTTL <= 0 {
+		errs = append(errs, errors.New("idempotency TTL must be positive"))
+	}
+	if c.RequestTimeout < 0 {
+		errs = append(errs, errors.New("request timeout must not be negative"))
+	}
+	for pattern, d := range c.RouteTimeouts {
+		if pattern == "" || d < 0 {
+			errs = append(errs, fmt.Errorf("route timeout %q=%s must have a pattern and must not be negative", pattern, time.Duration(d)))
+		}
+	}
+	if c.DeepHealthTTL <= 0 {
+		errs = append(errs, errors.New("deep health TTL must be positive"))
+	}
+	if c.MaxBodyBytes < 1 {
+		errs = append(errs, errors.New("max body size must be positive"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, errors.New("rate limit must not be negative"))
+	}
+	if c.RateLimit > 0 && c.RateLimitBurst < 1 {
+		errs = append(errs, errors.New("rate limit burst must be at least 1"))
+	}
+	if c.AdminAPIKey != "" && len(c.AdminAPIKey) < 16 {
+		errs = append(errs, errors.New("admin API key must be at least 16 characters"))
+	}
+	if c.JWKSURL != "" && c.JWTIssuer == "" {
+		errs = append(errs, errors.New("a JWKS URL needs the JWT issuer it belongs to"))
+	}
+	if c.JWTIssuer != "" && c.JWTUserClaim == "" {
+		errs = append(errs, errors.New("JWT user claim must not be empty"))
+	}
+	if c.OIDCClientID != "" && (c.OIDCClientSecret == "" || c.OIDCIssuer == "") {
+		errs = append(errs, errors.New("OIDC login needs a client secret and issuer"))
+	}
+	switch c.RecipeStore {
+	case "sqlite", "memory":
+	case "postgres":
+		if c.RecipeStoreURL == "" {
+			errs = append(errs, errors.New("recipe store postgres needs a connection string"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("recipe store must be sqlite, postgres or memory, got %q", c.RecipeStore))
+	}
+	switch c.VectorStore {
+	case "memory":
+	case "pgvector", "pinecone":
+		if c.VectorStoreURL == "" {
+			errs = append(errs, fmt.Errorf("vector store %s needs a URL", c.VectorStore))
+		}
+		if c.VectorStore == "pinecone" && c.VectorStoreAPIKey == "" {
+			errs = append(errs, errors.New("vector store pinecone needs an API key"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("vector store must be memory, pgvector or pinecone, got %q", c.VectorStore))
+	}
+	if c.EmbeddingModel == "" {
+		errs = append(errs, errors.New("embedding model must not be empty"))
+	}
+	if c.SemanticCacheThreshold < 0 || c.SemanticCacheThreshold > 1 {
+		errs = append(errs, fmt.Errorf("semantic cache threshold must be between 0 and 1, got %g", c.SemanticCacheThreshold))
+	}
+	if (c.SearchAPIKey == "") != (c.SearchEngineID == "") {
+		errs = append(errs, errors.New("web search needs both an API key and a search engine ID"))
+	}
+	if c.SessionTTL <= 0 {
+		errs = append(errs, errors.New("session TTL must be positive"))
+	}
+	if c.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("OTLP endpoint must be an http(s) URL, got %q", c.OTLPEndpoint))
+		}
+	}
+	switch c.AccessLogFormat {
+	case "json", "combined", "text", "off":
+	default:
+		errs = append(errs, fmt.Errorf("access log format must be json, combined, text or off, got %q", c.AccessLogFormat))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS certificate and key files must be set together"))
+	}
+	if c.TLSCertFile != "" && len(c.AutocertDomains) > 0 {
+		errs = append(errs, errors.New("use either TLS certificate files or autocert domains, not both"))
+	}
+	if len(c.AutocertDomains) > 0 && c.AutocertCacheDir == "" {
+		errs = append(errs, errors.New("autocert needs a cache directory"))
+	}
+	for _, l := range c.Listen {
+		if err := validateListener(l); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, p := range c.TrustedProxies {
+		if _, err := netip.ParsePrefix(p); err != nil && p != "unix" {
+			if _, err := netip.ParseAddr(p); err != nil {
+				errs = append(errs, fmt.Errorf("trusted proxy %q must be a CIDR, an IP or unix", p))
+			}
+		}
+	}
+	if c.HTTPRedirectPort != 0 {
+		switch {
+		case !c.TLS():
+			errs = append(errs, errors.New("the HTTP redirect port needs TLS to be enabled"))
+		case c.HTTPRedirectPort < 1 || c.HTTPRedirectPort > 65535 || c.HTTPRedirectPort == c.Port:
+			errs = append(errs, fmt.Errorf("HTTP redirect port must be between 1 and 65535 and differ from the port, got %d", c.HTTPRedirectPort))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Addr is the host:port the server listens on
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port))
+}
+
+// Listeners are the addresses the server accepts connections on
+func (c *Config) Listeners() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{c.Addr()}
+}
+
+// validateListener checks one listen address
+func validateListener(l string) error {
+	if l == "systemd" {
+		return nil
+	}
+	if path, ok := strings.CutPrefix(l, "unix:"); ok {
+		if path == "" {
+			return fmt.Errorf("listener %q needs a socket path", l)
+		}
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(l); err != nil || port == "" {
+		return fmt.Errorf("listener %q must be host:port, unix:/path or systemd", l)
+	}
+	return nil
+}
+
+// TLS reports whether the server is served over HTTPS
+func (c *Config) TLS() bool {
+	return c.TLSCertFile != "" || len(c.AutocertDomains) > 0
+}
+
+// RedirectAddr is the host:port of the HTTP to HTTPS redirect, if enabled
+func (c *Config) RedirectAddr() string {
+	return net.JoinHostPort(c.BindAddress, strconv.Itoa(c.HTTPRedirectPort))
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Redacted returns a copy that is safe to show, with secrets masked
+func (c *Config) Redacted() *Config {
+	r := *c
+	for _, secret := range []*string{&r.GeminiAPIKey, &r.OpenAIAPIKey, &r.WebhookSecret, &r.FDCAPIKey, &r.SearchAPIKey, &r.VectorStoreAPIKey, &r.AdminAPIKey, &r.OIDCClientSecret} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	for _, conn := range []*string{&r.VectorStoreURL, &r.RecipeStoreURL} {
+		*conn = redactConnString(*conn)
+	}
+	return &r
+}
+
+// A password in a key/value Postgres connection string, quoted or not
+var dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redactConnString masks the password of a Postgres connection string,
+// whether it is a URL (in the user info or ?password=) or key/value pairs
+func redactConnString(conn string) string {
+	if u, err := url.Parse(conn); err == nil && u.Scheme != "" && u.Host != "" {
+		query := u.Query()
+		if query.Has("password") {
+			query.Set("password", "xxxxx")
+			u.RawQuery = query.Encode()
+		}
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(conn, "${1}"+redacted)
+}
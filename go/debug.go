@@ -0,0 +1,32 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strconv"
+)
+
+// registerDebugRoutes adds the pprof profiles, expvar counters and a
+// goroutine dump under /debug/. They reveal internals, so they belong in
+// the admin group
+func registerDebugRoutes(rg *routeGroup) {
+	rg.HandleFunc("GET /debug/pprof/", pprof.Index)
+	rg.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	rg.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	rg.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	rg.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	rg.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	rg.Handle("GET /debug/vars", expvar.Handler())
+	rg.HandleFunc("GET /debug/goroutines", goroutinesHandler)
+}
+
+// goroutinesHandler dumps the stack of every goroutine as text, the
+// quickest way to see what a stuck or leaking server is doing
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Goroutine-Count", strconv.Itoa(runtime.NumGoroutine()))
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const (
+	// Allowed deviation of a day's totals from the daily targets
+	dietCalorieTolerance = 0.05
+	dietMacroTolerance   = 0.10
+	dietMacroSlackG      = 5.0
+
+	// Generation attempts before giving up on a plan that doesn't add up
+	dietPlanAttempts = 3
+)
+
+// Define input schema for diet plan requests
+type DietPlanInput struct {
+	DailyCalories float64  `json:"dailyCalories" jsonschema:"description=Daily calorie target,required=true"`
+	ProteinG      float64  `json:"proteinG,omitempty" jsonschema:"description=Daily protein target in grams"`
+	CarbsG        float64  `json:"carbsG,omitempty" jsonschema:"description=Daily carbohydrate target in grams"`
+	FatG          float64  `json:"fatG,omitempty" jsonschema:"description=Daily fat target in grams"`
+	Allergies     []string `json:"allergies,omitempty" jsonschema:"description=Foods that must be avoided"`
+	ActivityLevel string   `json:"activityLevel,omitempty" jsonschema:"description=sedentary\\, light\\, moderate\\, active or very active"`
+	Days          int      `json:"days,omitempty" jsonschema:"description=Number of days to plan (default 7)"`
+	MealsPerDay   int      `json:"mealsPerDay,omitempty" jsonschema:"description=Meals and snacks per day (default 3)"`
+}
+
+// Calories and macronutrients of a meal or a day
+type Macros struct {
+	Calories float64 `json:"calories"`
+	ProteinG float64 `json:"proteinG"`
+	CarbsG   float64 `json:"carbsG"`
+	FatG     float64 `json:"fatG"`
+}
+
+// add returns the sum of two macro sets
+func (m Macros) add(o Macros) Macros {
+	return Macros{
+		Calories: m.Calories + o.Calories,
+		ProteinG: m.ProteinG + o.ProteinG,
+		CarbsG:   m.CarbsG + o.CarbsG,
+		FatG:     m.FatG + o.FatG,
+	}
+}
+
+// A single meal in the diet plan
+type DietMeal struct {
+	Meal        string   `json:"meal" jsonschema:"description=breakfast\\, lunch\\, dinner or snack"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Ingredients []string `json:"ingredients"`
+	Macros      Macros   `json:"macros"`
+}
+
+// Meals and computed totals for one day
+type DietPlanDay struct {
+	Day    int        `json:"day"`
+	Meals  []DietMeal `json:"meals"`
+	Totals Macros     `json:"totals"`
+}
+
+// Define output schema for a diet plan
+type DietPlan struct {
+	DailyTarget Macros        `json:"dailyTarget"`
+	Days        []DietPlanDay `json:"days"`
+	Notes       string        `json:"notes,omitempty"`
+}
+
+// Define the personalized diet plan flow
+func defineDietPlanFlow(g *genkit.Genkit) *core.Flow[*DietPlanInput, *DietPlan, struct{}] {
+	return genkit.DefineFlow(g, "dietPlanFlow", func(ctx context.Context, input *DietPlanInput) (*DietPlan, error) {
+		target, err := dietTarget(input)
+		if err != nil {
+			return nil, err
+		}
+
+		// Set default values
+		days := input.Days
+		if days == 0 {
+			days = 7
+		}
+		if days < 0 || days > maxMealPlanDays {
+			return nil, newInputError("days must be between 1 and %d", maxMealPlanDays)
+		}
+
+		mealsPerDay := input.MealsPerDay
+		if mealsPerDay == 0 {
+			mealsPerDay = 3
+		}
+		if mealsPerDay < 1 || mealsPerDay > 6 {
+			return nil, newInputError("mealsPerDay must be between 1 and 6")
+		}
+
+		activityLevel := input.ActivityLevel
+		if activityLevel == "" {
+			activityLevel = "moderate"
+		}
+
+		allergies := "none"
+		if len(input.Allergies) > 0 {
+			allergies = strings.Join(input.Allergies, ", ")
+		}
+
+		macroTargets := "choose a balanced split for the activity level"
+		if target.ProteinG > 0 {
+			macroTargets = fmt.Sprintf("%.0fg protein, %.0fg carbohydrates, %.0fg fat", target.ProteinG, target.CarbsG, target.FatG)
+		}
+
+		prompt := fmt.Sprintf(`Create a %d-day diet plan with %d meals per day.
+
+		Daily calorie target: %.0f kcal
+		Daily macro targets: %s
+		Activity level: %s
+		Allergies (never include these): %s
+
+		For every meal provide a name, a short description, ingredients with quantities,
+		and its calories, protein, carbohydrates and fat. The meals of each day must add up
+		to the daily targets within %.0f%% for calories and %.0f%% for each macro. Calories
+		should be consistent with the macros (4 kcal/g protein and carbohydrates, 9 kcal/g fat).`,
+			days, mealsPerDay, target.Calories, macroTargets, activityLevel, allergies,
+			dietCalorieTolerance*100, dietMacroTolerance*100)
+
+		// Regenerate with the validation errors as feedback until the totals add up
+		var problems []string
+		for attempt := 1; attempt <= dietPlanAttempts; attempt++ {
+			attemptPrompt := prompt
+			if len(problems) > 0 {
+				attemptPrompt += "\n\n\t\tThe previous plan was rejected because:\n\t\t- " + strings.Join(problems, "\n\t\t- ")
+			}
+
+			plan, _, err := genkit.GenerateData[DietPlan](ctx, g,
+				ai.WithPrompt(attemptPrompt),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate diet plan: %w", err)
+			}
+
+			plan.DailyTarget = target
+			problems = plan.validate(days)
+			if len(problems) == 0 {
+				return plan, nil
+			}
+		}
+
+		return nil, fmt.Errorf("generated diet plan does not meet the targets: %s", strings.Join(problems, "; "))
+	})
+}
+
+// dietTarget validates the requested daily targets
+func dietTarget(input *DietPlanInput) (Macros, error) {
+	target := Macros{
+		Calories: input.DailyCalories,
+		ProteinG: input.ProteinG,
+		CarbsG:   input.CarbsG,
+		FatG:     input.FatG,
+	}
+
+	if target.Calories < 1000 || target.Calories > 6000 {
+		return target, newInputError("dailyCalories must be between 1000 and 6000")
+	}
+	if target.ProteinG < 0 || target.CarbsG < 0 || target.FatG < 0 {
+		return target, newInputError("macro targets cannot be negative")
+	}
+
+	// Macro targets are all-or-nothing and must roughly match the calories
+	given := 0
+	for _, v := range []float64{target.ProteinG, target.CarbsG, target.FatG} {
+		if v > 0 {
+			given++
+		}
+	}
+	switch given {
+	case 0:
+		return target, nil
+	case 3:
+		if !withinTolerance(macroCalories(target), target.Calories, dietMacroTolerance, 0) {
+			return target, newInputError("macro targets add up to %.0f kcal, which doesn't match the %.0f kcal target",
+				macroCalories(target), target.Calories)
+		}
+		return target, nil
+	default:
+		return target, newInputError("proteinG, carbsG and fatG must be given together")
+	}
+}
+
+// validate recomputes each day's totals from its meals and reports every
+// place the plan misses the daily target
+func (p *DietPlan) validate(days int) []string {
+	var problems []string
+	if len(p.Days) != days {
+		problems = append(problems, fmt.Sprintf("expected %d days but got %d", days, len(p.Days)))
+	}
+
+	target := p.DailyTarget
+	for i := range p.Days {
+		day := &p.Days[i]
+
+		var totals Macros
+		for _, meal := range day.Meals {
+			if !withinTolerance(macroCalories(meal.Macros), meal.Macros.Calories, dietMacroTolerance, 20) {
+				problems = append(problems, fmt.Sprintf("day %d %s: %.0f kcal doesn't match its macros (%.0f kcal)",
+					day.Day, meal.Name, meal.Macros.Calories, macroCalories(meal.Macros)))
+			}
+			totals = totals.add(meal.Macros)
+		}
+		day.Totals = roundMacros(totals)
+
+		if !withinTolerance(totals.Calories, target.Calories, dietCalorieTolerance, 0) {
+			problems = append(problems, fmt.Sprintf("day %d totals %.0f kcal instead of %.0f kcal",
+				day.Day, totals.Calories, target.Calories))
+		}
+		if target.ProteinG == 0 {
+			continue
+		}
+		for _, m := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"protein", totals.ProteinG, target.ProteinG},
+			{"carbohydrates", totals.CarbsG, target.CarbsG},
+			{"fat", totals.FatG, target.FatG},
+		} {
+			if !withinTolerance(m.got, m.want, dietMacroTolerance, dietMacroSlackG) {
+				problems = append(problems, fmt.Sprintf("day %d totals %.0fg %s instead of %.0fg",
+					day.Day, m.got, m.name, m.want))
+			}
+		}
+	}
+	return problems
+}
+
+// macroCalories computes the calories implied by the macros
+func macroCalories(m Macros) float64 {
+	return 4*m.ProteinG + 4*m.CarbsG + 9*m.FatG
+}
+
+// withinTolerance reports whether got is within a relative tolerance of want,
+// with an absolute slack for small values
+func withinTolerance(got, want, tolerance, slack float64) bool {
+	return math.Abs(got-want) <= math.Max(want*tolerance, slack)
+}
+
+// roundMacros rounds every value to one decimal place
+func roundMacros(m Macros) Macros {
+	round := func(v float64) float64 { return math.Round(v*10) / 10 }
+	return Macros{
+		Calories: round(m.Calories),
+		ProteinG: round(m.ProteinG),
+		CarbsG:   round(m.CarbsG),
+		FatG:     round(m.FatG),
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const (
+	// Model used to render finished dishes
+	dishImageModel = "googleai/imagen-3.0-generate-002"
+
+	// How long a recipe response waits for its image after the recipe is
+	// ready before returning a pending placeholder
+	dishImageWait = 5 * time.Second
+
+	// Upper bound for a background image generation
+	dishImageTimeout = 2 * time.Minute
+
+	// How long generated images are kept and how many at most
+	dishImageTTL        = time.Hour
+	maxStoredDishImages = 256
+)
+
+// Image generation states
+const (
+	imageStatusPending = "pending"
+	imageStatusReady   = "ready"
+	imageStatusFailed  = "failed"
+)
+
+// A generated image of the finished dish; URL serves the image once ready
+type DishImage struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	URL    string `json:"url"`
+}
+
+// dishImageEntry is a stored image and its generation state
+type dishImageEntry struct {
+	status      string
+	contentType string
+	data        []byte
+	err         error
+	created     time.Time
+	done        chan struct{}
+	// Stops the generation when the recipe it was for isn't returned
+	cancel context.CancelFunc
+}
+
+// dishImageStore keeps generated dish images in memory so they can be
+// served after the recipe response has been sent
+type dishImageStore struct {
+	mu     sync.Mutex
+	images map[string]*dishImageEntry
+}
+
+// Images generated for recipe responses
+var dishImages = &dishImageStore{images: make(map[string]*dishImageEntry)}
+
+// newID returns a random 128-bit hex identifier
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// start begins generating an image of the dish in the background and
+// returns the id and a channel that is closed when generation finishes
+func (s *dishImageStore) start(ctx context.Context, g *genkit.Genkit, dish string) (string, <-chan struct{}) {
+	id := newID()
+	stopped, cancel := context.WithCancel(context.Background())
+	entry := &dishImageEntry{
+		status:  imageStatusPending,
+		created: time.Now(),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+	}
+
+	s.mu.Lock()
+	s.evictLocked()
+	s.images[id] = entry
+	s.mu.Unlock()
+
+	// Keep generating even if the request that asked for it goes away
+	goBackground(ctx, dishImageTimeout, func(ctx context.Context) {
+		defer close(entry.done)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(stopped, cancel)()
+
+		contentType, data, err := generateDishImage(ctx, g, dish)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err != nil {
+			if stopped.Err() == nil {
+				log.Printf("Error generating image for %s: %v", dish, err)
+			}
+			entry.status, entry.err = imageStatusFailed, err
+			return
+		}
+		entry.status, entry.contentType, entry.data = imageStatusReady, contentType, data
+	})
+
+	return id, entry.done
+}
+
+// cancel stops generating an image nobody will fetch and forgets it
+func (s *dishImageStore) cancel(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.images[id]; ok {
+		entry.cancel()
+		delete(s.images, id)
+	}
+}
+
+// describe returns the public view of a stored image
+func (s *dishImageStore) describe(id string) *DishImage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	image := &DishImage{ID: id, Status: imageStatusFailed, URL: "/api/images/" + id}
+	if entry, ok := s.images[id]; ok {
+		image.Status = entry.status
+	}
+	return image
+}
+
+// evictLocked drops expired images and, if still full, the oldest ones
+func (s *dishImageStore) evictLocked() {
+	for id, entry := range s.images {
+		if time.Since(entry.created) > dishImageTTL {
+			delete(s.images, id)
+		}
+	}
+	for len(s.images) >= maxStoredDishImages {
+		var oldestID string
+		var oldest time.Time
+		for id, entry := range s.images {
+			if oldestID == "" || entry.created.Before(oldest) {
+				oldestID, oldest = id, entry.created
+			}
+		}
+		delete(s.images, oldestID)
+	}
+}
+
+// generateDishImage renders the dish with the image model and returns the
+// decoded image bytes
+func generateDishImage(ctx context.Context, g *genkit.Genkit, dish string) (string, []byte, error) {
+	resp, err := genkit.Generate(ctx, g,
+		ai.WithModelName(dishImageModel),
+		ai.WithPrompt(fmt.Sprintf(`A professional, appetizing food photograph of %s, plated and ready to serve.
+		Natural light, shallow depth of field, no text or people.`, dish)),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	recordUsage(ctx, dishImageModel, resp.Usage)
+
+	for _, part := range resp.Message.Content {
+		if !part.IsMedia() {
+			continue
+		}
+		header, payload, ok := strings.Cut(part.Text, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return "", nil, fmt.Errorf("unexpected image encoding")
+		}
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		contentType := part.ContentType
+		if contentType == "" {
+			contentType = strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+		}
+		return contentType, data, nil
+	}
+	return "", nil, fmt.Errorf("the model returned no image")
+}
+
+// dishImageHandler serves a generated image, or its status while it is
+// still pending or after it failed
+func dishImageHandler(w http.ResponseWriter, r *http.Request) {
+	dishImages.mu.Lock()
+	entry, ok := dishImages.images[r.PathValue("id")]
+	var status, contentType string
+	var data []byte
+	var genErr error
+	if ok {
+		status, contentType, data, genErr = entry.status, entry.contentType, entry.data, entry.err
+	}
+	dishImages.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "Image Not Found", "The image does not exist or has expired")
+	case status == imageStatusPending:
+		w.Header().Set("Retry-After", "2")
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": status})
+	case status == imageStatusFailed:
+		writeError(w, http.StatusInternalServerError, "Image Generation Failed", genErr.Error())
+	default:
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
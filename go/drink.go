@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for drink recipe requests
+type DrinkInput struct {
+	DrinkName    string `json:"drinkName" jsonschema:"description=Name or style of the drink,required=true"`
+	Servings     int    `json:"servings,omitempty" jsonschema:"description=Number of drinks to make (default 1)"`
+	Preferences  string `json:"preferences,omitempty" jsonschema:"description=Taste preferences (e.g. less sweet\\, smoky\\, no egg white)"`
+	NonAlcoholic bool   `json:"nonAlcoholic,omitempty" jsonschema:"description=Make the main recipe alcohol-free"`
+}
+
+// An alcohol-free version of a drink
+type MocktailVariant struct {
+	Name         string   `json:"name"`
+	Ingredients  []string `json:"ingredients"`
+	Instructions []string `json:"instructions"`
+}
+
+// Define output schema for drink recipe response
+type DrinkRecipe struct {
+	Name         string           `json:"name"`
+	Description  string           `json:"description"`
+	Method       string           `json:"method" jsonschema:"description=shaken\\, stirred\\, built\\, blended or thrown"`
+	Glassware    string           `json:"glassware"`
+	Ice          string           `json:"ice,omitempty"`
+	Garnish      string           `json:"garnish"`
+	Servings     int              `json:"servings"`
+	Ingredients  []string         `json:"ingredients"`
+	Instructions []string         `json:"instructions"`
+	ABVPercent   float64          `json:"abvPercent" jsonschema:"description=Estimated alcohol by volume of the finished drink"`
+	Mocktail     *MocktailVariant `json:"mocktail,omitempty"`
+}
+
+// Define the drink recipe flow
+func defineDrinkRecipeFlow(g *genkit.Genkit) *core.Flow[*DrinkInput, *DrinkRecipe, struct{}] {
+	return genkit.DefineFlow(g, "drinkRecipeFlow", func(ctx context.Context, input *DrinkInput) (*DrinkRecipe, error) {
+		if strings.TrimSpace(input.DrinkName) == "" {
+			return nil, newInputError("drink name is required")
+		}
+
+		// Set default values
+		servings := input.Servings
+		if servings == 0 {
+			servings = 1
+		}
+
+		preferences := input.Preferences
+		if preferences == "" {
+			preferences = "none"
+		}
+
+		style := "a classic drink recipe, plus an alcohol-free mocktail variant"
+		if input.NonAlcoholic {
+			style = "an alcohol-free drink recipe with an ABV of 0"
+		}
+
+		prompt := fmt.Sprintf(`Create %s for "%s":
+
+		Servings: %d
+		Preferences: %s
+
+		Please provide:
+		1. A brief description of the drink
+		2. The method, glassware, ice and garnish
+		3. Ingredients with precise measurements in ml and oz
+		4. Step-by-step instructions
+		5. An estimate of the alcohol by volume of the finished drink, accounting for dilution`,
+			style, input.DrinkName, servings, preferences)
+
+		drink, _, err := genkit.GenerateData[DrinkRecipe](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate drink recipe for %s: %w", input.DrinkName, err)
+		}
+
+		if drink.Name == "" {
+			drink.Name = input.DrinkName
+		}
+		if drink.Servings == 0 {
+			drink.Servings = servings
+		}
+		drink.ABVPercent = math.Round(drink.ABVPercent*10) / 10
+		if input.NonAlcoholic {
+			drink.ABVPercent = 0
+			drink.Mocktail = nil
+		}
+
+		return drink, nil
+	})
+}
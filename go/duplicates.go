@@ -0,0 +1,75 @@
+package main
+
+import "strings"
+
+// How alike a new recipe must be to one the account already has to count
+// as a copy of it
+const (
+	// Recipes named nearly the same need most of the same ingredients
+	duplicateNameSimilarity  = 0.8
+	duplicateIngredientShare = 0.7
+
+	// Recipes named only somewhat alike need almost all of them
+	duplicateLooseNameSimilarity  = 0.5
+	duplicateLooseIngredientShare = 0.9
+)
+
+// Words left out when comparing recipe names
+var nameStopWords = map[string]bool{"a": true, "an": true, "and": true, "the": true, "with": true, "of": true, "in": true}
+
+// nameWords returns the words of a recipe name, singular and without
+// filler words, so "Tomatoes with Basil" and "Tomato Basil" match
+func nameWords(name string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.Fields(normalizeFoodText(name)) {
+		if !nameStopWords[word] {
+			words[ingredientKey(word)] = true
+		}
+	}
+	return words
+}
+
+// ingredientNames returns the ingredients of a recipe without their
+// quantities and notes
+func ingredientNames(lines []string) map[string]bool {
+	names := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if name := ingredientKey(parseIngredientLine(line).name); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// jaccard is the share of the words in either set that are in both
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	both := 0
+	for word := range a {
+		if b[word] {
+			both++
+		}
+	}
+	return float64(both) / float64(len(a)+len(b)-both)
+}
+
+// findDuplicate looks through the recipes an account generated for one
+// that is a near-identical copy of a new recipe: a similar name and a large
+// overlap of ingredients. Of several it returns the closest
+func findDuplicate(account string, recipe *FoodRecipe) (storedItem[FoodRecipe], bool) {
+	var best storedItem[FoodRecipe]
+	bestScore := 0.0
+	name, ingredients := nameWords(recipe.Name), ingredientNames(recipe.Ingredients)
+	for _, item := range savedRecipes.history(account) {
+		nameScore := jaccard(name, nameWords(item.item.Name))
+		share := jaccard(ingredients, ingredientNames(item.item.Ingredients))
+		duplicate := nameScore >= duplicateNameSimilarity && share >= duplicateIngredientShare ||
+			nameScore >= duplicateLooseNameSimilarity && share >= duplicateLooseIngredientShare
+		if duplicate && nameScore+share > bestScore {
+			best, bestScore = item.storedItem, nameScore+share
+		}
+	}
+	return best, bestScore > 0
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Names of the recipe quality evaluators
+const (
+	completenessEvaluator = "recipeQuality/completeness"
+	clarityEvaluator      = "recipeQuality/clarity"
+	constraintsEvaluator  = "recipeQuality/constraints"
+)
+
+// Score from which an evaluation passes
+const qualityPassScore = 0.8
+
+// Quality scores of a generated recipe, each from 0 to 1
+type RecipeQuality struct {
+	Completeness float64  `json:"completeness" jsonschema:"description=Share of the expected recipe parts present"`
+	Clarity      float64  `json:"clarity" jsonschema:"description=Share of instruction steps that are one clear action with the cues needed to follow it"`
+	Constraints  float64  `json:"constraints" jsonschema:"description=Share of the requested constraints the recipe keeps"`
+	Issues       []string `json:"issues,omitempty"`
+}
+
+// An evaluator's scoring of a recipe generated for an input
+type recipeScorer func(input *FoodInput, recipe *FoodRecipe) (float64, []string)
+
+// Leading amounts and the measures that stand in for one
+var ingredientMeasurePattern = regexp.MustCompile(`(?i)^\s*(a|an|one|two|three|half|pinch|dash|handful|some)\b|\bto taste\b`)
+
+// scoreCompleteness checks the recipe has every part a cook needs
+func scoreCompleteness(input *FoodInput, r *FoodRecipe) (float64, []string) {
+	var issues []string
+	check := func(ok bool, issue string) {
+		if !ok {
+			issues = append(issues, issue)
+		}
+	}
+	check(strings.TrimSpace(r.Description) != "", "no description")
+	check(r.PrepTime != "" && r.CookTime != "" && r.TotalTime != "", "missing prep, cook or total time")
+	check(r.Servings > 0, "no serving count")
+	check(len(r.Ingredients) >= 2, "fewer than two ingredients")
+	check(len(r.Instructions) >= 2, "fewer than two instruction steps")
+	check(r.Nutrition != nil && r.Nutrition.Calories > 0, "no nutrition facts")
+
+	unmeasured := 0
+	for _, ingredient := range r.Ingredients {
+		if !ingredientAmountPattern.MatchString(strings.TrimSpace(ingredient)) && !ingredientMeasurePattern.MatchString(ingredient) {
+			unmeasured++
+		}
+	}
+	check(unmeasured == 0, fmt.Sprintf("%d ingredient(s) without a quantity", unmeasured))
+
+	const checks = 7
+	return float64(checks-len(issues)) / checks, issues
+}
+
+// Steps that apply heat need a time, temperature or doneness cue
+var (
+	heatKeywords = []string{"bake", "roast", "boil", "simmer", "fry", "sear", "sauté", "saute", "grill", "broil", "cook", "braise", "toast", "steam", "poach"}
+	cuePattern   = regexp.MustCompile(`(?i)\d|minute|hour|second|until|golden|tender|degrees|°|low heat|medium heat|high heat`)
+)
+
+// Bounds on the words of a clear instruction step
+const (
+	minStepWords = 4
+	maxStepWords = 60
+)
+
+// scoreClarity checks each instruction step is one readable action, and
+// that steps applying heat say how long or until when
+func scoreClarity(input *FoodInput, r *FoodRecipe) (float64, []string) {
+	if len(r.Instructions) == 0 {
+		return 0, []string{"no instructions"}
+	}
+	var issues []string
+	clear := 0
+	for i, step := range r.Instructions {
+		words := len(strings.Fields(step))
+		switch {
+		case words < minStepWords:
+			issues = append(issues, fmt.Sprintf("step %d is too terse", i+1))
+		case words > maxStepWords:
+			issues = append(issues, fmt.Sprintf("step %d is too long to follow as one step", i+1))
+		case containsAny(strings.ToLower(step), heatKeywords) && !cuePattern.MatchString(step):
+			issues = append(issues, fmt.Sprintf("step %d applies heat without a time, temperature or doneness cue", i+1))
+		default:
+			clear++
+		}
+	}
+	return float64(clear) / float64(len(r.Instructions)), issues
+}
+
+// Ingredients no vegetarian recipe has
+var meatKeywords = []string{"beef", "pork", "chicken", "lamb", "mutton", "veal", "turkey", "duck", "bacon", "ham",
+	"sausage", "chorizo", "salami", "pancetta", "prosciutto", "mince", "steak", "fish", "salmon", "tuna", "cod",
+	"anchovy", "anchovies", "gelatin", "gelatine", "lard", "stock cube"}
+
+// Weights and volumes that baking mode asks ingredients in
+var metricMeasurePattern = regexp.MustCompile(`(?i)\d\s*(g|kg|ml|l|grams?|kilograms?|millilit(er|re)s?|lit(er|re)s?)\b`)
+
+// scoreConstraints checks the recipe keeps what the request asked for
+func scoreConstraints(input *FoodInput, r *FoodRecipe) (float64, []string) {
+	req, err := newRecipeRequest(input)
+	if err != nil {
+		return 0, []string{"invalid request: " + err.Error()}
+	}
+	var issues []string
+	checks := 0
+	check := func(ok bool, issue string) {
+		checks++
+		if !ok {
+			issues = append(issues, issue)
+		}
+	}
+
+	check(r.Servings == req.ServingSize, fmt.Sprintf("makes %d servings instead of %d", r.Servings, req.ServingSize))
+	check(strings.EqualFold(r.Difficulty, req.Difficulty), fmt.Sprintf("difficulty is %s instead of %s", r.Difficulty, req.Difficulty))
+
+	if restricted := restrictedAllergens(req.DietaryRestrictions); len(restricted) > 0 {
+		var violations []string
+		for _, ingredient := range r.Ingredients {
+			for _, a := range detectAllergens(ingredient) {
+				if restricted[a] {
+					violations = append(violations, fmt.Sprintf("%s (%s)", ingredient, a))
+				}
+			}
+		}
+		check(len(violations) == 0, "contains restricted allergens: "+strings.Join(violations, "; "))
+	}
+	diet := normalizeFoodText(req.DietaryRestrictions)
+	if containsWord(diet, "vegetarian") || containsWord(diet, "vegan") || containsWord(diet, "plant based") {
+		meat := slices.DeleteFunc(slices.Clone(r.Ingredients), func(ingredient string) bool {
+			return !containsAny(strings.ToLower(ingredient), meatKeywords)
+		})
+		check(len(meat) == 0, "not vegetarian: "+strings.Join(meat, "; "))
+	}
+
+	if avoided := avoidedIngredients(req.AvoidIngredients); len(avoided) > 0 {
+		var used []string
+		for _, ingredient := range r.Ingredients {
+			text := normalizeFoodText(ingredient)
+			if slices.ContainsFunc(avoided, func(avoid string) bool { return containsWord(text, avoid) }) {
+				used = append(used, ingredient)
+			}
+		}
+		check(len(used) == 0, "uses ingredients to avoid: "+strings.Join(used, "; "))
+	}
+
+	if req.Budget != nil {
+		check(r.EstimatedCost > 0 && r.EstimatedCost <= req.Budget.Max,
+			fmt.Sprintf("estimated cost %.2f is over the budget of %.2f", r.EstimatedCost, req.Budget.Max))
+	}
+	if req.BakingMode {
+		byVolume := 0
+		for _, ingredient := range r.Ingredients {
+			if !metricMeasurePattern.MatchString(ingredient) {
+				byVolume++
+			}
+		}
+		check(byVolume == 0, fmt.Sprintf("%d ingredient(s) not given by weight in baking mode", byVolume))
+		check(r.BakingDetails != nil, "no baking details")
+	}
+	if req.KidFriendly {
+		check(len(r.KidSteps) > 0, "no kid-friendly steps")
+	}
+	if req.Grounded {
+		check(len(r.Sources) > 0, "no sources cited")
+	}
+	return float64(checks-len(issues)) / float64(checks), issues
+}
+
+// Scorers behind the evaluators, by evaluator name
+var recipeScorers = map[string]recipeScorer{
+	completenessEvaluator: scoreCompleteness,
+	clarityEvaluator:      scoreClarity,
+	constraintsEvaluator:  scoreConstraints,
+}
+
+// What each evaluator checks, as shown in the developer UI
+var recipeEvaluatorDefinitions = map[string]string{
+	completenessEvaluator: "Checks the recipe has a description, times, servings, measured ingredients, several steps and nutrition facts",
+	clarityEvaluator:      "Checks each instruction step is one readable action and that steps applying heat give a time, temperature or doneness cue",
+	constraintsEvaluator:  "Checks the recipe keeps the requested servings, difficulty, dietary restrictions, budget and modes",
+}
+
+// defineRecipeEvaluators registers the recipe quality evaluators. Their
+// examples are flow runs: the FoodInput as input and the FoodRecipe as
+// output, so they also score datasets in the developer UI
+func defineRecipeEvaluators(g *genkit.Genkit) {
+	for _, name := range slices.Sorted(maps.Keys(recipeScorers)) {
+		score := recipeScorers[name]
+		genkit.DefineEvaluator(g, name, &ai.EvaluatorOptions{DisplayName: name, Definition: recipeEvaluatorDefinitions[name]},
+			func(ctx context.Context, req *ai.EvaluatorCallbackRequest) (*ai.EvaluatorCallbackResponse, error) {
+				var input FoodInput
+				var recipe FoodRecipe
+				if err := remarshal(req.Input.Input, &input); err != nil {
+					return nil, fmt.Errorf("example input is not a recipe request: %w", err)
+				}
+				if err := remarshal(req.Input.Output, &recipe); err != nil {
+					return nil, fmt.Errorf("example output is not a recipe: %w", err)
+				}
+				value, issues := score(&input, &recipe)
+				status := ai.ScoreStatusPass
+				if value < qualityPassScore {
+					status = ai.ScoreStatusFail
+				}
+				return &ai.EvaluatorCallbackResponse{
+					TestCaseId: req.Input.TestCaseId,
+					Evaluation: []ai.Score{{Score: value, Status: status.String(), Details: map[string]any{"issues": issues}}},
+				}, nil
+			})
+	}
+}
+
+// remarshal converts decoded JSON, or a value of another type, to out
+func remarshal(in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// evaluateRecipe runs the quality evaluators on a generated recipe. A
+// failed evaluation is logged and leaves the recipe unscored
+func evaluateRecipe(ctx context.Context, g *genkit.Genkit, input *FoodInput, recipe *FoodRecipe) *RecipeQuality {
+	example := &ai.Example{TestCaseId: newID(), Input: input, Output: recipe}
+	quality := &RecipeQuality{}
+	for name, field := range map[string]*float64{
+		completenessEvaluator: &quality.Completeness,
+		clarityEvaluator:      &quality.Clarity,
+		constraintsEvaluator:  &quality.Constraints,
+	} {
+		resp, err := genkit.Evaluate(ctx, g, ai.WithEvaluatorName(name), ai.WithDataset(example))
+		if err == nil && (len(*resp) != 1 || len((*resp)[0].Evaluation) != 1) {
+			err = fmt.Errorf("no score returned")
+		}
+		if err != nil {
+			log.Printf("Evaluator %s failed: %v", name, err)
+			return nil
+		}
+		s := (*resp)[0].Evaluation[0]
+		if s.Error != "" {
+			log.Printf("Evaluator %s failed: %s", name, s.Error)
+			return nil
+		}
+		value, ok := s.Score.(float64)
+		if !ok {
+			log.Printf("Evaluator %s returned a %T score", name, s.Score)
+			return nil
+		}
+		*field = math.Round(value*100) / 100
+		var issues []string
+		if err := remarshal(s.Details["issues"], &issues); err == nil {
+			quality.Issues = append(quality.Issues, issues...)
+		}
+	}
+	slices.Sort(quality.Issues)
+	return quality
+}
+
+// Average quality of a group of recipes
+type QualityGroupStats struct {
+	Group        string  `json:"group"`
+	Recipes      int64   `json:"recipes"`
+	Completeness float64 `json:"completeness"`
+	Clarity      float64 `json:"clarity"`
+	Constraints  float64 `json:"constraints"`
+	PassRate     float64 `json:"passRate" jsonschema:"description=Share of recipes passing every evaluator"`
+}
+
+// Quality of the recipes generated since the server started, overall and
+// by model and prompt version
+type QualityStats struct {
+	Overall         QualityGroupStats   `json:"overall"`
+	ByModel         []QualityGroupStats `json:"byModel"`
+	ByPromptVersion []QualityGroupStats `json:"byPromptVersion"`
+}
+
+// qualityTotals sums the scores of a group of recipes
+type qualityTotals struct {
+	recipes, passed                    int64
+	completeness, clarity, constraints float64
+}
+
+// add counts a recipe's scores
+func (t *qualityTotals) add(q *RecipeQuality) {
+	t.recipes++
+	t.completeness += q.Completeness
+	t.clarity += q.Clarity
+	t.constraints += q.Constraints
+	if min(q.Completeness, q.Clarity, q.Constraints) >= qualityPassScore {
+		t.passed++
+	}
+}
+
+// stats averages the group's scores
+func (t *qualityTotals) stats(group string) QualityGroupStats {
+	s := QualityGroupStats{Group: group, Recipes: t.recipes}
+	if t.recipes > 0 {
+		n := float64(t.recipes)
+		s.Completeness = math.Round(t.completeness/n*1000) / 1000
+		s.Clarity = math.Round(t.clarity/n*1000) / 1000
+		s.Constraints = math.Round(t.constraints/n*1000) / 1000
+		s.PassRate = math.Round(float64(t.passed)/n*1000) / 1000
+	}
+	return s
+}
+
+// qualityTracker aggregates the scores of generated recipes
+type qualityTracker struct {
+	mu       sync.Mutex
+	overall  qualityTotals
+	byModel  map[string]*qualityTotals
+	byPrompt map[string]*qualityTotals
+}
+
+// Quality of the running server's recipes
+var recipeQuality = &qualityTracker{byModel: make(map[string]*qualityTotals), byPrompt: make(map[string]*qualityTotals)}
+
+// record counts a scored recipe
+func (t *qualityTracker) record(recipe *FoodRecipe) {
+	if recipe.Quality == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.overall.add(recipe.Quality)
+	for _, g := range []struct {
+		groups map[string]*qualityTotals
+		key    string
+	}{
+		{t.byModel, recipe.Model},
+		{t.byPrompt, recipePromptName + "." + recipe.PromptVersion},
+	} {
+		if g.groups[g.key] == nil {
+			g.groups[g.key] = &qualityTotals{}
+		}
+		g.groups[g.key].add(recipe.Quality)
+	}
+}
+
+// stats returns the averages overall and by group
+func (t *qualityTracker) stats() QualityStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	groupStats := func(groups map[string]*qualityTotals) []QualityGroupStats {
+		stats := []QualityGroupStats{}
+		for _, key := range slices.Sorted(maps.Keys(groups)) {
+			stats = append(stats, groups[key].stats(key))
+		}
+		return stats
+	}
+	return QualityStats{
+		Overall:         t.overall.stats("all"),
+		ByModel:         groupStats(t.byModel),
+		ByPromptVersion: groupStats(t.byPrompt),
+	}
+}
+
+// qualityStatsHandler reports the average quality scores of generated
+// recipes
+func qualityStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, recipeQuality.stats())
+}
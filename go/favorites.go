@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// Whether the caller has favorited a recipe, and how many accounts have
+type FavoriteStatus struct {
+	RecipeID      string `json:"recipeId"`
+	Favorited     bool   `json:"favorited"`
+	FavoriteCount int    `json:"favoriteCount"`
+}
+
+// favoriteHandler adds a stored recipe to the caller's favorites, or
+// removes it when favorite is false. Doing either twice is harmless.
+// Favorites belong to an account, so anonymous callers are turned away
+func favoriteHandler(favorite bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := requireAccount(w, r, "keep favorites")
+		if !ok {
+			return
+		}
+		id := r.PathValue("id")
+		if _, ok := savedRecipes.entry(id); !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+			return
+		}
+
+		if err := savedRecipes.setFavorite(r.Context(), account, id, favorite); err != nil {
+			log.Printf("Failed to update favorite %s of %s: %v", id, account, err)
+			writeError(w, http.StatusInternalServerError, "Favorite Failed", "The favorite could not be saved; try again later")
+			return
+		}
+		status := FavoriteStatus{RecipeID: id, Favorited: favorite}
+		if stored, ok := savedRecipes.entry(id); ok {
+			status.FavoriteCount = stored.item.FavoriteCount
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// favoritesHandler lists the caller's favorite recipes, most recently
+// favorited first
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep favorites")
+	if !ok {
+		return
+	}
+
+	list := RecipeList{Recipes: []RecipeSummary{}}
+	for _, stored := range savedRecipes.favorites(account) {
+		list.Recipes = append(list.Recipes, summarizeRecipe(stored))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Flags gating experimental features
+const (
+	featureDishImages   = "dishImages"
+	featureChatSessions = "chatSessions"
+)
+
+// How one feature is rolled out. A disabled flag is off for everyone;
+// otherwise it is on for the listed API keys and for the given percentage
+// of other clients, 100 when unset
+type FeatureFlag struct {
+	Enabled    bool     `yaml:"enabled" json:"enabled"`
+	Percentage *int     `yaml:"percentage" json:"percentage,omitempty"`
+	APIKeys    []string `yaml:"apiKeys" json:"apiKeys,omitempty" jsonschema:"description=IDs of API keys that always get the feature"`
+}
+
+// The flags in effect and where they came from
+type FeatureFlags struct {
+	Flags    map[string]FeatureFlag `json:"flags"`
+	File     string                 `json:"file,omitempty"`
+	LoadedAt time.Time              `json:"loadedAt"`
+}
+
+// featureFlagSource reads flags from a YAML or JSON file, overridden by a
+// "name=on|off|NN%" list from the environment. Reloading swaps the whole
+// set, so a request sees either the old flags or the new ones
+type featureFlagSource struct {
+	file string
+	env  string
+
+	mu      sync.Mutex
+	current atomic.Pointer[FeatureFlags]
+}
+
+// Flags of the running server, set up at startup. Features without a flag
+// are on
+var featureFlags = &featureFlagSource{}
+
+// newFeatureFlagSource loads the flags once, failing on a bad file or list
+func newFeatureFlagSource(file, env string) (*featureFlagSource, error) {
+	s := &featureFlagSource{file: file, env: env}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the flags again. On error the previous flags stay in effect
+func (s *featureFlagSource) reload() (*FeatureFlags, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flags := make(map[string]FeatureFlag)
+	if s.file != "" {
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalWithOptions(data, &flags, yaml.Strict()); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.file, err)
+		}
+	}
+	if err := parseFeatureFlagList(s.env, flags); err != nil {
+		return nil, err
+	}
+	for name, flag := range flags {
+		if flag.Percentage != nil && (*flag.Percentage < 0 || *flag.Percentage > 100) {
+			return nil, fmt.Errorf("feature flag %s: percentage must be between 0 and 100", name)
+		}
+	}
+
+	loaded := &FeatureFlags{Flags: flags, File: s.file, LoadedAt: time.Now().UTC()}
+	s.current.Store(loaded)
+	return loaded, nil
+}
+
+// parseFeatureFlagList reads "name=on,name=off,name=25%" into flags
+func parseFeatureFlagList(list string, flags map[string]FeatureFlag) error {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("feature flag %q must be written as name=on, name=off or name=NN%%", item)
+		}
+		switch value {
+		case "on", "true":
+			flags[name] = FeatureFlag{Enabled: true}
+		case "off", "false":
+			flags[name] = FeatureFlag{}
+		default:
+			percentage, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || !strings.HasSuffix(value, "%") {
+				return fmt.Errorf("feature flag %q must be written as name=on, name=off or name=NN%%", item)
+			}
+			flags[name] = FeatureFlag{Enabled: true, Percentage: &percentage}
+		}
+	}
+	return nil
+}
+
+// flags returns the flags in effect
+func (s *featureFlagSource) flags() *FeatureFlags {
+	if f := s.current.Load(); f != nil {
+		return f
+	}
+	return &FeatureFlags{}
+}
+
+// reloadOnSIGHUP reloads the flags whenever the process gets SIGHUP
+func (s *featureFlagSource) reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := s.reload(); err != nil {
+				log.Printf("Failed to reload feature flags, keeping the previous ones: %v", err)
+				continue
+			}
+			log.Println("Reloaded feature flags")
+		}
+	}()
+}
+
+// featureEnabled reports whether a feature is on for the request the
+// context belongs to. Clients are put in a percentage bucket by API key,
+// user or IP, so each one sees the same answer on every request
+func featureEnabled(ctx context.Context, name string) bool {
+	flag, ok := featureFlags.flags().Flags[name]
+	if !ok {
+		return true
+	}
+	if !flag.Enabled {
+		return false
+	}
+	key, hasKey := requestKey(ctx)
+	if hasKey && slices.Contains(flag.APIKeys, key.ID) {
+		return true
+	}
+	if flag.Percentage == nil {
+		return true
+	}
+	return clientBucket(ctx, name) < *flag.Percentage
+}
+
+// clientBucket puts the client of the context's request in one of 100
+// buckets by API key, user or IP. Each salt splits clients independently
+func clientBucket(ctx context.Context, salt string) int {
+	client, _ := ctx.Value(clientIPContextKey{}).(string)
+	if user, ok := requestUser(ctx); ok {
+		client = "user:" + user
+	}
+	if key, ok := requestKey(ctx); ok {
+		client = "key:" + key.ID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(salt + "\x00" + client))
+	return int(h.Sum32() % 100)
+}
+
+// errFeatureDisabled is returned for a feature turned off for the caller
+var errFeatureDisabled = errors.New("this feature is not available")
+
+// requireFeature answers 404 on a route whose feature is off for the caller
+func requireFeature(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(r.Context(), name) {
+			writeError(w, http.StatusNotFound, "Feature Not Available", errFeatureDisabled.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// featureFlagsHandler lists the flags in effect
+func featureFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, featureFlags.flags())
+}
+
+// reloadFeatureFlagsHandler reads the flags file again
+func reloadFeatureFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	flags, err := featureFlags.reload()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid Feature Flags", err.Error())
+		return
+	}
+	log.Printf("Feature flags reloaded by %s", requestActor(r.Context()))
+	writeJSON(w, http.StatusOK, flags)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fieldMask is a parsed ?fields= parameter. A field maps to the mask of its
+// children, or to nil when it is kept whole
+type fieldMask map[string]fieldMask
+
+// parseFieldMask reads a comma-separated list of fields, where nested fields
+// are written with dots (e.g. "name,nutrition.calories")
+func parseFieldMask(fields string) fieldMask {
+	mask := fieldMask{}
+	for _, path := range strings.Split(fields, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		m := mask
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			child, seen := m[part]
+			if i == len(parts)-1 {
+				// A whole field wins over any of its children
+				m[part] = nil
+				break
+			}
+			if seen && child == nil {
+				break
+			}
+			if child == nil {
+				child = fieldMask{}
+				m[part] = child
+			}
+			m = child
+		}
+	}
+	return mask
+}
+
+// pruneJSON keeps only the masked fields of a JSON document, preserving
+// field order. Masks apply to every element of arrays
+func pruneJSON(data []byte, mask fieldMask) ([]byte, error) {
+	pruned, err := pruneValue(data, mask)
+	if err != nil {
+		return nil, err
+	}
+	return append(pruned, '\n'), nil
+}
+
+// pruneValue prunes one JSON value
+func pruneValue(data []byte, mask fieldMask) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return trimmed, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	open, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(trimmed[0])
+	first := true
+	for dec.More() {
+		var key string
+		if open == json.Delim('{') {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key = tok.(string)
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+
+		value := []byte(raw)
+		if open == json.Delim('{') {
+			children, ok := mask[key]
+			if !ok {
+				continue
+			}
+			if children != nil {
+				if value, err = pruneValue(raw, children); err != nil {
+					return nil, err
+				}
+			}
+		} else if value, err = pruneValue(raw, mask); err != nil {
+			return nil, err
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		if open == json.Delim('{') {
+			buf.WriteString(strconv.Quote(key))
+			buf.WriteByte(':')
+		}
+		buf.Write(value)
+	}
+	if open == json.Delim('{') {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return buf.Bytes(), nil
+}
+
+// fieldMaskWriter holds back successful JSON responses so they can be pruned,
+// and passes everything else straight through
+type fieldMaskWriter struct {
+	http.ResponseWriter
+	status   int
+	buffered bool
+	body     bytes.Buffer
+}
+
+func (fw *fieldMaskWriter) WriteHeader(status int) {
+	if fw.status != 0 {
+		return
+	}
+	fw.status = status
+	mediaType, _, _ := mime.ParseMediaType(fw.Header().Get("Content-Type"))
+	if status >= 200 && status < 300 && mediaType == "application/json" {
+		fw.buffered = true
+		return
+	}
+	fw.ResponseWriter.WriteHeader(status)
+}
+
+func (fw *fieldMaskWriter) Write(b []byte) (int, error) {
+	if fw.status == 0 {
+		fw.WriteHeader(http.StatusOK)
+	}
+	if fw.buffered {
+		return fw.body.Write(b)
+	}
+	return fw.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming
+func (fw *fieldMaskWriter) Flush() {
+	if fw.buffered {
+		return
+	}
+	if f, ok := fw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sparseFields prunes JSON responses to the fields listed in ?fields=, so
+// clients that only need part of a recipe don't download all of it. Error
+// responses and other formats are left alone
+func sparseFields(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := r.URL.Query().Get("fields")
+		if fields == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fw := &fieldMaskWriter{ResponseWriter: w}
+		next.ServeHTTP(fw, r)
+		if !fw.buffered {
+			return
+		}
+
+		body, err := pruneJSON(fw.body.Bytes(), parseFieldMask(fields))
+		if err != nil {
+			log.Printf("Error applying field mask: %v", err)
+			body = fw.body.Bytes()
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(fw.status)
+		w.Write(body)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Bounds on generation parameters
+const (
+	maxTemperature     = 2.0
+	maxTopK            = 100
+	maxOutputTokensCap = 65536
+)
+
+// Seed of deterministic requests that don't choose their own
+const deterministicSeed = 1
+
+// Sampling and length settings for a model call. Unset fields keep the
+// server defaults, and those the model's own
+type GenerationParams struct {
+	Temperature     *float64 `json:"temperature,omitempty" jsonschema:"description=Sampling temperature from 0 to 2; lower is more deterministic"`
+	TopP            *float64 `json:"topP,omitempty" jsonschema:"description=Nucleus sampling: only tokens within this cumulative probability (0 to 1) are considered"`
+	TopK            *int     `json:"topK,omitempty" jsonschema:"description=Only the K most likely tokens are considered (1 to 100)"`
+	MaxOutputTokens *int     `json:"maxOutputTokens,omitempty" jsonschema:"description=Longest answer in tokens"`
+	Seed            *int     `json:"seed,omitempty" jsonschema:"description=Seed for sampling (0 to 2147483647); the same input\\, seed and model give the same recipe as far as the model allows"`
+	Deterministic   bool     `json:"deterministic,omitempty" jsonschema:"description=Sample at temperature 0 with a fixed seed unless seed is set\\, so the same input reproduces the same recipe"`
+}
+
+// validate checks the parameters against their bounds
+func (p *GenerationParams) validate() []FieldError {
+	var errs []FieldError
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > maxTemperature) {
+		errs = append(errs, FieldError{"temperature", fmt.Sprintf("must be between 0 and %g", maxTemperature)})
+	}
+	if p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1) {
+		errs = append(errs, FieldError{"topP", "must be between 0 and 1"})
+	}
+	if p.TopK != nil && (*p.TopK < 1 || *p.TopK > maxTopK) {
+		errs = append(errs, FieldError{"topK", fmt.Sprintf("must be between 1 and %d", maxTopK)})
+	}
+	if p.MaxOutputTokens != nil && (*p.MaxOutputTokens < 1 || *p.MaxOutputTokens > maxOutputTokensCap) {
+		errs = append(errs, FieldError{"maxOutputTokens", fmt.Sprintf("must be between 1 and %d", maxOutputTokensCap)})
+	}
+	if p.Seed != nil && (*p.Seed < 0 || *p.Seed > math.MaxInt32) {
+		errs = append(errs, FieldError{"seed", fmt.Sprintf("must be between 0 and %d", math.MaxInt32)})
+	}
+	if p.Deterministic && p.Temperature != nil && *p.Temperature != 0 {
+		errs = append(errs, FieldError{"temperature", "must be 0 or unset in deterministic mode"})
+	}
+	return errs
+}
+
+// err returns the first invalid parameter as an input error, or nil
+func (p *GenerationParams) err() error {
+	errs := p.validate()
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + " " + e.Message
+	}
+	return newInputError("%s", strings.Join(msgs, "; "))
+}
+
+// seed returns the seed the model samples with, or nil when it picks its
+// own
+func (p *GenerationParams) seed() *int {
+	if p.Seed == nil && p.Deterministic {
+		seed := deterministicSeed
+		return &seed
+	}
+	return p.Seed
+}
+
+// config returns the parameters that are set, keyed as the model config
+// expects them, or nil when none are. Deterministic mode sets temperature 0
+// and a seed
+func (p *GenerationParams) config() map[string]any {
+	cfg := make(map[string]any)
+	if p.Temperature != nil {
+		cfg["temperature"] = *p.Temperature
+	} else if p.Deterministic {
+		cfg["temperature"] = 0.0
+	}
+	if p.TopP != nil {
+		cfg["topP"] = *p.TopP
+	}
+	if p.TopK != nil {
+		cfg["topK"] = *p.TopK
+	}
+	if p.MaxOutputTokens != nil {
+		cfg["maxOutputTokens"] = *p.MaxOutputTokens
+	}
+	if seed := p.seed(); seed != nil {
+		cfg["seed"] = *seed
+	}
+	if len(cfg) == 0 {
+		return nil
+	}
+	return cfg
+}
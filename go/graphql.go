@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/graphql-go/graphql"
+)
+
+// graphQLTypes derives GraphQL types from the Go structs the flows use, so
+// the schema follows the same json tags as the REST API
+type graphQLTypes struct {
+	objects map[reflect.Type]*graphql.Object
+	inputs  map[reflect.Type]*graphql.InputObject
+}
+
+// output returns the GraphQL output type for a Go type
+func (b *graphQLTypes) output(t reflect.Type) graphql.Output {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.output(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return graphql.NewList(b.output(t.Elem()))
+	case reflect.Struct:
+		if obj, ok := b.objects[t]; ok {
+			return obj
+		}
+		obj := graphql.NewObject(graphql.ObjectConfig{
+			Name: t.Name(),
+			Fields: graphql.FieldsThunk(func() graphql.Fields {
+				fields := graphql.Fields{}
+				for name, f := range jsonFields(t) {
+					index := f.Index
+					fields[name] = &graphql.Field{
+						Type:        b.output(f.Type),
+						Description: schemaDescription(f),
+						Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+							v := reflect.Indirect(reflect.ValueOf(p.Source))
+							field, err := v.FieldByIndexErr(index)
+							if err != nil {
+								return nil, nil
+							}
+							return field.Interface(), nil
+						},
+					}
+				}
+				return fields
+			}),
+		})
+		b.objects[t] = obj
+		return obj
+	}
+	return scalarType(t)
+}
+
+// input returns the GraphQL input type for a Go type
+func (b *graphQLTypes) input(t reflect.Type) graphql.Input {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.input(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return graphql.NewList(b.input(t.Elem()))
+	case reflect.Struct:
+		if obj, ok := b.inputs[t]; ok {
+			return obj
+		}
+		name := t.Name()
+		if !strings.HasSuffix(name, "Input") {
+			name += "Input"
+		}
+		obj := graphql.NewInputObject(graphql.InputObjectConfig{
+			Name: name,
+			Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+				fields := graphql.InputObjectConfigFieldMap{}
+				for name, f := range jsonFields(t) {
+					fields[name] = &graphql.InputObjectFieldConfig{
+						Type:        b.input(f.Type),
+						Description: schemaDescription(f),
+					}
+				}
+				return fields
+			}),
+		})
+		b.inputs[t] = obj
+		return obj
+	}
+	return scalarType(t)
+}
+
+// scalarType maps a Go basic type to a GraphQL scalar; anything else is
+// rendered as a string
+func scalarType(t reflect.Type) *graphql.Scalar {
+	switch t.Kind() {
+	case reflect.String:
+		return graphql.String
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return graphql.Int
+	case reflect.Float32, reflect.Float64:
+		return graphql.Float
+	}
+	return graphql.String
+}
+
+// jsonFields lists a struct's fields by JSON name, including those of
+// embedded structs, and skips fields hidden from JSON
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// schemaDescription reads the description from a field's jsonschema tag
+func schemaDescription(f reflect.StructField) string {
+	tag := strings.ReplaceAll(f.Tag.Get("jsonschema"), `\,`, "\x00")
+	for _, part := range strings.Split(tag, ",") {
+		if description, ok := strings.CutPrefix(part, "description="); ok {
+			return strings.ReplaceAll(description, "\x00", ",")
+		}
+	}
+	return ""
+}
+
+// decodeArg converts a GraphQL input argument into a Go value
+func decodeArg[T any](arg interface{}) (*T, error) {
+	b, err := json.Marshal(arg)
+	if err != nil {
+		return nil, err
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// newGraphQLSchema builds the schema exposing recipe generation, meal plans
+// and saved recipes
+func newGraphQLSchema(
+	recipeFlow *core.Flow[*FoodInput, *FoodRecipe, struct{}],
+	mealPlanFlow *core.Flow[*MealPlanInput, *MealPlan, struct{}],
+) (graphql.Schema, error) {
+	types := &graphQLTypes{
+		objects: map[reflect.Type]*graphql.Object{},
+		inputs:  map[reflect.Type]*graphql.InputObject{},
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"generateRecipe": &graphql.Field{
+				Type:        types.output(reflect.TypeFor[FoodRecipe]()),
+				Description: "Generate a recipe for a given food name",
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(types.input(reflect.TypeFor[FoodInput]()))},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					input, err := decodeArg[FoodInput](p.Args["input"])
+					if err != nil {
+						return nil, err
+					}
+					return recipeFlow.Run(p.Context, input)
+				},
+			},
+			"mealPlan": &graphql.Field{
+				Type:        types.output(reflect.TypeFor[MealPlan]()),
+				Description: "Generate a meal plan with recipes and an aggregated shopping list",
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(types.input(reflect.TypeFor[MealPlanInput]()))},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					input, err := decodeArg[MealPlanInput](p.Args["input"])
+					if err != nil {
+						return nil, err
+					}
+					return mealPlanFlow.Run(p.Context, input)
+				},
+			},
+			"recipe": &graphql.Field{
+				Type:        types.output(reflect.TypeFor[FoodRecipe]()),
+				Description: "Look up a previously generated recipe by id",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, _ := p.Args["id"].(string)
+					recipe, ok := savedRecipes.get(id)
+					if !ok {
+						return nil, fmt.Errorf("recipe %q does not exist or has expired", id)
+					}
+					return recipe, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// A GraphQL request as sent by GraphQL clients
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphQLHandler executes GraphQL queries sent as a JSON POST body or, for
+// simple queries, in the query string
+func graphQLHandler(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		if r.Method == http.MethodGet {
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if vars := r.URL.Query().Get("variables"); vars != "" {
+				if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+					writeError(w, http.StatusBadRequest, "Invalid Variables", "variables must be a JSON object")
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			writeError(w, http.StatusBadRequest, "Missing Query", "query is required")
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        r.Context(),
+		})
+		writeJSON(w, http.StatusOK, result)
+	}
+}
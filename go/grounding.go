@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Name of the tool the model calls to search the web in grounded mode
+const searchToolName = "searchRecipes"
+
+// Google Programmable Search key and engine ID, set from the config at
+// startup. Grounded recipes are unavailable without them
+var searchAPIKey, searchEngineID string
+
+// Programmable Search endpoint and client
+var (
+	searchURL    = "https://www.googleapis.com/customsearch/v1"
+	searchClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// How many results one search returns to the model
+const searchResults = 5
+
+// A web page a grounded recipe draws on
+type Source struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// Input of the web search tool
+type WebSearchInput struct {
+	Query string `json:"query" jsonschema:"description=Search query\\, e.g. traditional Neapolitan ragù recipe"`
+}
+
+// A page found by the web search tool
+type WebSearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+}
+
+// Output of the web search tool. Error explains a failed search, so the
+// model can carry on without it
+type WebSearch struct {
+	Results []WebSearchResult `json:"results"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// searchWeb runs a Programmable Search query
+func searchWeb(ctx context.Context, query string) ([]WebSearchResult, error) {
+	params := url.Values{"key": {searchAPIKey}, "cx": {searchEngineID}, "q": {query}, "num": {fmt.Sprint(searchResults)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, searchURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := searchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("web search answered %s", resp.Status)
+	}
+
+	var result struct {
+		Items []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("web search: %w", err)
+	}
+	results := []WebSearchResult{}
+	for _, item := range result.Items {
+		results = append(results, WebSearchResult{Title: item.Title, URL: item.Link, Snippet: item.Snippet})
+	}
+	return results, nil
+}
+
+// groundingSources collects the pages the web search tool showed the model
+// during one generation, so the sources it cites can be checked against
+// them
+type groundingSources struct {
+	mu    sync.Mutex
+	found []Source
+}
+
+// Context key of the generation's grounding sources
+type groundingContextKey struct{}
+
+// withGrounding collects the search results of tool calls made with the
+// returned context
+func withGrounding(ctx context.Context) (context.Context, *groundingSources) {
+	s := &groundingSources{}
+	return context.WithValue(ctx, groundingContextKey{}, s), s
+}
+
+// add records search results shown to the model
+func (s *groundingSources) add(results []WebSearchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range results {
+		if !slices.ContainsFunc(s.found, func(f Source) bool { return f.URL == r.URL }) {
+			s.found = append(s.found, Source{Title: r.Title, URL: r.URL})
+		}
+	}
+}
+
+// cite keeps the sources the model cited that the search actually found,
+// with their titles as found. When the model cites none of them, every page
+// it was shown is cited instead
+func (s *groundingSources) cite(cited []Source) []Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sources []Source
+	for _, c := range cited {
+		i := slices.IndexFunc(s.found, func(f Source) bool { return f.URL == c.URL })
+		if i >= 0 && !slices.Contains(sources, s.found[i]) {
+			sources = append(sources, s.found[i])
+		}
+	}
+	if len(sources) == 0 {
+		sources = slices.Clone(s.found)
+	}
+	return sources
+}
+
+// defineSearchTool registers the tool the model calls in grounded mode to
+// find authentic regional references for a dish
+func defineSearchTool(g *genkit.Genkit) ai.Tool {
+	return genkit.DefineTool(g, searchToolName,
+		"Searches the web for recipes and articles about a dish. Use it to find how the dish is traditionally made in its region of origin, and cite the pages you rely on.",
+		func(ctx *ai.ToolContext, input WebSearchInput) (*WebSearch, error) {
+			results, err := searchWeb(ctx, input.Query)
+			if err != nil {
+				log.Printf("Web search for %q failed: %v", input.Query, err)
+				return &WebSearch{Results: []WebSearchResult{}, Error: "search failed; continue without it"}, nil
+			}
+			if s, _ := ctx.Value(groundingContextKey{}).(*groundingSources); s != nil {
+				s.add(results)
+			}
+			return &WebSearch{Results: results}, nil
+		})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// inputError marks a flow failure caused by the request rather than by
+// generation, so handlers can answer with a 4xx status
+type inputError struct {
+	msg string
+}
+
+func (e *inputError) Error() string { return e.msg }
+
+// newInputError formats an inputError
+func newInputError(format string, args ...any) error {
+	return &inputError{msg: fmt.Sprintf(format, args...)}
+}
+
+// errorStatus maps a flow error to the HTTP status to report
+func errorStatus(err error) int {
+	var ie *inputError
+	var blocked *safetyBlockedError
+	if errors.As(err, &ie) || errors.As(err, &blocked) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	// The models kept failing for reasons that may pass, so the client can
+	// try again later
+	var failed *modelCallError
+	var open *circuitOpenError
+	if errors.As(err, &failed) && isTransient(failed.reason) || errors.As(err, &open) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// flowError builds the ErrorResponse for a failed flow. Generations the
+// safety filters stopped get their own title and code, so clients can tell
+// them from failures worth retrying; failed model calls are coded with the
+// reason they failed, e.g. model_quota
+func flowError(w http.ResponseWriter, err error, failure string) ErrorResponse {
+	resp := ErrorResponse{Error: failure, Message: err.Error(), RequestID: w.Header().Get(requestIDHeader)}
+	var blocked *safetyBlockedError
+	var failed *modelCallError
+	var open *circuitOpenError
+	switch {
+	case errors.As(err, &blocked):
+		resp.Error, resp.Code = "Content Blocked", "safety_blocked"
+	case errors.As(err, &failed):
+		resp.Code = "model_" + failed.reason
+	case errors.As(err, &open):
+		resp.Code = "circuit_open"
+	}
+	return resp
+}
+
+// writeFlowError reports a failed flow with the status its error maps to
+func writeFlowError(w http.ResponseWriter, err error, failure string) {
+	setRetryAfter(w, err)
+	writeJSON(w, errorStatus(err), flowError(w, err, failure))
+}
+
+// flowHandler exposes a flow as a JSON POST endpoint, reporting failures
+// with the given error title. Inputs with a callback URL are answered with
+// 202 and the result is delivered to the callback
+func flowHandler[In, Out any](flow *core.Flow[*In, Out, struct{}], failure string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input In
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+
+		// Deliver the result to the callback when one is given
+		if c, ok := any(&input).(callbackInput); ok && c.callback() != "" {
+			acceptWithCallback(w, r, flow, &input, c.callback())
+			return
+		}
+
+		output, err := flow.Run(r.Context(), &input)
+		if err != nil {
+			log.Printf("Error running %s: %v", flow.Name(), err)
+			writeFlowError(w, err, failure)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, output)
+	}
+}
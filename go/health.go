@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"google.golang.org/genai"
+)
+
+const (
+	// How long a model probe result is reused, so frequent readiness checks
+	// don't turn into a stream of Gemini API calls
+	modelProbeTTL = 30 * time.Second
+
+	// How long a model probe may take
+	modelProbeTimeout = 5 * time.Second
+
+	// How long the deep health check's test generation may take
+	generationProbeTimeout = 20 * time.Second
+)
+
+var (
+	// Set once Genkit is initialized and the flows are defined
+	genkitReady atomic.Bool
+
+	// Set while the server accepts requests; cleared when shutdown begins so
+	// load balancers stop routing here
+	serverReady atomic.Bool
+)
+
+// The outcome of one readiness check
+type HealthCheck struct {
+	Status    string     `json:"status" jsonschema:"enum=ok,enum=failing,enum=skipped"`
+	LatencyMS int64      `json:"latencyMs,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+
+	// Set by the deep health check
+	Model string      `json:"model,omitempty"`
+	Quota *QuotaHints `json:"quota,omitempty"`
+}
+
+// What the model API said about quota. Gemini doesn't report remaining
+// quota, so this is only filled in detail once a limit has been hit
+type QuotaHints struct {
+	Status     string   `json:"status" jsonschema:"enum=ok,enum=exhausted,enum=unknown"`
+	RetryAfter string   `json:"retryAfter,omitempty"`
+	Exceeded   []string `json:"exceeded,omitempty"`
+	TokensUsed int      `json:"tokensUsed,omitempty"`
+}
+
+// Define output schema for health probes
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// modelProbe checks that the selected Gemini model can be reached by
+// looking up its metadata, which is cheap and uses no generation quota
+type modelProbe struct {
+	client *genai.Client
+
+	mu      sync.Mutex
+	last    HealthCheck
+	model   string
+	checked time.Time
+}
+
+// Probe of the default model; nil when the server started without a Google
+// AI model
+var readinessProbe *modelProbe
+
+// newModelProbe returns a probe when model is a "googleai/..." model, or nil
+// for other providers
+func newModelProbe(ctx context.Context, apiKey, model string) (*modelProbe, error) {
+	if !strings.HasPrefix(model, "googleai/") {
+		return nil, nil
+	}
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey, Backend: genai.BackendGeminiAPI})
+	if err != nil {
+		return nil, err
+	}
+	return &modelProbe{client: client}, nil
+}
+
+// check returns the last probe result for the selected model, probing again
+// once it is stale or the model has changed. Other providers are skipped
+func (p *modelProbe) check(ctx context.Context) HealthCheck {
+	model := currentSettings().Model
+	name, ok := strings.CutPrefix(model, "googleai/")
+	if !ok {
+		return HealthCheck{Status: "skipped", Model: model}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.model == model && time.Since(p.checked) < modelProbeTTL {
+		return p.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, modelProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := p.client.Models.Get(ctx, name, nil)
+
+	checkedAt := time.Now().UTC()
+	p.last = HealthCheck{Status: "ok", LatencyMS: time.Since(start).Milliseconds(), CheckedAt: &checkedAt, Model: model}
+	if err != nil {
+		p.last.Status, p.last.Error = "failing", err.Error()
+	}
+	p.model, p.checked = model, checkedAt
+	return p.last
+}
+
+// generationProbe checks the selected model end to end with a tiny
+// generation. It costs a few tokens, so results are reused for the deep
+// health TTL
+type generationProbe struct {
+	g *genkit.Genkit
+
+	mu      sync.Mutex
+	last    HealthCheck
+	model   string
+	checked time.Time
+}
+
+// Probe behind /healthz/deep
+var deepProbe *generationProbe
+
+// check returns the last generation result, generating again once it is
+// stale or the model has changed
+func (p *generationProbe) check(ctx context.Context) HealthCheck {
+	settings := currentSettings()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.model == settings.Model && time.Since(p.checked) < time.Duration(settings.DeepHealthTTL) {
+		return p.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, generationProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := genkit.Generate(ctx, p.g,
+		ai.WithModelName(runtimeModelName),
+		ai.WithPrompt("Reply with the single word OK."),
+	)
+
+	checkedAt := time.Now().UTC()
+	p.last = HealthCheck{
+		Status:    "ok",
+		LatencyMS: time.Since(start).Milliseconds(),
+		CheckedAt: &checkedAt,
+		Model:     settings.Model,
+		Quota:     quotaHints(err),
+	}
+	if err != nil {
+		p.last.Status, p.last.Error = "failing", err.Error()
+	} else if resp.Usage != nil {
+		p.last.Quota.TokensUsed = resp.Usage.TotalTokens
+	}
+	p.model, p.checked = settings.Model, checkedAt
+	return p.last
+}
+
+// quotaHints reads the quota details of a Gemini API error: the retry delay
+// and which quotas were exceeded when the request was rate limited
+func quotaHints(err error) *QuotaHints {
+	if err == nil {
+		return &QuotaHints{Status: "ok"}
+	}
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return &QuotaHints{Status: "unknown"}
+	}
+	if apiErr.Code != http.StatusTooManyRequests {
+		return &QuotaHints{Status: "ok"}
+	}
+
+	hints := &QuotaHints{Status: "exhausted"}
+	for _, detail := range apiErr.Details {
+		switch detail["@type"] {
+		case "type.googleapis.com/google.rpc.RetryInfo":
+			hints.RetryAfter, _ = detail["retryDelay"].(string)
+		case "type.googleapis.com/google.rpc.QuotaFailure":
+			violations, _ := detail["violations"].([]any)
+			for _, v := range violations {
+				if v, ok := v.(map[string]any); ok {
+					if id, ok := v["quotaId"].(string); ok {
+						hints.Exceeded = append(hints.Exceeded, id)
+					}
+				}
+			}
+		}
+	}
+	return hints
+}
+
+// readyCheck turns a flag into a check
+func readyCheck(ok bool, reason string) HealthCheck {
+	if ok {
+		return HealthCheck{Status: "ok"}
+	}
+	return HealthCheck{Status: "failing", Error: reason}
+}
+
+// livenessHandler answers as long as the process can serve HTTP at all
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthReport{Status: "ok"})
+}
+
+// readinessHandler reports whether the server should receive traffic:
+// Genkit is initialized, shutdown hasn't begun and the model is reachable.
+// It answers 503 when any check fails
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := HealthReport{Status: "ready", Checks: map[string]HealthCheck{
+		"genkit": readyCheck(genkitReady.Load(), "Genkit is not initialized"),
+		"server": readyCheck(serverReady.Load(), "The server is not accepting requests"),
+		"model":  {Status: "skipped"},
+	}}
+	if readinessProbe != nil {
+		report.Checks["model"] = readinessProbe.check(r.Context())
+	}
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status == "failing" {
+			report.Status, status = "not ready", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, report)
+}
+
+// deepHealthHandler runs a small generation against the configured model,
+// cached for the deep health TTL, and answers 503 when it fails
+func deepHealthHandler(w http.ResponseWriter, r *http.Request) {
+	check := deepProbe.check(r.Context())
+	report := HealthReport{Status: "ok", Checks: map[string]HealthCheck{"model": check}}
+
+	status := http.StatusOK
+	if check.Status == "failing" {
+		report.Status, status = "failing", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// A recipe an account generated and the request it was generated from
+type historyItem struct {
+	storedItem[FoodRecipe]
+	input FoodInput
+}
+
+// A generation in an account's history: the request as it was run, with
+// the caller's profile applied, and the recipe it produced
+type HistoryEntry struct {
+	Recipe  RecipeSummary `json:"recipe"`
+	Request FoodInput     `json:"request"`
+}
+
+// One page of an account's history, newest first
+type HistoryPage struct {
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// matches reports whether a generation mentions every word of a search,
+// in its request or in the recipe's name, description or tags
+func (item *historyItem) matches(words []string) bool {
+	text := strings.ToLower(strings.Join(append([]string{
+		item.input.FoodName,
+		item.input.DietaryRestrictions,
+		item.item.Name,
+		item.item.Description,
+		item.item.Cuisine,
+	}, item.item.Tags...), " "))
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// historyHandler lists the recipes the caller generated with their
+// requests, newest first, a page at a time. ?q= keeps the generations
+// that mention every word of it
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a history")
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+
+	limit := defaultRecipePageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRecipePageSize {
+			writeError(w, http.StatusBadRequest, "Invalid Limit", fmt.Sprintf("limit must be between 1 and %d", maxRecipePageSize))
+			return
+		}
+		limit = n
+	}
+	words := strings.Fields(strings.ToLower(query.Get("q")))
+	var after time.Time
+	var afterID string
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		if after, afterID, err = decodeCursor(cursor); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Cursor", "The cursor is malformed; start again without one")
+			return
+		}
+	}
+
+	page := HistoryPage{Entries: []HistoryEntry{}}
+	for _, item := range savedRecipes.history(account) {
+		// Skip everything up to and including the cursor position
+		if afterID != "" && (item.created.After(after) ||
+			item.created.Equal(after) && item.id >= afterID) {
+			continue
+		}
+		if !item.matches(words) {
+			continue
+		}
+		if len(page.Entries) == limit {
+			last := page.Entries[limit-1].Recipe
+			page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+			break
+		}
+		page.Entries = append(page.Entries, HistoryEntry{Recipe: summarizeRecipe(item.storedItem), Request: item.input})
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// rerunHandler generates a recipe again from the request of one in the
+// caller's history. The new recipe is added to the history even when it
+// nearly matches an earlier one; a callback the request had is not called
+// again
+func rerunHandler(flow *core.Flow[*FoodInput, *FoodRecipe, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := requireAccount(w, r, "keep a history")
+		if !ok {
+			return
+		}
+		id := r.PathValue("id")
+		var input *FoodInput
+		for _, item := range savedRecipes.history(account) {
+			if item.id == id {
+				input = &item.input
+				break
+			}
+		}
+		if input == nil {
+			writeError(w, http.StatusNotFound, "Generation Not Found", "No recipe in your history has this id")
+			return
+		}
+		// A re-run asks for a new recipe, even one much like the last
+		input.CallbackURL, input.AllowDuplicate = "", true
+
+		recipe, err := flow.Run(r.Context(), input)
+		if err != nil {
+			log.Printf("Error re-running generation %s: %v", id, err)
+			writeFlowError(w, err, "Recipe Generation Failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, recipe)
+	}
+}
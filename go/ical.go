@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Local times for each meal of the day, as floating calendar times
+var icalMeals = []struct {
+	name       string
+	start, end string
+	entry      func(d *MealPlanDay) MealEntry
+}{
+	{"Breakfast", "080000", "090000", func(d *MealPlanDay) MealEntry { return d.Breakfast }},
+	{"Lunch", "123000", "133000", func(d *MealPlanDay) MealEntry { return d.Lunch }},
+	{"Dinner", "183000", "193000", func(d *MealPlanDay) MealEntry { return d.Dinner }},
+}
+
+// Longest content line allowed by RFC 5545, in octets
+const icalLineLimit = 75
+
+// mealPlanICal renders a meal plan as an iCalendar feed with one event per
+// meal, described with the recipe it refers to
+func mealPlanICal(p *MealPlan) string {
+	recipes := make(map[string]*MealPlanRecipe, len(p.Recipes))
+	for i := range p.Recipes {
+		recipes[p.Recipes[i].ID] = &p.Recipes[i]
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	var sb strings.Builder
+	line := func(name, value string) { icalLine(&sb, name+":"+value) }
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//genkit-go//Meal Plan//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("X-WR-CALNAME", "Meal Plan")
+
+	for i := range p.Days {
+		day := &p.Days[i]
+		date, err := time.Parse(time.DateOnly, day.Date)
+		if err != nil {
+			continue
+		}
+		for _, meal := range icalMeals {
+			entry := meal.entry(day)
+			if entry.Title == "" {
+				continue
+			}
+			line("BEGIN", "VEVENT")
+			line("UID", fmt.Sprintf("%s-%d-%s@genkit-go", p.ID, day.Day, strings.ToLower(meal.name)))
+			line("DTSTAMP", stamp)
+			line("DTSTART", date.Format("20060102")+"T"+meal.start)
+			line("DTEND", date.Format("20060102")+"T"+meal.end)
+			line("SUMMARY", icalEscape(meal.name+": "+entry.Title))
+			if recipe, ok := recipes[entry.RecipeID]; ok {
+				line("DESCRIPTION", icalEscape(icalDescription(recipe)))
+			}
+			line("END", "VEVENT")
+		}
+	}
+	line("END", "VCALENDAR")
+	return sb.String()
+}
+
+// icalDescription summarises a recipe for an event description
+func icalDescription(r *MealPlanRecipe) string {
+	parts := []string{r.Name}
+	if r.Description != "" {
+		parts = append(parts, r.Description)
+	}
+	var facts []string
+	if r.TotalTime != "" {
+		facts = append(facts, "Total time: "+r.TotalTime)
+	}
+	if r.Servings > 0 {
+		facts = append(facts, fmt.Sprintf("Serves %d", r.Servings))
+	}
+	if len(facts) > 0 {
+		parts = append(parts, strings.Join(facts, " · "))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// icalEscape escapes a TEXT value
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icalLine writes a content line, folding it at 75 octets without splitting
+// a UTF-8 character
+func icalLine(sb *strings.Builder, s string) {
+	limit := icalLineLimit
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		sb.WriteString(s[:cut])
+		sb.WriteString("\r\n ")
+		s = s[cut:]
+		limit = icalLineLimit - 1 // continuation lines start with a space
+	}
+	sb.WriteString(s)
+	sb.WriteString("\r\n")
+}
+
+// mealPlanICalHandler serves a generated meal plan as a calendar feed
+func mealPlanICalHandler(w http.ResponseWriter, r *http.Request) {
+	plan, ok := recentMealPlans.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Meal Plan Not Found", "The meal plan does not exist or has expired")
+		return
+	}
+
+	if notModified(w, r, contentETag(plan, "ical")) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="meal-plan-%s.ics"`, plan.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(mealPlanICal(plan)))
+}
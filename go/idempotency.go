@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Most responses kept, and the largest body worth keeping
+const (
+	maxIdempotentResponses    = 1000
+	maxIdempotentResponseSize = 1 << 20
+)
+
+// A response recorded for an idempotency key. done is closed once the first
+// request has finished; until then the response is still being generated
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	created     time.Time
+	done        chan struct{}
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyStore keeps responses by key
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+// Responses to POST requests sent with an Idempotency-Key
+var idempotentResponses = &idempotencyStore{responses: make(map[string]*idempotentResponse)}
+
+// begin returns the response recorded for the key, or registers a new one
+// that the caller must complete; created reports which
+func (s *idempotencyStore) begin(key string, fingerprint [sha256.Size]byte) (entry *idempotentResponse, created bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// How long responses are replayed for a repeated Idempotency-Key
+	ttl := time.Duration(currentSettings().IdempotencyTTL)
+	for k, e := range s.responses {
+		if time.Since(e.created) > ttl {
+			delete(s.responses, k)
+		}
+	}
+	if e, ok := s.responses[key]; ok {
+		return e, false
+	}
+	for len(s.responses) >= maxIdempotentResponses {
+		var oldestKey string
+		var oldest time.Time
+		for k, e := range s.responses {
+			if oldestKey == "" || e.created.Before(oldest) {
+				oldestKey, oldest = k, e.created
+			}
+		}
+		delete(s.responses, oldestKey)
+	}
+
+	entry = &idempotentResponse{fingerprint: fingerprint, created: time.Now(), done: make(chan struct{})}
+	s.responses[key] = entry
+	return entry, true
+}
+
+// forget drops a key so the request can be retried
+func (s *idempotencyStore) forget(key string, entry *idempotentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.responses[key] == entry {
+		delete(s.responses, key)
+	}
+}
+
+// idempotencyRecorder passes a response through while keeping a copy
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > maxIdempotentResponseSize {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming
+func (rec *idempotencyRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// idempotent replays the recorded response when a POST is retried with the
+// same Idempotency-Key by the same account, so retries don't generate (and
+// bill) twice. Reusing a key for a different request is rejected with 422,
+// and a retry that arrives while the first request is still running gets 409
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// limitBodies runs first, so this reads at most the route's limit
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(append([]byte(r.URL.RequestURI()+"\n"), body...))
+
+		// Keys are per account, so one client can't replay another's
+		// response by sending the same key. Anonymous callers are told
+		// apart by IP
+		account := requestAccount(r.Context())
+		if account == "anonymous" {
+			account += ":" + clientIP(r)
+		}
+		storeKey := account + "\n" + r.URL.Path + "\n" + key
+		entry, created := idempotentResponses.begin(storeKey, fingerprint)
+		if !created {
+			if entry.fingerprint != fingerprint {
+				writeError(w, http.StatusUnprocessableEntity, "Idempotency Key Reused", "The Idempotency-Key was already used for a different request")
+				return
+			}
+			select {
+			case <-entry.done:
+			default:
+				writeError(w, http.StatusConflict, "Request In Progress", "A request with this Idempotency-Key is still being processed")
+				return
+			}
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		defer func() {
+			// Server errors and oversized responses aren't kept, so the
+			// request can be retried for real
+			if rec.status == 0 || rec.status >= 500 || rec.overflow {
+				idempotentResponses.forget(storeKey, entry)
+			} else {
+				entry.status, entry.header, entry.body = rec.status, w.Header().Clone(), rec.body.Bytes()
+			}
+			close(entry.done)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
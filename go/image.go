@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const (
+	// Largest image accepted for dish identification
+	maxImageBytes = 10 << 20
+
+	// Below this confidence the dish is treated as unidentified
+	minDishConfidence = 0.3
+)
+
+// Image types Gemini accepts as input
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// Define input schema for recipe-from-image requests; the embedded recipe
+// options apply to the recipe generated for the identified dish
+type ImageRecipeInput struct {
+	Image    string `json:"image" jsonschema:"description=Base64 encoded image or data URL of the dish,required=true"`
+	MimeType string `json:"mimeType,omitempty" jsonschema:"description=Image type when image is plain base64 (e.g. image/jpeg)"`
+	FoodInput
+}
+
+// The model's identification of the dish in an image
+type DishIdentification struct {
+	Dish        string   `json:"dish"`
+	Confidence  float64  `json:"confidence" jsonschema:"description=Confidence between 0 and 1"`
+	Description string   `json:"description"`
+	Alternates  []string `json:"alternates,omitempty"`
+}
+
+// Define output schema for recipe-from-image response
+type ImageRecipeResult struct {
+	Identification DishIdentification `json:"identification"`
+	Recipe         *FoodRecipe        `json:"recipe"`
+}
+
+// Define the recipe-from-image flow, which identifies the dish and then
+// runs the recipe flow for it
+func defineRecipeFromImageFlow(g *genkit.Genkit, recipeFlow *core.Flow[*FoodInput, *FoodRecipe, struct{}]) *core.Flow[*ImageRecipeInput, *ImageRecipeResult, struct{}] {
+	return genkit.DefineFlow(g, "recipeFromImageFlow", func(ctx context.Context, input *ImageRecipeInput) (*ImageRecipeResult, error) {
+		contentType, dataURL, err := imageDataURL(input.Image, input.MimeType)
+		if err != nil {
+			return nil, err
+		}
+
+		identification, err := identifyDish(ctx, g, contentType, dataURL)
+		if err != nil {
+			return nil, err
+		}
+
+		options := input.FoodInput
+		options.FoodName = identification.Dish
+		recipe, err := recipeFlow.Run(ctx, &options)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ImageRecipeResult{
+			Identification: *identification,
+			Recipe:         recipe,
+		}, nil
+	})
+}
+
+// identifyDish asks the model which dish is shown in the image
+func identifyDish(ctx context.Context, g *genkit.Genkit, contentType, dataURL string) (*DishIdentification, error) {
+	identification, _, err := genkit.GenerateData[DishIdentification](ctx, g,
+		ai.WithMessages(ai.NewUserMessage(
+			ai.NewTextPart(`Identify the dish shown in this photo.
+
+		Please provide:
+		1. The most specific common name of the dish
+		2. Your confidence between 0 and 1
+		3. A one-sentence description of what you see
+		4. Up to three alternative dishes it could be
+
+		If the photo does not show food, return an empty dish name with confidence 0.`),
+			ai.NewMediaPart(contentType, dataURL),
+		)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify dish: %w", err)
+	}
+
+	if strings.TrimSpace(identification.Dish) == "" || identification.Confidence < minDishConfidence {
+		return nil, newInputError("could not identify a dish in the image")
+	}
+	return identification, nil
+}
+
+// imageDataURL validates an image given as a data URL or plain base64 and
+// returns its content type and data URL
+func imageDataURL(image, mimeType string) (string, string, error) {
+	image = strings.TrimSpace(image)
+	if image == "" {
+		return "", "", newInputError("image is required")
+	}
+
+	if rest, ok := strings.CutPrefix(image, "data:"); ok {
+		header, payload, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(header, ";base64") {
+			return "", "", newInputError("image data URL must be base64 encoded")
+		}
+		mimeType, image = strings.TrimSuffix(header, ";base64"), payload
+	}
+
+	data, err := base64.StdEncoding.DecodeString(image)
+	if err != nil {
+		return "", "", newInputError("image is not valid base64: %v", err)
+	}
+	return encodeImage(data, mimeType)
+}
+
+// encodeImage checks the size and type of raw image bytes and returns the
+// content type and data URL; the type is sniffed when not declared
+func encodeImage(data []byte, mimeType string) (string, string, error) {
+	if len(data) == 0 {
+		return "", "", newInputError("image is empty")
+	}
+	if len(data) > maxImageBytes {
+		return "", "", newInputError("image must be smaller than %d MB", maxImageBytes>>20)
+	}
+
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = http.DetectContentType(data)
+	}
+	if !supportedImageTypes[mimeType] {
+		return "", "", newInputError("unsupported image type %q", mimeType)
+	}
+	return mimeType, fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// recipeFromImageHandler accepts either a JSON ImageRecipeInput or a
+// multipart form with an "image" file and optional recipe option fields
+func recipeFromImageHandler(flow *core.Flow[*ImageRecipeInput, *ImageRecipeResult, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Leave room for base64 and form overhead on top of the image itself
+		r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes*2)
+
+		var input ImageRecipeInput
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := parseImageForm(r, &input); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid Form", err.Error())
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+
+		if input.Language == "" {
+			input.Language = acceptLanguage(r.Header.Get("Accept-Language"))
+		}
+
+		result, err := flow.Run(r.Context(), &input)
+		if err != nil {
+			log.Printf("Error generating recipe from image: %v", err)
+			writeFlowError(w, err, "Recipe Generation Failed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// formImage reads the "image" file of a multipart form as base64 along with
+// its declared content type
+func formImage(r *http.Request) (string, string, error) {
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		return "", "", fmt.Errorf("an image file is required in the \"image\" field")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read image: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(data), header.Header.Get("Content-Type"), nil
+}
+
+// parseImageForm reads the image file and recipe options from a multipart form
+func parseImageForm(r *http.Request, input *ImageRecipeInput) error {
+	var err error
+	if input.Image, input.MimeType, err = formImage(r); err != nil {
+		return err
+	}
+
+	input.DietaryRestrictions = r.FormValue("dietaryRestrictions")
+	input.Difficulty = r.FormValue("difficulty")
+	input.Language = r.FormValue("language")
+	if v := r.FormValue("servingSize"); v != "" {
+		if input.ServingSize, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("servingSize must be a number")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for recipe improvement requests
+type ImproveInput struct {
+	Name         string   `json:"name" jsonschema:"description=Name of the recipe,required=true"`
+	Servings     int      `json:"servings,omitempty" jsonschema:"description=Number of servings the recipe makes"`
+	Ingredients  []string `json:"ingredients" jsonschema:"description=Ingredient list with quantities,required=true"`
+	Instructions []string `json:"instructions" jsonschema:"description=Cooking steps,required=true"`
+	Goal         string   `json:"goal,omitempty" jsonschema:"description=What to improve (e.g. more flavor\\, faster\\, healthier)"`
+}
+
+// A problem found in the submitted recipe
+type RecipeIssue struct {
+	Area     string `json:"area" jsonschema:"description=ingredients\\, technique\\, timing\\, seasoning\\, clarity or safety"`
+	Severity string `json:"severity" jsonschema:"description=minor\\, moderate or major"`
+	Problem  string `json:"problem"`
+}
+
+// A change made in the improved recipe and why
+type RecipeChange struct {
+	Change    string `json:"change"`
+	Rationale string `json:"rationale"`
+}
+
+// Define output schema for a recipe critique
+type RecipeCritique struct {
+	Summary        string         `json:"summary"`
+	Issues         []RecipeIssue  `json:"issues"`
+	ImprovedRecipe FoodRecipe     `json:"improvedRecipe"`
+	Changes        []RecipeChange `json:"changes"`
+}
+
+// Define the recipe critique and improvement flow
+func defineImproveFlow(g *genkit.Genkit) *core.Flow[*ImproveInput, *RecipeCritique, struct{}] {
+	return genkit.DefineFlow(g, "improveFlow", func(ctx context.Context, input *ImproveInput) (*RecipeCritique, error) {
+		if strings.TrimSpace(input.Name) == "" {
+			return nil, newInputError("recipe name is required")
+		}
+		if len(input.Ingredients) == 0 || len(input.Instructions) == 0 {
+			return nil, newInputError("ingredients and instructions are required")
+		}
+
+		goal := input.Goal
+		if goal == "" {
+			goal = "overall quality and clarity"
+		}
+
+		servings := "not specified"
+		if input.Servings > 0 {
+			servings = fmt.Sprint(input.Servings)
+		}
+
+		prompt := fmt.Sprintf(`Critique and improve the following home recipe:
+
+		Recipe: %s
+		Servings: %s
+		Improvement goal: %s
+		Ingredients:
+		- %s
+		Instructions:
+		- %s
+
+		Please provide:
+		1. A short overall summary of the recipe's strengths and weaknesses
+		2. The issues found, each with the area it affects and its severity
+		3. An improved version of the full recipe
+		4. Every change made in the improved version with the rationale for it
+
+		Keep the character of the original dish and only change what clearly helps.`,
+			input.Name, servings, goal,
+			strings.Join(input.Ingredients, "\n\t\t- "),
+			strings.Join(input.Instructions, "\n\t\t- "))
+
+		critique, _, err := genkit.GenerateData[RecipeCritique](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to improve recipe %s: %w", input.Name, err)
+		}
+
+		if critique.ImprovedRecipe.Name == "" {
+			critique.ImprovedRecipe.Name = input.Name
+		}
+		if critique.ImprovedRecipe.Servings == 0 {
+			critique.ImprovedRecipe.Servings = input.Servings
+		}
+
+		return critique, nil
+	})
+}
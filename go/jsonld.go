@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A schema.org Recipe as JSON-LD, for embedding in web pages
+type RecipeJSONLD struct {
+	Context            string                `json:"@context"`
+	Type               string                `json:"@type"`
+	Name               string                `json:"name"`
+	Description        string                `json:"description,omitempty"`
+	Image              string                `json:"image,omitempty"`
+	RecipeYield        string                `json:"recipeYield,omitempty"`
+	PrepTime           string                `json:"prepTime,omitempty"`
+	CookTime           string                `json:"cookTime,omitempty"`
+	TotalTime          string                `json:"totalTime,omitempty"`
+	RecipeIngredient   []string              `json:"recipeIngredient"`
+	RecipeInstructions []HowToStep           `json:"recipeInstructions"`
+	Nutrition          *NutritionInformation `json:"nutrition,omitempty"`
+	EstimatedCost      *MonetaryAmount       `json:"estimatedCost,omitempty"`
+}
+
+// A schema.org HowToStep
+type HowToStep struct {
+	Type     string `json:"@type"`
+	Position int    `json:"position"`
+	Text     string `json:"text"`
+}
+
+// A schema.org NutritionInformation, per serving
+type NutritionInformation struct {
+	Type                string `json:"@type"`
+	Calories            string `json:"calories"`
+	ProteinContent      string `json:"proteinContent"`
+	CarbohydrateContent string `json:"carbohydrateContent"`
+	FatContent          string `json:"fatContent"`
+	SaturatedFatContent string `json:"saturatedFatContent,omitempty"`
+	SugarContent        string `json:"sugarContent,omitempty"`
+	FiberContent        string `json:"fiberContent"`
+	SodiumContent       string `json:"sodiumContent"`
+}
+
+// A schema.org MonetaryAmount
+type MonetaryAmount struct {
+	Type     string  `json:"@type"`
+	Currency string  `json:"currency"`
+	Value    float64 `json:"value"`
+}
+
+func init() {
+	registerSerializer("application/ld+json", "application/ld+json", encodeJSONLD)
+	formatMediaTypes["jsonld"] = "application/ld+json"
+}
+
+// encodeJSONLD writes recipes as schema.org JSON-LD and anything else as
+// plain JSON
+func encodeJSONLD(w io.Writer, v any) error {
+	if recipe, ok := v.(*FoodRecipe); ok {
+		v = toJSONLD(recipe)
+	}
+	return encodeJSON(w, v)
+}
+
+// toJSONLD maps a recipe to a schema.org Recipe
+func toJSONLD(r *FoodRecipe) RecipeJSONLD {
+	doc := RecipeJSONLD{
+		Context:            "https://schema.org",
+		Type:               "Recipe",
+		Name:               r.Name,
+		Description:        r.Description,
+		PrepTime:           isoDuration(r.PrepTime),
+		CookTime:           isoDuration(r.CookTime),
+		TotalTime:          isoDuration(r.TotalTime),
+		RecipeIngredient:   r.Ingredients,
+		RecipeInstructions: make([]HowToStep, 0, len(r.Instructions)),
+	}
+	if doc.RecipeIngredient == nil {
+		doc.RecipeIngredient = []string{}
+	}
+	if r.Servings > 0 {
+		doc.RecipeYield = fmt.Sprintf("%d servings", r.Servings)
+	}
+	if r.Image != nil && r.Image.Status == imageStatusReady {
+		doc.Image = r.Image.URL
+	}
+	for i, step := range r.Instructions {
+		doc.RecipeInstructions = append(doc.RecipeInstructions, HowToStep{Type: "HowToStep", Position: i + 1, Text: step})
+	}
+	if n := r.Nutrition; n != nil {
+		doc.Nutrition = &NutritionInformation{
+			Type:                "NutritionInformation",
+			Calories:            fmt.Sprintf("%.0f calories", n.Calories),
+			ProteinContent:      fmt.Sprintf("%g g", n.ProteinG),
+			CarbohydrateContent: fmt.Sprintf("%g g", n.CarbsG),
+			FatContent:          fmt.Sprintf("%g g", n.FatG),
+			FiberContent:        fmt.Sprintf("%g g", n.FiberG),
+			SodiumContent:       fmt.Sprintf("%g mg", n.SodiumMg),
+		}
+		if n.SaturatedFatG > 0 {
+			doc.Nutrition.SaturatedFatContent = fmt.Sprintf("%g g", n.SaturatedFatG)
+		}
+		if n.SugarG > 0 {
+			doc.Nutrition.SugarContent = fmt.Sprintf("%g g", n.SugarG)
+		}
+	}
+	if r.Currency != "" {
+		doc.EstimatedCost = &MonetaryAmount{Type: "MonetaryAmount", Currency: r.Currency, Value: r.EstimatedCost}
+	}
+	return doc
+}
+
+// Amounts of time in free text such as "1 hour 30 minutes" or "1.5 hrs"
+var durationPattern = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(hours?|hrs?|h|minutes?|mins?|m|seconds?|secs?|s)\b`)
+
+// textDuration reads a free-text duration, returning 0 if it can't be read
+func textDuration(text string) time.Duration {
+	var d time.Duration
+	for _, m := range durationPattern.FindAllStringSubmatch(text, -1) {
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		switch unit := strings.ToLower(m[2]); {
+		case strings.HasPrefix(unit, "h"):
+			d += time.Duration(n * float64(time.Hour))
+		case strings.HasPrefix(unit, "m"):
+			d += time.Duration(n * float64(time.Minute))
+		default:
+			d += time.Duration(n * float64(time.Second))
+		}
+	}
+	return d
+}
+
+// isoDuration converts a free-text duration to ISO 8601 (e.g. "PT1H30M"),
+// or returns an empty string if it can't be read
+func isoDuration(text string) string {
+	d := textDuration(text)
+	if d <= 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("PT")
+	if h := int(d / time.Hour); h > 0 {
+		fmt.Fprintf(&sb, "%dH", h)
+	}
+	if m := int(d % time.Hour / time.Minute); m > 0 {
+		fmt.Fprintf(&sb, "%dM", m)
+	}
+	if s := int(d % time.Minute / time.Second); s > 0 {
+		fmt.Fprintf(&sb, "%dS", s)
+	}
+	return sb.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/dinocodesx/genkit-go/config"
+)
+
+// Verifies bearer JWTs when an issuer is configured; nil otherwise
+var jwtVerifier *oidc.IDTokenVerifier
+
+// Claim holding the user ID, "sub" unless configured otherwise
+var jwtUserClaim = "sub"
+
+// newJWTVerifier checks tokens against the configured JWKS URL, or the keys
+// the issuer publishes in its OpenID discovery document
+func newJWTVerifier(ctx context.Context, cfg *config.Config) (*oidc.IDTokenVerifier, error) {
+	verifierConfig := &oidc.Config{
+		ClientID:          cfg.JWTAudience,
+		SkipClientIDCheck: cfg.JWTAudience == "",
+	}
+	if cfg.JWKSURL != "" {
+		return oidc.NewVerifier(cfg.JWTIssuer, oidc.NewRemoteKeySet(ctx, cfg.JWKSURL), verifierConfig), nil
+	}
+	provider, err := oidc.NewProvider(ctx, cfg.JWTIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering keys of JWT issuer: %w", err)
+	}
+	return provider.Verifier(verifierConfig), nil
+}
+
+// looksLikeJWT tells bearer JWTs apart from API keys
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && !strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// Context key of the user a request was made by
+type userContextKey struct{}
+
+// requestUser returns the ID of the user a request was authenticated as
+func requestUser(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userContextKey{}).(string)
+	return user, ok && user != ""
+}
+
+// authenticateJWT verifies a bearer JWT and returns the user it was issued to
+// with the scopes it grants. Tokens without a scope claim get the recipe and
+// mealplan scopes; admin must be granted explicitly
+func authenticateJWT(r *http.Request, token string) (user string, scopes []string, err error) {
+	idToken, err := jwtVerifier.Verify(r.Context(), token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return "", nil, err
+	}
+	user, _ = claims[jwtUserClaim].(string)
+	if user == "" {
+		return "", nil, fmt.Errorf("token has no %q claim", jwtUserClaim)
+	}
+
+	switch scope := claims["scope"].(type) {
+	case string:
+		scopes = strings.Fields(scope)
+	case []any:
+		for _, s := range scope {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	default:
+		scopes = []string{scopeRecipe, scopeMealPlan}
+	}
+	return user, scopes, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A simplified instruction step for children
+type KidStep struct {
+	Instruction      string   `json:"instruction"`
+	AdultSupervision bool     `json:"adultSupervision"`
+	SafetyCallouts   []string `json:"safetyCallouts,omitempty"`
+}
+
+// Hazards that always require adult supervision, keyed by the callout shown
+var kidHazards = []struct {
+	callout  string
+	keywords []string
+}{
+	{"Knife safety: an adult should help with cutting", []string{"knife", "cut", "chop", "slice", "dice", "mince", "peel", "grate", "grater"}},
+	{"Heat safety: an adult should handle hot surfaces and liquids", []string{"oven", "stove", "hob", "boil", "simmer", "fry", "sear", "sauté", "saute", "bake", "roast", "grill", "broil", "hot", "heat", "microwave"}},
+}
+
+// enforceKidSafety flags any step that mentions a hazard as needing an adult
+// and makes sure the matching safety callout is present
+func enforceKidSafety(steps []KidStep) {
+	for i := range steps {
+		text := strings.ToLower(steps[i].Instruction)
+		for _, hazard := range kidHazards {
+			if !containsAny(text, hazard.keywords) {
+				continue
+			}
+			steps[i].AdultSupervision = true
+			if !containsAny(strings.ToLower(strings.Join(steps[i].SafetyCallouts, " ")), hazard.keywords) {
+				steps[i].SafetyCallouts = append(steps[i].SafetyCallouts, hazard.callout)
+			}
+		}
+	}
+}
+
+// containsAny reports whether any word in text starts with one of the
+// keywords, so "chopping" matches "chop" but "wheat" doesn't match "heat"
+func containsAny(text string, keywords []string) bool {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		for _, keyword := range keywords {
+			if strings.HasPrefix(word, keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
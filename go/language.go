@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// Language used when neither the input nor the request specifies one
+var defaultLanguage = language.AmericanEnglish
+
+// parseLanguage validates a BCP 47 language tag, defaulting to American English
+func parseLanguage(tag string) (language.Tag, error) {
+	if strings.TrimSpace(tag) == "" {
+		return defaultLanguage, nil
+	}
+	t, err := language.Parse(tag)
+	if err != nil {
+		return language.Und, newInputError("invalid language %q: %v", tag, err)
+	}
+	return t, nil
+}
+
+// languageName describes a tag in English for use in prompts (e.g. "French (fr-CA)")
+func languageName(t language.Tag) string {
+	return fmt.Sprintf("%s (%s)", display.English.Tags().Name(t), t)
+}
+
+// acceptLanguage returns the preferred language from an Accept-Language
+// header, or an empty string when the header is missing or invalid
+func acceptLanguage(header string) string {
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil || len(tags) == 0 || tags[0] == language.Und {
+		return ""
+	}
+	return tags[0].String()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const leftoverRecipeCount = 3
+
+// Define input schema for leftover transformation requests
+type LeftoverInput struct {
+	Leftovers           string `json:"leftovers" jsonschema:"description=Leftovers to use up (e.g. roast chicken\\, cooked rice),required=true"`
+	DietaryRestrictions string `json:"dietaryRestrictions,omitempty" jsonschema:"description=Any dietary restrictions (vegetarian\\, vegan\\, gluten-free\\, etc.)"`
+}
+
+// Food-safety guidance for a single leftover item
+type FoodSafetyNote struct {
+	Item              string `json:"item"`
+	StorageDuration   string `json:"storageDuration" jsonschema:"description=How long the item keeps refrigerated and frozen"`
+	ReheatTemperature string `json:"reheatTemperature" jsonschema:"description=Minimum internal reheating temperature in C and F"`
+	Notes             string `json:"notes,omitempty"`
+}
+
+// A recipe that transforms leftovers into a new dish
+type LeftoverRecipe struct {
+	Name            string   `json:"name"`
+	Description     string   `json:"description"`
+	TotalTime       string   `json:"totalTime"`
+	LeftoversUsed   []string `json:"leftoversUsed"`
+	Ingredients     []string `json:"ingredients"`
+	Instructions    []string `json:"instructions"`
+	FoodSafetyNotes []string `json:"foodSafetyNotes,omitempty"`
+}
+
+// Define output schema for leftover transformations
+type LeftoverTransformations struct {
+	Recipes    []LeftoverRecipe `json:"recipes"`
+	FoodSafety []FoodSafetyNote `json:"foodSafety"`
+}
+
+// Define the leftover transformation flow
+func defineLeftoverFlow(g *genkit.Genkit) *core.Flow[*LeftoverInput, *LeftoverTransformations, struct{}] {
+	return genkit.DefineFlow(g, "leftoverFlow", func(ctx context.Context, input *LeftoverInput) (*LeftoverTransformations, error) {
+		if strings.TrimSpace(input.Leftovers) == "" {
+			return nil, newInputError("leftovers are required")
+		}
+
+		dietaryRestrictions := input.DietaryRestrictions
+		if dietaryRestrictions == "" {
+			dietaryRestrictions = "none"
+		}
+
+		prompt := fmt.Sprintf(`Create %d different recipes that transform the following leftovers into new dishes:
+
+		Leftovers: %s
+		Dietary restrictions: %s
+
+		Please provide:
+		1. For each recipe, which leftovers it uses, the additional ingredients with quantities, and step-by-step instructions
+		2. Recipe-specific food-safety notes (e.g. bring sauces to a boil, do not reheat rice more than once)
+		3. For each leftover item, how long it keeps refrigerated and frozen, and the minimum internal reheating temperature in both Celsius and Fahrenheit
+
+		Follow standard food-safety guidance and make the dishes clearly different from each other.`,
+			leftoverRecipeCount, input.Leftovers, dietaryRestrictions)
+
+		result, _, err := genkit.GenerateData[LeftoverTransformations](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate leftover recipes: %w", err)
+		}
+
+		if len(result.Recipes) == 0 {
+			return nil, fmt.Errorf("no leftover recipes were generated")
+		}
+		if len(result.Recipes) > leftoverRecipeCount {
+			result.Recipes = result.Recipes[:leftoverRecipeCount]
+		}
+
+		return result, nil
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// A link to a related operation. Method is left out for GET
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+// recipeLinks builds the related operations of a stored recipe
+func recipeLinks(id string) map[string]Link {
+	base := "/api/recipe/" + id
+	return map[string]Link{
+		"self":      {Href: base},
+		"scale":     {Href: base + "/scale", Method: http.MethodPost},
+		"nutrition": {Href: base + "/nutrition"},
+		"pdf":       {Href: base + "/pdf", Type: "application/pdf"},
+		"share":     {Href: base + "/share", Method: http.MethodPost},
+		"refine":    {Href: base + "/refine", Method: http.MethodPost},
+		"favorite":  {Href: base + "/favorite", Method: http.MethodPost},
+		"review":    {Href: base + "/review", Method: http.MethodPut},
+		"reviews":   {Href: base + "/reviews"},
+		"delete":    {Href: base, Method: http.MethodDelete},
+	}
+}
+
+// recipeNutritionHandler analyses the nutrition of a stored recipe
+func recipeNutritionHandler(flow *core.Flow[*NutritionInput, *NutritionAnalysis, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recipe, ok := savedRecipes.get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+			return
+		}
+
+		analysis, err := flow.Run(r.Context(), &NutritionInput{
+			RecipeName:  recipe.Name,
+			Servings:    recipe.Servings,
+			Ingredients: recipe.Ingredients,
+		})
+		if err != nil {
+			log.Printf("Error analyzing nutrition of recipe %s: %v", recipe.ID, err)
+			writeFlowError(w, err, "Nutrition Analysis Failed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, analysis)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/dinocodesx/genkit-go/config"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// Cookie holding the session id
+	sessionCookie = "recipe_session"
+
+	// How long a login may take between redirect and callback
+	loginTimeout = 10 * time.Minute
+)
+
+// How long a session lasts, set from the config at startup
+var sessionTTL = 7 * 24 * time.Hour
+
+// An OpenID Connect client for signing in to the HTML pages; nil when
+// login isn't configured
+type oidcLogin struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Login client set up at startup
+var login *oidcLogin
+
+// A login that has been sent to the provider and not yet come back
+type pendingLogin struct {
+	nonce    string
+	verifier string
+	redirect string
+	created  time.Time
+}
+
+// A signed-in user
+type Session struct {
+	User    string    `json:"user"`
+	Email   string    `json:"email,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// sessionStore keeps sessions by id and logins in progress by state
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	pending  map[string]*pendingLogin
+}
+
+// Sessions created by signing in
+var sessions = &sessionStore{sessions: make(map[string]*Session), pending: make(map[string]*pendingLogin)}
+
+// randomToken returns 32 random bytes, base64url encoded
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newOIDCLogin discovers the provider's endpoints and keys
+func newOIDCLogin(ctx context.Context, cfg *config.Config) (*oidcLogin, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider: %w", err)
+	}
+	return &oidcLogin{
+		oauth: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}),
+	}, nil
+}
+
+// begin records a login in progress and returns its state
+func (s *sessionStore) begin(p *pendingLogin) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for state, pending := range s.pending {
+		if time.Since(pending.created) > loginTimeout {
+			delete(s.pending, state)
+		}
+	}
+	state := randomToken()
+	s.pending[state] = p
+	return state
+}
+
+// finish takes the login in progress for a state; each state is used once
+func (s *sessionStore) finish(state string) (*pendingLogin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pending[state]
+	delete(s.pending, state)
+	if !ok || time.Since(p.created) > loginTimeout {
+		return nil, false
+	}
+	return p, true
+}
+
+// create stores a session and returns its id
+func (s *sessionStore) create(session *Session) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, existing := range s.sessions {
+		if time.Now().After(existing.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+	id := randomToken()
+	s.sessions[id] = session
+	return id
+}
+
+// get returns an unexpired session
+func (s *sessionStore) get(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.Expires) {
+		return nil, false
+	}
+	return session, true
+}
+
+// end deletes a session
+func (s *sessionStore) end(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// requestSession returns the session of the request's cookie, if any
+func requestSession(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	return sessions.get(cookie.Value)
+}
+
+// safeRedirect keeps post-login redirects on this site
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// loginHandler sends the browser to the provider's sign-in page, using
+// PKCE and a nonce. ?redirect= is where to go afterwards
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if login == nil {
+		writeError(w, http.StatusNotImplemented, "Login Disabled", "Set OIDC_CLIENT_ID and OIDC_CLIENT_SECRET on the server to enable login")
+		return
+	}
+
+	pending := &pendingLogin{
+		nonce:    randomToken(),
+		verifier: oauth2.GenerateVerifier(),
+		redirect: safeRedirect(r.URL.Query().Get("redirect")),
+		created:  time.Now(),
+	}
+	state := sessions.begin(pending)
+
+	oauthConfig := login.oauth
+	if oauthConfig.RedirectURL == "" {
+		oauthConfig.RedirectURL = baseURL(r) + "/auth/callback"
+	}
+	http.Redirect(w, r, oauthConfig.AuthCodeURL(state, oidc.Nonce(pending.nonce), oauth2.S256ChallengeOption(pending.verifier)), http.StatusFound)
+}
+
+// callbackHandler finishes a login: it exchanges the code, verifies the ID
+// token and sets the session cookie
+func callbackHandler(w http.ResponseWriter, r *http.Request) {
+	if login == nil {
+		writeError(w, http.StatusNotImplemented, "Login Disabled", "Set OIDC_CLIENT_ID and OIDC_CLIENT_SECRET on the server to enable login")
+		return
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		writeError(w, http.StatusUnauthorized, "Login Failed", e+": "+query.Get("error_description"))
+		return
+	}
+	pending, ok := sessions.finish(query.Get("state"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Login Failed", "The login has expired or was already used, please sign in again")
+		return
+	}
+
+	oauthConfig := login.oauth
+	if oauthConfig.RedirectURL == "" {
+		oauthConfig.RedirectURL = baseURL(r) + "/auth/callback"
+	}
+	token, err := oauthConfig.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(pending.verifier))
+	if err != nil {
+		log.Printf("Error exchanging login code: %v", err)
+		writeError(w, http.StatusBadGateway, "Login Failed", "Could not exchange the authorization code")
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		writeError(w, http.StatusBadGateway, "Login Failed", "The provider did not return an ID token")
+		return
+	}
+	idToken, err := login.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != pending.nonce {
+		writeError(w, http.StatusUnauthorized, "Login Failed", "The ID token could not be verified")
+		return
+	}
+
+	var claims struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		writeError(w, http.StatusBadGateway, "Login Failed", err.Error())
+		return
+	}
+
+	// Signing in registers the user, or refreshes their name and email
+	if _, err := users.register(r.Context(), idToken.Subject, claims.Email, claims.Name); err != nil {
+		log.Printf("Failed to register user %s: %v", idToken.Subject, err)
+	}
+
+	session := &Session{User: idToken.Subject, Email: claims.Email, Name: claims.Name, Expires: time.Now().Add(sessionTTL).UTC()}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sessions.create(session),
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, pending.redirect, http.StatusFound)
+}
+
+// logoutHandler ends the session and clears its cookie
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		sessions.end(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// meHandler returns the signed-in user
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := requestSession(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Not Signed In", "Sign in at /auth/login")
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// requireLogin sends visitors without a session to the sign-in page when
+// login is configured
+func requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if login != nil {
+			if _, ok := requestSession(r); !ok {
+				http.Redirect(w, r, "/auth/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
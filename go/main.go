@@ -6,114 +6,233 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/firebase/genkit/go/ai"
+	"github.com/dinocodesx/genkit-go/config"
+	"github.com/firebase/genkit/go/core/api"
 	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/compat_oai"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
-	"github.com/firebase/genkit/go/plugins/server"
+	"github.com/firebase/genkit/go/plugins/ollama"
 )
 
-// Define input schema for food recipe requests
-type FoodInput struct {
-	FoodName            string `json:"foodName" jsonschema:"description=Name of the food to cook,required=true"`
-	DietaryRestrictions string `json:"dietaryRestrictions,omitempty" jsonschema:"description=Any dietary restrictions (vegetarian, vegan, gluten-free, etc.)"`
-	Difficulty          string `json:"difficulty,omitempty" jsonschema:"description=Preferred difficulty level (easy, medium, hard)"`
-	ServingSize         int    `json:"servingSize,omitempty" jsonschema:"description=Number of servings needed"`
-}
-
-// Define output schema for recipe response
-type FoodRecipe struct {
-	Name         string   `json:"name"`
-	Description  string   `json:"description"`
-	Difficulty   string   `json:"difficulty"`
-	PrepTime     string   `json:"prepTime"`
-	CookTime     string   `json:"cookTime"`
-	TotalTime    string   `json:"totalTime"`
-	Servings     int      `json:"servings"`
-	Ingredients  []string `json:"ingredients"`
-	Instructions []string `json:"instructions"`
-	Tips         []string `json:"tips,omitempty"`
-	Nutrition    string   `json:"nutrition,omitempty"`
-}
+func main() {
+	// Cancelled when shutdown gives up waiting for in-flight generations
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	appCtx = ctx
 
-// Error response structure
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
-}
+	// Load settings from the config file, environment and flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	webhookSecret = cfg.WebhookSecret
+	adminAPIKey = cfg.AdminAPIKey
+	if cfg.JWTIssuer != "" {
+		if jwtVerifier, err = newJWTVerifier(ctx, cfg); err != nil {
+			log.Fatalf("Failed to set up JWT authentication: %v", err)
+		}
+		jwtUserClaim = cfg.JWTUserClaim
+	}
+	sessionTTL = time.Duration(cfg.SessionTTL)
+	if cfg.OIDCClientID != "" {
+		if login, err = newOIDCLogin(ctx, cfg); err != nil {
+			log.Fatalf("Failed to set up login: %v", err)
+		}
+	}
+	runtimeSettings.Store(newRuntimeSettings(cfg))
+	trustedProxies, trustUnixPeers = parseTrustedProxies(cfg.TrustedProxies)
+	allowedModels = cfg.AllowedModels
+	maxModelRetries, modelRetryDelay = cfg.ModelRetries, time.Duration(cfg.ModelRetryDelay)
+	breakerThreshold, breakerCooldown = cfg.CircuitBreakerThreshold, time.Duration(cfg.CircuitBreakerCooldown)
+	safetySettings = newSafetySettings(cfg.SafetySettings)
+	fdcAPIKey = cfg.FDCAPIKey
+	searchAPIKey, searchEngineID = cfg.SearchAPIKey, cfg.SearchEngineID
+	if featureFlags, err = newFeatureFlagSource(cfg.FeatureFlagsFile, cfg.FeatureFlags); err != nil {
+		log.Fatalf("Invalid feature flags: %v", err)
+	}
+	featureFlags.reloadOnSIGHUP()
+	if accessLog, err = newAccessLogger(cfg.AccessLogFormat, cfg.AccessLogFile); err != nil {
+		log.Fatalf("Failed to open the access log: %v", err)
+	}
+	requestTimeout = time.Duration(cfg.RequestTimeout)
+	maxBodyBytes = cfg.MaxBodyBytes
+	for pattern, timeout := range cfg.RouteTimeouts {
+		routeTimeouts[pattern] = time.Duration(timeout)
+	}
 
-func main() {
-	ctx := context.Background()
+	// Export traces of requests, flows and model calls
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.OTLPEndpoint != "" {
+		if shutdownTracing, err = setupTracing(ctx, cfg.OTLPEndpoint); err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+	}
 
-	// Initialize Genkit with the Google AI plugin
+	// Initialize Genkit with the Google AI plugin, the Ollama plugin for
+	// local models, the plugin of an OpenAI-compatible API and the .prompt
+	// files. Google AI is left out when only other providers are
+	// configured, so the server runs without a Gemini key. Flows
+	// generate with the runtime model, which forwards to the model selected
+	// in the settings
+	if info, err := os.Stat(cfg.PromptDir); err != nil || !info.IsDir() {
+		log.Fatalf("Prompt directory %q not found; set PROMPT_DIR to the directory holding recipe.prompt", cfg.PromptDir)
+	}
+	var plugins []api.Plugin
+	if cfg.GeminiAPIKey != "" || cfg.OllamaAddress == "" && cfg.OpenAIBaseURL == "" && cfg.OpenAIAPIKey == "" {
+		plugins = append(plugins, &googlegenai.GoogleAI{APIKey: cfg.GeminiAPIKey})
+	}
+	var local *ollama.Ollama
+	if cfg.OllamaAddress != "" {
+		local = &ollama.Ollama{ServerAddress: cfg.OllamaAddress, Timeout: int(ollamaTimeout / time.Second)}
+		plugins = append(plugins, local)
+	}
+	var compatible *compat_oai.OpenAICompatible
+	if cfg.OpenAIBaseURL != "" || cfg.OpenAIAPIKey != "" {
+		compatible = newOpenAIPlugin(cfg.OpenAIBaseURL, cfg.OpenAIAPIKey)
+		plugins = append(plugins, compatible)
+	}
 	g := genkit.Init(ctx,
-		genkit.WithPlugins(&googlegenai.GoogleAI{}),
-		genkit.WithDefaultModel("googleai/gemini-2.0-flash"),
+		genkit.WithPlugins(plugins...),
+		genkit.WithDefaultModel(runtimeModelName),
+		genkit.WithPromptDir(cfg.PromptDir),
 	)
-
-	// Define the food recipe generator flow
-	foodRecipeFlow := genkit.DefineFlow(g, "foodRecipeFlow", func(ctx context.Context, input *FoodInput) (*FoodRecipe, error) {
-		// Validate input
-		if strings.TrimSpace(input.FoodName) == "" {
-			return nil, fmt.Errorf("food name is required")
+	if genkit.LookupPrompt(g, recipePromptName) == nil {
+		log.Fatalf("Prompt %q not found in %s", recipePromptName, cfg.PromptDir)
+	}
+	if promptVersions, err = newPromptRegistry(g, cfg.PromptTraffic); err != nil {
+		log.Fatalf("Invalid prompt experiments: %v", err)
+	}
+	prompts.dir = cfg.PromptDir
+	prompts.reloadOnSIGHUP(g)
+	if local != nil {
+		defineOllamaModels(ctx, g, local, cfg.OllamaModels)
+		if name, ok := strings.CutPrefix(cfg.EmbeddingModel, "ollama/"); ok {
+			defineOllamaEmbedder(g, local, name)
 		}
-
-		// Set default values
-		difficulty := input.Difficulty
-		if difficulty == "" {
-			difficulty = "medium"
+	}
+	if compatible != nil {
+		defineOpenAIModels()
+		if name, ok := strings.CutPrefix(cfg.EmbeddingModel, openAIProvider+"/"); ok {
+			defineOpenAIEmbedder(g, compatible, name)
 		}
+	}
+	if genkit.LookupModel(g, cfg.Model) == nil {
+		log.Fatalf("Model %q not found", cfg.Model)
+	}
+	defineRuntimeModel(g)
+
+	// Tools the model calls during generation
+	defineNutritionTool(g)
+	defineUnitsTool(g)
+	defineSearchTool(g)
+
+	// Keep recipes in the configured store, so they can be fetched by id
+	// after a restart, and user profiles, API keys, pantries, shopping
+	// lists, calendars and collections with them
+	if savedRecipes, err = newRecipeStore(ctx, cfg.RecipeStore, cfg.RecipeStoreURL); err != nil {
+		log.Fatalf("Failed to open the recipe store: %v", err)
+	}
+	users = newUserStore(savedRecipes)
+	apiKeys = newAPIKeyStore(savedRecipes)
+	pantries = newPantryStore(savedRecipes)
+	shoppingLists = newShoppingListStore(savedRecipes)
+	calendars = newCalendarStore(savedRecipes)
+	collections = newCollectionStore(savedRecipes)
+
+	// Embed recipes into the configured vector store, so they can be
+	// retrieved by meaning
+	if genkit.LookupEmbedder(g, cfg.EmbeddingModel) == nil {
+		log.Fatalf("Embedder %q not found", cfg.EmbeddingModel)
+	}
+	store, err := newVectorStore(ctx, cfg.VectorStore, cfg.VectorStoreURL, cfg.VectorStoreAPIKey)
+	if err != nil {
+		log.Fatalf("Failed to open the vector store: %v", err)
+	}
+	recipeVectors = &recipeIndex{g: g, store: store, embedder: cfg.EmbeddingModel}
+	defineRecipeRetriever(g)
+	if cfg.SemanticCacheThreshold > 0 {
+		recipeCache = newSemanticCache(recipeVectors, cfg.SemanticCacheThreshold)
+	}
 
-		servingSize := input.ServingSize
-		if servingSize == 0 {
-			servingSize = 4
-		}
+	// Score every generated recipe's quality
+	defineRecipeEvaluators(g)
 
-		dietaryRestrictions := input.DietaryRestrictions
-		if dietaryRestrictions == "" {
-			dietaryRestrictions = "none"
-		}
+	// Define the food recipe generator flows
+	foodRecipeFlow := defineFoodRecipeFlow(g)
+	foodRecipeStreamFlow := defineFoodRecipeStreamFlow(g)
 
-		// Create a detailed prompt for recipe generation
-		prompt := fmt.Sprintf(`Create a detailed, authentic recipe for "%s" with the following specifications:
-
-		Food: %s
-		Difficulty level: %s
-		Servings: %d
-		Dietary restrictions: %s
-
-		Please provide:
-		1. A brief description of the dish
-		2. Accurate preparation and cooking times
-		3. A complete ingredients list with specific quantities
-		4. Step-by-step cooking instructions that are easy to follow
-		5. Helpful cooking tips and techniques
-		6. Basic nutritional information
-
-		Make sure the recipe is practical and achievable for home cooking.`,
-			input.FoodName, input.FoodName, difficulty, servingSize, dietaryRestrictions)
-
-		// Generate structured recipe data - Genkit Model Calling
-		recipe, _, err := genkit.GenerateData[FoodRecipe](ctx, g,
-			ai.WithPrompt(prompt),
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate recipe for %s: %w", input.FoodName, err)
-		}
+	// Define the meal plan generator flow
+	mealPlanFlow := defineMealPlanFlow(g)
 
-		// Ensure the recipe name matches the input
-		if recipe.Name == "" {
-			recipe.Name = input.FoodName
-		}
+	// Define the flow that plans a single calendar meal
+	mealSlotFlow := defineMealSlotFlow(g)
 
-		// Set servings if not provided by AI
-		if recipe.Servings == 0 {
-			recipe.Servings = servingSize
-		}
+	// Define the pantry suggestion flow
+	pantryFlow := definePantryFlow(g)
 
-		return recipe, nil
-	})
+	// Define the shopping list flow
+	shoppingListFlow := defineShoppingListFlow(g)
+
+	// Define the ingredient substitution flow
+	substitutionFlow := defineSubstitutionFlow(g)
+
+	// Define the nutrition analysis flow
+	nutritionFlow := defineNutritionFlow(g)
+
+	// Define the beverage pairing flow
+	pairingFlow := definePairingFlow(g)
+
+	// Define the leftover transformation flow
+	leftoverFlow := defineLeftoverFlow(g)
+
+	// Define the cuisine variation flow
+	variationFlow := defineVariationFlow(g)
+
+	// Define the cooking technique explainer flow
+	techniqueFlow := defineTechniqueFlow(g)
+
+	// Define the recipe improvement flow
+	improveFlow := defineImproveFlow(g)
+
+	// Define the personalized diet plan flow
+	dietPlanFlow := defineDietPlanFlow(g)
+
+	// Define the drink recipe flow
+	drinkRecipeFlow := defineDrinkRecipeFlow(g)
+
+	// Define the recipe-from-image flow
+	recipeFromImageFlow := defineRecipeFromImageFlow(g, foodRecipeFlow)
+
+	// Define the cook-along chat flow
+	chatFlow := defineChatFlow(g)
+
+	// Define the seasonal suggestion flow
+	seasonalFlow := defineSeasonalFlow(g)
+
+	// Define the pantry photo flow
+	pantryFromImageFlow := definePantryFromImageFlow(g, pantryFlow)
+
+	// Define the recipe refinement flow
+	refineFlow := defineRefineFlow(g)
+
+	genkitReady.Store(true)
+
+	// Probe the model for readiness checks
+	if readinessProbe, err = newModelProbe(ctx, cfg.GeminiAPIKey, cfg.Model); err != nil {
+		log.Fatalf("Failed to set up the model probe: %v", err)
+	}
+	deepProbe = &generationProbe{g: g}
+
+	// Build the GraphQL schema over the recipe, meal plan and saved recipe data
+	graphQLSchema, err := newGraphQLSchema(foodRecipeFlow, mealPlanFlow)
+	if err != nil {
+		log.Fatalf("Failed to build GraphQL schema: %v", err)
+	}
 
 	// Test the flow with a sample request
 	log.Println("Testing recipe generation...")
@@ -131,48 +250,314 @@ func main() {
 		fmt.Println(string(recipeJSON))
 	}
 
-	// Set up HTTP routes
+	// Set up HTTP routes. Every route is logged and counted, and reports the
+	// model retries it needed and, on request, its token usage. API routes
+	// also allow cross-origin calls and check credentials; they and the other
+	// pages and flows are rate limited, have their body size capped, replay
+	// responses to retried POSTs that carry an Idempotency-Key, prune JSON
+	// responses to ?fields= and get a deadline; in maintenance mode most API
+	// routes answer 503. Admin routes need admin credentials and are off
+	// until some are configured. Probes and docs skip all of that so they
+	// keep answering a client that has used up its requests
 	mux := http.NewServeMux()
+	routes := newRouteGroup(mux, logRequests, recordMetrics, reportModelRetries, reportUsage)
+	api := routes.Group(allowCORS, duringMaintenance, requireAuth, rateLimited, limitBodies, idempotent, sparseFields, withTimeouts)
+	limited := routes.Group(rateLimited, limitBodies, idempotent, sparseFields, withTimeouts)
+	admin := routes.Group(requireAdminAPI, requireAuth, rateLimited, withTimeouts, limitBodies)
+	ops := newRouteGroup(mux, recordMetrics)
 
-	// Main recipe endpoint
-	mux.HandleFunc("POST /api/recipe", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	// Browser preflights for any API route, answered by allowCORS
+	api.HandleFunc("OPTIONS /api/", func(w http.ResponseWriter, r *http.Request) {})
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+	// Main recipe endpoint
+	api.HandleFunc("POST /api/recipe", validated[FoodInput](func(w http.ResponseWriter, r *http.Request) {
+		// Respond as JSON, YAML, XML, Markdown or JSON-LD depending on ?format=
+		// or the Accept header
+		serializer, ok := requestSerializer(r)
+		if !ok {
+			writeError(w, http.StatusNotAcceptable, "Not Acceptable", "Supported formats are json, yaml, xml, markdown and jsonld")
 			return
 		}
 
 		var input FoodInput
 		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(ErrorResponse{
-				Error:   "Invalid JSON",
-				Message: "Please provide valid JSON input",
+			writeSerialized(w, serializer, http.StatusBadRequest, ErrorResponse{
+				Error:     "Invalid JSON",
+				Message:   "Please provide valid JSON input",
+				RequestID: w.Header().Get(requestIDHeader),
 			})
 			return
 		}
 
+		// Fall back to the Accept-Language header when no language is given
+		if input.Language == "" {
+			input.Language = acceptLanguage(r.Header.Get("Accept-Language"))
+		}
+
+		// Answer right away and deliver the recipe to the callback later
+		if input.CallbackURL != "" {
+			acceptWithCallback(w, r, foodRecipeFlow, &input, input.CallbackURL)
+			return
+		}
+
 		recipe, err := foodRecipeFlow.Run(r.Context(), &input)
 		if err != nil {
 			log.Printf("Error generating recipe: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(ErrorResponse{
-				Error:   "Recipe Generation Failed",
-				Message: err.Error(),
-			})
+			writeSerialized(w, serializer, errorStatus(err), flowError(w, err, "Recipe Generation Failed"))
+			return
+		}
+
+		writeSerialized(w, serializer, http.StatusOK, recipe)
+	}))
+
+	api.HandleFunc("POST /api/recipe/stream", validated[FoodInput](func(w http.ResponseWriter, r *http.Request) {
+		var input FoodInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+
+		if input.Language == "" {
+			input.Language = acceptLanguage(r.Header.Get("Accept-Language"))
+		}
+
+		if _, ok := w.(http.Flusher); !ok {
+			writeError(w, http.StatusInternalServerError, "Streaming Unsupported", "The server does not support streaming responses")
 			return
 		}
 
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(recipe)
+
+		// Emit the recipe as far as it has been written as "partial" events
+		// and the final recipe as "done"
+		for value, err := range foodRecipeStreamFlow.Stream(r.Context(), &input) {
+			if err != nil {
+				log.Printf("Error streaming recipe: %v", err)
+				writeSSE(w, "error", flowError(w, err, "Recipe Generation Failed"))
+				return
+			}
+			if value.Done {
+				writeSSE(w, "done", value.Output)
+				return
+			}
+			if err := writeSSE(w, "partial", value.Stream); err != nil {
+				return
+			}
+		}
+	}))
+
+	// Meal plan endpoint
+	api.HandleFunc("POST /api/mealplan", flowHandler(mealPlanFlow, "Meal Plan Generation Failed"))
+
+	// Pantry suggestion endpoint
+	api.HandleFunc("POST /api/suggest", flowHandler(pantryFlow, "Suggestion Generation Failed"))
+
+	// Shopping list endpoint
+	api.HandleFunc("POST /api/shopping-list", flowHandler(shoppingListFlow, "Shopping List Generation Failed"))
+
+	// Ingredient substitution endpoint
+	api.HandleFunc("POST /api/substitute", flowHandler(substitutionFlow, "Substitution Generation Failed"))
+
+	// Nutrition analysis endpoint
+	api.HandleFunc("POST /api/nutrition", flowHandler(nutritionFlow, "Nutrition Analysis Failed"))
+
+	// Beverage pairing endpoint
+	api.HandleFunc("POST /api/pairing", flowHandler(pairingFlow, "Pairing Generation Failed"))
+
+	// Leftover transformation endpoint
+	api.HandleFunc("POST /api/leftovers", flowHandler(leftoverFlow, "Leftover Recipe Generation Failed"))
+
+	// Cuisine variation endpoint
+	api.HandleFunc("POST /api/recipe/variations", flowHandler(variationFlow, "Variation Generation Failed"))
+
+	// Cooking technique endpoint
+	api.HandleFunc("POST /api/technique", flowHandler(techniqueFlow, "Technique Explanation Failed"))
+
+	// Recipe critique and improvement endpoint
+	api.HandleFunc("POST /api/recipe/improve", flowHandler(improveFlow, "Recipe Improvement Failed"))
+
+	// Diet plan endpoint
+	api.HandleFunc("POST /api/dietplan", flowHandler(dietPlanFlow, "Diet Plan Generation Failed"))
+
+	// Drink recipe endpoint
+	api.HandleFunc("POST /api/drink", flowHandler(drinkRecipeFlow, "Drink Recipe Generation Failed"))
+
+	// Recipe-from-image endpoint (multipart or base64 JSON)
+	api.HandleFunc("POST /api/recipe/from-image", recipeFromImageHandler(recipeFromImageFlow))
+
+	// Generated dish images
+	api.HandleFunc("GET /api/images/{id}", dishImageHandler)
+
+	// Cook-along chat session endpoints
+	api.HandleFunc("POST /api/session", requireFeature(featureChatSessions, createSessionHandler))
+	api.HandleFunc("POST /api/session/{id}/message", requireFeature(featureChatSessions, sessionMessageHandler(chatFlow)))
+
+	// Spoken instruction steps for hands-free clients
+	api.HandleFunc("GET /api/recipe/{id}/audio", recipeAudioHandler)
+	api.HandleFunc("GET /api/recipe/{id}/audio/{step}", recipeStepAudioHandler(g))
+
+	// Seasonal suggestions take their parameters from the query string
+	api.HandleFunc("GET /api/seasonal", seasonalHandler(seasonalFlow))
+
+	// GraphQL endpoint for clients that select only the fields they need
+	limited.HandleFunc("POST /graphql", graphQLHandler(graphQLSchema))
+	limited.HandleFunc("GET /graphql", graphQLHandler(graphQLSchema))
+
+	// WebSocket streaming for clients that cancel generations mid-stream
+	limited.HandleFunc("GET /ws/recipe", recipeWebSocketHandler(foodRecipeStreamFlow))
+
+	// Versioned routes: the recipe schema is pinned per version and other
+	// endpoints are shared with /api until their schema changes
+	api.HandleFunc("POST /v1/recipe", validated[FoodInput](versionedRecipeHandler(foodRecipeFlow, toRecipeV1)))
+	api.HandleFunc("POST /v2/recipe", validated[FoodInput](versionedRecipeHandler(foodRecipeFlow, toRecipeV2)))
+	// The aliases go straight on the mux, since the /api route they resolve
+	// to runs its own middleware
+	mux.Handle("/v1/", versionAlias("v1", mux))
+	mux.Handle("/v2/", versionAlias("v2", mux))
+
+	// Generated recipes and meal plans by id, with ETags for cheap re-syncs
+	api.HandleFunc("GET /api/recipe/{id}", storedRecipeHandler)
+	api.HandleFunc("DELETE /api/recipe/{id}", deleteRecipeHandler)
+	api.HandleFunc("PATCH /api/recipe/{id}", validated[RecipeEdit](editRecipeHandler))
+	api.HandleFunc("GET /api/recipe/{id}/versions", recipeVersionsHandler)
+	api.HandleFunc("GET /api/recipe/{id}/versions/{version}", recipeVersionHandler)
+	api.HandleFunc("GET /api/mealplan/{id}", storedMealPlanHandler)
+	api.HandleFunc("GET /v1/recipe/{id}", storedVersionedRecipeHandler(toRecipeV1))
+	api.HandleFunc("GET /v2/recipe/{id}", storedVersionedRecipeHandler(toRecipeV2))
+
+	// Printable recipe card
+	api.HandleFunc("GET /api/recipe/{id}/pdf", recipePDFHandler)
+
+	// Collections of stored recipes, printable as cookbooks
+	api.HandleFunc("GET /api/collections", listCollectionsHandler)
+	api.HandleFunc("POST /api/collections", validated[CollectionInput](putCollectionHandler))
+	api.HandleFunc("GET /api/collections/{id}", getCollectionHandler)
+	api.HandleFunc("PUT /api/collections/{id}", validated[CollectionInput](putCollectionHandler))
+	api.HandleFunc("DELETE /api/collections/{id}", deleteCollectionHandler)
+	api.HandleFunc("POST /api/collections/{id}/recipes", validated[CollectionRecipe](addCollectionRecipeHandler))
+	api.HandleFunc("DELETE /api/collections/{id}/recipes/{recipe}", removeCollectionRecipeHandler)
+	api.HandleFunc("GET /api/collections/{id}/pdf", collectionPDFHandler)
+
+	// Meal plan calendar feed
+	api.HandleFunc("GET /api/mealplan/{id}/ical", mealPlanICalHandler)
+
+	// Each account's meal calendar, filled from meal plans
+	api.HandleFunc("GET /api/calendar", calendarHandler)
+	api.HandleFunc("POST /api/calendar", validated[CalendarPlacement](placeMealPlanHandler))
+	api.HandleFunc("POST /api/calendar/move", validated[CalendarMove](moveMealHandler))
+	api.HandleFunc("POST /api/calendar/{date}/{meal}/regenerate", regenerateMealHandler(mealSlotFlow))
+	api.HandleFunc("DELETE /api/calendar/{date}/{meal}", clearMealHandler)
+
+	// Shopping list export as {id}.csv or {id}.tsv
+	api.HandleFunc("GET /api/shopping-list/{file}", shoppingListExportHandler)
+
+	// Shopping lists each account keeps, merging recipes and meal plans
+	api.HandleFunc("GET /api/shopping-lists", listShoppingListsHandler)
+	api.HandleFunc("POST /api/shopping-lists", validated[NewShoppingList](createShoppingListHandler))
+	api.HandleFunc("GET /api/shopping-lists/{id}", getShoppingListHandler)
+	api.HandleFunc("PATCH /api/shopping-lists/{id}", validated[ShoppingListRename](renameShoppingListHandler))
+	api.HandleFunc("DELETE /api/shopping-lists/{id}", deleteShoppingListHandler)
+	api.HandleFunc("POST /api/shopping-lists/{id}/recipes", validated[ShoppingListAdditions](addShoppingListRecipesHandler))
+	api.HandleFunc("POST /api/shopping-lists/{id}/items/{item}/check", checkShoppingItemHandler(true))
+	api.HandleFunc("DELETE /api/shopping-lists/{id}/items/{item}/check", checkShoppingItemHandler(false))
+
+	// Stored recipe listing with cursor pagination
+	api.HandleFunc("GET /api/recipes", listRecipesHandler)
+	api.HandleFunc("GET /api/tags", tagsHandler)
+
+	// Bulk import of user-authored recipes (JSON array or NDJSON)
+	api.HandleFunc("POST /api/recipes/import", importRecipesHandler)
+
+	// Semantic search over stored recipes
+	api.HandleFunc("POST /api/recipes/search", validated[RecipeSearchInput](searchRecipesHandler))
+	api.HandleFunc("GET /api/recipes/search", textSearchHandler)
+
+	// Share links with QR codes, and the HTML page they point at
+	api.HandleFunc("POST /api/recipe/{id}/share", shareRecipeHandler)
+	api.HandleFunc("GET /api/share/{slug}/qr.png", shareQRHandler)
+	limited.HandleFunc("GET /s/{slug}", requireLogin(sharePageHandler))
+
+	// Pantry photo endpoint (multipart or base64 JSON)
+	api.HandleFunc("POST /api/pantry/from-image", pantryFromImageHandler(pantryFromImageFlow))
+
+	// Each account's pantry, which suggestions can cook from
+	api.HandleFunc("GET /api/pantry", listPantryHandler)
+	api.HandleFunc("POST /api/pantry", validated[PantryEntryInput](putPantryItemHandler))
+	api.HandleFunc("GET /api/pantry/{id}", getPantryItemHandler)
+	api.HandleFunc("PUT /api/pantry/{id}", validated[PantryEntryInput](putPantryItemHandler))
+	api.HandleFunc("DELETE /api/pantry/{id}", deletePantryItemHandler)
+
+	// Operations on a stored recipe, advertised in its _links
+	api.HandleFunc("POST /api/recipe/{id}/scale", scaleRecipeHandler)
+	api.HandleFunc("GET /api/recipe/{id}/nutrition", recipeNutritionHandler(nutritionFlow))
+	api.HandleFunc("POST /api/recipe/{id}/refine", refineRecipeHandler(refineFlow))
+
+	// Per-account favorites, counted on every stored recipe
+	api.HandleFunc("POST /api/recipe/{id}/favorite", favoriteHandler(true))
+	api.HandleFunc("DELETE /api/recipe/{id}/favorite", favoriteHandler(false))
+	api.HandleFunc("GET /api/favorites", favoritesHandler)
+
+	// One rating and review per account and recipe, averaged on reads
+	api.HandleFunc("PUT /api/recipe/{id}/review", validated[ReviewInput](reviewHandler))
+	api.HandleFunc("GET /api/recipe/{id}/reviews", reviewsHandler)
+
+	// Profiles of signed-in users, whose preferences fill in their recipe
+	// requests
+	api.HandleFunc("GET /api/profile", profileHandler)
+	api.HandleFunc("PUT /api/profile", validated[UserPreferences](updateProfileHandler))
+
+	// Each account's generations, searchable and re-runnable
+	api.HandleFunc("GET /api/history", historyHandler)
+	api.HandleFunc("POST /api/history/{id}/rerun", rerunHandler(foodRecipeFlow))
+
+	// Effective configuration, with secrets redacted
+	limited.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cfg.Redacted())
 	})
 
+	// Runtime settings and their audit log (admin scope)
+	admin.HandleFunc("GET /admin/config", getSettingsHandler)
+	admin.HandleFunc("PATCH /admin/config", updateSettingsHandler(g))
+	admin.HandleFunc("GET /admin/config/audit", settingsAuditHandler)
+
+	// Profiles, expvar counters and goroutine dumps for live debugging
+	registerDebugRoutes(admin)
+
+	// Feature flags, reloaded from their file on request or SIGHUP
+	admin.HandleFunc("GET /admin/flags", featureFlagsHandler)
+	admin.HandleFunc("POST /admin/flags/reload", reloadFeatureFlagsHandler)
+
+	// Prompt versions and how each is doing
+	admin.HandleFunc("GET /admin/prompts", promptStatsHandler)
+	admin.HandleFunc("POST /admin/prompts/reload", reloadPromptsHandler(g))
+
+	// Average quality scores of generated recipes
+	admin.HandleFunc("GET /admin/quality", qualityStatsHandler)
+
+	// Token usage and cost per API key per day
+	admin.HandleFunc("GET /admin/usage", usageHandler)
+
+	// API key management (admin scope)
+	api.HandleFunc("POST /api/keys", createAPIKeyHandler)
+	api.HandleFunc("GET /api/keys", listAPIKeysHandler)
+	api.HandleFunc("DELETE /api/keys/{id}", revokeAPIKeyHandler)
+
+	// Sign-in for the HTML pages through the configured OIDC provider
+	limited.HandleFunc("GET /auth/login", loginHandler)
+	limited.HandleFunc("GET /auth/callback", callbackHandler)
+	limited.HandleFunc("POST /auth/logout", logoutHandler)
+	limited.HandleFunc("GET /auth/me", meHandler)
+
+	// Liveness and readiness probes
+	ops.HandleFunc("GET /healthz", livenessHandler)
+	ops.HandleFunc("GET /healthz/deep", deepHealthHandler)
+	ops.HandleFunc("GET /readyz", readinessHandler)
+
 	// Health check endpoint
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+	ops.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -181,45 +566,110 @@ func main() {
 		})
 	})
 
-	// API documentation endpoint
-	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"service": "Food Recipe API",
-			"version": "1.0.0",
-			"endpoints": map[string]interface{}{
-				"POST /api/recipe": map[string]interface{}{
-					"description": "Generate a recipe for a given food name",
-					"input": map[string]string{
-						"foodName":            "Name of the food (required)",
-						"dietaryRestrictions": "Optional dietary restrictions",
-						"difficulty":          "Optional difficulty level (easy, medium, hard)",
-						"servingSize":         "Optional number of servings",
-					},
-				},
-				"GET /health": "Health check endpoint",
-			},
-			"example_request": map[string]interface{}{
-				"foodName":            "Chicken Tikka Masala",
-				"dietaryRestrictions": "gluten-free",
-				"difficulty":          "medium",
-				"servingSize":         6,
-			},
-		})
+	// API documentation generated from the input/output structs
+	ops.HandleFunc("GET /openapi.json", openAPIHandler)
+	ops.HandleFunc("GET /docs", docsHandler)
+	ops.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs", http.StatusFound)
 	})
 
 	// Genkit flow endpoint (for development/testing)
-	mux.HandleFunc("POST /foodRecipeFlow", genkit.Handler(foodRecipeFlow))
+	limited.HandleFunc("POST /foodRecipeFlow", genkit.Handler(foodRecipeFlow))
+	limited.HandleFunc("POST /foodRecipeStreamFlow", genkit.Handler(foodRecipeStreamFlow))
+	limited.HandleFunc("POST /mealPlanFlow", genkit.Handler(mealPlanFlow))
+	limited.HandleFunc("POST /mealSlotFlow", genkit.Handler(mealSlotFlow))
+	limited.HandleFunc("POST /pantryFlow", genkit.Handler(pantryFlow))
+	limited.HandleFunc("POST /shoppingListFlow", genkit.Handler(shoppingListFlow))
+	limited.HandleFunc("POST /substitutionFlow", genkit.Handler(substitutionFlow))
+	limited.HandleFunc("POST /nutritionFlow", genkit.Handler(nutritionFlow))
+	limited.HandleFunc("POST /pairingFlow", genkit.Handler(pairingFlow))
+	limited.HandleFunc("POST /leftoverFlow", genkit.Handler(leftoverFlow))
+	limited.HandleFunc("POST /variationFlow", genkit.Handler(variationFlow))
+	limited.HandleFunc("POST /techniqueFlow", genkit.Handler(techniqueFlow))
+	limited.HandleFunc("POST /improveFlow", genkit.Handler(improveFlow))
+	limited.HandleFunc("POST /dietPlanFlow", genkit.Handler(dietPlanFlow))
+	limited.HandleFunc("POST /drinkRecipeFlow", genkit.Handler(drinkRecipeFlow))
+	limited.HandleFunc("POST /recipeFromImageFlow", genkit.Handler(recipeFromImageFlow))
+	limited.HandleFunc("POST /cookAlongChatFlow", genkit.Handler(chatFlow))
+	limited.HandleFunc("POST /seasonalFlow", genkit.Handler(seasonalFlow))
+	limited.HandleFunc("POST /pantryFromImageFlow", genkit.Handler(pantryFromImageFlow))
+	limited.HandleFunc("POST /refineRecipeFlow", genkit.Handler(refineFlow))
 
 	// Start the server
-	port := "8080"
+	port := strconv.Itoa(cfg.Port)
+	scheme := "http"
+	if cfg.TLS() {
+		scheme = "https"
+	}
 
-	log.Printf("🚀 Food Recipe API starting on http://localhost:%s", port)
-	log.Printf("📖 API Documentation: GET http://localhost:%s/", port)
+	log.Printf("🚀 Food Recipe API starting on %s://localhost:%s", scheme, port)
+	log.Printf("📖 API Documentation: GET http://localhost:%s/docs (spec at /openapi.json)", port)
 	log.Printf("🍳 Recipe endpoint: POST http://localhost:%s/api/recipe", port)
-	log.Printf("❤️  Health check: GET http://localhost:%s/health", port)
+	log.Printf("📡 Streaming endpoint: POST http://localhost:%s/api/recipe/stream", port)
+	log.Printf("🗓️  Meal plan endpoint: POST http://localhost:%s/api/mealplan", port)
+	log.Printf("🥕 Suggestion endpoint: POST http://localhost:%s/api/suggest", port)
+	log.Printf("🛒 Shopping list endpoint: POST http://localhost:%s/api/shopping-list", port)
+	log.Printf("🔄 Substitution endpoint: POST http://localhost:%s/api/substitute", port)
+	log.Printf("🥗 Nutrition endpoint: POST http://localhost:%s/api/nutrition", port)
+	log.Printf("🍷 Pairing endpoint: POST http://localhost:%s/api/pairing", port)
+	log.Printf("🍱 Leftovers endpoint: POST http://localhost:%s/api/leftovers", port)
+	log.Printf("🌍 Variations endpoint: POST http://localhost:%s/api/recipe/variations", port)
+	log.Printf("🔪 Technique endpoint: POST http://localhost:%s/api/technique", port)
+	log.Printf("📝 Improve endpoint: POST http://localhost:%s/api/recipe/improve", port)
+	log.Printf("🏋️  Diet plan endpoint: POST http://localhost:%s/api/dietplan", port)
+	log.Printf("🍸 Drink endpoint: POST http://localhost:%s/api/drink", port)
+	log.Printf("📷 Recipe from image endpoint: POST http://localhost:%s/api/recipe/from-image", port)
+	log.Printf("💬 Cook-along sessions: POST http://localhost:%s/api/session", port)
+	log.Printf("🍓 Seasonal endpoint: GET http://localhost:%s/api/seasonal?region=&month=", port)
+	log.Printf("🕸️  GraphQL endpoint: POST http://localhost:%s/graphql", port)
+	log.Printf("🔌 WebSocket endpoint: ws://localhost:%s/ws/recipe", port)
+	log.Printf("🔢 Versioned routes: POST http://localhost:%s/v1/recipe, /v2/recipe", port)
+	log.Printf("🖨️  Recipe PDF: GET http://localhost:%s/api/recipe/{id}/pdf", port)
+	log.Printf("📚 Collections: GET/POST http://localhost:%s/api/collections, cookbook PDF at /api/collections/{id}/pdf", port)
+	log.Printf("📅 Meal plan calendar: GET http://localhost:%s/api/mealplan/{id}/ical", port)
+	log.Printf("🗓️  Saved calendar: GET/POST http://localhost:%s/api/calendar, POST /api/calendar/move, POST /api/calendar/{date}/{meal}/regenerate", port)
+	log.Printf("📊 Shopping list export: GET http://localhost:%s/api/shopping-list/{id}.csv (or .tsv)", port)
+	log.Printf("📝 Saved shopping lists: GET/POST http://localhost:%s/api/shopping-lists, POST /api/shopping-lists/{id}/recipes", port)
+	log.Printf("📚 Recipe listing: GET http://localhost:%s/api/recipes?cursor=&limit=&difficulty=&cuisine=&tag=&course=&diet=&occasion=&maxTotalTime=", port)
+	log.Printf("🏷️ Recipe tags: GET http://localhost:%s/api/tags", port)
+	log.Printf("🆔 Stored items: GET http://localhost:%s/api/recipe/{id}, /api/mealplan/{id} (ETag / If-None-Match); DELETE /api/recipe/{id}", port)
+	log.Printf("📥 Recipe import: POST http://localhost:%s/api/recipes/import", port)
+	log.Printf("🔎 Recipe search: POST http://localhost:%s/api/recipes/search", port)
+	log.Printf("🔤 Keyword search: GET http://localhost:%s/api/recipes/search?q=", port)
+	log.Printf("🔗 Share links: POST http://localhost:%s/api/recipe/{id}/share, QR at /api/share/{slug}/qr.png", port)
+	log.Printf("🧊 Pantry photo endpoint: POST http://localhost:%s/api/pantry/from-image", port)
+	log.Printf("🥫 Pantry: GET/POST http://localhost:%s/api/pantry, GET/PUT/DELETE /api/pantry/{id}", port)
+	log.Printf("⚖️  Scale and nutrition: POST http://localhost:%s/api/recipe/{id}/scale, GET /api/recipe/{id}/nutrition", port)
+	log.Printf("🌶️  Recipe refinement: POST http://localhost:%s/api/recipe/{id}/refine", port)
+	log.Printf("✏️  Recipe edits: PATCH http://localhost:%s/api/recipe/{id}, GET /api/recipe/{id}/versions, /api/recipe/{id}/versions/{version}", port)
+	log.Printf("⭐ Favorites: POST/DELETE http://localhost:%s/api/recipe/{id}/favorite, GET /api/favorites", port)
+	log.Printf("📝 Reviews: PUT http://localhost:%s/api/recipe/{id}/review, GET /api/recipe/{id}/reviews", port)
+	log.Printf("👤 Profile: GET/PUT http://localhost:%s/api/profile", port)
+	log.Printf("🕘 History: GET http://localhost:%s/api/history?q=, POST /api/history/{id}/rerun", port)
+	log.Printf("⚙️  Configuration: GET http://localhost:%s/config", port)
+	log.Printf("🛠️  Runtime settings: GET/PATCH http://localhost:%s/admin/config, audit at /admin/config/audit", port)
+	log.Printf("🐞 Debug endpoints: GET http://localhost:%s/debug/pprof/, /debug/vars, /debug/goroutines", port)
+	log.Printf("🧪 Prompt versions: GET http://localhost:%s/admin/prompts, POST /admin/prompts/reload (or SIGHUP)", port)
+	log.Printf("🏅 Recipe quality: GET http://localhost:%s/admin/quality", port)
+	log.Printf("🪙 Token usage: GET http://localhost:%s/admin/usage", port)
+	log.Printf("🚩 Feature flags: GET http://localhost:%s/admin/flags, POST /admin/flags/reload (or SIGHUP)", port)
+	log.Printf("🔑 API keys: POST/GET http://localhost:%s/api/keys, DELETE /api/keys/{id}", port)
+	log.Printf("👤 Login: GET http://localhost:%s/auth/login, GET /auth/me, POST /auth/logout", port)
+	log.Printf("❤️  Health check: GET http://localhost:%s/health, probes at /healthz, /healthz/deep and /readyz", port)
 	log.Printf("🔧 Genkit flow (dev): POST http://localhost:%s/foodRecipeFlow", port)
 
-	log.Fatal(server.Start(ctx, "127.0.0.1:"+port, mux))
+	// Around every request, including unmatched ones: trace it, tag it with
+	// an ID, find the client behind trusted proxies, recover from panics and
+	// compress the response
+	handler := traced(mux, chain(mux, withRequestID, withClientIP, recoverPanics, compressResponses))
+
+	err = serve(cfg, handler, cancel)
+
+	// Flush the last spans before exiting
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
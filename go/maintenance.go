@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Routes that keep answering in maintenance mode: reads of stored data,
+// such as recipes, meal plans and their exports, which don't need the
+// model. Writes wait until maintenance is over
+var maintenanceExempt = map[string]bool{
+	"GET /api/images/{id}":                    true,
+	"GET /api/recipe/{id}":                    true,
+	"GET /api/recipe/{id}/versions":           true,
+	"GET /api/recipe/{id}/versions/{version}": true,
+	"GET /api/mealplan/{id}":                  true,
+	"GET /v1/recipe/{id}":                     true,
+	"GET /v2/recipe/{id}":                     true,
+	"GET /api/recipe/{id}/pdf":                true,
+	"GET /api/mealplan/{id}/ical":             true,
+	"GET /api/shopping-list/{file}":           true,
+	"GET /api/recipes":                        true,
+	"GET /api/tags":                           true,
+	"GET /api/recipes/search":                 true,
+	"GET /api/favorites":                      true,
+	"GET /api/history":                        true,
+	"GET /api/pantry":                         true,
+	"GET /api/pantry/{id}":                    true,
+	"GET /api/shopping-lists":                 true,
+	"GET /api/shopping-lists/{id}":            true,
+	"GET /api/calendar":                       true,
+	"GET /api/collections":                    true,
+	"GET /api/collections/{id}":               true,
+	"GET /api/collections/{id}/pdf":           true,
+	"GET /api/recipe/{id}/reviews":            true,
+	"GET /api/share/{slug}/qr.png":            true,
+	"GET /api/keys":                           true,
+}
+
+// Message shown when maintenance mode is on without one
+const defaultMaintenanceMessage = "The service is under maintenance, please try again later"
+
+// duringMaintenance answers 503 with Retry-After while maintenance mode is
+// on, except on the routes in maintenanceExempt
+func duringMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := currentSettings()
+		if !settings.Maintenance || maintenanceExempt[r.Pattern] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		message := settings.MaintenanceMessage
+		if message == "" {
+			message = defaultMaintenanceMessage
+		}
+		retryAfter := time.Duration(settings.MaintenanceRetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second).Seconds())))
+		writeError(w, http.StatusServiceUnavailable, "Service Under Maintenance", message)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Names accepted by the ?format= query parameter
+var formatMediaTypes = map[string]string{
+	"json":     "application/json",
+	"yaml":     "application/yaml",
+	"xml":      "application/xml",
+	"markdown": "text/markdown",
+	"md":       "text/markdown",
+}
+
+func init() {
+	registerSerializer("text/markdown", "text/markdown; charset=utf-8", encodeMarkdown)
+}
+
+// requestSerializer picks the response serializer from the ?format= query
+// parameter, falling back to the Accept header
+func requestSerializer(r *http.Request) (*responseSerializer, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		s, ok := responseSerializers[formatMediaTypes[strings.ToLower(format)]]
+		return s, ok
+	}
+	return negotiateSerializer(r.Header.Get("Accept"))
+}
+
+// encodeMarkdown renders recipes and errors as Markdown
+func encodeMarkdown(w io.Writer, v any) error {
+	switch v := v.(type) {
+	case *FoodRecipe:
+		_, err := io.WriteString(w, recipeMarkdown(v))
+		return err
+	case ErrorResponse:
+		_, err := fmt.Fprintf(w, "**%s:** %s\n", v.Error, v.Message)
+		return err
+	}
+	return fmt.Errorf("%T cannot be rendered as Markdown", v)
+}
+
+// recipeMarkdown renders a recipe with headings, an ingredient checklist and
+// numbered steps
+func recipeMarkdown(r *FoodRecipe) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", r.Name)
+	if r.Description != "" {
+		fmt.Fprintf(&sb, "%s\n\n", r.Description)
+	}
+
+	var facts []string
+	for _, f := range []struct{ label, value string }{
+		{"Difficulty", r.Difficulty},
+		{"Servings", fmt.Sprint(r.Servings)},
+		{"Prep", r.PrepTime},
+		{"Cook", r.CookTime},
+		{"Total", r.TotalTime},
+	} {
+		if f.value != "" && f.value != "0" {
+			facts = append(facts, fmt.Sprintf("**%s:** %s", f.label, f.value))
+		}
+	}
+	if len(facts) > 0 {
+		fmt.Fprintf(&sb, "%s\n\n", strings.Join(facts, " · "))
+	}
+	if len(r.Allergens) > 0 {
+		fmt.Fprintf(&sb, "**Allergens:** %s\n\n", strings.Join(r.Allergens, ", "))
+	}
+	if r.Currency != "" {
+		fmt.Fprintf(&sb, "**Estimated cost:** %.2f %s (%.2f per serving)\n\n", r.EstimatedCost, r.Currency, r.CostPerServing)
+	}
+
+	sb.WriteString("## Ingredients\n\n")
+	for _, ingredient := range r.Ingredients {
+		fmt.Fprintf(&sb, "- [ ] %s\n", ingredient)
+	}
+
+	sb.WriteString("\n## Instructions\n\n")
+	for i, step := range r.Instructions {
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, step)
+	}
+
+	if len(r.KidSteps) > 0 {
+		sb.WriteString("\n## Steps for Kids\n\n")
+		for i, step := range r.KidSteps {
+			line := step.Instruction
+			if step.AdultSupervision {
+				line += " **(adult supervision)**"
+			}
+			fmt.Fprintf(&sb, "%d. %s\n", i+1, line)
+			for _, callout := range step.SafetyCallouts {
+				fmt.Fprintf(&sb, "   - ⚠️ %s\n", callout)
+			}
+		}
+	}
+
+	if len(r.Tips) > 0 {
+		sb.WriteString("\n## Tips\n\n")
+		for _, tip := range r.Tips {
+			fmt.Fprintf(&sb, "- %s\n", tip)
+		}
+	}
+
+	if n := r.Nutrition; n != nil {
+		sb.WriteString("\n## Nutrition (per serving)\n\n| Calories | Protein | Carbs | Fat | Fiber | Sodium |\n|---|---|---|---|---|---|\n")
+		fmt.Fprintf(&sb, "| %.0f kcal | %.1f g | %.1f g | %.1f g | %.1f g | %.0f mg |\n",
+			n.Calories, n.ProteinG, n.CarbsG, n.FatG, n.FiberG, n.SodiumMg)
+	}
+
+	if p := r.Pairings; p != nil {
+		sb.WriteString("\n## Pairings\n\n")
+		for _, group := range []struct {
+			label    string
+			pairings []Pairing
+		}{{"Wine", p.Wine}, {"Beer", p.Beer}, {"Non-alcoholic", p.NonAlcoholic}} {
+			for _, pairing := range group.pairings {
+				fmt.Fprintf(&sb, "- **%s:** %s — %s\n", group.label, pairing.Name, pairing.Reasoning)
+			}
+		}
+	}
+
+	if r.Image != nil && r.Image.Status == imageStatusReady {
+		fmt.Fprintf(&sb, "\n![%s](%s)\n", r.Name, r.Image.URL)
+	}
+	return sb.String()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const maxMealPlanDays = 14
+
+// Define input schema for meal plan requests
+type MealPlanInput struct {
+	Days                int    `json:"days,omitempty" jsonschema:"description=Number of days to plan (default 7)"`
+	People              int    `json:"people,omitempty" jsonschema:"description=Number of people to feed (default 2)"`
+	DietaryRestrictions string `json:"dietaryRestrictions,omitempty" jsonschema:"description=Any dietary restrictions (vegetarian\\, vegan\\, gluten-free\\, etc.)"`
+	Budget              string `json:"budget,omitempty" jsonschema:"description=Grocery budget for the whole plan (e.g. $150 or low/moderate/high)"`
+	StartDate           string `json:"startDate,omitempty" jsonschema:"description=Date of the first day as YYYY-MM-DD (default tomorrow)"`
+	CallbackURL         string `json:"callbackUrl,omitempty" jsonschema:"description=Answer 202 right away and POST the finished plan to this URL"`
+}
+
+// A single meal slot that references a recipe in the plan
+type MealEntry struct {
+	Title    string `json:"title"`
+	RecipeID string `json:"recipeId"`
+}
+
+// Meals planned for one day
+type MealPlanDay struct {
+	Day       int       `json:"day"`
+	Date      string    `json:"date,omitempty" jsonschema:"-"`
+	Breakfast MealEntry `json:"breakfast"`
+	Lunch     MealEntry `json:"lunch"`
+	Dinner    MealEntry `json:"dinner"`
+}
+
+// A recipe referenced by one or more meal entries
+type MealPlanRecipe struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	TotalTime    string   `json:"totalTime"`
+	Servings     int      `json:"servings"`
+	Ingredients  []string `json:"ingredients"`
+	Instructions []string `json:"instructions"`
+}
+
+// An aggregated shopping list entry
+type ShoppingListItem struct {
+	Item     string `json:"item"`
+	Quantity string `json:"quantity"`
+	Aisle    string `json:"aisle,omitempty"`
+}
+
+// Define output schema for meal plan response
+type MealPlan struct {
+	ID             string             `json:"id,omitempty" jsonschema:"-"`
+	StartDate      string             `json:"startDate,omitempty" jsonschema:"-"`
+	Days           []MealPlanDay      `json:"days"`
+	Recipes        []MealPlanRecipe   `json:"recipes"`
+	ShoppingList   []ShoppingListItem `json:"shoppingList"`
+	ShoppingListID string             `json:"shoppingListId,omitempty" jsonschema:"-"`
+	EstimatedCost  string             `json:"estimatedCost,omitempty"`
+	Notes          string             `json:"notes,omitempty"`
+}
+
+// Define the meal plan generator flow
+func defineMealPlanFlow(g *genkit.Genkit) *core.Flow[*MealPlanInput, *MealPlan, struct{}] {
+	return genkit.DefineFlow(g, "mealPlanFlow", func(ctx context.Context, input *MealPlanInput) (*MealPlan, error) {
+		// Set default values
+		days := input.Days
+		if days == 0 {
+			days = 7
+		}
+		if days < 0 || days > maxMealPlanDays {
+			return nil, newInputError("days must be between 1 and %d", maxMealPlanDays)
+		}
+
+		people := input.People
+		if people == 0 {
+			people = 2
+		}
+		if people < 0 {
+			return nil, newInputError("people must be a positive number")
+		}
+
+		start := time.Now().AddDate(0, 0, 1)
+		if input.StartDate != "" {
+			date, err := time.Parse(time.DateOnly, input.StartDate)
+			if err != nil {
+				return nil, newInputError("startDate must be a date in YYYY-MM-DD format")
+			}
+			start = date
+		}
+
+		dietaryRestrictions := input.DietaryRestrictions
+		if dietaryRestrictions == "" {
+			dietaryRestrictions = "none"
+		}
+
+		budget := input.Budget
+		if strings.TrimSpace(budget) == "" {
+			budget = "moderate"
+		}
+
+		prompt := fmt.Sprintf(`Create a %d-day meal plan with breakfast, lunch and dinner for each day.
+
+		People: %d
+		Dietary restrictions: %s
+		Budget: %s
+
+		Please provide:
+		1. For every day (numbered from 1), a breakfast, lunch and dinner entry whose recipeId refers to a recipe in the recipes list
+		2. A recipes list where each recipe has a short unique id (e.g. "r1"), ingredients with quantities for %d people, and step-by-step instructions
+		3. Reuse recipes and leftovers where it makes sense to keep the plan within budget
+		4. A single shopping list that aggregates the ingredients of every recipe, merging duplicates and grouping by store aisle
+		5. An estimated total cost for the plan
+
+		Make sure every meal is practical for home cooking.`,
+			days, people, dietaryRestrictions, budget, people)
+
+		plan, _, err := genkit.GenerateData[MealPlan](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate meal plan: %w", err)
+		}
+
+		if err := plan.validate(); err != nil {
+			return nil, fmt.Errorf("generated meal plan is invalid: %w", err)
+		}
+
+		// Date the days and keep the plan for the calendar feed
+		plan.StartDate = start.Format(time.DateOnly)
+		for i := range plan.Days {
+			plan.Days[i].Date = start.AddDate(0, 0, plan.Days[i].Day-1).Format(time.DateOnly)
+		}
+		names := make([]string, len(plan.Recipes))
+		for i, recipe := range plan.Recipes {
+			names[i] = recipe.Name
+		}
+		plan.ShoppingListID = recentShoppingLists.save(&ShoppingList{Recipes: names, Aisles: groupByAisle(plan.ShoppingList)})
+		plan.ID = recentMealPlans.save(plan)
+
+		return plan, nil
+	})
+}
+
+// validate checks that every meal entry references a known recipe
+func (p *MealPlan) validate() error {
+	recipes := make(map[string]bool, len(p.Recipes))
+	for _, recipe := range p.Recipes {
+		recipes[recipe.ID] = true
+	}
+
+	for _, day := range p.Days {
+		for _, meal := range []MealEntry{day.Breakfast, day.Lunch, day.Dinner} {
+			if !recipes[meal.RecipeID] {
+				return fmt.Errorf("day %d: %q references unknown recipe %q", day.Day, meal.Title, meal.RecipeID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// modelLimit describes what a model can't do that the flows would like it
+// to. The runtime model works around it, so flows run unchanged
+type modelLimit struct {
+	// The model can't be held to a JSON schema, so the schema is described
+	// in the prompt and the answer repaired to fit it
+	schemaInPrompt bool
+	// The model can't call tools, so it answers without them
+	noTools bool
+	// The model's names for the generation parameters, keyed by the names
+	// the flows use; other config settings are left out. Nil passes the
+	// config through unchanged
+	configKeys map[string]string
+}
+
+// Limits of the models that have any, by provider/name, or by provider for
+// all of its models. Set at startup
+var modelLimits = map[string]modelLimit{}
+
+// limitsOf returns the limits of a model, if it has any
+func limitsOf(model string) (modelLimit, bool) {
+	if limit, ok := modelLimits[model]; ok {
+		return limit, true
+	}
+	provider, _, _ := strings.Cut(model, "/")
+	limit, ok := modelLimits[provider]
+	return limit, ok
+}
+
+// adaptRequest fits a request to what the model can do: tools are left out
+// for models that can't call them, generation parameters are renamed to
+// the model's own, and a JSON schema the model can't be held to is
+// described in the prompt instead
+func adaptRequest(model string, req *ai.ModelRequest) *ai.ModelRequest {
+	limit, ok := limitsOf(model)
+	if !ok {
+		return req
+	}
+	r := *req
+	if limit.noTools {
+		r.Tools, r.ToolChoice = nil, ""
+	}
+	if limit.configKeys != nil {
+		r.Config = renameConfig(r.Config, limit.configKeys)
+	}
+	if limit.schemaInPrompt && r.Output != nil && r.Output.Schema != nil {
+		schema, err := json.Marshal(r.Output.Schema)
+		if err == nil {
+			instructions := ai.NewTextPart(fmt.Sprintf("Answer with only a JSON object, without any other text, that conforms to this JSON schema:\n\n```json\n%s\n```", schema))
+			r.Messages = slices.Clone(r.Messages)
+			if n := len(r.Messages); n > 0 && r.Messages[n-1].Role == ai.RoleUser {
+				last := *r.Messages[n-1]
+				last.Content = append(slices.Clone(last.Content), instructions)
+				r.Messages[n-1] = &last
+			} else {
+				r.Messages = append(r.Messages, ai.NewUserMessage(instructions))
+			}
+			output := *r.Output
+			output.Constrained = false
+			r.Output = &output
+		}
+	}
+	return &r
+}
+
+// renameConfig keeps the settings of an untyped config that the model
+// knows, under its names for them. A typed config, written for another
+// provider's model, is left out entirely
+func renameConfig(config any, keys map[string]string) any {
+	cfg, ok := config.(map[string]any)
+	if !ok {
+		return nil
+	}
+	renamed := make(map[string]any, len(cfg))
+	for key, value := range cfg {
+		if name, ok := keys[key]; ok {
+			renamed[name] = value
+		}
+	}
+	if len(renamed) == 0 {
+		return nil
+	}
+	return renamed
+}
+
+// adaptResponse repairs the answer of a model that was asked for JSON in
+// the prompt: the JSON is cut out of any surrounding text, closed if the
+// model stopped early, and fitted to the schema, so a slightly wrong
+// answer still makes a recipe rather than failing
+func adaptResponse(model string, req *ai.ModelRequest, resp *ai.ModelResponse) *ai.ModelResponse {
+	limit, ok := limitsOf(model)
+	if !ok || !limit.schemaInPrompt || req.Output == nil || req.Output.Schema == nil || resp.Message == nil {
+		return resp
+	}
+	for _, part := range resp.Message.Content {
+		if part.IsToolRequest() {
+			return resp
+		}
+	}
+	closed, ok := closeJSON(resp.Text())
+	if !ok {
+		return resp
+	}
+	var value any
+	if err := json.Unmarshal([]byte(closed), &value); err != nil {
+		return resp
+	}
+	repaired, err := json.Marshal(conformToSchema(value, req.Output.Schema))
+	if err != nil {
+		return resp
+	}
+	r := *resp
+	r.Message = ai.NewModelTextMessage(string(repaired))
+	return &r
+}
+
+// conformToSchema fits a decoded JSON value to a JSON schema as far as it
+// can: missing required fields get empty values, unknown fields of closed
+// objects are dropped, a single value stands in for a list, and numbers,
+// strings and booleans are converted into one another
+func conformToSchema(value any, schema map[string]any) any {
+	types := schemaTypes(schema)
+	if len(types) == 0 {
+		return value
+	}
+	if value == nil && slices.Contains(types, "null") {
+		return nil
+	}
+	t := types[0]
+	for _, candidate := range types {
+		if jsonTypeMatches(value, candidate) {
+			t = candidate
+			break
+		}
+	}
+
+	switch t {
+	case "object":
+		obj, _ := value.(map[string]any)
+		if obj == nil {
+			obj = map[string]any{}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, v := range obj {
+			p, known := props[name].(map[string]any)
+			switch {
+			case known:
+				obj[name] = conformToSchema(v, p)
+			case schema["additionalProperties"] == false:
+				delete(obj, name)
+			}
+		}
+		for _, name := range schemaRequired(schema) {
+			if _, ok := obj[name]; !ok {
+				p, _ := props[name].(map[string]any)
+				obj[name] = conformToSchema(nil, p)
+			}
+		}
+		return obj
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		list, ok := value.([]any)
+		if !ok {
+			list = []any{}
+			if value != nil {
+				list = append(list, value)
+			}
+		}
+		for i, v := range list {
+			list[i] = conformToSchema(v, items)
+		}
+		return list
+	case "string":
+		switch v := value.(type) {
+		case nil:
+			return ""
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Sprint(v)
+		}
+	case "integer", "number":
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case bool:
+			if v {
+				n = 1
+			}
+		case string:
+			// Keep the leading number of e.g. "4 servings"
+			fields := strings.Fields(strings.ReplaceAll(v, ",", ""))
+			if len(fields) > 0 {
+				n, _ = strconv.ParseFloat(strings.TrimLeft(fields[0], "$€£~"), 64)
+			}
+		}
+		if t == "integer" {
+			n = math.Round(n)
+		}
+		return n
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v
+		case string:
+			b, _ := strconv.ParseBool(strings.TrimSpace(v))
+			return b
+		case float64:
+			return v != 0
+		}
+		return false
+	}
+	return value
+}
+
+// schemaTypes lists the types a schema allows
+func schemaTypes(schema map[string]any) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, v := range t {
+			if s, ok := v.(string); ok && s != "null" {
+				types = append(types, s)
+			}
+		}
+		if len(types) < len(t) {
+			types = append(types, "null")
+		}
+		return types
+	case []string:
+		return t
+	}
+	return nil
+}
+
+// schemaRequired lists the required properties of an object schema
+func schemaRequired(schema map[string]any) []string {
+	switch r := schema["required"].(type) {
+	case []string:
+		return r
+	case []any:
+		names := make([]string, 0, len(r))
+		for _, v := range r {
+			if s, ok := v.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// jsonTypeMatches reports whether a decoded JSON value has a schema type
+func jsonTypeMatches(value any, t string) bool {
+	switch v := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case float64:
+		return t == "number" || t == "integer" && v == math.Trunc(v)
+	case string:
+		return t == "string"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Models clients may choose per request, as provider/name. Set from the
+// config at startup
+var allowedModels []string
+
+// resolveModel finds an allowed model by its full name or by its name
+// without the provider, e.g. "gemini-2.0-flash"
+func resolveModel(name string) (string, bool) {
+	if slices.Contains(allowedModels, name) {
+		return name, true
+	}
+	for _, m := range allowedModels {
+		if _, short, _ := strings.Cut(m, "/"); short == name {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// modelChoice is the model a request asked for, if any, and the model that
+// answered it
+type modelChoice struct {
+	requested string
+
+	mu   sync.Mutex
+	used string
+}
+
+// Context key of the request's model choice
+type modelChoiceContextKey struct{}
+
+// withModelChoice makes the runtime model generate with requested, or with
+// the default when it is empty, for calls made with the returned context
+func withModelChoice(ctx context.Context, requested string) (context.Context, *modelChoice) {
+	c := &modelChoice{requested: requested}
+	return context.WithValue(ctx, modelChoiceContextKey{}, c), c
+}
+
+// requestedModel returns the model the context's request asked for
+func requestedModel(ctx context.Context) (string, bool) {
+	c, _ := ctx.Value(modelChoiceContextKey{}).(*modelChoice)
+	if c == nil || c.requested == "" {
+		return "", false
+	}
+	return c.requested, true
+}
+
+// setModelUsed records the model that answered the context's request
+func setModelUsed(ctx context.Context, model string) {
+	if c, _ := ctx.Value(modelChoiceContextKey{}).(*modelChoice); c != nil {
+		c.mu.Lock()
+		c.used = model
+		c.mu.Unlock()
+	}
+}
+
+// modelUsed returns the model that answered last
+func (c *modelChoice) modelUsed() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// modelChain lists the models to try for a request: the chosen one, then
+// the fallbacks not already tried
+func modelChain(model string, fallbacks []string) []string {
+	chain := []string{model}
+	for _, f := range fallbacks {
+		if !slices.Contains(chain, f) {
+			chain = append(chain, f)
+		}
+	}
+	return chain
+}
+
+// generateWithFallbacks generates with each model of the chain in turn
+// until one answers, skipping models whose circuit is open. Each model is
+// retried first when it fails for a reason that may pass, and replaced only
+// once its retries are used up. A model that already streamed part of its
+// answer is not replaced, since the client has seen that part
+func generateWithFallbacks(ctx context.Context, g *genkit.Genkit, chain []string, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+	var errs []error
+	for i, name := range chain {
+		model := genkit.LookupModel(g, name)
+		if model == nil {
+			errs = append(errs, fmt.Errorf("model %q is not available", name))
+			continue
+		}
+
+		breaker := modelBreakers.get(name)
+		if ok, wait := breaker.allow(); !ok {
+			errs = append(errs, &circuitOpenError{model: name, retryAfter: wait})
+			continue
+		}
+
+		modelReq := adaptRequest(name, req)
+		resp, err := generateWithRetry(ctx, name, model, modelReq, cb)
+		breaker.record(name, err)
+		if err == nil {
+			setModelUsed(ctx, name)
+			recordUsage(ctx, name, resp.Usage)
+			return adaptResponse(name, modelReq, resp), nil
+		}
+		errs = append(errs, err)
+		var failed *modelCallError
+		if !errors.As(err, &failed) || failed.streamed || ctx.Err() != nil || !isTransient(failed.reason) {
+			break
+		}
+		if i < len(chain)-1 {
+			log.Printf("Model %s failed, falling back to %s: %v", name, chain[i+1], err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
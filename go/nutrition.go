@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Structured nutrition facts for one serving
+type NutritionFacts struct {
+	Calories      float64 `json:"calories"`
+	ProteinG      float64 `json:"proteinG"`
+	CarbsG        float64 `json:"carbsG"`
+	FatG          float64 `json:"fatG"`
+	SaturatedFatG float64 `json:"saturatedFatG,omitempty"`
+	SugarG        float64 `json:"sugarG,omitempty"`
+	FiberG        float64 `json:"fiberG"`
+	SodiumMg      float64 `json:"sodiumMg"`
+}
+
+// scale returns the facts multiplied by factor, rounded to one decimal place
+func (n NutritionFacts) scale(factor float64) NutritionFacts {
+	round := func(v float64) float64 { return math.Round(v*factor*10) / 10 }
+	return NutritionFacts{
+		Calories:      round(n.Calories),
+		ProteinG:      round(n.ProteinG),
+		CarbsG:        round(n.CarbsG),
+		FatG:          round(n.FatG),
+		SaturatedFatG: round(n.SaturatedFatG),
+		SugarG:        round(n.SugarG),
+		FiberG:        round(n.FiberG),
+		SodiumMg:      round(n.SodiumMg),
+	}
+}
+
+// Define input schema for nutrition analysis requests
+type NutritionInput struct {
+	RecipeName  string   `json:"recipeName,omitempty" jsonschema:"description=Name of the recipe"`
+	Servings    int      `json:"servings,omitempty" jsonschema:"description=Number of servings the ingredients make (default 1)"`
+	Ingredients []string `json:"ingredients" jsonschema:"description=Ingredient list with quantities,required=true"`
+}
+
+// Nutrition contributed by a single ingredient for the whole recipe
+type IngredientNutrition struct {
+	Ingredient string         `json:"ingredient"`
+	Facts      NutritionFacts `json:"facts"`
+}
+
+// Define output schema for nutrition analysis response
+type NutritionAnalysis struct {
+	RecipeName  string                `json:"recipeName,omitempty"`
+	Servings    int                   `json:"servings"`
+	PerServing  NutritionFacts        `json:"perServing"`
+	Total       NutritionFacts        `json:"total"`
+	Ingredients []IngredientNutrition `json:"ingredients"`
+	Notes       string                `json:"notes,omitempty"`
+}
+
+// Define the nutrition analysis flow
+func defineNutritionFlow(g *genkit.Genkit) *core.Flow[*NutritionInput, *NutritionAnalysis, struct{}] {
+	return genkit.DefineFlow(g, "nutritionFlow", func(ctx context.Context, input *NutritionInput) (*NutritionAnalysis, error) {
+		if len(input.Ingredients) == 0 {
+			return nil, newInputError("ingredients are required")
+		}
+
+		servings := input.Servings
+		if servings <= 0 {
+			servings = 1
+		}
+
+		recipeName := input.RecipeName
+		if recipeName == "" {
+			recipeName = "unnamed recipe"
+		}
+
+		prompt := fmt.Sprintf(`Analyze the nutrition of the following recipe:
+
+		Recipe: %s
+		Servings: %d
+		Ingredients:
+		- %s
+
+		Please provide:
+		1. For each ingredient, its nutrition facts for the full quantity listed
+		2. Nutrition facts per serving: calories, protein, carbohydrates, fat, saturated fat, sugar and fiber in grams, and sodium in milligrams
+		3. Any notes about assumptions made for ambiguous quantities
+
+		Get each ingredient's nutrition with the %s tool, converting its quantity to grams.
+		Only estimate ingredients the tool does not find, and say so in the notes.`,
+			recipeName, servings, strings.Join(input.Ingredients, "\n\t\t- "), nutritionToolName)
+
+		analysis, _, err := genkit.GenerateData[NutritionAnalysis](ctx, g,
+			ai.WithPrompt(prompt),
+			ai.WithTools(ai.ToolName(nutritionToolName)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze nutrition for %s: %w", recipeName, err)
+		}
+
+		analysis.RecipeName = input.RecipeName
+		analysis.Servings = servings
+		analysis.Total = analysis.PerServing.scale(float64(servings))
+
+		return analysis, nil
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Name of the tool the model calls for ingredient nutrition
+const nutritionToolName = "lookupNutrition"
+
+// Nutrients per 100 g of common ingredients, used when FoodData Central is
+// not configured or has no match
+//
+//go:embed data/nutrients.csv
+var bundledNutrientsCSV string
+
+// Input of the nutrition lookup tool
+type NutritionLookupInput struct {
+	Ingredient string  `json:"ingredient" jsonschema:"description=Ingredient name without the quantity (e.g. unsalted butter)"`
+	Grams      float64 `json:"grams,omitempty" jsonschema:"description=Weight of the ingredient in grams (default 100)"`
+}
+
+// Nutrition of an ingredient from a nutrient database
+type NutritionLookup struct {
+	Found  bool           `json:"found"`
+	Food   string         `json:"food,omitempty" jsonschema:"description=Database food the ingredient was matched to"`
+	Source string         `json:"source,omitempty" jsonschema:"description=usda for FoodData Central\\, bundled for the built-in table"`
+	Grams  float64        `json:"grams"`
+	Facts  NutritionFacts `json:"facts" jsonschema:"description=Nutrition for the given weight"`
+}
+
+// A food of the bundled table and the names it is matched by
+type nutrientEntry struct {
+	names   []string
+	per100g NutritionFacts
+}
+
+// The bundled table, parsed once
+var bundledNutrients = sync.OnceValue(func() []nutrientEntry {
+	r := csv.NewReader(strings.NewReader(bundledNutrientsCSV))
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("bundled nutrient table: %v", err))
+	}
+	var entries []nutrientEntry
+	for _, rec := range records[1:] {
+		var v [8]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(rec[i+1], 64); err != nil {
+				panic(fmt.Sprintf("bundled nutrient table: %s: %v", rec[0], err))
+			}
+		}
+		entries = append(entries, nutrientEntry{
+			names:   strings.Split(rec[0], ";"),
+			per100g: NutritionFacts{v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7]},
+		})
+	}
+	return entries
+})
+
+// matchIngredient finds which of the candidates' names appear in an
+// ingredient and returns the index of the candidate with the longest one,
+// so "brown sugar" beats "sugar" and "peanut butter" beats "butter"
+func matchIngredient(ingredient string, n int, names func(i int) []string) (int, bool) {
+	text := " " + strings.Join(strings.FieldsFunc(strings.ToLower(ingredient), func(r rune) bool {
+		return !('a' <= r && r <= 'z') && r != '-'
+	}), " ") + " "
+	best, bestName := -1, ""
+	for i := range n {
+		for _, name := range names(i) {
+			if len(name) > len(bestName) && strings.Contains(text, " "+name+" ") {
+				best, bestName = i, name
+			}
+		}
+	}
+	return best, best >= 0
+}
+
+// lookupBundled matches an ingredient against the bundled table
+func lookupBundled(ingredient string) (string, NutritionFacts, bool) {
+	entries := bundledNutrients()
+	i, ok := matchIngredient(ingredient, len(entries), func(i int) []string { return entries[i].names })
+	if !ok {
+		return "", NutritionFacts{}, false
+	}
+	return entries[i].names[0], entries[i].per100g, true
+}
+
+// USDA FoodData Central API key, set from the config at startup. Without
+// it only the bundled table is used
+var fdcAPIKey string
+
+// FoodData Central search endpoint and client
+var (
+	fdcSearchURL = "https://api.nal.usda.gov/fdc/v1/foods/search"
+	fdcClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// FoodData Central nutrient numbers of the NutritionFacts fields
+var fdcNutrients = map[string]func(*NutritionFacts) *float64{
+	"208": func(n *NutritionFacts) *float64 { return &n.Calories },
+	"203": func(n *NutritionFacts) *float64 { return &n.ProteinG },
+	"205": func(n *NutritionFacts) *float64 { return &n.CarbsG },
+	"204": func(n *NutritionFacts) *float64 { return &n.FatG },
+	"606": func(n *NutritionFacts) *float64 { return &n.SaturatedFatG },
+	"269": func(n *NutritionFacts) *float64 { return &n.SugarG },
+	"291": func(n *NutritionFacts) *float64 { return &n.FiberG },
+	"307": func(n *NutritionFacts) *float64 { return &n.SodiumMg },
+}
+
+// FoodData Central answers by ingredient, so a recipe repeating an
+// ingredient or a popular one costs one request
+var fdcCache sync.Map
+
+// A food found by FoodData Central and its nutrients per 100 g
+type fdcFood struct {
+	name    string
+	per100g NutritionFacts
+}
+
+// lookupFDC searches FoodData Central's SR Legacy foods, whose nutrients
+// are given per 100 g
+func lookupFDC(ctx context.Context, ingredient string) (*fdcFood, error) {
+	key := strings.ToLower(strings.TrimSpace(ingredient))
+	if cached, ok := fdcCache.Load(key); ok {
+		return cached.(*fdcFood), nil
+	}
+
+	query := url.Values{"query": {ingredient}, "dataType": {"SR Legacy"}, "pageSize": {"1"}, "api_key": {fdcAPIKey}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fdcSearchURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fdcClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("FoodData Central answered %s", resp.Status)
+	}
+
+	var result struct {
+		Foods []struct {
+			Description   string `json:"description"`
+			FoodNutrients []struct {
+				NutrientNumber string  `json:"nutrientNumber"`
+				Value          float64 `json:"value"`
+			} `json:"foodNutrients"`
+		} `json:"foods"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("FoodData Central: %w", err)
+	}
+	var food *fdcFood
+	if len(result.Foods) > 0 {
+		f := result.Foods[0]
+		food = &fdcFood{name: f.Description}
+		for _, n := range f.FoodNutrients {
+			if field, ok := fdcNutrients[n.NutrientNumber]; ok {
+				*field(&food.per100g) = n.Value
+			}
+		}
+	}
+	fdcCache.Store(key, food)
+	return food, nil
+}
+
+// defineNutritionTool registers the tool the model calls to get an
+// ingredient's nutrition from FoodData Central, falling back to the bundled
+// table, instead of estimating it
+func defineNutritionTool(g *genkit.Genkit) ai.Tool {
+	return genkit.DefineTool(g, nutritionToolName,
+		"Looks up the nutrition facts of an ingredient by weight in a nutrient database. Call it once per ingredient and add up the results instead of estimating nutrition.",
+		func(ctx *ai.ToolContext, input NutritionLookupInput) (*NutritionLookup, error) {
+			grams := input.Grams
+			if grams <= 0 {
+				grams = 100
+			}
+			out := &NutritionLookup{Grams: grams}
+
+			if fdcAPIKey != "" {
+				food, err := lookupFDC(ctx, input.Ingredient)
+				if err != nil {
+					log.Printf("FoodData Central lookup of %q failed, using the bundled table: %v", input.Ingredient, err)
+				}
+				if food != nil {
+					out.Found, out.Food, out.Source, out.Facts = true, food.name, "usda", food.per100g.scale(grams/100)
+					return out, nil
+				}
+			}
+			if name, per100g, ok := lookupBundled(input.Ingredient); ok {
+				out.Found, out.Food, out.Source, out.Facts = true, name, "bundled", per100g.scale(grams/100)
+			}
+			return out, nil
+		})
+}
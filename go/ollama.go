@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/ollama"
+)
+
+// How long a local model may take to answer. Local models on a CPU can be
+// slow, so this is generous; the request deadline still applies
+const ollamaTimeout = 10 * time.Minute
+
+// ollamaCapabilities asks an Ollama server what a pulled model can do,
+// e.g. "completion", "tools" or "vision"
+func ollamaCapabilities(ctx context.Context, address, model string) ([]string, error) {
+	body, _ := json.Marshal(map[string]string{"model": model})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(address, "/")+"/api/show", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama answered %s; is the model pulled?", resp.Status)
+	}
+	var show struct {
+		Capabilities []string `json:"capabilities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return nil, err
+	}
+	return show.Capabilities, nil
+}
+
+// defineOllamaModels registers the local models served by Ollama as
+// ollama/<name>. A model's tool and image support is read from the server;
+// one that can't be reached is used without tools or images
+func defineOllamaModels(ctx context.Context, g *genkit.Genkit, plugin *ollama.Ollama, names []string) {
+	for _, name := range names {
+		capabilities, err := ollamaCapabilities(ctx, plugin.ServerAddress, name)
+		if err != nil {
+			log.Printf("Could not read the capabilities of Ollama model %s, using it without tools: %v", name, err)
+		}
+		tools := slices.Contains(capabilities, "tools")
+		plugin.DefineModel(g, ollama.ModelDefinition{Name: name, Type: "chat"}, &ai.ModelOptions{
+			Supports: &ai.ModelSupports{
+				Multiturn:   true,
+				SystemRole:  true,
+				Tools:       tools,
+				Media:       slices.Contains(capabilities, "vision"),
+				Constrained: ai.ConstrainedSupportNone,
+			},
+		})
+		modelLimits["ollama/"+name] = modelLimit{schemaInPrompt: true, noTools: !tools}
+	}
+}
+
+// defineOllamaEmbedder registers an Ollama embedding model as
+// ollama/<name>, so EMBEDDING_MODEL can name it like any other embedder
+func defineOllamaEmbedder(g *genkit.Genkit, plugin *ollama.Ollama, name string) ai.Embedder {
+	server := plugin.DefineEmbedder(g, plugin.ServerAddress, name, nil)
+	return genkit.DefineEmbedder(g, "ollama/"+name, nil, func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		return server.Embed(ctx, &ai.EmbedRequest{Input: req.Input, Options: &ollama.EmbedOptions{Model: name}})
+	})
+}
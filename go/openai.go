@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/compat_oai"
+	"github.com/openai/openai-go/option"
+)
+
+// Provider of the models of the OpenAI-compatible API, served as
+// openai/<name>
+const openAIProvider = "openai"
+
+// Chat completion names of the generation parameters the flows set
+var openAIConfigKeys = map[string]string{
+	"temperature":     "temperature",
+	"topP":            "top_p",
+	"maxOutputTokens": "max_tokens",
+	"stopSequences":   "stop",
+	"seed":            "seed",
+}
+
+// newOpenAIPlugin returns the plugin of an OpenAI-compatible API at
+// baseURL, or at api.openai.com when it is empty. The client doesn't retry,
+// since model calls are retried with the configured backoff
+func newOpenAIPlugin(baseURL, apiKey string) *compat_oai.OpenAICompatible {
+	opts := []option.RequestOption{option.WithMaxRetries(0)}
+	if apiKey != "" {
+		opts = append(opts, option.WithAPIKey(apiKey))
+	}
+	if baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+	return &compat_oai.OpenAICompatible{Provider: openAIProvider, Opts: opts}
+}
+
+// defineOpenAIModels makes the API's models usable by the flows. Any model
+// the API serves resolves as openai/<name>; the plugin doesn't pass a JSON
+// schema or Gemini's settings on, so the schema is described in the prompt
+// and the generation parameters renamed
+func defineOpenAIModels() {
+	modelLimits[openAIProvider] = modelLimit{schemaInPrompt: true, configKeys: openAIConfigKeys}
+}
+
+// defineOpenAIEmbedder registers an embedding model of the API as
+// openai/<name>, so EMBEDDING_MODEL can name it like any other embedder
+func defineOpenAIEmbedder(g *genkit.Genkit, plugin *compat_oai.OpenAICompatible, name string) ai.Embedder {
+	server := plugin.DefineEmbedder(openAIProvider, name, nil)
+	return genkit.DefineEmbedder(g, openAIProvider+"/"+name, nil, func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		return server.Embed(ctx, req)
+	})
+}
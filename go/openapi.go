@@ -0,0 +1,751 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dinocodesx/genkit-go/config"
+	"github.com/invopop/jsonschema"
+)
+
+// Version reported in the OpenAPI document
+const apiVersion = "1.0.0"
+
+// An endpoint documented in the OpenAPI specification. Request and response
+// are zero values of the JSON body types; their schemas are reflected from
+// the same struct tags the flows use
+type apiOperation struct {
+	method      string
+	path        string
+	summary     string
+	request     any
+	response    any
+	contentType string   // response media type when it isn't JSON
+	alsoAs      []string // other media types the response can be negotiated as
+	example     any
+	query       []apiParam
+}
+
+// A query parameter of an endpoint
+type apiParam struct {
+	name        string
+	description string
+	required    bool
+}
+
+// The public API; keep in step with the routes registered in main
+var apiOperations = []apiOperation{
+	{
+		method: "POST", path: "/api/recipe",
+		summary: "Generate a recipe for a given food name",
+		request: FoodInput{}, response: FoodRecipe{},
+		alsoAs: []string{"application/yaml", "application/xml", "text/markdown", "application/ld+json"},
+		query: []apiParam{
+			{name: "format", description: "Response format (json, yaml, xml, markdown or jsonld); overrides the Accept header"},
+			{name: "fields", description: "Comma-separated JSON fields to return (e.g. name,ingredients,nutrition.calories)"},
+			{name: "usage", description: "true to add the tokens and estimated cost of the model calls as a usage field"},
+		},
+		example: FoodInput{FoodName: "Chicken Tikka Masala", DietaryRestrictions: "gluten-free", Difficulty: "medium", ServingSize: 6},
+	},
+	{
+		method: "POST", path: "/api/recipe/stream",
+		summary: "Stream a recipe as Server-Sent Events: partial (the recipe as far as it has been written), done and error",
+		request: FoodInput{}, contentType: "text/event-stream",
+	},
+	{
+		method: "POST", path: "/api/mealplan",
+		summary: "Generate a meal plan with recipes and an aggregated shopping list",
+		request: MealPlanInput{}, response: MealPlan{},
+	},
+	{
+		method: "POST", path: "/api/suggest",
+		summary: "Suggest recipes ranked by fewest missing ingredients; with usePantry, your pantry is added and dishes using up items expiring soon come first",
+		request: PantryInput{}, response: PantrySuggestions{},
+	},
+	{
+		method: "POST", path: "/api/shopping-list",
+		summary: "Merge the ingredients of one or more recipes into a shopping list grouped by aisle",
+		request: ShoppingListInput{}, response: ShoppingList{},
+	},
+	{
+		method: "POST", path: "/api/substitute",
+		summary: "Suggest ingredient substitutes with ratios and flavor/texture impact",
+		request: SubstitutionInput{}, response: SubstitutionResult{},
+	},
+	{
+		method: "POST", path: "/api/nutrition",
+		summary: "Analyze a recipe's ingredient list into structured nutrition facts",
+		request: NutritionInput{}, response: NutritionAnalysis{},
+	},
+	{
+		method: "POST", path: "/api/pairing",
+		summary: "Suggest wine, beer and non-alcoholic pairings for a dish",
+		request: PairingInput{}, response: Pairings{},
+	},
+	{
+		method: "POST", path: "/api/leftovers",
+		summary: "Turn leftovers into three new recipes with food-safety notes",
+		request: LeftoverInput{}, response: LeftoverTransformations{},
+	},
+	{
+		method: "POST", path: "/api/recipe/variations",
+		summary: "Create cuisine variations of a recipe as ingredient and technique changes",
+		request: VariationInput{}, response: RecipeVariations{},
+	},
+	{
+		method: "POST", path: "/api/technique",
+		summary: "Explain a cooking technique with steps, equipment and common mistakes",
+		request: TechniqueInput{}, response: TechniqueExplanation{},
+	},
+	{
+		method: "POST", path: "/api/recipe/improve",
+		summary: "Critique a user recipe and return an improved version with the rationale per change",
+		request: ImproveInput{}, response: RecipeCritique{},
+	},
+	{
+		method: "POST", path: "/api/dietplan",
+		summary: "Generate a diet plan whose per-meal macros add up to the daily targets",
+		request: DietPlanInput{}, response: DietPlan{},
+	},
+	{
+		method: "POST", path: "/api/drink",
+		summary: "Generate a cocktail or drink recipe with glassware, garnish, ABV and a mocktail variant",
+		request: DrinkInput{}, response: DrinkRecipe{},
+	},
+	{
+		method: "POST", path: "/api/recipe/from-image",
+		summary: "Identify the dish in a photo and generate its recipe (JSON, or multipart form with an image file)",
+		request: ImageRecipeInput{}, response: ImageRecipeResult{},
+	},
+	{
+		method: "GET", path: "/api/images/{id}",
+		summary:     "Fetch a generated dish image (202 while still generating)",
+		contentType: "image/*",
+	},
+	{
+		method: "POST", path: "/api/session",
+		summary: "Start a cook-along chat session about a recipe",
+		request: SessionInput{}, response: map[string]string{},
+	},
+	{
+		method: "POST", path: "/api/session/{id}/message",
+		summary: "Ask a follow-up question in a cook-along session",
+		request: ChatMessageInput{}, response: ChatReply{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}/audio",
+		summary:  "List spoken instruction steps of a generated recipe",
+		response: RecipeAudio{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}/audio/{step}",
+		summary:     "Read one instruction step aloud as WAV audio",
+		contentType: "audio/wav",
+	},
+	{
+		method: "GET", path: "/api/seasonal",
+		summary:  "Suggest dishes using in-season produce; each suggestion includes a body for POST /api/recipe",
+		response: SeasonalSuggestions{},
+		query: []apiParam{
+			{name: "region", description: "Country or region", required: true},
+			{name: "month", description: "Month number or name (default current month)"},
+			{name: "limit", description: "Number of suggestions (default 5)"},
+		},
+	},
+	{
+		method: "POST", path: "/graphql",
+		summary:  "Run a GraphQL query (generateRecipe, mealPlan, recipe) selecting only the needed fields",
+		request:  graphQLRequest{},
+		response: map[string]any{},
+	},
+	{
+		method: "GET", path: "/ws/recipe",
+		summary: "Upgrade to a WebSocket; send {type: generate, requestId, input} or {type: cancel, requestId} and receive partial, done, error and cancelled messages; partial carries the recipe as far as it has been written",
+	},
+	{
+		method: "POST", path: "/v1/recipe",
+		summary: "Generate a recipe in the version 1 schema (other /v1/ paths mirror /api/)",
+		request: FoodInput{}, response: RecipeV1{},
+	},
+	{
+		method: "POST", path: "/v2/recipe",
+		summary: "Generate a recipe in the version 2 schema with grouped timing and cost and per-ingredient allergens (other /v2/ paths mirror /api/)",
+		request: FoodInput{}, response: RecipeV2{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}/pdf",
+		summary:     "Download a generated recipe as a printable PDF card",
+		contentType: "application/pdf",
+	},
+	{
+		method: "GET", path: "/api/collections",
+		summary:  "List your recipe collections, most recently updated first",
+		response: Collections{},
+	},
+	{
+		method: "POST", path: "/api/collections",
+		summary: "Create a collection of stored recipes in the order given, with an optional cover recipe",
+		request: CollectionInput{}, response: Collection{},
+	},
+	{
+		method: "GET", path: "/api/collections/{id}",
+		summary:  "Fetch one of your collections with summaries of its recipes",
+		response: Collection{},
+	},
+	{
+		method: "PUT", path: "/api/collections/{id}",
+		summary: "Replace the name, description, recipe order and cover of one of your collections",
+		request: CollectionInput{}, response: Collection{},
+	},
+	{
+		method: "DELETE", path: "/api/collections/{id}",
+		summary: "Delete one of your collections; its recipes are kept",
+	},
+	{
+		method: "POST", path: "/api/collections/{id}/recipes",
+		summary: "Add a recipe to a collection at a position, or move it there",
+		request: CollectionRecipe{}, response: Collection{},
+	},
+	{
+		method: "DELETE", path: "/api/collections/{id}/recipes/{recipe}",
+		summary:  "Take a recipe out of a collection",
+		response: Collection{},
+	},
+	{
+		method: "GET", path: "/api/collections/{id}/pdf",
+		summary:     "Download a collection as a printable cookbook with a cover, contents and a page per recipe",
+		contentType: "application/pdf",
+	},
+	{
+		method: "GET", path: "/api/mealplan/{id}/ical",
+		summary:     "Subscribe to a generated meal plan as an iCalendar feed with one event per meal",
+		contentType: "text/calendar",
+	},
+	{
+		method: "GET", path: "/api/calendar",
+		summary:  "Fetch your meal calendar with every date from from to to (default a week from today, at most 92 days)",
+		response: Calendar{},
+		query: []apiParam{
+			{name: "from", description: "First date as YYYY-MM-DD"},
+			{name: "to", description: "Last date as YYYY-MM-DD"},
+		},
+	},
+	{
+		method: "POST", path: "/api/calendar",
+		summary: "Put the meals of a generated meal plan on your calendar, replacing the meals in the slots it fills",
+		request: CalendarPlacement{}, response: Calendar{},
+	},
+	{
+		method: "POST", path: "/api/calendar/move",
+		summary: "Move a meal to another date or meal, swapping it with the meal there",
+		request: CalendarMove{}, response: CalendarMeals{},
+	},
+	{
+		method: "POST", path: "/api/calendar/{date}/{meal}/regenerate",
+		summary: "Replace one meal on your calendar with a new recipe, different from the dishes planned around it",
+		request: MealSlotInput{}, response: CalendarMeal{},
+	},
+	{
+		method: "DELETE", path: "/api/calendar/{date}/{meal}",
+		summary: "Remove a meal from your calendar",
+	},
+	{
+		method: "GET", path: "/api/shopping-list/{id}.csv",
+		summary:     "Export a generated shopping list as CSV with item, quantity, unit and aisle columns (use .tsv for tab-separated)",
+		contentType: "text/csv",
+	},
+	{
+		method: "GET", path: "/api/recipes",
+		summary:  "List generated recipes newest first; pass nextCursor as cursor for the next page",
+		response: RecipeList{},
+		query: []apiParam{
+			{name: "cursor", description: "nextCursor from the previous page"},
+			{name: "limit", description: "Recipes per page (default 20, at most 100)"},
+			{name: "difficulty", description: "Only recipes of this difficulty"},
+			{name: "cuisine", description: "Only recipes of this cuisine"},
+			{name: "tag", description: "Only recipes with this tag; repeat or comma-separate to require several"},
+			{name: "course", description: "Only recipes classified into this course; repeat or comma-separate to require several"},
+			{name: "diet", description: "Only recipes classified into this diet; repeat or comma-separate to require several"},
+			{name: "occasion", description: "Only recipes classified into this occasion; repeat or comma-separate to require several"},
+			{name: "maxTotalTime", description: "Only recipes ready within this many minutes"},
+		},
+	},
+	{
+		method: "GET", path: "/api/tags",
+		summary:  "List the cuisine, course, diet and occasion tags recipes are classified into, with how many stored recipes carry each",
+		response: TagList{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}",
+		summary:  "Fetch a generated recipe; send its ETag as If-None-Match to get 304 when unchanged",
+		response: FoodRecipe{},
+		alsoAs:   []string{"application/yaml", "application/xml", "text/markdown", "application/ld+json"},
+		query: []apiParam{
+			{name: "format", description: "Response format (json, yaml, xml, markdown or jsonld); overrides the Accept header"},
+			{name: "fields", description: "Comma-separated JSON fields to return (e.g. name,ingredients,nutrition.calories)"},
+		},
+	},
+	{
+		method: "DELETE", path: "/api/recipe/{id}",
+		summary: "Delete a stored recipe; only the account that created it, or an admin, may",
+	},
+	{
+		method: "PATCH", path: "/api/recipe/{id}",
+		summary: "Edit the given fields of a stored recipe, keeping the recipe as it was as an earlier version; only the account that created it, or an admin, may. Send the number of its latest version as If-Match to get 412 if it was edited since",
+		request: RecipeEdit{}, response: FoodRecipe{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}/versions",
+		summary:  "List the versions of a stored recipe oldest first, including edits and the recipes it was refined from, each with what changed from the version before",
+		response: RecipeVersions{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}/versions/{version}",
+		summary:  "Fetch one version of a stored recipe, numbered as in its versions, with its full content",
+		response: RecipeVersionDetail{},
+	},
+	{
+		method: "POST", path: "/api/recipe/{id}/favorite",
+		summary:  "Add a stored recipe to your favorites; requires an API key or token",
+		response: FavoriteStatus{},
+	},
+	{
+		method: "DELETE", path: "/api/recipe/{id}/favorite",
+		summary:  "Remove a recipe from your favorites",
+		response: FavoriteStatus{},
+	},
+	{
+		method: "GET", path: "/api/favorites",
+		summary:  "List your favorite recipes, most recently favorited first",
+		response: RecipeList{},
+	},
+	{
+		method: "PUT", path: "/api/recipe/{id}/review",
+		summary: "Rate a stored recipe from 1 to 5 stars with an optional review; rating again replaces your earlier review",
+		request: ReviewInput{}, response: Review{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}/reviews",
+		summary:  "List a recipe's reviews with their authors, most recently updated first; pass nextCursor as cursor for the next page",
+		response: ReviewList{},
+		query: []apiParam{
+			{name: "cursor", description: "nextCursor from the previous page"},
+			{name: "limit", description: "Reviews per page (default 20, at most 100)"},
+			{name: "stars", description: "Only reviews with this many stars"},
+		},
+	},
+	{
+		method: "GET", path: "/api/profile",
+		summary:  "Fetch your profile, registering you on the first call; requires a signed-in user or bearer JWT",
+		response: UserProfile{},
+	},
+	{
+		method: "PUT", path: "/api/profile",
+		summary: "Replace your dietary restrictions, default serving size and disliked ingredients, applied to your recipe requests",
+		request: UserPreferences{}, response: UserProfile{},
+	},
+	{
+		method: "GET", path: "/api/history",
+		summary:  "List the recipes you generated with the requests they came from, newest first; pass nextCursor as cursor for the next page",
+		response: HistoryPage{},
+		query: []apiParam{
+			{name: "q", description: "Only generations whose request or recipe mentions every word"},
+			{name: "cursor", description: "nextCursor from the previous page"},
+			{name: "limit", description: "Entries per page (default 20, at most 100)"},
+		},
+	},
+	{
+		method: "POST", path: "/api/history/{id}/rerun",
+		summary:  "Generate a new recipe from the request of a recipe in your history",
+		response: FoodRecipe{},
+	},
+	{
+		method: "GET", path: "/api/pantry",
+		summary:  "List the items in your pantry, soonest to expire first",
+		response: PantryEntries{},
+	},
+	{
+		method: "POST", path: "/api/pantry",
+		summary: "Add an item to your pantry with an optional quantity and expiry date",
+		request: PantryEntryInput{}, response: PantryEntry{},
+	},
+	{
+		method: "GET", path: "/api/pantry/{id}",
+		summary:  "Fetch an item in your pantry",
+		response: PantryEntry{},
+	},
+	{
+		method: "PUT", path: "/api/pantry/{id}",
+		summary: "Replace an item in your pantry",
+		request: PantryEntryInput{}, response: PantryEntry{},
+	},
+	{
+		method: "DELETE", path: "/api/pantry/{id}",
+		summary: "Remove an item from your pantry",
+	},
+	{
+		method: "GET", path: "/api/shopping-lists",
+		summary:  "List your shopping lists, most recently updated first",
+		response: SavedShoppingLists{},
+	},
+	{
+		method: "POST", path: "/api/shopping-lists",
+		summary: "Start a shopping list from stored recipes and meal plans, merging duplicate ingredients across units",
+		request: NewShoppingList{}, response: SavedShoppingList{},
+	},
+	{
+		method: "GET", path: "/api/shopping-lists/{id}",
+		summary:  "Fetch one of your shopping lists with its items in aisle order",
+		response: SavedShoppingList{},
+	},
+	{
+		method: "PATCH", path: "/api/shopping-lists/{id}",
+		summary: "Rename one of your shopping lists",
+		request: ShoppingListRename{}, response: SavedShoppingList{},
+	},
+	{
+		method: "DELETE", path: "/api/shopping-lists/{id}",
+		summary: "Delete one of your shopping lists",
+	},
+	{
+		method: "POST", path: "/api/shopping-lists/{id}/recipes",
+		summary: "Merge more recipes and meal plans into a shopping list; items checked off get unchecked when more is added",
+		request: ShoppingListAdditions{}, response: SavedShoppingList{},
+	},
+	{
+		method: "POST", path: "/api/shopping-lists/{id}/items/{item}/check",
+		summary:  "Check an item off a shopping list",
+		response: SavedShoppingList{},
+	},
+	{
+		method: "DELETE", path: "/api/shopping-lists/{id}/items/{item}/check",
+		summary:  "Uncheck an item of a shopping list",
+		response: SavedShoppingList{},
+	},
+	{
+		method: "GET", path: "/api/mealplan/{id}",
+		summary:  "Fetch a generated meal plan; send its ETag as If-None-Match to get 304 when unchanged",
+		response: MealPlan{},
+	},
+	{
+		method: "GET", path: "/v1/recipe/{id}",
+		summary:  "Fetch a generated recipe in the version 1 schema",
+		response: RecipeV1{},
+	},
+	{
+		method: "GET", path: "/v2/recipe/{id}",
+		summary:  "Fetch a generated recipe in the version 2 schema",
+		response: RecipeV2{},
+	},
+	{
+		method: "POST", path: "/api/recipes/import",
+		summary:  "Import recipes from a JSON array or NDJSON (application/x-ndjson); each row is checked against the FoodRecipe schema and reported separately",
+		request:  []FoodRecipe{},
+		response: ImportResult{},
+	},
+	{
+		method: "POST", path: "/api/recipes/search",
+		summary: "Find stored recipes similar in meaning to a free-text query (e.g. cozy winter soup, no dairy), most similar first",
+		request: RecipeSearchInput{}, response: RecipeSearchResults{},
+	},
+	{
+		method: "GET", path: "/api/recipes/search",
+		summary:  "Find stored recipes whose name, ingredients or instructions contain every word of a keyword query, best match first",
+		response: RecipeTextResults{},
+		query: []apiParam{
+			{name: "q", description: "Words to find, such as chickpea spinach", required: true},
+			{name: "limit", description: "Recipes to return (default 20, at most 100)"},
+			{name: "difficulty", description: "Only recipes of this difficulty"},
+			{name: "cuisine", description: "Only recipes of this cuisine"},
+			{name: "tag", description: "Only recipes with this tag; repeat or comma-separate to require several"},
+			{name: "course", description: "Only recipes classified into this course"},
+			{name: "diet", description: "Only recipes classified into this diet"},
+			{name: "occasion", description: "Only recipes classified into this occasion"},
+			{name: "maxTotalTime", description: "Only recipes ready within this many minutes"},
+		},
+	},
+	{
+		method: "POST", path: "/api/recipe/{id}/share",
+		summary:  "Create a short share link to a recipe's HTML page",
+		response: ShareLink{},
+	},
+	{
+		method: "GET", path: "/api/share/{slug}/qr.png",
+		summary:     "QR code pointing at a share link's page",
+		contentType: "image/png",
+		query:       []apiParam{{name: "size", description: "Image size in pixels (default 256)"}},
+	},
+	{
+		method: "GET", path: "/s/{slug}",
+		summary:     "Printable HTML page of a shared recipe",
+		contentType: "text/html",
+	},
+	{
+		method: "POST", path: "/api/pantry/from-image",
+		summary: "List the ingredients in a fridge or pantry photo and suggest what to cook with them (JSON or multipart with an image file)",
+		request: PantryImageInput{}, response: PantryImageResult{},
+	},
+	{
+		method: "POST", path: "/api/recipe/{id}/scale",
+		summary: "Store a copy of a recipe with ingredient amounts scaled to a new number of servings",
+		request: ScaleInput{}, response: FoodRecipe{},
+	},
+	{
+		method: "GET", path: "/api/recipe/{id}/nutrition",
+		summary:  "Analyze the nutrition of a stored recipe",
+		response: NutritionAnalysis{},
+	},
+	{
+		method: "POST", path: "/api/recipe/{id}/refine",
+		summary: "Store a new version of a recipe changed as instructed, with earlier refinements as context, and list what changed",
+		request: RefineInput{}, response: RecipeRefinement{},
+	},
+	{
+		method: "GET", path: "/config",
+		summary:  "Show the effective server configuration with secrets redacted",
+		response: config.Config{},
+	},
+	{
+		method: "GET", path: "/admin/config",
+		summary:  "Show the runtime settings in effect",
+		response: RuntimeSettings{},
+	},
+	{
+		method: "PATCH", path: "/admin/config",
+		summary: "Change runtime settings without a restart; all changes apply together and are audited",
+		request: RuntimeSettingsPatch{}, response: RuntimeSettings{},
+	},
+	{
+		method: "GET", path: "/admin/config/audit",
+		summary:  "List recent runtime settings changes, newest first",
+		response: []SettingsChange{},
+	},
+	{
+		method: "GET", path: "/debug/goroutines",
+		summary: "Dump every goroutine's stack as text; pprof and expvar are under /debug/pprof/ and /debug/vars",
+	},
+	{
+		method: "GET", path: "/admin/prompts",
+		summary:  "Traffic split and success rate, rejections and latency of each prompt version",
+		response: []PromptStats{},
+	},
+	{
+		method: "POST", path: "/admin/prompts/reload",
+		summary:  "Reload the prompt directory; the previous prompts stay in use on error",
+		response: PromptReload{},
+	},
+	{
+		method: "GET", path: "/admin/quality",
+		summary:  "Average completeness, clarity and constraint scores of generated recipes, overall and by model and prompt version",
+		response: QualityStats{},
+	},
+	{
+		method: "GET", path: "/admin/usage",
+		summary:  "Token usage and estimated cost per API key per day, newest day first",
+		response: UsageReport{},
+		query: []apiParam{
+			{name: "from", description: "First day, as YYYY-MM-DD (default 29 days ago)"},
+			{name: "to", description: "Last day, as YYYY-MM-DD (default today)"},
+			{name: "key", description: "Only this API key ID, user:<id> or anonymous"},
+		},
+	},
+	{
+		method: "GET", path: "/admin/flags",
+		summary:  "List the feature flags in effect",
+		response: FeatureFlags{},
+	},
+	{
+		method: "POST", path: "/admin/flags/reload",
+		summary:  "Reload the feature flags file; the previous flags stay on error",
+		response: FeatureFlags{},
+	},
+	{
+		method: "POST", path: "/api/keys",
+		summary: "Issue an API key with the given scopes; the key is only returned once",
+		request: APIKeyInput{}, response: APIKey{},
+	},
+	{
+		method: "GET", path: "/api/keys",
+		summary:  "List issued API keys without their secrets",
+		response: []APIKey{},
+	},
+	{
+		method: "DELETE", path: "/api/keys/{id}",
+		summary: "Revoke an API key",
+	},
+	{
+		method: "GET", path: "/auth/me",
+		summary:  "Show the user signed in with the session cookie",
+		response: Session{},
+	},
+	{
+		method: "POST", path: "/auth/logout",
+		summary: "Sign out and clear the session cookie",
+	},
+	{
+		method: "GET", path: "/healthz",
+		summary:  "Liveness probe: the process is up",
+		response: HealthReport{},
+	},
+	{
+		method: "GET", path: "/healthz/deep",
+		summary:  "Deep health check: a small cached generation against the configured model, with latency and quota hints (503 when it fails)",
+		response: HealthReport{},
+	},
+	{
+		method: "GET", path: "/readyz",
+		summary:  "Readiness probe: Genkit is initialized and the model is reachable (503 otherwise)",
+		response: HealthReport{},
+	},
+	{
+		method: "GET", path: "/health",
+		summary:  "Health check endpoint",
+		response: map[string]string{},
+	},
+}
+
+// Path parameters such as {id}
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument builds the OpenAPI 3.1 document once from apiOperations
+var openAPIDocument = sync.OnceValue(func() map[string]any {
+	schemas := map[string]any{}
+	ref := func(v any) map[string]any {
+		t := reflect.TypeOf(v)
+		if t.Kind() != reflect.Struct {
+			return reflectSchema(v)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = reflectSchema(v)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	errorBody := map[string]any{
+		"description": "Error",
+		"content":     map[string]any{"application/json": map[string]any{"schema": ref(ErrorResponse{})}},
+	}
+
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		var params []map[string]any
+		for _, m := range pathParamPattern.FindAllStringSubmatch(op.path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range op.query {
+			params = append(params, map[string]any{
+				"name": q.name, "in": "query", "required": q.required, "description": q.description,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+
+		ok := map[string]any{"description": "Success"}
+		switch {
+		case op.response != nil:
+			content := map[string]any{"application/json": map[string]any{"schema": ref(op.response)}}
+			for _, mediaType := range op.alsoAs {
+				content[mediaType] = content["application/json"]
+			}
+			ok["content"] = content
+		case op.contentType != "":
+			ok["content"] = map[string]any{op.contentType: map[string]any{}}
+		}
+
+		operation := map[string]any{
+			"summary":     op.summary,
+			"operationId": operationID(op),
+			"responses":   map[string]any{"200": ok, "default": errorBody},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.request != nil {
+			media := map[string]any{"schema": ref(op.request)}
+			if op.example != nil {
+				media["example"] = op.example
+			}
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": media},
+			}
+		}
+
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]any{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "Food Recipe API",
+			"version":     apiVersion,
+			"description": "Recipe generation and cooking assistance powered by Genkit and Gemini",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+})
+
+// reflectSchema derives the JSON schema of a value's type, inlining nested
+// types the way Genkit does for flow schemas
+func reflectSchema(v any) map[string]any {
+	r := jsonschema.Reflector{DoNotReference: true}
+	s := r.Reflect(v)
+	s.Version, s.ID = "", ""
+
+	var schema map[string]any
+	b, _ := json.Marshal(s)
+	json.Unmarshal(b, &schema)
+	return schema
+}
+
+// operationID derives a stable id such as "postApiRecipeStream"
+func operationID(op apiOperation) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(op.method))
+	words := strings.FieldsFunc(op.path, func(r rune) bool {
+		return r == '/' || r == '-' || r == '{' || r == '}'
+	})
+	for _, word := range words {
+		sb.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return sb.String()
+}
+
+// openAPIHandler serves the OpenAPI document
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+// Swagger UI page pointing at /openapi.json
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Food Recipe API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// docsHandler serves the Swagger UI
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(swaggerUIPage))
+}
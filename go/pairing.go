@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for beverage pairing requests
+type PairingInput struct {
+	Dish        string   `json:"dish" jsonschema:"description=Name of the dish to pair with,required=true"`
+	Description string   `json:"description,omitempty" jsonschema:"description=Short description of the dish"`
+	Ingredients []string `json:"ingredients,omitempty" jsonschema:"description=Main ingredients of the dish"`
+}
+
+// A single beverage pairing suggestion
+type Pairing struct {
+	Name      string `json:"name"`
+	Style     string `json:"style,omitempty"`
+	Reasoning string `json:"reasoning"`
+}
+
+// Define output schema for beverage pairings
+type Pairings struct {
+	Wine         []Pairing `json:"wine"`
+	Beer         []Pairing `json:"beer"`
+	NonAlcoholic []Pairing `json:"nonAlcoholic"`
+}
+
+// generatePairings asks the model for wine, beer and non-alcoholic pairings
+func generatePairings(ctx context.Context, g *genkit.Genkit, input *PairingInput) (*Pairings, error) {
+	if strings.TrimSpace(input.Dish) == "" {
+		return nil, newInputError("dish is required")
+	}
+
+	description := input.Description
+	if description == "" {
+		description = "not provided"
+	}
+
+	ingredients := "not provided"
+	if len(input.Ingredients) > 0 {
+		ingredients = strings.Join(input.Ingredients, ", ")
+	}
+
+	prompt := fmt.Sprintf(`Suggest beverage pairings for the following dish:
+
+		Dish: %s
+		Description: %s
+		Ingredients: %s
+
+		Please provide two or three suggestions each for wine, beer and non-alcoholic drinks.
+		For each suggestion give the specific beverage, its style, and a short reasoning
+		based on how it complements or contrasts the flavors of the dish.`,
+		input.Dish, description, ingredients)
+
+	pairings, _, err := genkit.GenerateData[Pairings](ctx, g,
+		ai.WithPrompt(prompt),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate pairings for %s: %w", input.Dish, err)
+	}
+
+	return pairings, nil
+}
+
+// Define the beverage pairing flow
+func definePairingFlow(g *genkit.Genkit) *core.Flow[*PairingInput, *Pairings, struct{}] {
+	return genkit.DefineFlow(g, "pairingFlow", func(ctx context.Context, input *PairingInput) (*Pairings, error) {
+		return generatePairings(ctx, g, input)
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for "what can I cook" requests
+type PantryInput struct {
+	Ingredients         []string `json:"ingredients" jsonschema:"description=Ingredients already available,required=true"`
+	DietaryRestrictions string   `json:"dietaryRestrictions,omitempty" jsonschema:"description=Any dietary restrictions (vegetarian\\, vegan\\, gluten-free\\, etc.)"`
+	MaxSuggestions      int      `json:"maxSuggestions,omitempty" jsonschema:"description=Maximum number of suggestions (default 5)"`
+	UsePantry           bool     `json:"usePantry,omitempty" jsonschema:"description=Also cook with the items in your pantry\\, using up those expiring soon first"`
+}
+
+// A recipe suggestion based on available ingredients
+type RecipeSuggestion struct {
+	Rank               int      `json:"rank"`
+	Name               string   `json:"name"`
+	Description        string   `json:"description"`
+	Difficulty         string   `json:"difficulty"`
+	TotalTime          string   `json:"totalTime"`
+	UsedIngredients    []string `json:"usedIngredients"`
+	MissingIngredients []string `json:"missingIngredients"`
+	// The pantry items expiring soon that the dish uses up
+	ExpiringIngredients []string `json:"expiringIngredients,omitempty" jsonschema:"-"`
+}
+
+// Define output schema for pantry suggestions
+type PantrySuggestions struct {
+	Suggestions []RecipeSuggestion `json:"suggestions"`
+}
+
+// Define the pantry suggestion flow
+func definePantryFlow(g *genkit.Genkit) *core.Flow[*PantryInput, *PantrySuggestions, struct{}] {
+	return genkit.DefineFlow(g, "pantryFlow", func(ctx context.Context, input *PantryInput) (*PantrySuggestions, error) {
+		var ingredients []string
+		for _, ingredient := range input.Ingredients {
+			if ingredient = strings.TrimSpace(ingredient); ingredient != "" {
+				ingredients = append(ingredients, ingredient)
+			}
+		}
+		var expiring []string
+		if input.UsePantry {
+			ingredients, expiring = withPantry(ctx, ingredients)
+		}
+		if len(ingredients) == 0 {
+			return nil, newInputError("at least one ingredient is required")
+		}
+
+		maxSuggestions := input.MaxSuggestions
+		if maxSuggestions <= 0 {
+			maxSuggestions = 5
+		}
+
+		dietaryRestrictions := input.DietaryRestrictions
+		if dietaryRestrictions == "" {
+			dietaryRestrictions = "none"
+		}
+
+		prompt := fmt.Sprintf(`Suggest up to %d recipes that can be cooked with the following ingredients:
+
+		Available ingredients: %s
+		Dietary restrictions: %s
+
+		For each suggestion provide:
+		1. The dish name and a brief description
+		2. Difficulty level and total time
+		3. usedIngredients: the available ingredients the dish uses
+		4. missingIngredients: anything else needed, excluding basic staples like salt, pepper, oil and water
+
+		Prefer dishes that need as few missing ingredients as possible.`,
+			maxSuggestions, strings.Join(ingredients, ", "), dietaryRestrictions)
+		if len(expiring) > 0 {
+			prompt += fmt.Sprintf(`
+
+		These ingredients expire soon, so prefer dishes that use them up: %s`, strings.Join(expiring, ", "))
+		}
+
+		result, _, err := genkit.GenerateData[PantrySuggestions](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate suggestions: %w", err)
+		}
+
+		result.rank(maxSuggestions, expiring)
+		return result, nil
+	})
+}
+
+// withPantry adds the caller's pantry items to the ingredients, leaving out
+// those that have expired, and returns the items expiring soon
+func withPantry(ctx context.Context, ingredients []string) (all, expiring []string) {
+	account := requestAccount(ctx)
+	if account == "anonymous" {
+		return ingredients, nil
+	}
+	now := time.Now()
+	for _, entry := range pantries.list(account) {
+		days, dated := entry.daysLeft(now)
+		if dated && days < 0 {
+			continue
+		}
+		if !slices.ContainsFunc(ingredients, func(ingredient string) bool { return strings.EqualFold(ingredient, entry.Name) }) {
+			ingredients = append(ingredients, entry.Name)
+		}
+		if dated && days <= expiringSoonDays {
+			expiring = append(expiring, entry.Name)
+		}
+	}
+	return ingredients, expiring
+}
+
+// rank orders suggestions by how many of the expiring ingredients they use
+// up, then by fewest missing ingredients, and keeps at most limit
+func (s *PantrySuggestions) rank(limit int, expiring []string) {
+	for i := range s.Suggestions {
+		s.Suggestions[i].ExpiringIngredients = usedExpiring(s.Suggestions[i].UsedIngredients, expiring)
+	}
+	sort.SliceStable(s.Suggestions, func(i, j int) bool {
+		a, b := &s.Suggestions[i], &s.Suggestions[j]
+		if len(a.ExpiringIngredients) != len(b.ExpiringIngredients) {
+			return len(a.ExpiringIngredients) > len(b.ExpiringIngredients)
+		}
+		return len(a.MissingIngredients) < len(b.MissingIngredients)
+	})
+	if len(s.Suggestions) > limit {
+		s.Suggestions = s.Suggestions[:limit]
+	}
+	for i := range s.Suggestions {
+		s.Suggestions[i].Rank = i + 1
+		if s.Suggestions[i].MissingIngredients == nil {
+			s.Suggestions[i].MissingIngredients = []string{}
+		}
+	}
+}
+
+// usedExpiring returns the expiring ingredients that a dish's used
+// ingredients mention, in either direction ("spinach" and "baby spinach")
+func usedExpiring(used, expiring []string) []string {
+	var found []string
+	for _, item := range expiring {
+		name := strings.TrimSpace(normalizeFoodText(item))
+		if name == "" {
+			continue
+		}
+		for _, ingredient := range used {
+			text := normalizeFoodText(ingredient)
+			if containsWord(text, name) || containsWord(normalizeFoodText(item), strings.TrimSpace(text)) {
+				found = append(found, item)
+				break
+			}
+		}
+	}
+	return found
+}
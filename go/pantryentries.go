@@ -0,0 +1,358 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Layout of pantry expiry dates
+	expiryDateLayout = "2006-01-02"
+
+	// Items expiring within this many days are used up first
+	expiringSoonDays = 3
+
+	// Bounds on pantry items
+	maxPantryItemName = 100
+	maxPantryItems    = 500
+)
+
+// Body of POST /api/pantry and PUT /api/pantry/{id}
+type PantryEntryInput struct {
+	Name      string  `json:"name" jsonschema:"description=Ingredient name (e.g. spinach),required=true"`
+	Quantity  float64 `json:"quantity,omitempty" jsonschema:"description=How much is left"`
+	Unit      string  `json:"unit,omitempty" jsonschema:"description=Unit of the quantity (e.g. g\\, bunch)"`
+	ExpiresOn string  `json:"expiresOn,omitempty" jsonschema:"description=Expiry date as YYYY-MM-DD"`
+}
+
+// validate checks the name, quantity and expiry date
+func (in *PantryEntryInput) validate() []FieldError {
+	var errs []FieldError
+	switch name := strings.TrimSpace(in.Name); {
+	case name == "":
+		errs = append(errs, FieldError{"name", "is required"})
+	case utf8.RuneCountInString(name) > maxPantryItemName:
+		errs = append(errs, FieldError{"name", fmt.Sprintf("must be at most %d characters", maxPantryItemName)})
+	}
+	if in.Quantity < 0 {
+		errs = append(errs, FieldError{"quantity", "must not be negative"})
+	}
+	if utf8.RuneCountInString(in.Unit) > maxPantryItemName {
+		errs = append(errs, FieldError{"unit", fmt.Sprintf("must be at most %d characters", maxPantryItemName)})
+	}
+	if in.ExpiresOn != "" {
+		if _, err := time.Parse(expiryDateLayout, in.ExpiresOn); err != nil {
+			errs = append(errs, FieldError{"expiresOn", "must be a date as YYYY-MM-DD"})
+		}
+	}
+	return errs
+}
+
+// An ingredient in an account's pantry
+type PantryEntry struct {
+	ID string `json:"id"`
+	PantryEntryInput
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// An account's pantry, soonest to expire first
+type PantryEntries struct {
+	Items []PantryEntry `json:"items"`
+}
+
+// daysLeft returns how many days after now's date the item expires,
+// negative once it has expired. It reports false for items without an
+// expiry date
+func (item *PantryEntry) daysLeft(now time.Time) (int, bool) {
+	expires, err := time.Parse(expiryDateLayout, item.ExpiresOn)
+	if err != nil {
+		return 0, false
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return int(expires.Sub(today).Hours() / 24), true
+}
+
+// comparePantryEntries orders items soonest to expire first, items without
+// an expiry date last, then by name
+func comparePantryEntries(a, b PantryEntry) int {
+	switch {
+	case a.ExpiresOn == "" && b.ExpiresOn != "":
+		return 1
+	case a.ExpiresOn != "" && b.ExpiresOn == "":
+		return -1
+	}
+	return cmp.Or(strings.Compare(a.ExpiresOn, b.ExpiresOn), strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), strings.Compare(a.ID, b.ID))
+}
+
+// pantryStore keeps each account's pantry items
+type pantryStore interface {
+	// list returns an account's items, soonest to expire first
+	list(account string) []PantryEntry
+	// get returns one of an account's items
+	get(account, id string) (*PantryEntry, bool)
+	// put adds an item to an account's pantry, or replaces the one with the
+	// item's id. It reports false when there is no item to replace
+	put(ctx context.Context, account string, item *PantryEntry) (bool, error)
+	// delete removes one of an account's items, reporting whether there
+	// was one
+	delete(ctx context.Context, account, id string) (bool, error)
+}
+
+// Pantry items of every account. Set at startup to keep them in the
+// recipe database when recipes are kept in SQL
+var pantries pantryStore = newMemoryPantryStore()
+
+// newPantryStore keeps pantries alongside the recipes: in their database
+// for the SQL stores, in memory otherwise
+func newPantryStore(recipes recipeStore) pantryStore {
+	if s, ok := recipes.(*sqlRecipeStore); ok {
+		return &sqlPantryStore{db: s.db}
+	}
+	return newMemoryPantryStore()
+}
+
+// memoryPantryStore keeps pantry items in memory
+type memoryPantryStore struct {
+	mu sync.Mutex
+	// Items by account and id
+	items map[string]map[string]PantryEntry
+}
+
+// newMemoryPantryStore creates an empty in-memory pantry store
+func newMemoryPantryStore() *memoryPantryStore {
+	return &memoryPantryStore{items: make(map[string]map[string]PantryEntry)}
+}
+
+func (s *memoryPantryStore) list(account string) []PantryEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]PantryEntry, 0, len(s.items[account]))
+	for _, item := range s.items[account] {
+		items = append(items, item)
+	}
+	slices.SortFunc(items, comparePantryEntries)
+	return items
+}
+
+func (s *memoryPantryStore) get(account, id string) (*PantryEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[account][id]
+	if !ok {
+		return nil, false
+	}
+	return &item, true
+}
+
+func (s *memoryPantryStore) put(ctx context.Context, account string, item *PantryEntry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items, ok := s.items[account]
+	if !ok {
+		items = make(map[string]PantryEntry)
+		s.items[account] = items
+	}
+	if item.ID == "" {
+		item.ID, item.CreatedAt = newID(), item.UpdatedAt
+	} else if previous, ok := items[item.ID]; ok {
+		item.CreatedAt = previous.CreatedAt
+	} else {
+		return false, nil
+	}
+	items[item.ID] = *item
+	return true, nil
+}
+
+func (s *memoryPantryStore) delete(ctx context.Context, account, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.items[account][id]
+	delete(s.items[account], id)
+	return ok, nil
+}
+
+// sqlPantryStore keeps pantry items in the pantry_items table of the
+// recipe database. Expiry dates are kept as YYYY-MM-DD text, which sorts
+// by date
+type sqlPantryStore struct {
+	db *sql.DB
+}
+
+// Columns scanPantryEntry reads
+const pantryItemColumns = `id, name, quantity, unit, expires_on, created_at, updated_at`
+
+func (s *sqlPantryStore) list(account string) []PantryEntry {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	items := []PantryEntry{}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+pantryItemColumns+` FROM pantry_items WHERE account = $1`, account)
+	if err != nil {
+		log.Printf("Failed to list the pantry of %s: %v", account, err)
+		return items
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scanPantryEntry(rows)
+		if err != nil {
+			log.Printf("Failed to read a pantry item: %v", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list the pantry of %s: %v", account, err)
+	}
+	slices.SortFunc(items, comparePantryEntries)
+	return items
+}
+
+func (s *sqlPantryStore) get(account, id string) (*PantryEntry, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	item, err := scanPantryEntry(s.db.QueryRowContext(ctx,
+		`SELECT `+pantryItemColumns+` FROM pantry_items WHERE account = $1 AND id = $2`, account, id))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to read pantry item %s: %v", id, err)
+		}
+		return nil, false
+	}
+	return &item, true
+}
+
+func (s *sqlPantryStore) put(ctx context.Context, account string, item *PantryEntry) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	if item.ID == "" {
+		item.ID, item.CreatedAt = newID(), item.UpdatedAt
+		_, err := s.db.ExecContext(ctx,
+			`INSERT INTO pantry_items (id, account, name, quantity, unit, expires_on, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $7)`,
+			item.ID, account, item.Name, item.Quantity, item.Unit, item.ExpiresOn, item.UpdatedAt.UTC())
+		if err != nil {
+			return false, fmt.Errorf("adding pantry item %s: %w", item.Name, err)
+		}
+		return true, nil
+	}
+
+	err := s.db.QueryRowContext(ctx,
+		`UPDATE pantry_items SET name = $1, quantity = $2, unit = $3, expires_on = $4, updated_at = $5
+		WHERE account = $6 AND id = $7 RETURNING created_at`,
+		item.Name, item.Quantity, item.Unit, item.ExpiresOn, item.UpdatedAt.UTC(), account, item.ID).Scan(&item.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("updating pantry item %s: %w", item.ID, err)
+	}
+	return true, nil
+}
+
+func (s *sqlPantryStore) delete(ctx context.Context, account, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM pantry_items WHERE account = $1 AND id = $2`, account, id)
+	if err != nil {
+		return false, fmt.Errorf("deleting pantry item %s: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// scanPantryEntry reads a row of pantryItemColumns
+func scanPantryEntry(row interface{ Scan(...any) error }) (PantryEntry, error) {
+	var item PantryEntry
+	err := row.Scan(&item.ID, &item.Name, &item.Quantity, &item.Unit, &item.ExpiresOn, &item.CreatedAt, &item.UpdatedAt)
+	return item, err
+}
+
+// listPantryHandler lists the caller's pantry, soonest to expire first
+func listPantryHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a pantry")
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, PantryEntries{Items: pantries.list(account)})
+}
+
+// getPantryItemHandler serves one of the caller's pantry items
+func getPantryItemHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a pantry")
+	if !ok {
+		return
+	}
+	item, ok := pantries.get(account, r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Item Not Found", "Your pantry has no item with this id")
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// putPantryItemHandler adds an item to the caller's pantry, or replaces
+// the one in the path
+func putPantryItemHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a pantry")
+	if !ok {
+		return
+	}
+	var input PantryEntryInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	input.Name, input.Unit = strings.TrimSpace(input.Name), strings.TrimSpace(input.Unit)
+
+	id := r.PathValue("id")
+	if id == "" && len(pantries.list(account)) >= maxPantryItems {
+		writeError(w, http.StatusConflict, "Pantry Full", fmt.Sprintf("A pantry holds at most %d items; delete some first", maxPantryItems))
+		return
+	}
+	item := PantryEntry{ID: id, PantryEntryInput: input, UpdatedAt: time.Now()}
+	found, err := pantries.put(r.Context(), account, &item)
+	if err != nil {
+		log.Printf("Failed to save pantry item of %s: %v", account, err)
+		writeError(w, http.StatusInternalServerError, "Save Failed", "The pantry item could not be saved; try again later")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "Item Not Found", "Your pantry has no item with this id")
+		return
+	}
+	if id == "" {
+		w.Header().Set("Location", "/api/pantry/"+item.ID)
+		writeJSON(w, http.StatusCreated, item)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// deletePantryItemHandler removes one of the caller's pantry items
+func deletePantryItemHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep a pantry")
+	if !ok {
+		return
+	}
+	deleted, err := pantries.delete(r.Context(), account, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Failed to delete pantry item of %s: %v", account, err)
+		writeError(w, http.StatusInternalServerError, "Delete Failed", "The pantry item could not be deleted; try again later")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "Item Not Found", "Your pantry has no item with this id")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
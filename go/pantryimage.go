@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Items seen with less confidence than this are left out of the inventory
+const minPantryItemConfidence = 0.4
+
+// Define input schema for pantry photo requests
+type PantryImageInput struct {
+	Image               string `json:"image" jsonschema:"description=Base64 encoded image or data URL of the fridge or pantry,required=true"`
+	MimeType            string `json:"mimeType,omitempty" jsonschema:"description=Image type when image is plain base64 (e.g. image/jpeg)"`
+	DietaryRestrictions string `json:"dietaryRestrictions,omitempty" jsonschema:"description=Any dietary restrictions for the suggestions"`
+	MaxSuggestions      int    `json:"maxSuggestions,omitempty" jsonschema:"description=Maximum number of suggestions (default 5)"`
+	InventoryOnly       bool   `json:"inventoryOnly,omitempty" jsonschema:"description=Only list the ingredients without suggesting recipes"`
+}
+
+// An ingredient recognised in a pantry photo
+type PantryItem struct {
+	Name       string  `json:"name"`
+	Quantity   string  `json:"quantity,omitempty" jsonschema:"description=Rough amount visible (e.g. half a carton)"`
+	Confidence float64 `json:"confidence" jsonschema:"description=Confidence between 0 and 1"`
+}
+
+// The model's inventory of a pantry photo
+type pantryInventory struct {
+	Items []PantryItem `json:"items"`
+}
+
+// Define output schema for pantry photo response
+type PantryImageResult struct {
+	Inventory   []PantryItem       `json:"inventory"`
+	Suggestions *PantrySuggestions `json:"suggestions,omitempty"`
+}
+
+// Define the pantry photo flow, which lists the ingredients in the photo and
+// then runs the "what can I cook" flow with them
+func definePantryFromImageFlow(g *genkit.Genkit, pantryFlow *core.Flow[*PantryInput, *PantrySuggestions, struct{}]) *core.Flow[*PantryImageInput, *PantryImageResult, struct{}] {
+	return genkit.DefineFlow(g, "pantryFromImageFlow", func(ctx context.Context, input *PantryImageInput) (*PantryImageResult, error) {
+		contentType, dataURL, err := imageDataURL(input.Image, input.MimeType)
+		if err != nil {
+			return nil, err
+		}
+
+		inventory, err := identifyPantryItems(ctx, g, contentType, dataURL)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &PantryImageResult{Inventory: inventory}
+		if input.InventoryOnly {
+			return result, nil
+		}
+
+		ingredients := make([]string, len(inventory))
+		for i, item := range inventory {
+			ingredients[i] = item.Name
+		}
+		result.Suggestions, err = pantryFlow.Run(ctx, &PantryInput{
+			Ingredients:         ingredients,
+			DietaryRestrictions: input.DietaryRestrictions,
+			MaxSuggestions:      input.MaxSuggestions,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+}
+
+// identifyPantryItems asks the model which ingredients are visible in the photo
+func identifyPantryItems(ctx context.Context, g *genkit.Genkit, contentType, dataURL string) ([]PantryItem, error) {
+	inventory, _, err := genkit.GenerateData[pantryInventory](ctx, g,
+		ai.WithMessages(ai.NewUserMessage(
+			ai.NewTextPart(`List the food ingredients visible in this photo of a fridge, pantry or countertop.
+
+		For each ingredient provide:
+		1. A generic ingredient name as used in recipes (e.g. "eggs", "cheddar cheese", "spinach"), not brand names
+		2. A rough quantity if it can be judged
+		3. Your confidence between 0 and 1
+
+		List each ingredient once and leave out non-food items and condiments you cannot read.
+		If the photo shows no food, return an empty list.`),
+			ai.NewMediaPart(contentType, dataURL),
+		)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify pantry items: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	items := []PantryItem{}
+	for _, item := range inventory.Items {
+		item.Name = strings.TrimSpace(item.Name)
+		key := strings.ToLower(item.Name)
+		if key == "" || seen[key] || item.Confidence < minPantryItemConfidence {
+			continue
+		}
+		seen[key] = true
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, newInputError("could not find any ingredients in the image")
+	}
+	return items, nil
+}
+
+// pantryFromImageHandler accepts either a JSON PantryImageInput or a
+// multipart form with an "image" file and optional option fields
+func pantryFromImageHandler(flow *core.Flow[*PantryImageInput, *PantryImageResult, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Leave room for base64 and form overhead on top of the image itself
+		r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes*2)
+
+		var input PantryImageInput
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := parsePantryImageForm(r, &input); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid Form", err.Error())
+				return
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+
+		result, err := flow.Run(r.Context(), &input)
+		if err != nil {
+			log.Printf("Error reading pantry image: %v", err)
+			writeFlowError(w, err, "Pantry Recognition Failed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// parsePantryImageForm reads the image file and options from a multipart form
+func parsePantryImageForm(r *http.Request, input *PantryImageInput) error {
+	var err error
+	if input.Image, input.MimeType, err = formImage(r); err != nil {
+		return err
+	}
+
+	input.DietaryRestrictions = r.FormValue("dietaryRestrictions")
+	input.InventoryOnly = r.FormValue("inventoryOnly") == "true"
+	if v := r.FormValue("maxSuggestions"); v != "" {
+		if input.MaxSuggestions, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("maxSuggestions must be a number")
+		}
+	}
+	return nil
+}
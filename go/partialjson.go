@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// jsonFrame is an object or array the scan is inside
+type jsonFrame struct {
+	closer byte
+	// For objects: the next string is a key rather than a value
+	expectKey bool
+}
+
+// closeJSON completes the start of a JSON document the model is still
+// writing, so it can be parsed as far as it goes. A member or element cut
+// off mid-way is dropped, except that a string value is kept up to where
+// it stops. Text before the first brace, such as a Markdown fence, is
+// skipped. It reports false while not even the opening brace has arrived
+func closeJSON(text string) (string, bool) {
+	start := strings.IndexByte(text, '{')
+	if start < 0 {
+		return "", false
+	}
+	text = text[start:]
+
+	var stack []jsonFrame
+	closers := func() string {
+		b := make([]byte, len(stack))
+		for i := range stack {
+			b[len(stack)-1-i] = stack[i].closer
+		}
+		return string(b)
+	}
+	// The longest prefix that is complete up to its closing brackets
+	safe, safeClosers := 0, ""
+	valueEnd := func(end int) {
+		if n := len(stack); n > 0 && stack[n-1].closer == '}' {
+			stack[n-1].expectKey = false
+		}
+		safe, safeClosers = end, closers()
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case ' ', '\t', '\n', '\r', ':':
+		case '{', '[':
+			closer := byte('}')
+			if c == '[' {
+				closer = ']'
+			}
+			stack = append(stack, jsonFrame{closer: closer, expectKey: c == '{'})
+			safe, safeClosers = i+1, closers()
+		case '}', ']':
+			if len(stack) == 0 {
+				return text[:safe] + safeClosers, true
+			}
+			stack = stack[:len(stack)-1]
+			valueEnd(i + 1)
+			if len(stack) == 0 {
+				return text[:i+1], true
+			}
+		case ',':
+			if n := len(stack); n > 0 && stack[n-1].closer == '}' {
+				stack[n-1].expectKey = true
+			}
+		case '"':
+			isKey := len(stack) > 0 && stack[len(stack)-1].closer == '}' && stack[len(stack)-1].expectKey
+			// Find the closing quote, remembering where an unfinished
+			// escape starts
+			j, escape := i+1, -1
+			for ; j < len(text); j++ {
+				if text[j] == '\\' {
+					escape = j
+					j++
+					if j < len(text) && text[j] == 'u' {
+						j += 4
+					}
+					continue
+				}
+				if text[j] == '"' {
+					break
+				}
+			}
+			if j >= len(text) {
+				// The string is cut off: keep a value as far as it goes
+				if isKey {
+					return text[:safe] + safeClosers, true
+				}
+				end := len(text)
+				if escape >= 0 && (escape == len(text)-1 || text[escape+1] == 'u' && escape+6 > len(text)) {
+					end = escape
+				}
+				return text[:end] + `"` + closers(), true
+			}
+			if isKey {
+				stack[len(stack)-1].expectKey = false
+			} else {
+				valueEnd(j + 1)
+			}
+			i = j
+		default:
+			// A number or literal, complete only once something follows it
+			j := i
+			for j < len(text) && strings.IndexByte(" \t\n\r,}]", text[j]) < 0 {
+				j++
+			}
+			if j == len(text) {
+				return text[:safe] + safeClosers, true
+			}
+			valueEnd(j)
+			i = j - 1
+		}
+	}
+	return text[:safe] + safeClosers, true
+}
+
+// partialRecipe parses what the model has written of a recipe so far. It
+// reports false when nothing can be parsed yet
+func partialRecipe(text string) (*FoodRecipe, bool) {
+	closed, ok := closeJSON(text)
+	if !ok {
+		return nil, false
+	}
+	var recipe FoodRecipe
+	if err := json.Unmarshal([]byte(closed), &recipe); err != nil {
+		return nil, false
+	}
+	return &recipe, true
+}
+
+// recipeStreamer turns the model's streamed JSON into partial recipes,
+// emitting one only when more of the recipe has been parsed
+type recipeStreamer struct {
+	text strings.Builder
+	last []byte
+}
+
+// add appends a chunk of model text and returns the recipe parsed so far
+// when it has changed
+func (s *recipeStreamer) add(chunk string) (*FoodRecipe, bool) {
+	s.text.WriteString(chunk)
+	recipe, ok := partialRecipe(s.text.String())
+	if !ok {
+		return nil, false
+	}
+	data, err := json.Marshal(recipe)
+	if err != nil || bytes.Equal(data, s.last) {
+		return nil, false
+	}
+	s.last = data
+	return recipe, true
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Layout of the printable recipe card, in millimetres
+const (
+	pdfMargin      = 15.0
+	pdfColumnGap   = 8.0
+	pdfLineHeight  = 5.5
+	pdfHeadingSize = 13.0
+	pdfBodySize    = 10.5
+)
+
+// Characters that can't appear in a filename
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// newPDF starts an A4 document with the card margins
+func newPDF(title string) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetTitle(title, true)
+	return pdf
+}
+
+// pdfBytes finishes a document
+func pdfBytes(pdf *gofpdf.Fpdf) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// recipePDF renders a recipe as a single printable card: title, times,
+// ingredients in two columns and numbered steps
+func recipePDF(r *FoodRecipe) ([]byte, error) {
+	pdf := newPDF(r.Name)
+	pdfRecipePage(pdf, r)
+	return pdfBytes(pdf)
+}
+
+// pdfRecipePage adds a page with a recipe's card, running onto more pages
+// when it is long
+func pdfRecipePage(pdf *gofpdf.Fpdf, r *FoodRecipe) {
+	pdf.AddPage()
+
+	// The core fonts are Latin-1, so translate the UTF-8 text
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 2*pdfMargin
+
+	pdf.SetFont("Helvetica", "B", 22)
+	pdf.MultiCell(contentWidth, 9, tr(r.Name), "", "L", false)
+
+	if r.Description != "" {
+		pdf.Ln(1)
+		pdf.SetFont("Helvetica", "I", pdfBodySize)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.MultiCell(contentWidth, pdfLineHeight, tr(r.Description), "", "L", false)
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	// Times and servings on a shaded band
+	var facts []string
+	for _, f := range []struct{ label, value string }{
+		{"Prep", r.PrepTime},
+		{"Cook", r.CookTime},
+		{"Total", r.TotalTime},
+		{"Serves", strconv.Itoa(r.Servings)},
+		{"Difficulty", r.Difficulty},
+	} {
+		if f.value != "" && f.value != "0" {
+			facts = append(facts, f.label+": "+f.value)
+		}
+	}
+	pdf.Ln(3)
+	pdf.SetFont("Helvetica", "B", pdfBodySize)
+	pdf.SetFillColor(240, 236, 228)
+	pdf.CellFormat(contentWidth, 8, tr(strings.Join(facts, "    ")), "", 1, "C", true, 0, "")
+	if len(r.Allergens) > 0 {
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(contentWidth, 6, tr("Contains: "+strings.Join(r.Allergens, ", ")), "", 1, "C", false, 0, "")
+	}
+
+	// Ingredients split over two columns
+	pdfHeading(pdf, "Ingredients")
+	pdf.SetFont("Helvetica", "", pdfBodySize)
+	columnWidth := (contentWidth - pdfColumnGap) / 2
+	half := (len(r.Ingredients) + 1) / 2
+	top := pdf.GetY()
+	bottom := top
+	for col, ingredients := range [][]string{r.Ingredients[:half], r.Ingredients[half:]} {
+		pdf.SetXY(pdfMargin+float64(col)*(columnWidth+pdfColumnGap), top)
+		for _, ingredient := range ingredients {
+			pdf.SetX(pdfMargin + float64(col)*(columnWidth+pdfColumnGap))
+			pdf.MultiCell(columnWidth, pdfLineHeight, tr("- "+ingredient), "", "L", false)
+		}
+		bottom = max(bottom, pdf.GetY())
+	}
+	pdf.SetXY(pdfMargin, bottom)
+
+	// Numbered steps with a hanging indent
+	pdfHeading(pdf, "Instructions")
+	const numberWidth = 8.0
+	for i, step := range r.Instructions {
+		pdf.SetFont("Helvetica", "B", pdfBodySize)
+		pdf.CellFormat(numberWidth, pdfLineHeight, fmt.Sprintf("%d.", i+1), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", pdfBodySize)
+		pdf.MultiCell(contentWidth-numberWidth, pdfLineHeight, tr(step), "", "L", false)
+		pdf.Ln(1.5)
+	}
+
+	if len(r.Tips) > 0 {
+		pdfHeading(pdf, "Tips")
+		pdf.SetFont("Helvetica", "", pdfBodySize)
+		for _, tip := range r.Tips {
+			pdf.MultiCell(contentWidth, pdfLineHeight, tr("- "+tip), "", "L", false)
+		}
+	}
+}
+
+// pdfHeading writes a section heading with a rule underneath
+func pdfHeading(pdf *gofpdf.Fpdf, title string) {
+	pdf.Ln(5)
+	pdf.SetFont("Helvetica", "B", pdfHeadingSize)
+	pdf.CellFormat(0, 7, title, "B", 1, "L", false, 0, "")
+	pdf.Ln(2)
+}
+
+// recipePDFHandler serves a generated recipe as a printable PDF card
+func recipePDFHandler(w http.ResponseWriter, r *http.Request) {
+	recipe, ok := savedRecipes.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+
+	if notModified(w, r, contentETag(recipe, "pdf")) {
+		return
+	}
+
+	data, err := recipePDF(recipe)
+	if err != nil {
+		log.Printf("Error rendering recipe PDF: %v", err)
+		writeError(w, http.StatusInternalServerError, "PDF Rendering Failed", err.Error())
+		return
+	}
+
+	writePDF(w, data, recipe.Name, "recipe")
+}
+
+// writePDF answers with a PDF named after title, or fallback when the
+// title has no usable characters
+func writePDF(w http.ResponseWriter, data []byte, title, fallback string) {
+	filename := strings.Trim(unsafeFilenameChars.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if filename == "" {
+		filename = fallback
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
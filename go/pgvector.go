@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Table of the pgvector store, created on first use. The embedding column
+// has no fixed dimension, so changing the embedder needs a reindex rather
+// than a migration
+const pgvectorSchema = `
+CREATE EXTENSION IF NOT EXISTS vector;
+CREATE TABLE IF NOT EXISTS recipe_vectors (
+	id        text PRIMARY KEY,
+	embedding vector NOT NULL,
+	content   text NOT NULL,
+	metadata  jsonb NOT NULL DEFAULT '{}'
+)`
+
+// pgvectorStore is a vectorStore in Postgres with the pgvector extension
+type pgvectorStore struct {
+	pool *pgxpool.Pool
+}
+
+// newPgvectorStore connects to Postgres and creates the table if needed
+func newPgvectorStore(ctx context.Context, connString string) (*pgvectorStore, error) {
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("pgvector: %w", err)
+	}
+	if _, err := pool.Exec(ctx, pgvectorSchema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("pgvector: creating the table: %w", err)
+	}
+	return &pgvectorStore{pool: pool}, nil
+}
+
+// pgvectorLiteral formats a vector as pgvector's text input, e.g. [1,2,3]
+func pgvectorLiteral(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func (s *pgvectorStore) upsert(ctx context.Context, docs ...vectorDoc) error {
+	batch := &pgx.Batch{}
+	for _, doc := range docs {
+		metadata, err := json.Marshal(doc.Metadata)
+		if err != nil {
+			return err
+		}
+		batch.Queue(`INSERT INTO recipe_vectors (id, embedding, content, metadata)
+			VALUES ($1, $2::vector, $3, $4::jsonb)
+			ON CONFLICT (id) DO UPDATE SET embedding = EXCLUDED.embedding, content = EXCLUDED.content, metadata = EXCLUDED.metadata`,
+			doc.ID, pgvectorLiteral(doc.Vector), doc.Content, string(metadata))
+	}
+	if err := s.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("pgvector: %w", err)
+	}
+	return nil
+}
+
+func (s *pgvectorStore) query(ctx context.Context, vector []float32, k int, filter map[string]string) ([]vectorMatch, error) {
+	if filter == nil {
+		filter = map[string]string{}
+	}
+	metadataFilter, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	// <=> is cosine distance, so similarity is one minus it
+	rows, err := s.pool.Query(ctx, `SELECT id, content, metadata, 1 - (embedding <=> $1::vector)
+		FROM recipe_vectors
+		WHERE metadata @> $2::jsonb AND vector_dims(embedding) = vector_dims($1::vector)
+		ORDER BY embedding <=> $1::vector, id
+		LIMIT $3`,
+		pgvectorLiteral(vector), string(metadataFilter), k)
+	if err != nil {
+		return nil, fmt.Errorf("pgvector: %w", err)
+	}
+	matches, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (vectorMatch, error) {
+		var m vectorMatch
+		err := row.Scan(&m.ID, &m.Content, &m.Metadata, &m.Score)
+		return m, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pgvector: %w", err)
+	}
+	return matches, nil
+}
+
+func (s *pgvectorStore) delete(ctx context.Context, ids ...string) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM recipe_vectors WHERE id = ANY($1)`, ids); err != nil {
+		return fmt.Errorf("pgvector: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Pinecone API version the requests are written against
+const pineconeAPIVersion = "2025-01"
+
+// Metadata key Pinecone keeps a document's text under
+const pineconeContentKey = "content"
+
+// pineconeStore is a vectorStore in a hosted Pinecone index, created with
+// the cosine metric and the embedder's dimension
+type pineconeStore struct {
+	host   string
+	apiKey string
+	client *http.Client
+}
+
+// newPineconeStore uses the index served at host, e.g.
+// recipes-abc123.svc.us-east1-gcp.pinecone.io
+func newPineconeStore(host, apiKey string) *pineconeStore {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return &pineconeStore{host: strings.TrimSuffix(host, "/"), apiKey: apiKey, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// call POSTs a request to the index and decodes the answer into out, if
+// not nil
+func (s *pineconeStore) call(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.host+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", s.apiKey)
+	req.Header.Set("X-Pinecone-API-Version", pineconeAPIVersion)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pinecone: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pinecone answered %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("pinecone: %w", err)
+	}
+	return nil
+}
+
+func (s *pineconeStore) upsert(ctx context.Context, docs ...vectorDoc) error {
+	type vector struct {
+		ID       string            `json:"id"`
+		Values   []float32         `json:"values"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	vectors := make([]vector, len(docs))
+	for i, doc := range docs {
+		metadata := map[string]string{pineconeContentKey: doc.Content}
+		for key, value := range doc.Metadata {
+			metadata[key] = value
+		}
+		vectors[i] = vector{ID: doc.ID, Values: doc.Vector, Metadata: metadata}
+	}
+	return s.call(ctx, "/vectors/upsert", map[string]any{"vectors": vectors}, nil)
+}
+
+func (s *pineconeStore) query(ctx context.Context, vector []float32, k int, filter map[string]string) ([]vectorMatch, error) {
+	in := map[string]any{"vector": vector, "topK": k, "includeMetadata": true}
+	if len(filter) > 0 {
+		conditions := make(map[string]any, len(filter))
+		for key, value := range filter {
+			conditions[key] = map[string]string{"$eq": value}
+		}
+		in["filter"] = conditions
+	}
+	var out struct {
+		Matches []struct {
+			ID       string            `json:"id"`
+			Score    float64           `json:"score"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"matches"`
+	}
+	if err := s.call(ctx, "/query", in, &out); err != nil {
+		return nil, err
+	}
+	matches := make([]vectorMatch, len(out.Matches))
+	for i, m := range out.Matches {
+		content := m.Metadata[pineconeContentKey]
+		delete(m.Metadata, pineconeContentKey)
+		matches[i] = vectorMatch{ID: m.ID, Content: content, Metadata: m.Metadata, Score: m.Score}
+	}
+	return matches, nil
+}
+
+func (s *pineconeStore) delete(ctx context.Context, ids ...string) error {
+	return s.call(ctx, "/vectors/delete", map[string]any{"ids": ids}, nil)
+}
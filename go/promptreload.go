@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// promptLoader reloads the prompt directory while the server runs. Genkit
+// can't register a prompt or partial twice, so each reload registers the
+// prompts under a new namespace, with their partials written into them, and
+// lookups move to it once every prompt in use has loaded
+type promptLoader struct {
+	dir string
+
+	mu      sync.Mutex
+	reloads int
+	// Namespace of the prompts in use; empty for those loaded at startup
+	namespace atomic.Pointer[string]
+}
+
+// Prompt directory of the running server, set up at startup
+var prompts = &promptLoader{}
+
+// lookup returns the prompt registered under key in the current load
+func (l *promptLoader) lookup(g *genkit.Genkit, key string) ai.Prompt {
+	if ns := l.namespace.Load(); ns != nil {
+		key = *ns + "/" + key
+	}
+	return genkit.LookupPrompt(g, key)
+}
+
+// Loaded prompts with how many times the directory has been reloaded
+type PromptReload struct {
+	Reloads int      `json:"reloads"`
+	Prompts []string `json:"prompts" jsonschema:"description=Prompts and prompt versions in use"`
+}
+
+// reload reads the prompt directory again. When it can't be read, or the
+// recipe prompt or a version in an experiment fails to load, the previous
+// prompts stay in use
+func (l *promptLoader) reload(g *genkit.Genkit) (*PromptReload, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sources := map[string]string{}
+	partials := map[string]string{}
+	err := filepath.WalkDir(l.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".prompt") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if name, ok := strings.CutPrefix(entry.Name(), "_"); ok {
+			partials[strings.TrimSuffix(name, ".prompt")] = strings.TrimRight(string(data), "\n")
+		} else {
+			sources[entry.Name()] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading the prompt directory: %w", err)
+	}
+
+	// The prompts are loaded from a copy with their partials written in
+	tmp, err := os.MkdirTemp("", "prompts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	namespace := fmt.Sprintf("reload%d", l.reloads+1)
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(sources)) {
+		source, err := inlinePartials(sources[name], partials)
+		if err == nil {
+			path := filepath.Join(tmp, name)
+			if err = os.WriteFile(path, []byte(source), 0o600); err == nil && genkit.LoadPrompt(g, path, namespace) == nil {
+				err = errors.New("it could not be parsed")
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	keys := promptVersions.keys()
+	for _, key := range keys {
+		if genkit.LookupPrompt(g, namespace+"/"+key) == nil {
+			errs = append(errs, fmt.Errorf("prompt %s did not load", key))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	l.reloads++
+	l.namespace.Store(&namespace)
+	return &PromptReload{Reloads: l.reloads, Prompts: keys}, nil
+}
+
+// A partial included in a template, e.g. {{>budget budget}}
+var partialPattern = regexp.MustCompile(`\{\{>\s*([\w-]+)\s*([^}]*)\}\}`)
+
+// inlinePartials writes the partials a template includes into it. A partial
+// given a context is wrapped in a with block for it
+func inlinePartials(source string, partials map[string]string) (string, error) {
+	var missing []string
+	source = partialPattern.ReplaceAllStringFunc(source, func(include string) string {
+		m := partialPattern.FindStringSubmatch(include)
+		partial, ok := partials[m[1]]
+		if !ok {
+			missing = append(missing, m[1])
+			return include
+		}
+		if context := strings.TrimSpace(m[2]); context != "" {
+			return "{{#with " + context + "}}" + partial + "{{/with}}"
+		}
+		return partial
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("partials not found: %s", strings.Join(missing, ", "))
+	}
+	return source, nil
+}
+
+// reloadOnSIGHUP reloads the prompts whenever the process gets SIGHUP
+func (l *promptLoader) reloadOnSIGHUP(g *genkit.Genkit) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := l.reload(g); err != nil {
+				log.Printf("Failed to reload prompts, keeping the previous ones: %v", err)
+				continue
+			}
+			log.Println("Reloaded prompts")
+		}
+	}()
+}
+
+// keys returns the names the prompts in use are registered under: the
+// recipe prompt and every version in an experiment
+func (r *promptRegistry) keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := []string{recipePromptName}
+	for _, prompt := range slices.Sorted(maps.Keys(r.versions)) {
+		for _, v := range r.versions[prompt] {
+			if v.name != defaultPromptVersion {
+				keys = append(keys, prompt+"."+v.name)
+			}
+		}
+	}
+	return keys
+}
+
+// reloadPromptsHandler reloads the prompt directory; the previous prompts
+// stay in use on error
+func reloadPromptsHandler(g *genkit.Genkit) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := prompts.reload(g)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "Invalid Prompts", err.Error())
+			return
+		}
+		log.Printf("Prompts reloaded by %s", requestActor(r.Context()))
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Version name of a prompt's file without a variant, e.g. recipe.prompt
+const defaultPromptVersion = "default"
+
+// How one version of a prompt is doing
+type PromptVersionStats struct {
+	Version      string  `json:"version"`
+	Traffic      int     `json:"traffic" jsonschema:"description=Percentage of clients served this version"`
+	Requests     int64   `json:"requests"`
+	Failures     int64   `json:"failures" jsonschema:"description=Generations that failed\\, e.g. on a model error"`
+	Rejected     int64   `json:"rejected" jsonschema:"description=Generations rejected by the recipe checks\\, e.g. over budget\\, containing a restricted allergen or blocked for safety"`
+	SuccessRate  float64 `json:"successRate"`
+	AvgLatencyMS float64 `json:"avgLatencyMs"`
+}
+
+// The versions of one prompt
+type PromptStats struct {
+	Prompt   string               `json:"prompt"`
+	Versions []PromptVersionStats `json:"versions"`
+}
+
+// promptVersion is one version of a prompt, its traffic share and counters
+type promptVersion struct {
+	name    string
+	traffic int
+
+	requests, failures, rejected int64
+	latency                      time.Duration
+}
+
+// promptRegistry splits each prompt's traffic between its versions: the
+// variant files (recipe.v2.prompt) given a percentage in the config, and
+// the plain file for the rest. Clients stay on one version, so their
+// results can be compared
+type promptRegistry struct {
+	mu       sync.Mutex
+	versions map[string][]*promptVersion
+}
+
+// Prompt versions of the running server, set up at startup. Prompts
+// without an experiment have only their default version
+var promptVersions = &promptRegistry{}
+
+// newPromptRegistry sets up the experiments in traffic, keyed by
+// prompt.version, checking that every version is loaded
+func newPromptRegistry(g *genkit.Genkit, traffic map[string]int) (*promptRegistry, error) {
+	r := &promptRegistry{versions: make(map[string][]*promptVersion)}
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(traffic)) {
+		prompt, version, _ := strings.Cut(key, ".")
+		if genkit.LookupPrompt(g, key) == nil {
+			errs = append(errs, fmt.Errorf("prompt version %s is not loaded", key))
+			continue
+		}
+		if r.versions[prompt] == nil {
+			r.versions[prompt] = []*promptVersion{{name: defaultPromptVersion, traffic: 100}}
+		}
+		r.versions[prompt] = append(r.versions[prompt], &promptVersion{name: version, traffic: traffic[key]})
+		r.versions[prompt][0].traffic -= traffic[key]
+	}
+	return r, errors.Join(errs...)
+}
+
+// choose picks the version of a prompt for the context's request and
+// returns its name and the name the prompt is registered under
+func (r *promptRegistry) choose(ctx context.Context, prompt string) (version, key string) {
+	r.mu.Lock()
+	versions := r.versions[prompt]
+	r.mu.Unlock()
+
+	bucket := clientBucket(ctx, "prompt:"+prompt)
+	for _, v := range versions {
+		if bucket < v.traffic {
+			if v.name == defaultPromptVersion {
+				break
+			}
+			return v.name, prompt + "." + v.name
+		}
+		bucket -= v.traffic
+	}
+	return defaultPromptVersion, prompt
+}
+
+// record counts a generation with a prompt version. Input errors raised
+// after generation mean the recipe checks rejected the output
+func (r *promptRegistry) record(prompt, version string, elapsed time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.versions == nil {
+		r.versions = make(map[string][]*promptVersion)
+	}
+	i := slices.IndexFunc(r.versions[prompt], func(v *promptVersion) bool { return v.name == version })
+	if i < 0 {
+		r.versions[prompt] = append(r.versions[prompt], &promptVersion{name: version, traffic: 100})
+		i = len(r.versions[prompt]) - 1
+	}
+	v := r.versions[prompt][i]
+	v.requests++
+	v.latency += elapsed
+	var ie *inputError
+	var blocked *safetyBlockedError
+	switch {
+	case err == nil:
+	case errors.As(err, &ie), errors.As(err, &blocked):
+		v.rejected++
+	default:
+		v.failures++
+	}
+}
+
+// stats returns the counters of every prompt version
+func (r *promptRegistry) stats() []PromptStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := []PromptStats{}
+	for _, prompt := range slices.Sorted(maps.Keys(r.versions)) {
+		ps := PromptStats{Prompt: prompt}
+		for _, v := range r.versions[prompt] {
+			vs := PromptVersionStats{Version: v.name, Traffic: v.traffic, Requests: v.requests, Failures: v.failures, Rejected: v.rejected}
+			if v.requests > 0 {
+				vs.SuccessRate = float64(v.requests-v.failures-v.rejected) / float64(v.requests)
+				vs.AvgLatencyMS = float64(v.latency.Milliseconds()) / float64(v.requests)
+			}
+			ps.Versions = append(ps.Versions, vs)
+		}
+		stats = append(stats, ps)
+	}
+	return stats
+}
+
+// promptStatsHandler reports the traffic split and results of each prompt
+// version
+func promptStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, promptVersions.stats())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Most clients tracked; idle clients are dropped first
+const maxRateLimitClients = 10000
+
+// A token bucket for one client
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitStore keeps a token bucket per client
+type rateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// Token buckets by account or client IP
+var rateLimits = &rateLimitStore{buckets: make(map[string]*tokenBucket)}
+
+// take spends a token from the client's bucket. It returns the tokens left,
+// or how long until the next token when the bucket is empty
+func (s *rateLimitStore) take(client string, perMinute, burst int) (remaining int, retryAfter time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(perMinute) / 60
+
+	b, found := s.buckets[client]
+	if !found {
+		if len(s.buckets) >= maxRateLimitClients {
+			s.evictLocked(now, rate, float64(burst))
+		}
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		s.buckets[client] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return 0, time.Duration((1 - b.tokens) / rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return int(b.tokens), 0, true
+}
+
+// evictLocked drops clients whose buckets have refilled, or the longest idle
+// client if none have
+func (s *rateLimitStore) evictLocked(now time.Time, rate, burst float64) {
+	var idlest string
+	var idlestLast time.Time
+	for client, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(s.buckets, client)
+			continue
+		}
+		if idlest == "" || b.last.Before(idlestLast) {
+			idlest, idlestLast = client, b.last
+		}
+	}
+	if len(s.buckets) >= maxRateLimitClients {
+		delete(s.buckets, idlest)
+	}
+}
+
+// rateLimitClient identifies who a request counts against: the account it
+// authenticated as if it has one, otherwise its IP address. Credentials are
+// only trusted once requireAuth has checked them, so made-up keys can't get
+// fresh buckets
+func rateLimitClient(r *http.Request) string {
+	if account := requestAccount(r.Context()); account != "anonymous" {
+		return "account:" + account
+	}
+	return "ip:" + clientIP(r)
+}
+
+// allowRequest spends one of a client's requests. Once they are used up it
+// answers 429 with Retry-After and returns false
+func allowRequest(w http.ResponseWriter, r *http.Request, client string) bool {
+	settings := currentSettings()
+	if settings.RateLimit <= 0 || r.Method == http.MethodOptions {
+		return true
+	}
+
+	remaining, retryAfter, ok := rateLimits.take(client, settings.RateLimit, settings.RateLimitBurst)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(settings.RateLimit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !ok {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeError(w, http.StatusTooManyRequests, "Rate Limit Exceeded",
+			fmt.Sprintf("Too many requests, try again in %s", time.Duration(seconds)*time.Second))
+	}
+	return ok
+}
+
+// rateLimited answers 429 with Retry-After once a client has used up its
+// requests, so one busy client can't exhaust the Gemini quota for everyone.
+// On authenticated routes it runs after requireAuth
+func rateLimited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowRequest(w, r, rateLimitClient(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
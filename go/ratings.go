@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Bounds on reviews
+const (
+	minReviewStars  = 1
+	maxReviewStars  = 5
+	maxReviewLength = 2000
+)
+
+// Body of PUT /api/recipe/{id}/review
+type ReviewInput struct {
+	Stars  int    `json:"stars" jsonschema:"description=Rating from 1 to 5 stars,required=true"`
+	Review string `json:"review,omitempty" jsonschema:"description=Optional written review"`
+}
+
+// validate checks the stars and the length of the review
+func (in *ReviewInput) validate() []FieldError {
+	var errs []FieldError
+	if in.Stars < minReviewStars || in.Stars > maxReviewStars {
+		errs = append(errs, FieldError{"stars", fmt.Sprintf("must be between %d and %d", minReviewStars, maxReviewStars)})
+	}
+	if utf8.RuneCountInString(in.Review) > maxReviewLength {
+		errs = append(errs, FieldError{"review", fmt.Sprintf("must be at most %d characters", maxReviewLength)})
+	}
+	return errs
+}
+
+// An account's rating of a recipe. Each account has at most one per
+// recipe; rating again replaces it and moves UpdatedAt
+type Review struct {
+	ID        string    `json:"id"`
+	RecipeID  string    `json:"recipeId"`
+	Account   string    `json:"account"`
+	Stars     int       `json:"stars"`
+	Review    string    `json:"review,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// One page of a recipe's reviews, most recently updated first
+type ReviewList struct {
+	Reviews    []Review `json:"reviews"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+// Average stars of a recipe's reviews
+type RatingSummary struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// newRatingSummary averages count reviews with stars in total, rounded to
+// two decimals. It returns nil without reviews
+func newRatingSummary(count, stars int) *RatingSummary {
+	if count == 0 {
+		return nil
+	}
+	return &RatingSummary{Average: math.Round(float64(stars)/float64(count)*100) / 100, Count: count}
+}
+
+// reviewHandler keeps the caller's rating and review of a stored recipe,
+// replacing the one they left before
+func reviewHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "review recipes")
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := savedRecipes.entry(id); !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+
+	var input ReviewInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	review := Review{
+		RecipeID:  id,
+		Account:   account,
+		Stars:     input.Stars,
+		Review:    strings.TrimSpace(input.Review),
+		UpdatedAt: time.Now(),
+	}
+	if err := savedRecipes.review(r.Context(), &review); err != nil {
+		log.Printf("Failed to save the review of %s by %s: %v", id, account, err)
+		writeError(w, http.StatusInternalServerError, "Review Failed", "The review could not be saved; try again later")
+		return
+	}
+	writeJSON(w, http.StatusOK, review)
+}
+
+// reviewsHandler lists a recipe's reviews, most recently updated first, a
+// page at a time. Each review carries its id, account and times, so
+// moderators can tell who wrote what and when
+func reviewsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := savedRecipes.entry(id); !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+	query := r.URL.Query()
+
+	limit := defaultRecipePageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRecipePageSize {
+			writeError(w, http.StatusBadRequest, "Invalid Limit", fmt.Sprintf("limit must be between 1 and %d", maxRecipePageSize))
+			return
+		}
+		limit = n
+	}
+	var stars int
+	if v := query.Get("stars"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minReviewStars || n > maxReviewStars {
+			writeError(w, http.StatusBadRequest, "Invalid Stars", fmt.Sprintf("stars must be between %d and %d", minReviewStars, maxReviewStars))
+			return
+		}
+		stars = n
+	}
+	var after time.Time
+	var afterID string
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		if after, afterID, err = decodeCursor(cursor); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Cursor", "The cursor is malformed; start again without one")
+			return
+		}
+	}
+
+	list := ReviewList{Reviews: []Review{}}
+	for _, review := range savedRecipes.reviews(id) {
+		// Skip everything up to and including the cursor position
+		if afterID != "" && (review.UpdatedAt.After(after) ||
+			review.UpdatedAt.Equal(after) && review.ID >= afterID) {
+			continue
+		}
+		if stars != 0 && review.Stars != stars {
+			continue
+		}
+		if len(list.Reviews) == limit {
+			last := list.Reviews[limit-1]
+			list.NextCursor = encodeCursor(last.UpdatedAt, last.ID)
+			break
+		}
+		list.Reviews = append(list.Reviews, review)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for food recipe requests
+type FoodInput struct {
+	FoodName            string   `json:"foodName" jsonschema:"description=Name of the food to cook,required=true"`
+	DietaryRestrictions string   `json:"dietaryRestrictions,omitempty" jsonschema:"description=Any dietary restrictions (vegetarian\\, vegan\\, gluten-free\\, etc.)"`
+	AvoidIngredients    []string `json:"avoidIngredients,omitempty" jsonschema:"description=Ingredients to leave out (e.g. cilantro\\, mushrooms)"`
+	Difficulty          string   `json:"difficulty,omitempty" jsonschema:"description=Preferred difficulty level (easy\\, medium\\, hard)"`
+	ServingSize         int      `json:"servingSize,omitempty" jsonschema:"description=Number of servings needed"`
+	IncludePairings     bool     `json:"includePairings,omitempty" jsonschema:"description=Also suggest wine\\, beer and non-alcoholic pairings"`
+	Language            string   `json:"language,omitempty" jsonschema:"description=BCP 47 language tag for the recipe text and measurement conventions (e.g. fr-FR)"`
+	KidFriendly         bool     `json:"kidFriendly,omitempty" jsonschema:"description=Simplify steps for children and flag steps that need adult supervision"`
+	MaxBudget           float64  `json:"maxBudget,omitempty" jsonschema:"description=Maximum total ingredient cost"`
+	Currency            string   `json:"currency,omitempty" jsonschema:"description=ISO 4217 currency code for maxBudget (default USD)"`
+	BakingMode          bool     `json:"bakingMode,omitempty" jsonschema:"description=Use gram/ml measurements and include oven\\, proofing and hydration details"`
+	IncludeImage        bool     `json:"includeImage,omitempty" jsonschema:"description=Also generate an image of the finished dish"`
+	CallbackURL         string   `json:"callbackUrl,omitempty" jsonschema:"description=Answer 202 right away and POST the finished recipe to this URL"`
+	Model               string   `json:"model,omitempty" jsonschema:"description=Model to generate with\\, one of the allowed models (e.g. gemini-2.0-flash for speed); the server default when unset"`
+	Grounded            bool     `json:"grounded,omitempty" jsonschema:"description=Search the web for authentic regional references and cite them in sources"`
+	AllowDuplicate      bool     `json:"allowDuplicate,omitempty" jsonschema:"description=Keep the new recipe even when it nearly matches one you generated before"`
+
+	// Override the server's generation parameters for this request
+	GenerationParams
+}
+
+// Define output schema for recipe response
+type FoodRecipe struct {
+	ID             string          `json:"id,omitempty" jsonschema:"-"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	Difficulty     string          `json:"difficulty"`
+	PrepTime       string          `json:"prepTime"`
+	CookTime       string          `json:"cookTime"`
+	TotalTime      string          `json:"totalTime"`
+	Servings       int             `json:"servings"`
+	Cuisine        string          `json:"cuisine,omitempty" jsonschema:"description=Cuisine the dish belongs to (e.g. Italian)"`
+	Tags           []string        `json:"tags,omitempty" jsonschema:"description=A few short lowercase tags (e.g. vegetarian\\, one-pot\\, weeknight)"`
+	Ingredients    []string        `json:"ingredients"`
+	Instructions   []string        `json:"instructions"`
+	Tips           []string        `json:"tips,omitempty"`
+	Nutrition      *NutritionFacts `json:"nutrition,omitempty"`
+	Pairings       *Pairings       `json:"pairings,omitempty"`
+	KidSteps       []KidStep       `json:"kidSteps,omitempty"`
+	EstimatedCost  float64         `json:"estimatedCost,omitempty"`
+	CostPerServing float64         `json:"costPerServing,omitempty"`
+	Currency       string          `json:"currency,omitempty"`
+	BakingDetails  *BakingDetails  `json:"bakingDetails,omitempty"`
+	Image          *DishImage      `json:"image,omitempty"`
+	Sources        []Source        `json:"sources,omitempty" jsonschema:"description=Pages from the searchRecipes results the recipe draws on"`
+
+	// Set from the ingredients by applyAllergens, not by the model
+	Allergens           []string              `json:"allergens" jsonschema:"-"`
+	IngredientAllergens []IngredientAllergens `json:"ingredientAllergens,omitempty" jsonschema:"-"`
+	// Set by the classification step after generation
+	Categories *RecipeCategories `json:"categories,omitempty" jsonschema:"-"`
+
+	// Model, prompt version and seed that generated the recipe, and how
+	// well the evaluators scored it
+	Model         string         `json:"model,omitempty" jsonschema:"-"`
+	PromptVersion string         `json:"promptVersion,omitempty" jsonschema:"-"`
+	Seed          *int           `json:"seed,omitempty" jsonschema:"-"`
+	Quality       *RecipeQuality `json:"quality,omitempty" jsonschema:"-"`
+	// Set when the recipe was served from the semantic cache
+	Cached bool `json:"cached,omitempty" jsonschema:"-"`
+	// Set when a new recipe nearly matched one the account generated
+	// before, which is returned instead: the id of that recipe
+	DuplicateOf string `json:"duplicateOf,omitempty" jsonschema:"-"`
+	// How many users have favorited the stored recipe
+	FavoriteCount int `json:"favoriteCount,omitempty" jsonschema:"-"`
+	// Average stars of the stored recipe's reviews, when it has any
+	Rating *RatingSummary `json:"rating,omitempty" jsonschema:"-"`
+	// Set on refined recipes: the version refined and the instructions
+	// that led here, oldest first
+	RefinedFrom string           `json:"refinedFrom,omitempty" jsonschema:"-"`
+	Refinements []RefinementTurn `json:"refinements,omitempty" jsonschema:"-"`
+
+	// Related operations, set when the recipe is stored
+	Links map[string]Link `json:"_links,omitempty" jsonschema:"-"`
+}
+
+// recipeRequest holds a validated FoodInput with defaults applied
+type recipeRequest struct {
+	FoodName            string
+	Difficulty          string
+	ServingSize         int
+	DietaryRestrictions string
+	AvoidIngredients    []string
+	IncludePairings     bool
+	Language            string
+	KidFriendly         bool
+	Budget              *recipeBudget
+	BakingMode          bool
+	IncludeImage        bool
+	Model               string
+	Grounded            bool
+	AllowDuplicate      bool
+	Params              GenerationParams
+
+	// The request as the client sent it, for the quality evaluators
+	Input *FoodInput
+}
+
+// newRecipeRequest validates the input and fills in default values
+func newRecipeRequest(input *FoodInput) (*recipeRequest, error) {
+	if input == nil || strings.TrimSpace(input.FoodName) == "" {
+		return nil, fmt.Errorf("food name is required")
+	}
+
+	lang, err := parseLanguage(input.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	budget, err := newRecipeBudget(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := input.GenerationParams.err(); err != nil {
+		return nil, err
+	}
+
+	var model string
+	if input.Model != "" {
+		var ok bool
+		if model, ok = resolveModel(input.Model); !ok {
+			return nil, newInputError("model %q is not available", input.Model)
+		}
+	}
+
+	if input.Grounded && searchAPIKey == "" {
+		return nil, newInputError("grounded: web search is not configured")
+	}
+
+	req := &recipeRequest{
+		FoodName:            input.FoodName,
+		Difficulty:          input.Difficulty,
+		ServingSize:         input.ServingSize,
+		DietaryRestrictions: input.DietaryRestrictions,
+		AvoidIngredients:    input.AvoidIngredients,
+		IncludePairings:     input.IncludePairings,
+		Language:            languageName(lang),
+		KidFriendly:         input.KidFriendly,
+		Budget:              budget,
+		BakingMode:          input.BakingMode,
+		IncludeImage:        input.IncludeImage,
+		Model:               model,
+		Grounded:            input.Grounded,
+		AllowDuplicate:      input.AllowDuplicate,
+		Params:              input.GenerationParams,
+		Input:               input,
+	}
+	if req.Difficulty == "" {
+		req.Difficulty = "medium"
+	}
+	if req.ServingSize == 0 {
+		req.ServingSize = 4
+	}
+	if req.DietaryRestrictions == "" {
+		req.DietaryRestrictions = "none"
+	}
+	return req, nil
+}
+
+// Name of the recipe prompt, loaded from recipe.prompt in the prompt directory
+const recipePromptName = "recipe"
+
+// promptInput returns the variables of the recipe prompt template
+func (req *recipeRequest) promptInput() map[string]any {
+	input := map[string]any{
+		"foodName":            req.FoodName,
+		"difficulty":          req.Difficulty,
+		"servings":            req.ServingSize,
+		"dietaryRestrictions": req.DietaryRestrictions,
+		"language":            req.Language,
+		"kidFriendly":         req.KidFriendly,
+		"bakingMode":          req.BakingMode,
+		"grounded":            req.Grounded,
+	}
+	if len(req.AvoidIngredients) > 0 {
+		input["avoidIngredients"] = strings.Join(req.AvoidIngredients, ", ")
+	}
+	if req.Budget != nil {
+		input["budget"] = req.Budget.promptInput()
+	}
+	return input
+}
+
+// messages renders the recipe prompt registered under name for the request
+func (req *recipeRequest) messages(ctx context.Context, g *genkit.Genkit, name string) ([]*ai.Message, error) {
+	prompt := prompts.lookup(g, name)
+	if prompt == nil {
+		return nil, fmt.Errorf("prompt %q is not loaded", name)
+	}
+	rendered, err := prompt.Render(ctx, req.promptInput())
+	if err != nil {
+		return nil, fmt.Errorf("failed to render the recipe prompt: %w", err)
+	}
+	return rendered.Messages, nil
+}
+
+// finalize fills in fields the model may have left empty and applies the
+// checks of the requested modes
+func (req *recipeRequest) finalize(recipe *FoodRecipe) error {
+	// Ensure the recipe name matches the input
+	if recipe.Name == "" {
+		recipe.Name = req.FoodName
+	}
+
+	// Set servings if not provided by AI
+	if recipe.Servings == 0 {
+		recipe.Servings = req.ServingSize
+	}
+
+	// Tags are matched exactly when listing, so keep them in one form
+	recipe.Tags = normalizeTags(recipe.Tags)
+
+	// Only kid-friendly recipes carry kid steps, and their safety flags are
+	// enforced in code rather than trusted to the model
+	if req.KidFriendly {
+		enforceKidSafety(recipe.KidSteps)
+	} else {
+		recipe.KidSteps = nil
+	}
+
+	// Baking details are only kept in baking mode
+	if req.BakingMode && recipe.BakingDetails != nil {
+		recipe.BakingDetails.normalize()
+	} else if !req.BakingMode {
+		recipe.BakingDetails = nil
+	}
+
+	// Allergens are detected from the ingredients in code, and recipes
+	// containing a restricted allergen are rejected
+	if err := applyAllergens(recipe, req.DietaryRestrictions); err != nil {
+		return err
+	}
+
+	// Cost fields are only meaningful when a budget was given
+	if req.Budget != nil {
+		return req.Budget.apply(recipe)
+	}
+	recipe.EstimatedCost, recipe.CostPerServing, recipe.Currency = 0, 0, ""
+	return nil
+}
+
+// generateRecipe runs the model call shared by the recipe flows
+func generateRecipe(ctx context.Context, g *genkit.Genkit, req *recipeRequest, opts ...ai.GenerateOption) (*FoodRecipe, error) {
+	// The runtime model answers with the requested model, if any
+	ctx, choice := withModelChoice(ctx, req.Model)
+	if req.IncludeImage && !featureEnabled(ctx, featureDishImages) {
+		return nil, newInputError("includeImage: dish images are not available")
+	}
+
+	// Serve the recipe made for an earlier request that means the same
+	// and was made the same way
+	version, promptKey := promptVersions.choose(ctx, recipePromptName)
+	cached, cacheMiss := recipeCache.lookup(ctx, req, version)
+	if cached != nil {
+		return cached, nil
+	}
+
+	// Render the dish image alongside the recipe when requested
+	var imageID string
+	var imageDone <-chan struct{}
+	imageUsed := false
+	if req.IncludeImage {
+		imageID, imageDone = dishImages.start(ctx, g, req.FoodName)
+		// Stop it when the new recipe isn't returned: on an error, or when
+		// the account's earlier recipe is returned instead
+		defer func() {
+			if !imageUsed {
+				dishImages.cancel(imageID)
+			}
+		}()
+	}
+
+	// Generate structured recipe data - Genkit Model Calling, with the
+	// prompt version the client is assigned to
+	messages, err := req.messages(ctx, g, promptKey)
+	if err != nil {
+		return nil, err
+	}
+	tools := []ai.ToolRef{ai.ToolName(nutritionToolName), ai.ToolName(unitsToolName)}
+	var sources *groundingSources
+	if req.Grounded {
+		ctx, sources = withGrounding(ctx)
+		tools = append(tools, ai.ToolName(searchToolName))
+	}
+	opts = append([]ai.GenerateOption{ai.WithMessages(messages...), ai.WithTools(tools...)}, opts...)
+	if cfg := req.Params.config(); cfg != nil {
+		opts = append(opts, ai.WithConfig(cfg))
+	}
+	start := time.Now()
+	recipe, _, err := genkit.GenerateData[FoodRecipe](ctx, g, opts...)
+	if err != nil {
+		err = fmt.Errorf("failed to generate recipe for %s: %w", req.FoodName, err)
+	} else {
+		err = req.finalize(recipe)
+	}
+	promptVersions.record(recipePromptName, version, time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	recipe.Model, recipe.PromptVersion, recipe.Seed = choice.modelUsed(), version, req.Params.seed()
+
+	// Only cite pages the search actually returned
+	if sources != nil {
+		recipe.Sources = sources.cite(recipe.Sources)
+	} else {
+		recipe.Sources = nil
+	}
+
+	// Answer with the account's earlier recipe rather than storing a
+	// near-identical copy of it
+	if account := requestAccount(ctx); account != "anonymous" && !req.AllowDuplicate {
+		if existing, ok := findDuplicate(account, recipe); ok {
+			existing.item.DuplicateOf = existing.id
+			return &existing.item, nil
+		}
+	}
+
+	// Tag the recipe's cuisine, course, diet and occasion
+	applyCategories(ctx, g, recipe)
+
+	// Merge beverage pairings into the recipe when requested
+	if req.IncludePairings {
+		recipe.Pairings, err = generatePairings(ctx, g, &PairingInput{
+			Dish:        recipe.Name,
+			Description: recipe.Description,
+			Ingredients: recipe.Ingredients,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Give a slow image a little longer, then fall back to a pending placeholder
+	if req.IncludeImage {
+		select {
+		case <-imageDone:
+		case <-time.After(dishImageWait):
+		case <-ctx.Done():
+		}
+		recipe.Image = dishImages.describe(imageID)
+	}
+
+	// Score the recipe and count it in the quality metrics
+	recipe.Quality = evaluateRecipe(ctx, g, req.Input, recipe)
+	recipeQuality.record(recipe)
+
+	// Keep the recipe so follow-up endpoints can refer to it by id
+	if err := savedRecipes.save(ctx, recipe, req.Input); err != nil {
+		return nil, err
+	}
+	recipeVectors.add(ctx, recipe)
+	recipeCache.add(ctx, cacheMiss, recipe)
+
+	imageUsed = true
+	return recipe, nil
+}
+
+// Define the food recipe generator flow
+func defineFoodRecipeFlow(g *genkit.Genkit) *core.Flow[*FoodInput, *FoodRecipe, struct{}] {
+	return genkit.DefineFlow(g, "foodRecipeFlow", func(ctx context.Context, input *FoodInput) (*FoodRecipe, error) {
+		req, err := newRecipeRequest(withProfile(ctx, input))
+		if err != nil {
+			return nil, err
+		}
+		return generateRecipe(ctx, g, req)
+	})
+}
+
+// Define the streaming variant of the recipe flow; each stream chunk is
+// the recipe as far as the model has written it, so ingredients arrive one
+// by one and then the steps
+func defineFoodRecipeStreamFlow(g *genkit.Genkit) *core.Flow[*FoodInput, *FoodRecipe, *FoodRecipe] {
+	return genkit.DefineStreamingFlow(g, "foodRecipeStreamFlow", func(ctx context.Context, input *FoodInput, cb core.StreamCallback[*FoodRecipe]) (*FoodRecipe, error) {
+		req, err := newRecipeRequest(withProfile(ctx, input))
+		if err != nil {
+			return nil, err
+		}
+
+		var opts []ai.GenerateOption
+		if cb != nil {
+			var streamer recipeStreamer
+			opts = append(opts, ai.WithStreaming(func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+				if partial, ok := streamer.add(chunk.Text()); ok {
+					return cb(ctx, partial)
+				}
+				return nil
+			}))
+		}
+		return generateRecipe(ctx, g, req, opts...)
+	})
+}
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// recipeStore keeps recipes so follow-up endpoints can fetch them by id.
+// The memory store forgets them after a day; the SQL stores keep every
+// recipe in SQLite or Postgres across restarts. Each account can favorite
+// and review recipes, and recipes are read with their favorite count and
+// rating. Read errors are logged and reported as a missing recipe
+type recipeStore interface {
+	// save stamps the recipe with a new id and keeps it, with the input it
+	// was generated from when there is one and the account that made it
+	save(ctx context.Context, recipe *FoodRecipe, input *FoodInput) error
+	// get returns the recipe with the given id
+	get(id string) (*FoodRecipe, bool)
+	// entry returns the recipe with the given id and when it was saved
+	entry(id string) (storedItem[FoodRecipe], bool)
+	// list returns the recipes, newest first with ties broken by id
+	list() []storedItem[FoodRecipe]
+	// page returns up to limit recipes that pass a filter, in the order of
+	// list, starting after the recipe at a cursor position when afterID is
+	// set
+	page(filter *recipeFilter, after time.Time, afterID string, limit int) []storedItem[FoodRecipe]
+	// delete removes the recipe with the given id, reporting whether there
+	// was one
+	delete(ctx context.Context, id string) (bool, error)
+	// setFavorite adds a recipe to an account's favorites or removes it
+	setFavorite(ctx context.Context, account, id string, favorite bool) error
+	// favorites returns the recipes an account has favorited, most recently
+	// favorited first
+	favorites(account string) []storedItem[FoodRecipe]
+	// review keeps an account's review of a recipe, replacing the one it
+	// left before, and fills in its id and creation time
+	review(ctx context.Context, review *Review) error
+	// reviews returns the reviews of a recipe, most recently updated first
+	// with ties broken by id
+	reviews(id string) []Review
+	// history returns the recipes an account generated with the requests
+	// they were generated from, newest first with ties broken by id
+	history(account string) []historyItem
+	// searchText returns the recipes whose name, ingredients or
+	// instructions contain every word of a query, best match first
+	searchText(query string) []storedItem[FoodRecipe]
+	// edit replaces the content of the recipe with the recipe's id, keeping
+	// each version it had, and reports whether there was one. The edit is
+	// made to the recipe's version with the given number, counting the
+	// unedited recipe as 1; errRecipeChanged means there is a later one
+	edit(ctx context.Context, recipe *FoodRecipe, note string, version int) (bool, error)
+	// versions returns the versions of a recipe, oldest first, or nothing
+	// if it was never edited
+	versions(id string) []recipeVersion
+}
+
+// Recipes generated, imported, refined and scaled. Set from the config at
+// startup
+var savedRecipes recipeStore = newMemoryRecipeStore()
+
+// newRecipeStore opens the configured recipe store backend. url is the
+// SQLite file or the Postgres connection string
+func newRecipeStore(ctx context.Context, backend, url string) (recipeStore, error) {
+	switch backend {
+	case "memory":
+		return newMemoryRecipeStore(), nil
+	case "sqlite":
+		return openSQLRecipeStore(ctx, "sqlite3", cmp.Or(url, defaultRecipeDB), sqliteRecipeSchema)
+	case "postgres":
+		return openSQLRecipeStore(ctx, "pgx", url, postgresRecipeSchema)
+	}
+	return nil, fmt.Errorf("unknown recipe store %q", backend)
+}
+
+// memoryRecipeStore keeps the recent recipes, their favorites and reviews
+// in memory
+type memoryRecipeStore struct {
+	recent *recentStore[FoodRecipe]
+
+	mu sync.Mutex
+	// When each account favorited each recipe, by account and recipe id
+	favorited map[string]map[string]time.Time
+	// Reviews by recipe id and account
+	reviewed map[string]map[string]Review
+	// Requests recipes were generated from, by recipe id
+	inputs map[string]FoodInput
+	// Versions of edited recipes, by recipe id
+	edits map[string][]recipeVersion
+}
+
+// newMemoryRecipeStore creates an empty in-memory recipe store
+func newMemoryRecipeStore() *memoryRecipeStore {
+	return &memoryRecipeStore{
+		recent:    newRecentStore[FoodRecipe](recentRecipeTTL, maxRecentRecipes),
+		favorited: make(map[string]map[string]time.Time),
+		reviewed:  make(map[string]map[string]Review),
+		inputs:    make(map[string]FoodInput),
+		edits:     make(map[string][]recipeVersion),
+	}
+}
+
+func (s *memoryRecipeStore) save(ctx context.Context, recipe *FoodRecipe, input *FoodInput) error {
+	id := s.recent.saveOwned(recipe, requestAccount(ctx))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Forget the requests and versions of recipes that have expired or
+	// been evicted
+	for recipeID := range s.inputs {
+		if _, ok := s.recent.entry(recipeID); !ok {
+			delete(s.inputs, recipeID)
+		}
+	}
+	for recipeID := range s.edits {
+		if _, ok := s.recent.entry(recipeID); !ok {
+			delete(s.edits, recipeID)
+		}
+	}
+	if input != nil {
+		s.inputs[id] = *input
+	}
+	return nil
+}
+
+func (s *memoryRecipeStore) get(id string) (*FoodRecipe, bool) {
+	stored, ok := s.entry(id)
+	if !ok {
+		return nil, false
+	}
+	return &stored.item, true
+}
+
+func (s *memoryRecipeStore) entry(id string) (storedItem[FoodRecipe], bool) {
+	stored, ok := s.recent.entry(id)
+	if ok {
+		s.count(&stored)
+	}
+	return stored, ok
+}
+
+func (s *memoryRecipeStore) list() []storedItem[FoodRecipe] {
+	items := s.recent.list()
+	for i := range items {
+		s.count(&items[i])
+	}
+	return items
+}
+
+func (s *memoryRecipeStore) delete(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	for _, ids := range s.favorited {
+		delete(ids, id)
+	}
+	delete(s.reviewed, id)
+	delete(s.inputs, id)
+	delete(s.edits, id)
+	s.mu.Unlock()
+	return s.recent.delete(id), nil
+}
+
+func (s *memoryRecipeStore) setFavorite(ctx context.Context, account, id string, favorite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids, ok := s.favorited[account]
+	if !ok {
+		ids = make(map[string]time.Time)
+		s.favorited[account] = ids
+	}
+	if !favorite {
+		delete(ids, id)
+	} else if _, ok := ids[id]; !ok {
+		ids[id] = time.Now()
+	}
+	return nil
+}
+
+func (s *memoryRecipeStore) favorites(account string) []storedItem[FoodRecipe] {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.favorited[account]))
+	added := make(map[string]time.Time, len(s.favorited[account]))
+	for id, at := range s.favorited[account] {
+		ids = append(ids, id)
+		added[id] = at
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(ids, func(a, b string) int { return added[b].Compare(added[a]) })
+	items := []storedItem[FoodRecipe]{}
+	for _, id := range ids {
+		// Recipes that have expired are left out
+		if stored, ok := s.entry(id); ok {
+			items = append(items, stored)
+		}
+	}
+	return items
+}
+
+func (s *memoryRecipeStore) review(ctx context.Context, review *Review) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reviews, ok := s.reviewed[review.RecipeID]
+	if !ok {
+		reviews = make(map[string]Review)
+		s.reviewed[review.RecipeID] = reviews
+	}
+	review.ID, review.CreatedAt = newID(), review.UpdatedAt
+	if previous, ok := reviews[review.Account]; ok {
+		review.ID, review.CreatedAt = previous.ID, previous.CreatedAt
+	}
+	reviews[review.Account] = *review
+	return nil
+}
+
+func (s *memoryRecipeStore) reviews(id string) []Review {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reviews := make([]Review, 0, len(s.reviewed[id]))
+	for _, review := range s.reviewed[id] {
+		reviews = append(reviews, review)
+	}
+	slices.SortFunc(reviews, func(a, b Review) int {
+		if c := b.UpdatedAt.Compare(a.UpdatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	return reviews
+}
+
+func (s *memoryRecipeStore) history(account string) []historyItem {
+	items := []historyItem{}
+	for _, stored := range s.list() {
+		if stored.owner != account {
+			continue
+		}
+		s.mu.Lock()
+		input, ok := s.inputs[stored.id]
+		s.mu.Unlock()
+		if ok {
+			items = append(items, historyItem{stored, input})
+		}
+	}
+	return items
+}
+
+// count sets how many accounts have favorited a stored recipe and its
+// rating
+func (s *memoryRecipeStore) count(stored *storedItem[FoodRecipe]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored.item.FavoriteCount = 0
+	for _, ids := range s.favorited {
+		if _, ok := ids[stored.id]; ok {
+			stored.item.FavoriteCount++
+		}
+	}
+	stars := 0
+	for _, review := range s.reviewed[stored.id] {
+		stars += review.Stars
+	}
+	stored.item.Rating = newRatingSummary(len(s.reviewed[stored.id]), stars)
+}
+
+// Tables of the SQL recipe stores, which also keep user profiles, pantries,
+// shopping lists, meal calendars, collections and the versions of edited
+// recipes. The recipe and its input are kept as JSON, with the columns
+// recipes are looked up and sorted by alongside. SQLite keeps the words of
+// each recipe in a full-text table filled by triggers; Postgres indexes
+// them with a text search index
+const (
+	sqliteRecipeSchema = `
+CREATE TABLE IF NOT EXISTS recipes (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	recipe TEXT NOT NULL,
+	input TEXT,
+	model TEXT NOT NULL DEFAULT '',
+	prompt_version TEXT NOT NULL DEFAULT '',
+	owner TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS recipes_created_at ON recipes (created_at DESC, id DESC);
+CREATE TABLE IF NOT EXISTS favorites (
+	account TEXT NOT NULL,
+	recipe_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (account, recipe_id)
+);
+CREATE INDEX IF NOT EXISTS favorites_recipe_id ON favorites (recipe_id);
+CREATE TABLE IF NOT EXISTS reviews (
+	id TEXT PRIMARY KEY,
+	recipe_id TEXT NOT NULL,
+	account TEXT NOT NULL,
+	stars INTEGER NOT NULL,
+	review TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	UNIQUE (recipe_id, account)
+);
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	email TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL DEFAULT '',
+	preferences TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+	id TEXT PRIMARY KEY,
+	hash TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP,
+	last_used TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS pantry_items (
+	id TEXT PRIMARY KEY,
+	account TEXT NOT NULL,
+	name TEXT NOT NULL,
+	quantity REAL NOT NULL DEFAULT 0,
+	unit TEXT NOT NULL DEFAULT '',
+	expires_on TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pantry_items_account ON pantry_items (account);
+CREATE TABLE IF NOT EXISTS shopping_lists (
+	id TEXT PRIMARY KEY,
+	account TEXT NOT NULL,
+	name TEXT NOT NULL,
+	list TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS shopping_lists_account ON shopping_lists (account, updated_at DESC);
+CREATE TABLE IF NOT EXISTS calendar_meals (
+	account TEXT NOT NULL,
+	day TEXT NOT NULL,
+	meal TEXT NOT NULL,
+	title TEXT NOT NULL,
+	recipe TEXT NOT NULL,
+	plan_id TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (account, day, meal)
+);
+CREATE TABLE IF NOT EXISTS collections (
+	id TEXT PRIMARY KEY,
+	account TEXT NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	recipe_ids TEXT NOT NULL,
+	cover_recipe_id TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS collections_account ON collections (account, updated_at DESC);
+CREATE TABLE IF NOT EXISTS recipe_versions (
+	recipe_id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	recipe TEXT NOT NULL,
+	source TEXT NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	account TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (recipe_id, version)
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS recipe_text USING fts4(id, name, ingredients, instructions, notindexed=id, tokenize=porter);
+CREATE TRIGGER IF NOT EXISTS recipes_text_insert AFTER INSERT ON recipes BEGIN
+	INSERT INTO recipe_text (id, name, ingredients, instructions) VALUES (new.id, new.name,
+		(SELECT group_concat(value, ' ') FROM json_each(new.recipe, '$.ingredients')),
+		(SELECT group_concat(value, ' ') FROM json_each(new.recipe, '$.instructions')));
+END;
+CREATE TRIGGER IF NOT EXISTS recipes_text_update AFTER UPDATE OF name, recipe ON recipes BEGIN
+	DELETE FROM recipe_text WHERE id = old.id;
+	INSERT INTO recipe_text (id, name, ingredients, instructions) VALUES (new.id, new.name,
+		(SELECT group_concat(value, ' ') FROM json_each(new.recipe, '$.ingredients')),
+		(SELECT group_concat(value, ' ') FROM json_each(new.recipe, '$.instructions')));
+END;
+CREATE TRIGGER IF NOT EXISTS recipes_text_delete AFTER DELETE ON recipes BEGIN
+	DELETE FROM recipe_text WHERE id = old.id;
+END;
+INSERT INTO recipe_text (id, name, ingredients, instructions)
+	SELECT id, name,
+		(SELECT group_concat(value, ' ') FROM json_each(recipe, '$.ingredients')),
+		(SELECT group_concat(value, ' ') FROM json_each(recipe, '$.instructions'))
+	FROM recipes WHERE id NOT IN (SELECT id FROM recipe_text);`
+
+	postgresRecipeSchema = `
+CREATE TABLE IF NOT EXISTS recipes (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	recipe JSONB NOT NULL,
+	input JSONB,
+	model TEXT NOT NULL DEFAULT '',
+	prompt_version TEXT NOT NULL DEFAULT '',
+	owner TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS recipes_created_at ON recipes (created_at DESC, id DESC);
+CREATE TABLE IF NOT EXISTS favorites (
+	account TEXT NOT NULL,
+	recipe_id TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (account, recipe_id)
+);
+CREATE INDEX IF NOT EXISTS favorites_recipe_id ON favorites (recipe_id);
+CREATE TABLE IF NOT EXISTS reviews (
+	id TEXT PRIMARY KEY,
+	recipe_id TEXT NOT NULL,
+	account TEXT NOT NULL,
+	stars INTEGER NOT NULL,
+	review TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (recipe_id, account)
+);
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	email TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL DEFAULT '',
+	preferences JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+	id TEXT PRIMARY KEY,
+	hash TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ,
+	last_used TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS pantry_items (
+	id TEXT PRIMARY KEY,
+	account TEXT NOT NULL,
+	name TEXT NOT NULL,
+	quantity DOUBLE PRECISION NOT NULL DEFAULT 0,
+	unit TEXT NOT NULL DEFAULT '',
+	expires_on TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pantry_items_account ON pantry_items (account);
+CREATE TABLE IF NOT EXISTS shopping_lists (
+	id TEXT PRIMARY KEY,
+	account TEXT NOT NULL,
+	name TEXT NOT NULL,
+	list JSONB NOT NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS shopping_lists_account ON shopping_lists (account, updated_at DESC);
+CREATE TABLE IF NOT EXISTS calendar_meals (
+	account TEXT NOT NULL,
+	day TEXT NOT NULL,
+	meal TEXT NOT NULL,
+	title TEXT NOT NULL,
+	recipe JSONB NOT NULL,
+	plan_id TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (account, day, meal)
+);
+CREATE TABLE IF NOT EXISTS collections (
+	id TEXT PRIMARY KEY,
+	account TEXT NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	recipe_ids JSONB NOT NULL,
+	cover_recipe_id TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS collections_account ON collections (account, updated_at DESC);
+CREATE TABLE IF NOT EXISTS recipe_versions (
+	recipe_id TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	recipe JSONB NOT NULL,
+	source TEXT NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	account TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (recipe_id, version)
+);
+CREATE INDEX IF NOT EXISTS recipes_text ON recipes USING GIN ((` + postgresRecipeText + `));`
+)
+
+// SQLite file recipes are kept in when none is configured
+const defaultRecipeDB = "recipes.db"
+
+// Columns scanRecipe reads: the recipe's id, JSON, creation time, owner,
+// favorite count, review count and total stars
+const recipeColumns = `recipes.id, recipes.recipe, recipes.created_at, recipes.owner,
+	(SELECT COUNT(*) FROM favorites WHERE favorites.recipe_id = recipes.id),
+	(SELECT COUNT(*) FROM reviews WHERE reviews.recipe_id = recipes.id),
+	(SELECT COALESCE(SUM(stars), 0) FROM reviews WHERE reviews.recipe_id = recipes.id)`
+
+// How long a single query may take
+const recipeQueryTimeout = 5 * time.Second
+
+// sqlRecipeStore keeps recipes in a SQL database. Queries use $n
+// placeholders, which SQLite and Postgres both accept as long as they are
+// numbered in the order they appear, since SQLite binds them by position
+type sqlRecipeStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// openSQLRecipeStore connects to the database and creates the recipes table
+// if it doesn't exist yet
+func openSQLRecipeStore(ctx context.Context, driver, url, schema string) (*sqlRecipeStore, error) {
+	db, err := sql.Open(driver, url)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", driver, err)
+	}
+	if driver == "sqlite3" {
+		// SQLite allows one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: creating the recipes table: %w", driver, err)
+	}
+	return &sqlRecipeStore{db: db, driver: driver}, nil
+}
+
+func (s *sqlRecipeStore) save(ctx context.Context, recipe *FoodRecipe, input *FoodInput) error {
+	*recipe = recipe.withID(newID())
+	data, err := json.Marshal(recipe)
+	if err != nil {
+		return err
+	}
+	var inputJSON any
+	if input != nil {
+		b, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		inputJSON = string(b)
+	}
+
+	owner := requestAccount(ctx)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recipeQueryTimeout)
+	defer cancel()
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO recipes (id, name, recipe, input, model, prompt_version, owner, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		recipe.ID, recipe.Name, string(data), inputJSON, recipe.Model, recipe.PromptVersion, owner, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("saving recipe %s: %w", recipe.ID, err)
+	}
+	return nil
+}
+
+func (s *sqlRecipeStore) get(id string) (*FoodRecipe, bool) {
+	stored, ok := s.entry(id)
+	if !ok {
+		return nil, false
+	}
+	return &stored.item, true
+}
+
+func (s *sqlRecipeStore) entry(id string) (storedItem[FoodRecipe], bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	stored, err := scanRecipe(s.db.QueryRowContext(ctx, `SELECT `+recipeColumns+` FROM recipes WHERE recipes.id = $1`, id))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to read recipe %s: %v", id, err)
+		}
+		return storedItem[FoodRecipe]{}, false
+	}
+	return stored, true
+}
+
+func (s *sqlRecipeStore) list() []storedItem[FoodRecipe] {
+	return s.query(`SELECT ` + recipeColumns + ` FROM recipes ORDER BY recipes.created_at DESC, recipes.id DESC`)
+}
+
+// query returns the recipes a query selecting recipeColumns finds
+func (s *sqlRecipeStore) query(query string, args ...any) []storedItem[FoodRecipe] {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	items := []storedItem[FoodRecipe]{}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Failed to list recipes: %v", err)
+		return items
+	}
+	defer rows.Close()
+	for rows.Next() {
+		stored, err := scanRecipe(rows)
+		if err != nil {
+			log.Printf("Failed to read a recipe: %v", err)
+			continue
+		}
+		items = append(items, stored)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list recipes: %v", err)
+	}
+	return items
+}
+
+func (s *sqlRecipeStore) delete(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM favorites WHERE recipe_id = $1`, id); err != nil {
+		return false, fmt.Errorf("deleting the favorites of recipe %s: %w", id, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE recipe_id = $1`, id); err != nil {
+		return false, fmt.Errorf("deleting the reviews of recipe %s: %w", id, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recipe_versions WHERE recipe_id = $1`, id); err != nil {
+		return false, fmt.Errorf("deleting the versions of recipe %s: %w", id, err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM recipes WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("deleting recipe %s: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+func (s *sqlRecipeStore) setFavorite(ctx context.Context, account, id string, favorite bool) error {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	var err error
+	if favorite {
+		_, err = s.db.ExecContext(ctx,
+			`INSERT INTO favorites (account, recipe_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (account, recipe_id) DO NOTHING`,
+			account, id, time.Now().UTC())
+	} else {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM favorites WHERE account = $1 AND recipe_id = $2`, account, id)
+	}
+	if err != nil {
+		return fmt.Errorf("updating favorite %s of %s: %w", id, account, err)
+	}
+	return nil
+}
+
+func (s *sqlRecipeStore) favorites(account string) []storedItem[FoodRecipe] {
+	return s.query(`SELECT `+recipeColumns+` FROM favorites JOIN recipes ON recipes.id = favorites.recipe_id
+		WHERE favorites.account = $1 ORDER BY favorites.created_at DESC, recipes.id DESC`, account)
+}
+
+func (s *sqlRecipeStore) review(ctx context.Context, review *Review) error {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO reviews (id, recipe_id, account, stars, review, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (recipe_id, account) DO UPDATE SET stars = excluded.stars, review = excluded.review, updated_at = excluded.updated_at
+		RETURNING id, created_at`,
+		newID(), review.RecipeID, review.Account, review.Stars, review.Review, review.UpdatedAt.UTC(),
+	).Scan(&review.ID, &review.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("saving the review of %s by %s: %w", review.RecipeID, review.Account, err)
+	}
+	return nil
+}
+
+func (s *sqlRecipeStore) reviews(id string) []Review {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	reviews := []Review{}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, recipe_id, account, stars, review, created_at, updated_at FROM reviews WHERE recipe_id = $1 ORDER BY updated_at DESC, id DESC`, id)
+	if err != nil {
+		log.Printf("Failed to list the reviews of %s: %v", id, err)
+		return reviews
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var review Review
+		if err := rows.Scan(&review.ID, &review.RecipeID, &review.Account, &review.Stars, &review.Review, &review.CreatedAt, &review.UpdatedAt); err != nil {
+			log.Printf("Failed to read a review: %v", err)
+			continue
+		}
+		reviews = append(reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list the reviews of %s: %v", id, err)
+	}
+	return reviews
+}
+
+func (s *sqlRecipeStore) history(account string) []historyItem {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	items := []historyItem{}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+recipeColumns+`, recipes.input FROM recipes
+		WHERE recipes.owner = $1 AND recipes.input IS NOT NULL ORDER BY recipes.created_at DESC, recipes.id DESC`, account)
+	if err != nil {
+		log.Printf("Failed to list the history of %s: %v", account, err)
+		return items
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var input []byte
+		stored, err := scanRecipe(rows, &input)
+		if err == nil {
+			item := historyItem{storedItem: stored}
+			if err = json.Unmarshal(input, &item.input); err == nil {
+				items = append(items, item)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to read a recipe: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list the history of %s: %v", account, err)
+	}
+	return items
+}
+
+// scanRecipe reads a row of recipeColumns, followed by any extra columns
+// into extra
+func scanRecipe(row interface{ Scan(...any) error }, extra ...any) (storedItem[FoodRecipe], error) {
+	var stored storedItem[FoodRecipe]
+	var data []byte
+	var favorites, reviews, stars int
+	dest := append([]any{&stored.id, &data, &stored.created, &stored.owner, &favorites, &reviews, &stars}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return stored, err
+	}
+	if err := json.Unmarshal(data, &stored.item); err != nil {
+		return stored, fmt.Errorf("recipe %s: %w", stored.id, err)
+	}
+	stored.item.FavoriteCount = favorites
+	stored.item.Rating = newRatingSummary(reviews, stars)
+	// Links follow the current routes rather than those it was saved with
+	stored.item = stored.item.withID(stored.id)
+	return stored, nil
+}
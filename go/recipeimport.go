@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Limits on a single import request
+const (
+	maxImportBytes = 10 << 20
+	maxImportRows  = 1000
+)
+
+// Fields the server sets itself; they are ignored when importing so exported
+// recipes can be imported again
+var serverRecipeFields = []string{"id", "allergens", "ingredientAllergens", "_links"}
+
+// The outcome of importing one recipe
+type ImportRow struct {
+	Row    int      `json:"row"`
+	ID     string   `json:"id,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Result of a bulk recipe import
+type ImportResult struct {
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+// The FoodRecipe schema that imported recipes are checked against, the same
+// one published in the API documentation
+var recipeImportSchema = sync.OnceValues(func() (*gojsonschema.Schema, error) {
+	return gojsonschema.NewSchema(gojsonschema.NewGoLoader(reflectSchema(FoodRecipe{})))
+})
+
+// splitImportRows reads the request body as either a JSON array of recipes
+// or NDJSON with one recipe per line
+func splitImportRows(r *http.Request) ([]json.RawMessage, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, fmt.Errorf("the import is larger than %d MB", maxImportBytes>>20)
+		}
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	ndjson := mediaType == "application/x-ndjson" || mediaType == "application/jsonl"
+	if trimmed := bytes.TrimSpace(body); !ndjson && len(trimmed) > 0 && trimmed[0] == '[' {
+		var rows []json.RawMessage
+		if err := json.Unmarshal(trimmed, &rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+		return rows, nil
+	}
+
+	// Blank lines are kept as empty rows so reported rows match line numbers
+	var rows []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, maxImportBytes)
+	for scanner.Scan() {
+		rows = append(rows, json.RawMessage(bytes.TrimSpace(scanner.Bytes())))
+	}
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+	return rows, scanner.Err()
+}
+
+// importRecipe validates one row against the recipe schema and stores it
+func importRecipe(ctx context.Context, raw json.RawMessage) (*FoodRecipe, []string) {
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, []string{"not a JSON object: " + err.Error()}
+	}
+	for _, field := range serverRecipeFields {
+		delete(doc, field)
+	}
+
+	schema, err := recipeImportSchema()
+	if err != nil {
+		return nil, []string{"recipe schema unavailable: " + err.Error()}
+	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	if !result.Valid() {
+		var problems []string
+		for _, e := range result.Errors() {
+			problems = append(problems, e.String())
+		}
+		return nil, problems
+	}
+
+	var recipe FoodRecipe
+	cleaned, _ := json.Marshal(doc)
+	if err := json.Unmarshal(cleaned, &recipe); err != nil {
+		return nil, []string{err.Error()}
+	}
+	recipe.Tags = normalizeTags(recipe.Tags)
+	applyAllergens(&recipe, "")
+	if err := savedRecipes.save(ctx, &recipe, nil); err != nil {
+		log.Printf("Failed to import a recipe: %v", err)
+		return nil, []string{"the recipe could not be saved"}
+	}
+	recipeVectors.add(ctx, &recipe)
+	return &recipe, nil
+}
+
+// importRecipesHandler imports user-authored recipes from a JSON array or an
+// NDJSON stream, reporting the outcome of every row
+func importRecipesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	rows, err := splitImportRows(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid Import", err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid Import", "No recipes were given")
+		return
+	}
+	if len(rows) > maxImportRows {
+		writeError(w, http.StatusRequestEntityTooLarge, "Import Too Large", fmt.Sprintf("At most %d recipes can be imported at once", maxImportRows))
+		return
+	}
+
+	result := ImportResult{Rows: []ImportRow{}}
+	for i, raw := range rows {
+		if len(raw) == 0 {
+			continue
+		}
+		row := ImportRow{Row: i + 1}
+		if recipe, problems := importRecipe(r.Context(), raw); problems != nil {
+			row.Errors = problems
+			result.Failed++
+		} else {
+			row.ID, row.Name = recipe.ID, recipe.Name
+			result.Imported++
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
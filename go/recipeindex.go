@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Name of the retriever finding stored recipes similar to a query
+const recipeRetrieverName = "recipes"
+
+// How many recipes the retriever returns unless asked for another number
+const defaultRetrieveCount = 5
+
+// recipeIndex embeds recipes into a vector store so they can be found by
+// meaning rather than by name
+type recipeIndex struct {
+	g        *genkit.Genkit
+	store    vectorStore
+	embedder string
+}
+
+// Index of the running server's recipes, set up at startup
+var recipeVectors *recipeIndex
+
+// embed embeds texts with the configured embedder
+func (ix *recipeIndex) embed(ctx context.Context, texts ...string) ([][]float32, error) {
+	resp, err := genkit.Embed(ctx, ix.g, ai.WithEmbedderName(ix.embedder), ai.WithTextDocs(texts...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed with %s: %w", ix.embedder, err)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedder %s returned %d embeddings for %d texts", ix.embedder, len(resp.Embeddings), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for i, e := range resp.Embeddings {
+		vectors[i] = e.Embedding
+	}
+	return vectors, nil
+}
+
+// recipeDocument is the text a recipe is embedded as: what it is, how it
+// is made and what goes into it
+func recipeDocument(r *FoodRecipe) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n", r.Name, r.Description)
+	if r.Cuisine != "" {
+		fmt.Fprintf(&b, "Cuisine: %s\n", r.Cuisine)
+	}
+	if len(r.Tags) > 0 {
+		fmt.Fprintf(&b, "Tags: %s\n", strings.Join(r.Tags, ", "))
+	}
+	fmt.Fprintf(&b, "Difficulty: %s, total time %s\n", r.Difficulty, r.TotalTime)
+	fmt.Fprintf(&b, "Ingredients: %s\n", strings.Join(r.Ingredients, "; "))
+	return b.String()
+}
+
+// recipeMetadata is what similarity queries can filter recipes on
+func recipeMetadata(r *FoodRecipe) map[string]string {
+	return map[string]string{
+		"name":       r.Name,
+		"cuisine":    strings.ToLower(r.Cuisine),
+		"difficulty": strings.ToLower(r.Difficulty),
+	}
+}
+
+// add embeds and stores a recipe in the background, so indexing never
+// delays or fails the request that made it
+func (ix *recipeIndex) add(ctx context.Context, recipe *FoodRecipe) {
+	if ix == nil {
+		return
+	}
+	doc := vectorDoc{ID: recipe.ID, Content: recipeDocument(recipe), Metadata: recipeMetadata(recipe)}
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		vectors, err := ix.embed(ctx, doc.Content)
+		if err == nil {
+			doc.Vector = vectors[0]
+			err = ix.store.upsert(ctx, doc)
+		}
+		if err != nil {
+			log.Printf("Failed to index recipe %s: %v", doc.ID, err)
+		}
+	}()
+}
+
+// remove drops recipes from the index in the background, so they are no
+// longer found
+func (ix *recipeIndex) remove(ctx context.Context, ids ...string) {
+	if ix == nil {
+		return
+	}
+	go func() {
+		if err := ix.store.delete(context.WithoutCancel(ctx), ids...); err != nil {
+			log.Printf("Failed to drop recipes %v from the index: %v", ids, err)
+		}
+	}()
+}
+
+// search returns the k recipes nearest in meaning to a free-text query
+func (ix *recipeIndex) search(ctx context.Context, query string, k int, filter map[string]string) ([]vectorMatch, error) {
+	if ix == nil {
+		return nil, errors.New("recipe index is not set up")
+	}
+	vectors, err := ix.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return ix.store.query(ctx, vectors[0], k, filter)
+}
+
+// Options of the recipe retriever
+type RecipeRetrieverOptions struct {
+	K      int               `json:"k,omitempty" jsonschema:"description=How many recipes to return (default 5)"`
+	Filter map[string]string `json:"filter,omitempty" jsonschema:"description=Metadata the recipes must have\\, by name\\, cuisine or difficulty"`
+}
+
+// defineRecipeRetriever registers the retriever of stored recipes, so flows
+// can ground generation in recipes the server has already made
+func defineRecipeRetriever(g *genkit.Genkit) ai.Retriever {
+	return genkit.DefineRetriever(g, recipeRetrieverName, &ai.RetrieverOptions{Label: "Stored recipes"},
+		func(ctx context.Context, req *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
+			// Options arrive as the struct from Go callers and as decoded
+			// JSON from the developer UI
+			var opts RecipeRetrieverOptions
+			if req.Options != nil {
+				raw, err := json.Marshal(req.Options)
+				if err == nil {
+					err = json.Unmarshal(raw, &opts)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("invalid retriever options: %w", err)
+				}
+			}
+			if opts.K <= 0 {
+				opts.K = defaultRetrieveCount
+			}
+			var query strings.Builder
+			for _, p := range req.Query.Content {
+				query.WriteString(p.Text)
+			}
+
+			matches, err := recipeVectors.search(ctx, query.String(), opts.K, opts.Filter)
+			if err != nil {
+				return nil, err
+			}
+			resp := &ai.RetrieverResponse{}
+			for _, m := range matches {
+				metadata := map[string]any{"id": m.ID, "score": m.Score}
+				for key, value := range m.Metadata {
+					metadata[key] = value
+				}
+				resp.Documents = append(resp.Documents, ai.DocumentFromText(m.Content, metadata))
+			}
+			return resp, nil
+		})
+}
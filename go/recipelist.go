@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for recipe listings
+const (
+	defaultRecipePageSize = 20
+	maxRecipePageSize     = 100
+)
+
+// A stored recipe as shown in listings
+type RecipeSummary struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Description   string         `json:"description"`
+	Difficulty    string         `json:"difficulty"`
+	Cuisine       string         `json:"cuisine,omitempty"`
+	Tags          []string       `json:"tags,omitempty"`
+	TotalTime     string         `json:"totalTime"`
+	Servings      int            `json:"servings"`
+	FavoriteCount int            `json:"favoriteCount"`
+	Rating        *RatingSummary `json:"rating,omitempty"`
+	CreatedAt     time.Time      `json:"createdAt"`
+}
+
+// One page of stored recipes, newest first
+type RecipeList struct {
+	Recipes    []RecipeSummary `json:"recipes"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// recipeFilter holds the listing filters from the query string
+type recipeFilter struct {
+	difficulty   string
+	cuisine      string
+	tags         []string
+	maxTotalTime time.Duration
+	// Category tags, by category, that a recipe must all carry
+	categories map[string][]string
+}
+
+// normalizeTags lower-cases and de-duplicates tags, dropping empty ones
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	out := tags[:0]
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// matches reports whether a recipe passes every filter. Recipes whose total
+// time can't be read are left out when a maximum is given
+func (f *recipeFilter) matches(r *FoodRecipe) bool {
+	if f.difficulty != "" && !strings.EqualFold(r.Difficulty, f.difficulty) {
+		return false
+	}
+	if f.cuisine != "" && !strings.EqualFold(r.Cuisine, f.cuisine) &&
+		!slices.Contains(r.Categories.tags("cuisine"), strings.ToLower(f.cuisine)) {
+		return false
+	}
+	for category, tags := range f.categories {
+		for _, tag := range tags {
+			if !slices.Contains(r.Categories.tags(category), tag) {
+				return false
+			}
+		}
+	}
+	for _, tag := range f.tags {
+		found := false
+		for _, t := range r.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.maxTotalTime > 0 {
+		if d := textDuration(r.TotalTime); d <= 0 || d > f.maxTotalTime {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memoryRecipeStore) page(filter *recipeFilter, after time.Time, afterID string, limit int) []storedItem[FoodRecipe] {
+	items := []storedItem[FoodRecipe]{}
+	for _, stored := range s.list() {
+		// Skip everything up to and including the cursor position
+		if afterID != "" && (stored.created.After(after) ||
+			stored.created.Equal(after) && stored.id >= afterID) {
+			continue
+		}
+		if filter.matches(&stored.item) {
+			if items = append(items, stored); len(items) == limit {
+				break
+			}
+		}
+	}
+	return items
+}
+
+// page filters and pages in the query. The total time is free text that
+// only textDuration reads, so with a maximum the rows are read in batches
+// until enough of them pass it
+func (s *sqlRecipeStore) page(filter *recipeFilter, after time.Time, afterID string, limit int) []storedItem[FoodRecipe] {
+	items := []storedItem[FoodRecipe]{}
+	for {
+		query, args := s.pageQuery(filter, after, afterID, limit)
+		batch := s.query(query, args...)
+		for _, stored := range batch {
+			if filter.matches(&stored.item) {
+				if items = append(items, stored); len(items) == limit {
+					return items
+				}
+			}
+		}
+		if len(batch) < limit {
+			return items
+		}
+		last := batch[len(batch)-1]
+		after, afterID = last.created, last.id
+	}
+}
+
+// pageQuery writes the filters and the cursor of a listing page as SQL
+func (s *sqlRecipeStore) pageQuery(filter *recipeFilter, after time.Time, afterID string, limit int) (string, []any) {
+	var args []any
+	// Placeholders are numbered in the order they are written, as SQLite
+	// binds them
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	field := func(name string) string {
+		if s.driver == "pgx" {
+			return "recipes.recipe->>'" + name + "'"
+		}
+		return "json_extract(recipes.recipe, '$." + name + "')"
+	}
+	contains := func(path []string, value string) string {
+		if s.driver == "pgx" {
+			return "recipes.recipe->'" + strings.Join(path, "'->'") + "' @> jsonb_build_array(" + arg(value) + "::text)"
+		}
+		return "EXISTS (SELECT 1 FROM json_each(recipes.recipe, '$." + strings.Join(path, ".") + "') WHERE value = " + arg(value) + ")"
+	}
+
+	var where []string
+	if afterID != "" {
+		where = append(where, "(recipes.created_at < "+arg(after.UTC())+
+			" OR recipes.created_at = "+arg(after.UTC())+" AND recipes.id < "+arg(afterID)+")")
+	}
+	if filter.difficulty != "" {
+		where = append(where, "LOWER("+field("difficulty")+") = "+arg(strings.ToLower(filter.difficulty)))
+	}
+	if filter.cuisine != "" {
+		cuisine := strings.ToLower(filter.cuisine)
+		where = append(where, "(LOWER("+field("cuisine")+") = "+arg(cuisine)+" OR "+contains([]string{"categories", "cuisine"}, cuisine)+")")
+	}
+	for _, category := range slices.Sorted(maps.Keys(filter.categories)) {
+		for _, tag := range filter.categories[category] {
+			where = append(where, contains([]string{"categories", category}, tag))
+		}
+	}
+	for _, tag := range filter.tags {
+		where = append(where, contains([]string{"tags"}, tag))
+	}
+
+	query := `SELECT ` + recipeColumns + ` FROM recipes`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	return query + ` ORDER BY recipes.created_at DESC, recipes.id DESC LIMIT ` + arg(limit), args
+}
+
+// summarizeRecipe makes the listing entry of a stored recipe
+func summarizeRecipe(stored storedItem[FoodRecipe]) RecipeSummary {
+	recipe := &stored.item
+	return RecipeSummary{
+		ID:            recipe.ID,
+		Name:          recipe.Name,
+		Description:   recipe.Description,
+		Difficulty:    recipe.Difficulty,
+		Cuisine:       recipe.Cuisine,
+		Tags:          recipe.Tags,
+		TotalTime:     recipe.TotalTime,
+		Servings:      recipe.Servings,
+		FavoriteCount: recipe.FavoriteCount,
+		Rating:        recipe.Rating,
+		CreatedAt:     stored.created,
+	}
+}
+
+// encodeCursor makes an opaque cursor pointing after the given item
+func encodeCursor(created time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%s", created.UnixNano(), id)))
+}
+
+// decodeCursor reads a cursor made by encodeCursor
+func decodeCursor(cursor string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if nanos, id, ok := strings.Cut(string(data), "."); ok {
+			if n, err := strconv.ParseInt(nanos, 10, 64); err == nil && id != "" {
+				return time.Unix(0, n), id, nil
+			}
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("invalid cursor")
+}
+
+// queryRecipeFilter reads the listing filters from the query string,
+// answering 400 when one is malformed
+func queryRecipeFilter(w http.ResponseWriter, query url.Values) (recipeFilter, bool) {
+	filter := recipeFilter{
+		difficulty: query.Get("difficulty"),
+		cuisine:    query.Get("cuisine"),
+	}
+	for _, v := range query["tag"] {
+		filter.tags = append(filter.tags, strings.Split(v, ",")...)
+	}
+	filter.tags = normalizeTags(filter.tags)
+	for _, category := range []string{"course", "diet", "occasion"} {
+		var tags []string
+		for _, v := range query[category] {
+			tags = append(tags, strings.Split(v, ",")...)
+		}
+		if tags = normalizeTags(tags); len(tags) > 0 {
+			if filter.categories == nil {
+				filter.categories = map[string][]string{}
+			}
+			filter.categories[category] = tags
+		}
+	}
+	if v := query.Get("maxTotalTime"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 1 {
+			writeError(w, http.StatusBadRequest, "Invalid Time", "maxTotalTime must be a positive number of minutes")
+			return filter, false
+		}
+		filter.maxTotalTime = time.Duration(minutes) * time.Minute
+	}
+	return filter, true
+}
+
+// listRecipesHandler lists stored recipes newest first, a page at a time.
+// The cursor is a position in that order rather than an offset, so pages
+// stay consistent while new recipes are generated
+func listRecipesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultRecipePageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRecipePageSize {
+			writeError(w, http.StatusBadRequest, "Invalid Limit", fmt.Sprintf("limit must be between 1 and %d", maxRecipePageSize))
+			return
+		}
+		limit = n
+	}
+
+	filter, ok := queryRecipeFilter(w, query)
+	if !ok {
+		return
+	}
+
+	var after time.Time
+	var afterID string
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		if after, afterID, err = decodeCursor(cursor); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Cursor", "The cursor is malformed; start again without one")
+			return
+		}
+	}
+
+	// One more than a page tells whether there is a next one
+	list := RecipeList{Recipes: []RecipeSummary{}}
+	for _, stored := range savedRecipes.page(&filter, after, afterID, limit+1) {
+		if len(list.Recipes) == limit {
+			last := list.Recipes[limit-1]
+			list.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+			break
+		}
+		list.Recipes = append(list.Recipes, summarizeRecipe(stored))
+	}
+
+	if notModified(w, r, contentETag(list, "json")) {
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Bounds on semantic recipe searches
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+	maxSearchQuery     = 500
+
+	// Nearest matches fetched per result asked for, so results filtered out
+	// by tag or time still leave a full page
+	searchOverfetch = 4
+)
+
+// A free-text recipe search with optional filters
+type RecipeSearchInput struct {
+	Query        string   `json:"query" jsonschema:"description=What the recipe should be like (e.g. cozy winter soup\\, no dairy),required=true"`
+	Limit        int      `json:"limit,omitempty" jsonschema:"description=How many recipes to return (default 10\\, at most 50)"`
+	MinScore     float64  `json:"minScore,omitempty" jsonschema:"description=Leave out recipes less similar than this\\, from 0 to 1"`
+	Difficulty   string   `json:"difficulty,omitempty" jsonschema:"description=Only recipes of this difficulty"`
+	Cuisine      string   `json:"cuisine,omitempty" jsonschema:"description=Only recipes of this cuisine"`
+	Tags         []string `json:"tags,omitempty" jsonschema:"description=Only recipes with all of these tags"`
+	Course       []string `json:"course,omitempty" jsonschema:"description=Only recipes classified into all of these courses"`
+	Diet         []string `json:"diet,omitempty" jsonschema:"description=Only recipes classified into all of these diets"`
+	Occasion     []string `json:"occasion,omitempty" jsonschema:"description=Only recipes classified into all of these occasions"`
+	MaxTotalTime int      `json:"maxTotalTime,omitempty" jsonschema:"description=Only recipes ready within this many minutes"`
+}
+
+// validate checks the search bounds
+func (in *RecipeSearchInput) validate() []FieldError {
+	var errs []FieldError
+	switch q := strings.TrimSpace(in.Query); {
+	case q == "":
+		errs = append(errs, FieldError{"query", "is required"})
+	case len(q) > maxSearchQuery:
+		errs = append(errs, FieldError{"query", fmt.Sprintf("must be at most %d characters", maxSearchQuery)})
+	}
+	if in.Limit < 0 || in.Limit > maxSearchLimit {
+		errs = append(errs, FieldError{"limit", fmt.Sprintf("must be between 1 and %d", maxSearchLimit)})
+	}
+	if in.MinScore < 0 || in.MinScore > 1 {
+		errs = append(errs, FieldError{"minScore", "must be between 0 and 1"})
+	}
+	if in.MaxTotalTime < 0 {
+		errs = append(errs, FieldError{"maxTotalTime", "must be a positive number of minutes"})
+	}
+	return errs
+}
+
+// A stored recipe found by a search and how similar it is to the query
+type RecipeSearchResult struct {
+	RecipeSummary
+	Score float64 `json:"score" jsonschema:"description=Cosine similarity to the query\\, higher is closer"`
+}
+
+// Recipes found by a search, most similar first
+type RecipeSearchResults struct {
+	Results []RecipeSearchResult `json:"results"`
+}
+
+// searchRecipesHandler finds stored recipes similar in meaning to a
+// free-text query. Difficulty and cuisine are filtered in the vector store;
+// tags, categories and time, which it can't index, on the nearest matches
+func searchRecipesHandler(w http.ResponseWriter, r *http.Request) {
+	var input RecipeSearchInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	limit := input.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+
+	filter := recipeFilter{
+		tags:         normalizeTags(input.Tags),
+		maxTotalTime: time.Duration(input.MaxTotalTime) * time.Minute,
+		categories: map[string][]string{
+			"course":   normalizeTags(input.Course),
+			"diet":     normalizeTags(input.Diet),
+			"occasion": normalizeTags(input.Occasion),
+		},
+	}
+	storeFilter := map[string]string{}
+	if input.Difficulty != "" {
+		storeFilter["difficulty"] = strings.ToLower(input.Difficulty)
+	}
+	if input.Cuisine != "" {
+		storeFilter["cuisine"] = strings.ToLower(input.Cuisine)
+	}
+
+	matches, err := recipeVectors.search(r.Context(), input.Query, limit*searchOverfetch, storeFilter)
+	if err != nil {
+		log.Printf("Recipe search failed: %v", err)
+		writeError(w, http.StatusBadGateway, "Search Failed", "The recipe search is unavailable; try again later")
+		return
+	}
+
+	results := RecipeSearchResults{Results: []RecipeSearchResult{}}
+	var expired []string
+	for _, m := range matches {
+		if m.Score < input.MinScore || len(results.Results) == limit {
+			break
+		}
+		stored, ok := savedRecipes.entry(m.ID)
+		if !ok {
+			expired = append(expired, m.ID)
+			continue
+		}
+		if filter.matches(&stored.item) {
+			results.Results = append(results.Results, RecipeSearchResult{RecipeSummary: summarizeRecipe(stored), Score: m.Score})
+		}
+	}
+
+	// Recipes that expired from the store have nothing left to show
+	if len(expired) > 0 {
+		if err := recipeVectors.store.delete(r.Context(), expired...); err != nil {
+			log.Printf("Failed to drop expired recipes from the vector store: %v", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
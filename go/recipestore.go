@@ -0,0 +1,236 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// How long the memory recipe store keeps recipes, and how many at most
+	recentRecipeTTL  = 24 * time.Hour
+	maxRecentRecipes = 1000
+
+	// Meal plans stay longer so calendar subscriptions keep working
+	recentMealPlanTTL  = 30 * 24 * time.Hour
+	maxRecentMealPlans = 200
+
+	// Shopping lists are exported shortly after they're made
+	recentShoppingListTTL  = 7 * 24 * time.Hour
+	maxRecentShoppingLists = 500
+)
+
+// storedItem is a generated item with its id, when it was saved and the
+// account that saved it, if known
+type storedItem[T any] struct {
+	id      string
+	item    T
+	created time.Time
+	owner   string
+}
+
+// recentStore keeps recently generated items in memory so follow-up
+// endpoints can refer to them by id. T stamps its own id with withID
+type recentStore[T interface{ withID(string) T }] struct {
+	mu    sync.Mutex
+	items map[string]*storedItem[T]
+	ttl   time.Duration
+	max   int
+}
+
+// newRecentStore creates a store that keeps at most max items for ttl
+func newRecentStore[T interface{ withID(string) T }](ttl time.Duration, max int) *recentStore[T] {
+	return &recentStore[T]{items: make(map[string]*storedItem[T]), ttl: ttl, max: max}
+}
+
+// Recently generated meal plans and shopping lists
+var (
+	recentMealPlans     = newRecentStore[MealPlan](recentMealPlanTTL, maxRecentMealPlans)
+	recentShoppingLists = newRecentStore[ShoppingList](recentShoppingListTTL, maxRecentShoppingLists)
+)
+
+// withID returns a copy of the recipe with the given id and its links
+func (r FoodRecipe) withID(id string) FoodRecipe {
+	r.ID = id
+	r.Links = recipeLinks(id)
+	return r
+}
+
+// withID returns a copy of the meal plan with the given id
+func (p MealPlan) withID(id string) MealPlan {
+	p.ID = id
+	return p
+}
+
+// withID returns a copy of the shopping list with the given id
+func (l ShoppingList) withID(id string) ShoppingList {
+	l.ID = id
+	return l
+}
+
+// save stamps the item with a new id, stores a copy of it and returns the id
+func (s *recentStore[T]) save(item *T) string {
+	return s.saveOwned(item, "")
+}
+
+// saveOwned saves the item as save does, recording the account it belongs to
+func (s *recentStore[T]) saveOwned(item *T, owner string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, stored := range s.items {
+		if time.Since(stored.created) > s.ttl {
+			delete(s.items, id)
+		}
+	}
+	for len(s.items) >= s.max {
+		var oldestID string
+		var oldest time.Time
+		for id, stored := range s.items {
+			if oldestID == "" || stored.created.Before(oldest) {
+				oldestID, oldest = id, stored.created
+			}
+		}
+		delete(s.items, oldestID)
+	}
+
+	id := newID()
+	*item = (*item).withID(id)
+	s.items[id] = &storedItem[T]{id: id, item: *item, created: time.Now(), owner: owner}
+	return id
+}
+
+// replace swaps the item with the given id for a new one, keeping when it
+// was saved and its owner, and reports whether there was one
+func (s *recentStore[T]) replace(id string, item T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[id]
+	if !ok || time.Since(stored.created) > s.ttl {
+		return false
+	}
+	stored.item = item.withID(id)
+	return true
+}
+
+// delete removes the item with the given id, reporting whether there was one
+func (s *recentStore[T]) delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.items[id]
+	delete(s.items, id)
+	return ok
+}
+
+// get returns a copy of the item with the given id
+func (s *recentStore[T]) get(id string) (*T, bool) {
+	stored, ok := s.entry(id)
+	if !ok {
+		return nil, false
+	}
+	return &stored.item, true
+}
+
+// entry returns a copy of the item with the given id and when it was saved
+func (s *recentStore[T]) entry(id string) (storedItem[T], bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[id]
+	if !ok || time.Since(stored.created) > s.ttl {
+		return storedItem[T]{}, false
+	}
+	return *stored, true
+}
+
+// list returns copies of the unexpired items, newest first with ties broken
+// by id so the order is stable between calls
+func (s *recentStore[T]) list() []storedItem[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]storedItem[T], 0, len(s.items))
+	for _, stored := range s.items {
+		if time.Since(stored.created) <= s.ttl {
+			items = append(items, *stored)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].created.Equal(items[j].created) {
+			return items[i].created.After(items[j].created)
+		}
+		return items[i].id > items[j].id
+	})
+	return items
+}
+
+// storedRecipeHandler serves a generated recipe by id, in the format picked
+// by ?format= or the Accept header
+func storedRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	serializer, ok := requestSerializer(r)
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, "Not Acceptable", "Supported formats are json, yaml, xml, markdown and jsonld")
+		return
+	}
+
+	recipe, ok := savedRecipes.get(r.PathValue("id"))
+	if !ok {
+		writeSerialized(w, serializer, http.StatusNotFound, ErrorResponse{
+			Error:     "Recipe Not Found",
+			Message:   "The recipe does not exist or has expired",
+			RequestID: w.Header().Get(requestIDHeader),
+		})
+		return
+	}
+
+	if notModified(w, r, contentETag(recipe, serializer.contentType)) {
+		return
+	}
+	writeSerialized(w, serializer, http.StatusOK, recipe)
+}
+
+// deleteRecipeHandler deletes a stored recipe and drops it from the search
+// index. Only the account that made the recipe, or an admin, may delete it
+func deleteRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	stored, ok := savedRecipes.entry(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+	if !canChange(r.Context(), stored.owner) {
+		writeError(w, http.StatusForbidden, "Forbidden", "Only the account that created the recipe can delete it")
+		return
+	}
+
+	deleted, err := savedRecipes.delete(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to delete recipe %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Delete Failed", "The recipe could not be deleted; try again later")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+	recipeVectors.remove(r.Context(), id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storedMealPlanHandler serves a generated meal plan by id
+func storedMealPlanHandler(w http.ResponseWriter, r *http.Request) {
+	plan, ok := recentMealPlans.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Meal Plan Not Found", "The meal plan does not exist or has expired")
+		return
+	}
+
+	if notModified(w, r, contentETag(plan, "json")) {
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Bounds on full-text recipe searches
+const (
+	maxTextQuery = 200
+
+	// Most matches the SQL stores rank, so a common word doesn't read the
+	// whole table
+	maxTextMatches = 500
+)
+
+// The words of a recipe Postgres searches: its name, weighted highest,
+// then its ingredients and its instructions. The text search index is on
+// this expression, so queries must use it as written
+const postgresRecipeText = `setweight(to_tsvector('english', name), 'A') || ` +
+	`setweight(to_tsvector('english', COALESCE(recipe->>'ingredients', '')), 'B') || ` +
+	`setweight(to_tsvector('english', COALESCE(recipe->>'instructions', '')), 'C')`
+
+// searchTerms splits a full-text query into lowercase words
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// textScore counts how often the search terms occur in a recipe, with the
+// name counting most and the instructions least. A term matches its plural
+// too. It also reports whether every term occurs
+func textScore(recipe *FoodRecipe, terms []string) (float64, bool) {
+	fields := []struct {
+		text   string
+		weight float64
+	}{
+		{strings.ToLower(recipe.Name), 3},
+		{strings.ToLower(strings.Join(recipe.Ingredients, "\n")), 2},
+		{strings.ToLower(strings.Join(recipe.Instructions, "\n")), 1},
+	}
+	score, all := 0.0, true
+	for _, term := range terms {
+		term = ingredientKey(term)
+		found := false
+		for _, f := range fields {
+			if n := strings.Count(f.text, term); n > 0 {
+				score += f.weight * float64(n)
+				found = true
+			}
+		}
+		all = all && found
+	}
+	return score, all
+}
+
+func (s *memoryRecipeStore) searchText(query string) []storedItem[FoodRecipe] {
+	terms := searchTerms(query)
+	items := []storedItem[FoodRecipe]{}
+	scores := map[string]float64{}
+	for _, stored := range s.list() {
+		if score, all := textScore(&stored.item, terms); all && len(terms) > 0 {
+			items = append(items, stored)
+			scores[stored.id] = score
+		}
+	}
+	// Stable, so equal scores stay newest first
+	slices.SortStableFunc(items, func(a, b storedItem[FoodRecipe]) int {
+		return cmp.Compare(scores[b.id], scores[a.id])
+	})
+	return items
+}
+
+// searchText uses Postgres text search, ranked by ts_rank, or the SQLite
+// full-text table, whose matches are ranked by textScore
+func (s *sqlRecipeStore) searchText(query string) []storedItem[FoodRecipe] {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []storedItem[FoodRecipe]{}
+	}
+	if s.driver == "pgx" {
+		return s.query(`SELECT `+recipeColumns+` FROM recipes, plainto_tsquery('english', $1) AS query
+			WHERE (`+postgresRecipeText+`) @@ query
+			ORDER BY ts_rank(`+postgresRecipeText+`, query) DESC, recipes.created_at DESC, recipes.id DESC LIMIT $2`,
+			strings.Join(terms, " "), maxTextMatches)
+	}
+
+	// The terms are plain words, so joined with spaces they are a query
+	// that matches recipes containing all of them
+	items := s.query(`SELECT `+recipeColumns+` FROM recipes
+		WHERE recipes.id IN (SELECT id FROM recipe_text WHERE recipe_text MATCH $1 LIMIT $2)
+		ORDER BY recipes.created_at DESC, recipes.id DESC`,
+		strings.Join(terms, " "), maxTextMatches)
+	scores := make(map[string]float64, len(items))
+	for _, stored := range items {
+		scores[stored.id], _ = textScore(&stored.item, terms)
+	}
+	slices.SortStableFunc(items, func(a, b storedItem[FoodRecipe]) int {
+		return cmp.Compare(scores[b.id], scores[a.id])
+	})
+	return items
+}
+
+// Recipes found by a keyword search, best match first
+type RecipeTextResults struct {
+	Results []RecipeSummary `json:"results"`
+}
+
+// textSearchHandler finds stored recipes by keyword: ?q= words that must
+// all occur in the name, ingredients or instructions. It takes the
+// listing's filters and ranks matches in the name above the rest
+func textSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	switch {
+	case len(searchTerms(q)) == 0:
+		writeError(w, http.StatusBadRequest, "Invalid Query", "q must contain at least one word")
+		return
+	case utf8.RuneCountInString(q) > maxTextQuery:
+		writeError(w, http.StatusBadRequest, "Invalid Query", fmt.Sprintf("q must be at most %d characters", maxTextQuery))
+		return
+	}
+
+	limit := defaultRecipePageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRecipePageSize {
+			writeError(w, http.StatusBadRequest, "Invalid Limit", fmt.Sprintf("limit must be between 1 and %d", maxRecipePageSize))
+			return
+		}
+		limit = n
+	}
+	filter, ok := queryRecipeFilter(w, query)
+	if !ok {
+		return
+	}
+
+	results := RecipeTextResults{Results: []RecipeSummary{}}
+	for _, stored := range savedRecipes.searchText(q) {
+		if len(results.Results) == limit {
+			break
+		}
+		if filter.matches(&stored.item) {
+			results.Results = append(results.Results, summarizeRecipe(stored))
+		}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
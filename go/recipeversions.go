@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Bounds on recipe edits
+const (
+	maxEditNote  = 500
+	maxEditLines = 100
+	// Times an edit without If-Match is made again to a recipe that
+	// another edit changed first
+	recipeEditAttempts = 5
+)
+
+// errRecipeChanged reports an edit made to a version of a recipe that is no
+// longer the latest
+var errRecipeChanged = errors.New("recipe changed since it was read")
+
+// Body of PATCH /api/recipe/{id}: the fields to change, with the others
+// left as they are
+type RecipeEdit struct {
+	Name         *string  `json:"name,omitempty"`
+	Description  *string  `json:"description,omitempty"`
+	Difficulty   *string  `json:"difficulty,omitempty"`
+	PrepTime     *string  `json:"prepTime,omitempty"`
+	CookTime     *string  `json:"cookTime,omitempty"`
+	TotalTime    *string  `json:"totalTime,omitempty"`
+	Servings     *int     `json:"servings,omitempty"`
+	Cuisine      *string  `json:"cuisine,omitempty"`
+	Tags         []string `json:"tags,omitempty" jsonschema:"description=Replaces the tags; an empty list removes them"`
+	Ingredients  []string `json:"ingredients,omitempty" jsonschema:"description=Replaces the ingredients"`
+	Instructions []string `json:"instructions,omitempty" jsonschema:"description=Replaces the instructions"`
+	Tips         []string `json:"tips,omitempty" jsonschema:"description=Replaces the tips; an empty list removes them"`
+	Note         string   `json:"note,omitempty" jsonschema:"description=What was changed and why\\, kept with the version"`
+}
+
+// validate checks that the edit leaves the recipe with a name, servings,
+// ingredients and instructions
+func (in *RecipeEdit) validate() []FieldError {
+	var errs []FieldError
+	if in.Name != nil && strings.TrimSpace(*in.Name) == "" {
+		errs = append(errs, FieldError{"name", "must not be empty"})
+	}
+	if in.Servings != nil && *in.Servings < 1 {
+		errs = append(errs, FieldError{"servings", "must be 1 or more"})
+	}
+	for _, list := range []struct {
+		field    string
+		lines    []string
+		required bool
+	}{
+		{"ingredients", in.Ingredients, true},
+		{"instructions", in.Instructions, true},
+		{"tips", in.Tips, false},
+	} {
+		switch {
+		case list.required && list.lines != nil && len(list.lines) == 0:
+			errs = append(errs, FieldError{list.field, "must not be empty"})
+		case len(list.lines) > maxEditLines:
+			errs = append(errs, FieldError{list.field, fmt.Sprintf("must have at most %d lines", maxEditLines)})
+		}
+	}
+	if utf8.RuneCountInString(in.Note) > maxEditNote {
+		errs = append(errs, FieldError{"note", fmt.Sprintf("must be at most %d characters", maxEditNote)})
+	}
+	return errs
+}
+
+// apply changes a recipe as the edit asks
+func (in *RecipeEdit) apply(recipe *FoodRecipe) {
+	for _, f := range []struct {
+		to   *string
+		from *string
+	}{
+		{&recipe.Name, in.Name},
+		{&recipe.Description, in.Description},
+		{&recipe.Difficulty, in.Difficulty},
+		{&recipe.PrepTime, in.PrepTime},
+		{&recipe.CookTime, in.CookTime},
+		{&recipe.TotalTime, in.TotalTime},
+		{&recipe.Cuisine, in.Cuisine},
+	} {
+		if f.from != nil {
+			*f.to = strings.TrimSpace(*f.from)
+		}
+	}
+	if in.Servings != nil {
+		recipe.Servings = *in.Servings
+	}
+	if in.Tags != nil {
+		recipe.Tags = normalizeTags(in.Tags)
+	}
+	if in.Ingredients != nil {
+		recipe.Ingredients = in.Ingredients
+	}
+	if in.Instructions != nil {
+		recipe.Instructions = in.Instructions
+	}
+	if in.Tips != nil {
+		recipe.Tips = in.Tips
+	}
+}
+
+// A version of a stored recipe's content: the original, or the result of
+// an edit
+type recipeVersion struct {
+	recipe FoodRecipe
+	// original, refinement or edit
+	source  string
+	note    string
+	account string
+	created time.Time
+}
+
+// versionContent is a recipe as kept in a version, without the counts and
+// flags that belong to the stored recipe or to one response
+func versionContent(recipe FoodRecipe) FoodRecipe {
+	recipe.FavoriteCount, recipe.Rating = 0, nil
+	recipe.Cached, recipe.DuplicateOf = false, ""
+	return recipe
+}
+
+// originalVersion is the first version of a stored recipe: what it was
+// saved as, generated, imported or refined from another
+func originalVersion(stored storedItem[FoodRecipe]) recipeVersion {
+	version := recipeVersion{recipe: versionContent(stored.item), source: "original", account: stored.owner, created: stored.created}
+	if turns := stored.item.Refinements; stored.item.RefinedFrom != "" && len(turns) > 0 {
+		version.source, version.note = "refinement", turns[len(turns)-1].Summary
+	}
+	return version
+}
+
+func (s *memoryRecipeStore) edit(ctx context.Context, recipe *FoodRecipe, note string, version int) (bool, error) {
+	stored, ok := s.recent.entry(recipe.ID)
+	if !ok {
+		return false, nil
+	}
+	content := versionContent(*recipe)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if max(len(s.edits[recipe.ID]), 1) != version {
+		return false, errRecipeChanged
+	}
+	if !s.recent.replace(recipe.ID, content) {
+		return false, nil
+	}
+	versions := s.edits[recipe.ID]
+	if len(versions) == 0 {
+		versions = append(versions, originalVersion(stored))
+	}
+	s.edits[recipe.ID] = append(versions, recipeVersion{
+		recipe: content, source: "edit", note: note, account: requestAccount(ctx), created: time.Now(),
+	})
+	return true, nil
+}
+
+func (s *memoryRecipeStore) versions(id string) []recipeVersion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.edits[id])
+}
+
+func (s *sqlRecipeStore) edit(ctx context.Context, recipe *FoodRecipe, note string, version int) (bool, error) {
+	data, err := json.Marshal(versionContent(*recipe))
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Concurrent edits of a recipe take turns, so each numbers its version
+	// after the last one. Postgres locks the recipe's row; SQLite has one
+	// connection, so its transactions already run one at a time
+	query := `SELECT id, recipe, owner, created_at FROM recipes WHERE id = $1`
+	if s.driver == "pgx" {
+		query += ` FOR UPDATE`
+	}
+	var original storedItem[FoodRecipe]
+	var originalJSON []byte
+	err = tx.QueryRowContext(ctx, query, recipe.ID).Scan(&original.id, &originalJSON, &original.owner, &original.created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("reading recipe %s: %w", recipe.ID, err)
+	}
+	var last int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM recipe_versions WHERE recipe_id = $1`, recipe.ID).Scan(&last); err != nil {
+		return false, fmt.Errorf("reading the last version of recipe %s: %w", recipe.ID, err)
+	}
+	if max(last, 1) != version {
+		return false, errRecipeChanged
+	}
+
+	// The first edit keeps the recipe as it was saved as its first version
+	insert := func(number int, data []byte, version recipeVersion) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO recipe_versions (recipe_id, version, recipe, source, note, account, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			recipe.ID, number, string(data), version.source, version.note, version.account, version.created.UTC())
+		return err
+	}
+	if last == 0 {
+		if err := json.Unmarshal(originalJSON, &original.item); err != nil {
+			return false, fmt.Errorf("recipe %s: %w", recipe.ID, err)
+		}
+		if err := insert(1, originalJSON, originalVersion(original)); err != nil {
+			return false, fmt.Errorf("saving the first version of recipe %s: %w", recipe.ID, err)
+		}
+		last = 1
+	}
+	edit := recipeVersion{source: "edit", note: note, account: requestAccount(ctx), created: time.Now()}
+	if err := insert(last+1, data, edit); err != nil {
+		return false, fmt.Errorf("saving version %d of recipe %s: %w", last+1, recipe.ID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE recipes SET name = $1, recipe = $2 WHERE id = $3`, recipe.Name, string(data), recipe.ID); err != nil {
+		return false, fmt.Errorf("updating recipe %s: %w", recipe.ID, err)
+	}
+	return true, tx.Commit()
+}
+
+func (s *sqlRecipeStore) versions(id string) []recipeVersion {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	var versions []recipeVersion
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT recipe, source, note, account, created_at FROM recipe_versions WHERE recipe_id = $1 ORDER BY version`, id)
+	if err != nil {
+		log.Printf("Failed to list the versions of %s: %v", id, err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version recipeVersion
+		var data []byte
+		if err := rows.Scan(&data, &version.source, &version.note, &version.account, &version.created); err != nil {
+			log.Printf("Failed to read a version of %s: %v", id, err)
+			continue
+		}
+		if err := json.Unmarshal(data, &version.recipe); err != nil {
+			log.Printf("Failed to read a version of %s: %v", id, err)
+			continue
+		}
+		version.recipe = version.recipe.withID(id)
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list the versions of %s: %v", id, err)
+	}
+	return versions
+}
+
+// recipeLineage returns the versions a recipe went through, oldest first:
+// those of the recipes it was refined from, up to when each was refined,
+// then its own. It reports false if the recipe doesn't exist
+func recipeLineage(id string) ([]recipeVersion, bool) {
+	var chain []storedItem[FoodRecipe]
+	seen := map[string]bool{}
+	for next := id; next != "" && !seen[next]; {
+		// Recipes refined from one that has been deleted start the line
+		stored, ok := savedRecipes.entry(next)
+		if !ok {
+			break
+		}
+		seen[next] = true
+		chain = append(chain, stored)
+		next = stored.item.RefinedFrom
+	}
+	if len(chain) == 0 {
+		return nil, false
+	}
+	slices.Reverse(chain)
+
+	var lineage []recipeVersion
+	for i, stored := range chain {
+		versions := savedRecipes.versions(stored.id)
+		if len(versions) == 0 {
+			versions = []recipeVersion{originalVersion(stored)}
+		}
+		// Edits made to a recipe after it was refined aren't in this line
+		if i < len(chain)-1 {
+			n := 1
+			for n < len(versions) && !versions[n].created.After(chain[i+1].created) {
+				n++
+			}
+			versions = versions[:n]
+		}
+		lineage = append(lineage, versions...)
+	}
+	return lineage, true
+}
+
+// A version in a recipe's history and what changed from the one before
+type RecipeVersion struct {
+	Version   int         `json:"version"`
+	RecipeID  string      `json:"recipeId" jsonschema:"description=The stored recipe the version belongs to; refinements are stored under their own id"`
+	Source    string      `json:"source" jsonschema:"enum=original,enum=refinement,enum=edit"`
+	Note      string      `json:"note,omitempty" jsonschema:"description=The edit's note or the refinement's summary"`
+	Account   string      `json:"account,omitempty"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Diff      *RecipeDiff `json:"diff,omitempty" jsonschema:"description=What changed from the version before; absent on the first"`
+}
+
+// The history of a recipe, oldest version first
+type RecipeVersions struct {
+	Versions []RecipeVersion `json:"versions"`
+}
+
+// A version of a recipe with its full content
+type RecipeVersionDetail struct {
+	RecipeVersion
+	Recipe FoodRecipe `json:"recipe"`
+}
+
+// describeVersions numbers a lineage and diffs each version against the
+// one before
+func describeVersions(lineage []recipeVersion) []RecipeVersion {
+	versions := make([]RecipeVersion, len(lineage))
+	for i, v := range lineage {
+		versions[i] = RecipeVersion{
+			Version:   i + 1,
+			RecipeID:  v.recipe.ID,
+			Source:    v.source,
+			Note:      v.note,
+			Account:   v.account,
+			CreatedAt: v.created,
+		}
+		if i > 0 {
+			diff := diffRecipes(&lineage[i-1].recipe, &v.recipe)
+			versions[i].Diff = &diff
+		}
+	}
+	return versions
+}
+
+// editRecipeHandler changes the fields of a stored recipe given in the
+// body and keeps the recipe as it was as an earlier version. Allergens are
+// detected again from the new ingredients. Only the account that made the
+// recipe, or an admin, may edit it. The ETag is the number of the recipe's
+// latest version, as in its versions; with it in If-Match the edit is only
+// made to that version
+func editRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	stored, ok := savedRecipes.entry(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+	if !canChange(r.Context(), stored.owner) {
+		writeError(w, http.StatusForbidden, "Forbidden", "Only the account that created the recipe can edit it")
+		return
+	}
+
+	var edit RecipeEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	ifMatch := r.Header.Get("If-Match")
+	for attempt := 1; ; attempt++ {
+		// The content and its number come from the same read of the
+		// versions, so an edit made since is caught by the store
+		lineage, ok := recipeLineage(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+			return
+		}
+		etag := recipeVersionETag(len(lineage))
+		if ifMatch != "" && !ifMatches(ifMatch, etag) {
+			w.Header().Set("ETag", etag)
+			recipeChanged(w)
+			return
+		}
+		current := lineage[len(lineage)-1].recipe
+		// Versions before those of this recipe are of the recipes it was
+		// refined from
+		own := 0
+		for _, v := range lineage {
+			if v.recipe.ID == id {
+				own++
+			}
+		}
+
+		recipe := current
+		edit.apply(&recipe)
+		if diff := diffRecipes(&current, &recipe); len(diff.Fields) == 0 && len(diff.Ingredients) == 0 &&
+			len(diff.Instructions) == 0 && len(diff.Tips) == 0 {
+			// Nothing changed, so there is no new version
+			if latest, ok := savedRecipes.get(id); ok {
+				current = *latest
+			}
+			w.Header().Set("ETag", etag)
+			writeJSON(w, http.StatusOK, current)
+			return
+		}
+		applyAllergens(&recipe, "")
+		if recipe.Categories != nil {
+			categories := *recipe.Categories
+			categories.normalize(recipe.Allergens)
+			recipe.Categories = &categories
+		}
+
+		edited, err := savedRecipes.edit(r.Context(), &recipe, strings.TrimSpace(edit.Note), own)
+		if errors.Is(err, errRecipeChanged) {
+			if ifMatch != "" || attempt == recipeEditAttempts {
+				recipeChanged(w)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to edit recipe %s: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "Edit Failed", "The recipe could not be edited; try again later")
+			return
+		}
+		if !edited {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+			return
+		}
+		recipeVectors.add(r.Context(), &recipe)
+
+		if updated, ok := savedRecipes.get(id); ok {
+			recipe = *updated
+		}
+		w.Header().Set("ETag", recipeVersionETag(len(lineage)+1))
+		writeJSON(w, http.StatusOK, recipe)
+		return
+	}
+}
+
+// recipeVersionETag is the strong ETag edits of a recipe compare If-Match
+// with: the number of its latest version
+func recipeVersionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// recipeChanged answers 412 to an edit made to an older version of a recipe
+func recipeChanged(w http.ResponseWriter) {
+	writeError(w, http.StatusPreconditionFailed, "Recipe Changed",
+		"The recipe was edited since you read it; fetch it again and retry")
+}
+
+// recipeVersionsHandler lists the versions a stored recipe went through,
+// oldest first, including the recipes it was refined from, each with what
+// changed from the version before
+func recipeVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	lineage, ok := recipeLineage(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+	list := RecipeVersions{Versions: describeVersions(lineage)}
+	if notModified(w, r, contentETag(list, "json")) {
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// recipeVersionHandler serves one version of a stored recipe, numbered as
+// in its versions, with its full content
+func recipeVersionHandler(w http.ResponseWriter, r *http.Request) {
+	lineage, ok := recipeLineage(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || n < 1 || n > len(lineage) {
+		writeError(w, http.StatusNotFound, "Version Not Found", fmt.Sprintf("The recipe has versions 1 to %d", len(lineage)))
+		return
+	}
+	detail := RecipeVersionDetail{RecipeVersion: describeVersions(lineage)[n-1], Recipe: lineage[n-1].recipe}
+	if notModified(w, r, contentETag(detail, "json")) {
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
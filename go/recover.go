@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// Panics recovered from handlers since the process started
+var panicCount = expvar.NewInt("http_panics_total")
+
+// recoveryWriter notes whether the response has started, which decides if a
+// 500 can still be sent after a panic
+type recoveryWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (rw *recoveryWriter) WriteHeader(status int) {
+	rw.wrote = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recoveryWriter) Write(b []byte) (int, error) {
+	rw.wrote = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming
+func (rw *recoveryWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.wrote = true
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection
+func (rw *recoveryWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	rw.wrote = true
+	return h.Hijack()
+}
+
+// recoverPanics turns a panicking handler into a 500 ErrorResponse carrying
+// the request ID, logs the stack trace and counts it, instead of letting the
+// panic take the connection down without an answer. A panic after the
+// response has started can only abort it
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			panicCount.Add(1)
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID(r.Context()), p, debug.Stack())
+			if rw.wrote {
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, http.StatusInternalServerError, "Internal Server Error", "An unexpected error occurred")
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
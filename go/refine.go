@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Most recent refinements sent to the model as conversation history
+const maxRefineHistory = 10
+
+// An earlier refinement of a recipe: what was asked and what was changed
+type RefinementTurn struct {
+	Instruction string `json:"instruction"`
+	Summary     string `json:"summary"`
+}
+
+// Define input schema for recipe refinement requests
+type RefineInput struct {
+	RecipeID    string `json:"recipeId" jsonschema:"description=Stored recipe to refine,required=true"`
+	Instruction string `json:"instruction" jsonschema:"description=What to change (e.g. make it spicier\\, swap cream for coconut milk),required=true"`
+}
+
+// A changed scalar field of a recipe
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// A line added to or removed from a recipe list
+type LineChange struct {
+	Op   string `json:"op" jsonschema:"enum=added,enum=removed"`
+	Line int    `json:"line" jsonschema:"description=1-based position in the previous version for removals and in the new one for additions"`
+	Text string `json:"text"`
+}
+
+// What changed between two versions of a recipe
+type RecipeDiff struct {
+	Fields       []FieldChange `json:"fields"`
+	Ingredients  []LineChange  `json:"ingredients"`
+	Instructions []LineChange  `json:"instructions"`
+	Tips         []LineChange  `json:"tips"`
+}
+
+// Define output schema for a refinement: the new version, stored under its
+// own id, and how it differs from the one refined
+type RecipeRefinement struct {
+	Recipe  FoodRecipe `json:"recipe"`
+	Summary string     `json:"summary"`
+	Diff    RecipeDiff `json:"diff"`
+}
+
+// The model's answer to a refinement
+type refinedRecipe struct {
+	Recipe  FoodRecipe `json:"recipe" jsonschema:"description=The full recipe with the change applied"`
+	Summary string     `json:"summary" jsonschema:"description=What was changed and why\\, in one or two sentences"`
+}
+
+// refinementContext is the recipe as shown to the model, without the fields
+// the server sets
+func refinementContext(recipe FoodRecipe) FoodRecipe {
+	recipe.ID, recipe.Links, recipe.Image = "", nil, nil
+	recipe.Allergens, recipe.IngredientAllergens = nil, nil
+	recipe.Model, recipe.PromptVersion, recipe.Quality, recipe.Cached = "", "", nil, false
+	recipe.RefinedFrom, recipe.Refinements = "", nil
+	return recipe
+}
+
+// Define the recipe refinement flow, which changes a stored recipe as
+// instructed with the earlier refinements as conversation history
+func defineRefineFlow(g *genkit.Genkit) *core.Flow[*RefineInput, *RecipeRefinement, struct{}] {
+	return genkit.DefineFlow(g, "refineRecipeFlow", func(ctx context.Context, input *RefineInput) (*RecipeRefinement, error) {
+		instruction := strings.TrimSpace(input.Instruction)
+		if instruction == "" {
+			return nil, newInputError("instruction is required")
+		}
+		previous, ok := savedRecipes.get(input.RecipeID)
+		if !ok {
+			return nil, newInputError("recipe %q does not exist or has expired", input.RecipeID)
+		}
+
+		history := previous.Refinements
+		if len(history) > maxRefineHistory {
+			history = history[len(history)-maxRefineHistory:]
+		}
+		var messages []*ai.Message
+		for _, turn := range history {
+			messages = append(messages, ai.NewUserTextMessage(turn.Instruction), ai.NewModelTextMessage(turn.Summary))
+		}
+		recipeJSON, err := json.Marshal(refinementContext(*previous))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, ai.NewUserTextMessage(fmt.Sprintf("Current recipe: %s\n\nChange: %s", recipeJSON, instruction)))
+
+		ctx, choice := withModelChoice(ctx, "")
+		refined, _, err := genkit.GenerateData[refinedRecipe](ctx, g,
+			ai.WithSystem(`You refine home recipes step by step as the cook asks. Apply the requested change
+			to the current recipe and return the full updated recipe, adjusting quantities, times, steps
+			and nutrition to match. Keep everything the cook didn't ask to change, and keep earlier
+			changes from this conversation unless the cook reverses them.`),
+			ai.WithMessages(messages...),
+			ai.WithTools(ai.ToolName(nutritionToolName), ai.ToolName(unitsToolName)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to refine recipe %s: %w", previous.Name, err)
+		}
+
+		recipe := refined.Recipe
+		if recipe.Name == "" {
+			recipe.Name = previous.Name
+		}
+		if recipe.Servings == 0 {
+			recipe.Servings = previous.Servings
+		}
+		recipe.Tags = normalizeTags(recipe.Tags)
+		applyAllergens(&recipe, "")
+		recipe.Model = choice.modelUsed()
+		recipe.RefinedFrom = previous.ID
+		recipe.Refinements = append(append([]RefinementTurn(nil), previous.Refinements...),
+			RefinementTurn{Instruction: instruction, Summary: refined.Summary})
+
+		if err := savedRecipes.save(ctx, &recipe, nil); err != nil {
+			return nil, err
+		}
+		recipeVectors.add(ctx, &recipe)
+
+		return &RecipeRefinement{
+			Recipe:  recipe,
+			Summary: refined.Summary,
+			Diff:    diffRecipes(previous, &recipe),
+		}, nil
+	})
+}
+
+// diffRecipes lists what changed from one version of a recipe to the next
+func diffRecipes(before, after *FoodRecipe) RecipeDiff {
+	diff := RecipeDiff{
+		Fields:       []FieldChange{},
+		Ingredients:  diffLines(before.Ingredients, after.Ingredients),
+		Instructions: diffLines(before.Instructions, after.Instructions),
+		Tips:         diffLines(before.Tips, after.Tips),
+	}
+	for _, f := range []struct {
+		field         string
+		before, after string
+	}{
+		{"name", before.Name, after.Name},
+		{"description", before.Description, after.Description},
+		{"difficulty", before.Difficulty, after.Difficulty},
+		{"prepTime", before.PrepTime, after.PrepTime},
+		{"cookTime", before.CookTime, after.CookTime},
+		{"totalTime", before.TotalTime, after.TotalTime},
+		{"servings", strconv.Itoa(before.Servings), strconv.Itoa(after.Servings)},
+		{"cuisine", before.Cuisine, after.Cuisine},
+		{"tags", strings.Join(before.Tags, ", "), strings.Join(after.Tags, ", ")},
+	} {
+		if f.before != f.after {
+			diff.Fields = append(diff.Fields, FieldChange{Field: f.field, Before: f.before, After: f.after})
+		}
+	}
+	return diff
+}
+
+// diffLines lists the lines removed from a and added in b, keeping the
+// longest common subsequence of lines unchanged
+func diffLines(a, b []string) []LineChange {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	changes := []LineChange{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, LineChange{Op: "removed", Line: i + 1, Text: a[i]})
+			i++
+		default:
+			changes = append(changes, LineChange{Op: "added", Line: j + 1, Text: b[j]})
+			j++
+		}
+	}
+	return changes
+}
+
+// refineRecipeHandler refines the recipe named in the path and answers with
+// the new version, which is stored under its own id
+func refineRecipeHandler(flow *core.Flow[*RefineInput, *RecipeRefinement, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := savedRecipes.get(r.PathValue("id")); !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+			return
+		}
+
+		var input RefineInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+		input.RecipeID = r.PathValue("id")
+
+		refinement, err := flow.Run(r.Context(), &input)
+		if err != nil {
+			log.Printf("Error refining recipe %s: %v", input.RecipeID, err)
+			writeFlowError(w, err, "Recipe Refinement Failed")
+			return
+		}
+
+		w.Header().Set("Location", refinement.Recipe.Links["self"].Href)
+		writeJSON(w, http.StatusCreated, refinement)
+	}
+}
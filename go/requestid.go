@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Header carrying the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// Context key of the request ID
+type requestIDContextKey struct{}
+
+// requestID returns the ID of the request the context belongs to
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// validRequestID accepts short IDs of visible ASCII characters, so a
+// client's ID can be echoed into logs and headers safely
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID gives each request an ID, keeping the caller's X-Request-ID
+// when it sends a usable one. The ID is echoed in the response header and
+// in error responses, so a failure can be matched to the server logs
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Error response structure
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Code      string `json:"code,omitempty" jsonschema:"description=Machine-readable reason\\, e.g. safety_blocked when the safety filters stopped the answer or model_quota when the model was out of quota"`
+	RequestID string `json:"requestId,omitempty" jsonschema:"description=ID of the request\\, also sent in the X-Request-ID header"`
+}
+
+// writeJSON encodes v as the JSON response body with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// writeError sends an ErrorResponse with the given status, quoting the
+// request ID set on the response header
+func writeError(w http.ResponseWriter, status int, title, message string) {
+	writeJSON(w, status, ErrorResponse{
+		Error:     title,
+		Message:   message,
+		RequestID: w.Header().Get(requestIDHeader),
+	})
+}
+
+// writeSSE writes a single Server-Sent Event with a JSON encoded payload
+func writeSSE(w http.ResponseWriter, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
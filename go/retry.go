@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// How many times a model call failing for a reason that may pass is
+// retried, and the delay before the first retry. Set from the config at
+// startup
+var (
+	maxModelRetries = 2
+	modelRetryDelay = 500 * time.Millisecond
+)
+
+// Longest wait before a retry. A model that asks to be left alone longer is
+// given up on, so the next fallback can answer instead
+const maxModelRetryDelay = 10 * time.Second
+
+// Header telling clients how many model calls behind their request were
+// retried
+const modelRetriesHeader = "X-Model-Retries"
+
+// Retries by model, and calls that failed for good by reason
+var (
+	modelRetryCount   = expvar.NewMap("model_retries_total")
+	modelFailureCount = expvar.NewMap("model_failures_total")
+)
+
+// Reasons a model call fails
+const (
+	failureQuota   = "quota"
+	failureServer  = "server_error"
+	failureNetwork = "network"
+	failureTimeout = "timeout"
+	failureBlocked = "blocked"
+	failureRequest = "invalid_request"
+	failureOther   = "other"
+)
+
+// modelFailureReason classifies why a model call failed
+func modelFailureReason(err error) string {
+	var apiErr genai.APIError
+	var openAIErr *openai.Error
+	var blocked *safetyBlockedError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &blocked):
+		return failureBlocked
+	// Checked before network errors, which deadline errors also satisfy
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		return failureTimeout
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.Code == http.StatusTooManyRequests:
+			return failureQuota
+		case apiErr.Code >= 500:
+			return failureServer
+		default:
+			return failureRequest
+		}
+	case errors.As(err, &openAIErr):
+		switch {
+		case openAIErr.StatusCode == http.StatusTooManyRequests:
+			return failureQuota
+		case openAIErr.StatusCode >= 500:
+			return failureServer
+		default:
+			return failureRequest
+		}
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED):
+		return failureNetwork
+	}
+	return failureOther
+}
+
+// isTransient reports whether a model call that failed for reason may
+// succeed if tried again: quota and server errors and dropped connections
+func isTransient(reason string) bool {
+	return reason == failureQuota || reason == failureServer || reason == failureNetwork
+}
+
+// modelCallError is a model call that failed for good, after any retries
+type modelCallError struct {
+	model    string
+	attempts int
+	reason   string
+	// Part of the answer had already been streamed to the client
+	streamed bool
+	err      error
+}
+
+func (e *modelCallError) Error() string {
+	if e.attempts > 1 {
+		return fmt.Sprintf("%s failed after %d attempts: %v", e.model, e.attempts, e.err)
+	}
+	return fmt.Sprintf("%s: %v", e.model, e.err)
+}
+
+func (e *modelCallError) Unwrap() error { return e.err }
+
+// retryDelay is how long to wait before retry number attempt+1: the delay
+// the model asked for when it gave one, otherwise an exponential backoff
+// with jitter, so clients failing together don't retry together. It reports
+// false when the wait would be too long to be worth it
+func retryDelay(attempt int, err error) (time.Duration, bool) {
+	if hint := quotaHints(err).RetryAfter; hint != "" {
+		if d, perr := time.ParseDuration(hint); perr == nil {
+			return d, d <= maxModelRetryDelay
+		}
+	}
+	var openAIErr *openai.Error
+	if errors.As(err, &openAIErr) && openAIErr.Response != nil {
+		if seconds, perr := strconv.Atoi(openAIErr.Response.Header.Get("Retry-After")); perr == nil {
+			d := time.Duration(seconds) * time.Second
+			return d, d <= maxModelRetryDelay
+		}
+	}
+	ceiling := modelRetryDelay
+	for range attempt {
+		if ceiling >= maxModelRetryDelay {
+			break
+		}
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, maxModelRetryDelay)
+	return ceiling/2 + rand.N(ceiling/2+1), true
+}
+
+// generateWithRetry calls the model, retrying failures that may pass with
+// backoff. A call that already streamed part of its answer is not retried,
+// since the client has seen that part. Failures are *modelCallError
+func generateWithRetry(ctx context.Context, name string, model ai.Model, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+	streamed := false
+	var modelCb ai.ModelStreamCallback
+	if cb != nil {
+		modelCb = func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+			streamed = true
+			return cb(ctx, chunk)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := generateChecked(ctx, model, req, modelCb)
+		if err == nil {
+			return resp, nil
+		}
+		reason := modelFailureReason(err)
+		delay, retry := retryDelay(attempt, err)
+		if streamed || ctx.Err() != nil || !isTransient(reason) || attempt == maxModelRetries || !retry {
+			modelFailureCount.Add(reason, 1)
+			return nil, &modelCallError{model: name, attempts: attempt + 1, reason: reason, streamed: streamed, err: err}
+		}
+
+		log.Printf("Model %s failed (%s), retrying in %s: %v", name, reason, delay.Round(time.Millisecond), err)
+		modelRetryCount.Add(name, 1)
+		countRetry(ctx)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			modelFailureCount.Add(failureTimeout, 1)
+			return nil, &modelCallError{model: name, attempts: attempt + 1, reason: failureTimeout, err: errors.Join(err, ctx.Err())}
+		}
+	}
+}
+
+// retryCount counts the model retries made for one request
+type retryCount struct {
+	mu sync.Mutex
+	n  int
+}
+
+// Context key of the request's retry count
+type retryCountContextKey struct{}
+
+// countRetry adds a retry to the count of the context's request
+func countRetry(ctx context.Context) {
+	if c, _ := ctx.Value(retryCountContextKey{}).(*retryCount); c != nil {
+		c.mu.Lock()
+		c.n++
+		c.mu.Unlock()
+	}
+	if e := accessLogEntry(ctx); e != nil {
+		e.mu.Lock()
+		e.ModelRetries++
+		e.mu.Unlock()
+	}
+}
+
+// reportModelRetries sends the number of model retries a request needed in
+// the X-Model-Retries header, when there were any. Streamed responses send
+// their headers before the model answers, so they only report retries made
+// before the first chunk
+func reportModelRetries(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := &retryCount{}
+		rw := &retryHeaderWriter{ResponseWriter: w, count: count}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), retryCountContextKey{}, count)))
+	})
+}
+
+// retryHeaderWriter adds the retry count to the headers as they are sent
+type retryHeaderWriter struct {
+	http.ResponseWriter
+	count       *retryCount
+	wroteHeader bool
+}
+
+func (rw *retryHeaderWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.count.mu.Lock()
+		n := rw.count.n
+		rw.count.mu.Unlock()
+		if n > 0 {
+			rw.Header().Set(modelRetriesHeader, strconv.Itoa(n))
+		}
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *retryHeaderWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming
+func (rw *retryHeaderWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if !rw.wroteHeader {
+			rw.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection
+func (rw *retryHeaderWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	rw.wroteHeader = true
+	return h.Hijack()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Wraps a handler with behaviour shared by many routes
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h so a request passes through the middleware in order
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// routeGroup registers routes on a mux behind the group's middleware, so a
+// route added to a group picks up its logging, auth, limits and so on
+// without repeating them
+type routeGroup struct {
+	mux        *http.ServeMux
+	middleware []middleware
+}
+
+// newRouteGroup returns a top-level group of routes on mux
+func newRouteGroup(mux *http.ServeMux, mws ...middleware) *routeGroup {
+	return &routeGroup{mux: mux, middleware: mws}
+}
+
+// Group returns a group that runs this group's middleware and then mws
+func (rg *routeGroup) Group(mws ...middleware) *routeGroup {
+	return &routeGroup{mux: rg.mux, middleware: append(append([]middleware(nil), rg.middleware...), mws...)}
+}
+
+// Handle registers h for pattern behind the group's middleware
+func (rg *routeGroup) Handle(pattern string, h http.Handler) {
+	rg.mux.Handle(pattern, chain(h, rg.middleware...))
+}
+
+// HandleFunc registers f for pattern behind the group's middleware
+func (rg *routeGroup) HandleFunc(pattern string, f http.HandlerFunc) {
+	rg.Handle(pattern, f)
+}
+
+// statusRecorder notes the status a handler answered with and how many
+// body bytes it wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streamed responses streaming
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	sr.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Requests and total time spent, keyed by route pattern and status
+var (
+	requestCount    = expvar.NewMap("http_requests_total")
+	requestDuration = expvar.NewMap("http_request_duration_ms_total")
+)
+
+// recordMetrics counts requests per route and status and adds up the time
+// spent serving them
+func recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+		requestCount.Add(r.Pattern+" "+strconv.Itoa(sr.status), 1)
+		requestDuration.Add(r.Pattern, time.Since(start).Milliseconds())
+	})
+}
+
+// allowCORS lets browser clients on other origins call the API and answers
+// their preflight requests before auth, which preflights never carry
+func allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", "*")
+		h.Set("Access-Control-Expose-Headers", "X-Request-ID, ETag, Location, Retry-After, X-Model-Retries, X-RateLimit-Limit, X-RateLimit-Remaining")
+		if r.Method == http.MethodOptions {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key, If-Match, If-None-Match, X-API-Key, Authorization")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"google.golang.org/genai"
+)
+
+// Gemini harm categories and block thresholds by their config names, which
+// the config has already checked
+var (
+	harmCategories = map[string]genai.HarmCategory{
+		"harassment":       genai.HarmCategoryHarassment,
+		"hateSpeech":       genai.HarmCategoryHateSpeech,
+		"sexuallyExplicit": genai.HarmCategorySexuallyExplicit,
+		"dangerousContent": genai.HarmCategoryDangerousContent,
+		"civicIntegrity":   genai.HarmCategoryCivicIntegrity,
+	}
+	harmThresholds = map[string]genai.HarmBlockThreshold{
+		"off":                 genai.HarmBlockThresholdOff,
+		"blockNone":           genai.HarmBlockThresholdBlockNone,
+		"blockOnlyHigh":       genai.HarmBlockThresholdBlockOnlyHigh,
+		"blockMediumAndAbove": genai.HarmBlockThresholdBlockMediumAndAbove,
+		"blockLowAndAbove":    genai.HarmBlockThresholdBlockLowAndAbove,
+	}
+)
+
+// Safety settings sent with every Gemini call that doesn't set its own. Set
+// from the config at startup; nil keeps Gemini's defaults
+var safetySettings []*genai.SafetySetting
+
+// newSafetySettings turns the configured category thresholds into Gemini
+// safety settings
+func newSafetySettings(thresholds map[string]string) []*genai.SafetySetting {
+	var settings []*genai.SafetySetting
+	for _, name := range slices.Sorted(maps.Keys(thresholds)) {
+		settings = append(settings, &genai.SafetySetting{
+			Category:  harmCategories[name],
+			Threshold: harmThresholds[thresholds[name]],
+		})
+	}
+	return settings
+}
+
+// safetyBlockedError reports a generation the model refused on safety
+// grounds. Retrying the same request won't help, so it is answered with 422
+type safetyBlockedError struct {
+	reason string
+}
+
+func (e *safetyBlockedError) Error() string {
+	if e.reason == "" {
+		return "the response was blocked for safety reasons"
+	}
+	return "the response was blocked for safety reasons: " + e.reason
+}
+
+// blockedResponse returns an error for a response the safety filters
+// stopped: either the answer was cut off or, when the prompt itself was
+// blocked, there is no answer at all
+func blockedResponse(resp *ai.ModelResponse) error {
+	if resp.FinishReason == ai.FinishReasonBlocked || (resp.Message == nil && resp.FinishReason == "") {
+		return &safetyBlockedError{reason: resp.FinishMessage}
+	}
+	return nil
+}
+
+// generateChecked calls the model and reports blocked answers as errors
+func generateChecked(ctx context.Context, model ai.Model, req *ai.ModelRequest, cb ai.ModelStreamCallback) (resp *ai.ModelResponse, err error) {
+	if provider, _, _ := strings.Cut(model.Name(), "/"); provider == "googleai" || provider == "vertexai" {
+		defer recoverBlockedCandidate(&resp, &err)
+	}
+	resp, err = model.Generate(ctx, req, cb)
+	if err != nil {
+		return nil, err
+	}
+	if err := blockedResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// The Gemini plugin function that reads a candidate's content. A candidate
+// the safety filters blocked has none, and reading it panics
+const geminiCandidateReader = "github.com/firebase/genkit/go/plugins/googlegenai.translateCandidate"
+
+// recoverBlockedCandidate, deferred around a Gemini call, reports the panic
+// of the Gemini plugin reading a blocked candidate as a block. Any other
+// panic is passed on
+func recoverBlockedCandidate(resp **ai.ModelResponse, err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if _, ok := p.(runtime.Error); ok && panickedIn(geminiCandidateReader) {
+		*resp, *err = nil, &safetyBlockedError{reason: "the model returned no content"}
+		return
+	}
+	panic(p)
+}
+
+// panickedIn reports whether the panic being recovered was first raised in
+// the named function. Deferred functions that recover and panic again, such
+// as the tracing spans, stack further panics on top of it, so the frame that
+// counts is the one under the deepest panic
+func panickedIn(function string) bool {
+	pcs := make([]uintptr, 128)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(0, pcs)])
+	var origin string
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		switch {
+		case frame.Function == "runtime.gopanic":
+			panicking = true
+		case panicking && !strings.HasPrefix(frame.Function, "runtime."):
+			origin, panicking = frame.Function, false
+		}
+		if !more {
+			return origin == function
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Most servings a recipe can be scaled to
+const maxScaledServings = 100
+
+// Define input schema for recipe scaling requests
+type ScaleInput struct {
+	Servings int `json:"servings" jsonschema:"description=Number of servings to scale the recipe to,required=true"`
+}
+
+// Unicode vulgar fractions used in ingredient amounts
+var unicodeFractions = map[string]float64{
+	"¼": 0.25, "½": 0.5, "¾": 0.75, "⅓": 1.0 / 3, "⅔": 2.0 / 3, "⅛": 0.125,
+}
+
+// A leading amount: a mixed number, a fraction, a decimal or a unicode
+// fraction, optionally followed by the upper end of a range
+var ingredientAmountPattern = regexp.MustCompile(`^(\d+\s+\d+/\d+|\d+\s*[¼½¾⅓⅔⅛]|\d+/\d+|\d+(?:\.\d+)?|[¼½¾⅓⅔⅛])(?:\s*(?:-|–|to)\s*(\d+(?:\.\d+)?))?`)
+
+// parseAmount reads an amount matched by ingredientAmountPattern
+func parseAmount(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	var whole float64
+	if fields := strings.Fields(s); len(fields) == 2 {
+		w, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, false
+		}
+		whole, s = w, fields[1]
+	}
+	for symbol, value := range unicodeFractions {
+		if rest, ok := strings.CutSuffix(s, symbol); ok {
+			if rest == "" {
+				return whole + value, true
+			}
+			w, err := strconv.ParseFloat(rest, 64)
+			return whole + w + value, err == nil
+		}
+	}
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		n, err1 := strconv.ParseFloat(num, 64)
+		d, err2 := strconv.ParseFloat(den, 64)
+		if err1 != nil || err2 != nil || d == 0 {
+			return 0, false
+		}
+		return whole + n/d, true
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return whole + v, err == nil
+}
+
+// formatAmount writes an amount the way recipes do: whole numbers, common
+// fractions ("1 1/2") or a short decimal
+func formatAmount(v float64) string {
+	whole := math.Floor(v)
+	frac := v - whole
+	for _, f := range []struct {
+		value float64
+		text  string
+	}{{0, ""}, {0.25, "1/4"}, {1.0 / 3, "1/3"}, {0.5, "1/2"}, {2.0 / 3, "2/3"}, {0.75, "3/4"}, {1, ""}} {
+		if math.Abs(frac-f.value) < 0.02 {
+			if f.value == 1 {
+				whole++
+			}
+			switch {
+			case f.text == "":
+				return strconv.FormatFloat(whole, 'f', -1, 64)
+			case whole == 0:
+				return f.text
+			default:
+				return strconv.FormatFloat(whole, 'f', -1, 64) + " " + f.text
+			}
+		}
+	}
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// scaleIngredient multiplies the leading amount of an ingredient line.
+// Lines without one ("salt to taste") are returned unchanged
+func scaleIngredient(line string, factor float64) string {
+	m := ingredientAmountPattern.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line
+	}
+	low, ok := parseAmount(line[m[2]:m[3]])
+	if !ok {
+		return line
+	}
+	amount := formatAmount(low * factor)
+	if m[4] >= 0 {
+		high, err := strconv.ParseFloat(line[m[4]:m[5]], 64)
+		if err != nil {
+			return line
+		}
+		amount += "-" + formatAmount(high*factor)
+	}
+	return amount + line[m[1]:]
+}
+
+// scaleRecipeHandler stores a copy of a recipe scaled to a new number of
+// servings. Per-serving nutrition is unchanged; the cost scales with it
+func scaleRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	recipe, ok := savedRecipes.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+
+	var input ScaleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	if input.Servings < 1 || input.Servings > maxScaledServings {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid Servings", fmt.Sprintf("servings must be between 1 and %d", maxScaledServings))
+		return
+	}
+	if recipe.Servings < 1 {
+		writeError(w, http.StatusUnprocessableEntity, "Cannot Scale", "The recipe does not say how many servings it makes")
+		return
+	}
+
+	factor := float64(input.Servings) / float64(recipe.Servings)
+	scaled := *recipe
+	scaled.Servings = input.Servings
+	scaled.Ingredients = make([]string, len(recipe.Ingredients))
+	for i, ingredient := range recipe.Ingredients {
+		scaled.Ingredients[i] = scaleIngredient(ingredient, factor)
+	}
+	scaled.EstimatedCost = math.Round(recipe.EstimatedCost*factor*100) / 100
+	applyAllergens(&scaled, "")
+	if err := savedRecipes.save(r.Context(), &scaled, nil); err != nil {
+		log.Printf("Failed to save a scaled recipe: %v", err)
+		writeError(w, http.StatusInternalServerError, "Save Failed", "The scaled recipe could not be saved; try again later")
+		return
+	}
+
+	w.Header().Set("Location", scaled.Links["self"].Href)
+	writeJSON(w, http.StatusCreated, &scaled)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for seasonal suggestions
+type SeasonalInput struct {
+	Region         string `json:"region" jsonschema:"description=Country or region to suggest dishes for,required=true"`
+	Month          int    `json:"month,omitempty" jsonschema:"description=Month number 1-12 (default current month)"`
+	MaxSuggestions int    `json:"maxSuggestions,omitempty" jsonschema:"description=Maximum number of suggestions (default 5)"`
+}
+
+// A dish that makes use of in-season produce
+type SeasonalSuggestion struct {
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	Difficulty      string    `json:"difficulty"`
+	SeasonalProduce []string  `json:"seasonalProduce"`
+	RecipeRequest   FoodInput `json:"recipeRequest" jsonschema:"-"`
+}
+
+// Define output schema for seasonal suggestions
+type SeasonalSuggestions struct {
+	Region      string               `json:"region"`
+	Month       string               `json:"month"`
+	InSeason    []string             `json:"inSeason"`
+	Suggestions []SeasonalSuggestion `json:"suggestions"`
+}
+
+// Define the seasonal suggestion flow
+func defineSeasonalFlow(g *genkit.Genkit) *core.Flow[*SeasonalInput, *SeasonalSuggestions, struct{}] {
+	return genkit.DefineFlow(g, "seasonalFlow", func(ctx context.Context, input *SeasonalInput) (*SeasonalSuggestions, error) {
+		region := strings.TrimSpace(input.Region)
+		if region == "" {
+			return nil, newInputError("region is required")
+		}
+
+		month := time.Now().Month()
+		if input.Month != 0 {
+			if input.Month < 1 || input.Month > 12 {
+				return nil, newInputError("month must be between 1 and 12")
+			}
+			month = time.Month(input.Month)
+		}
+
+		maxSuggestions := input.MaxSuggestions
+		if maxSuggestions <= 0 {
+			maxSuggestions = 5
+		}
+
+		prompt := fmt.Sprintf(`Suggest up to %d dishes that make the most of produce in season in %s during %s.
+
+		Provide:
+		1. inSeason: the fruits, vegetables and herbs at their peak there that month
+		2. For each dish, its name, a brief description and difficulty level (easy, medium or hard)
+		3. seasonalProduce: the in-season items the dish uses
+
+		Favor dishes that are traditional or popular in the region.`,
+			maxSuggestions, region, month)
+
+		result, _, err := genkit.GenerateData[SeasonalSuggestions](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate seasonal suggestions: %w", err)
+		}
+
+		result.Region, result.Month = region, month.String()
+		if len(result.Suggestions) > maxSuggestions {
+			result.Suggestions = result.Suggestions[:maxSuggestions]
+		}
+		// Each suggestion carries a ready-made body for POST /api/recipe
+		for i := range result.Suggestions {
+			s := &result.Suggestions[i]
+			s.RecipeRequest = FoodInput{FoodName: s.Name, Difficulty: strings.ToLower(s.Difficulty)}
+		}
+		return result, nil
+	})
+}
+
+// parseMonth accepts a month number (1-12) or an English month name
+func parseMonth(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return n, nil
+	}
+	for m := time.January; m <= time.December; m++ {
+		name := m.String()
+		if strings.EqualFold(value, name) || strings.EqualFold(value, name[:3]) {
+			return int(m), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid month %q", value)
+}
+
+// seasonalHandler runs the seasonal flow with the region and month taken
+// from the query string
+func seasonalHandler(flow *core.Flow[*SeasonalInput, *SeasonalSuggestions, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		month, err := parseMonth(query.Get("month"))
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "Invalid Month", err.Error())
+			return
+		}
+
+		input := &SeasonalInput{Region: query.Get("region"), Month: month}
+		if limit := query.Get("limit"); limit != "" {
+			if input.MaxSuggestions, err = strconv.Atoi(limit); err != nil {
+				writeError(w, http.StatusUnprocessableEntity, "Invalid Limit", "limit must be a number")
+				return
+			}
+		}
+
+		suggestions, err := flow.Run(r.Context(), input)
+		if err != nil {
+			log.Printf("Error running %s: %v", flow.Name(), err)
+			writeFlowError(w, err, "Seasonal Suggestions Failed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, suggestions)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Hits and misses of the semantic recipe cache
+var semanticCacheCount = expvar.NewMap("semantic_cache_total")
+
+// semanticCache answers a recipe request with the recipe generated for an
+// earlier request that means the same, e.g. "pasta carbonara" and
+// "spaghetti alla carbonara". Only the dish and dietary restrictions are
+// compared by meaning; every other setting of the request must be equal
+type semanticCache struct {
+	index     *recipeIndex
+	store     vectorStore
+	threshold float64
+
+	mu  sync.Mutex
+	ids []string
+}
+
+// The running server's recipe cache, nil when turned off
+var recipeCache *semanticCache
+
+// newSemanticCache creates a cache answering requests at least threshold
+// similar to an earlier one, embedding them with the recipe index's embedder
+func newSemanticCache(index *recipeIndex, threshold float64) *semanticCache {
+	return &semanticCache{index: index, store: newMemoryVectorStore(), threshold: threshold}
+}
+
+// cacheText is the part of the request compared by meaning: the dish and
+// the dietary restrictions in their words, normalized
+func (req *recipeRequest) cacheText() string {
+	return strings.Join(strings.Fields(strings.ToLower(
+		fmt.Sprintf("%s; dietary restrictions: %s", req.FoodName, req.DietaryRestrictions))), " ")
+}
+
+// cacheVariant hashes the settings a cached recipe must have been generated
+// with, including the allergens the restrictions rule out, so requests that
+// read alike but exclude different foods never share a recipe
+func (req *recipeRequest) cacheVariant(promptVersion string) string {
+	data, _ := json.Marshal(struct {
+		Difficulty, Language, Model, PromptVersion                       string
+		ServingSize                                                      int
+		KidFriendly, BakingMode, IncludeImage, IncludePairings, Grounded bool
+		Budget                                                           *recipeBudget
+		Params                                                           GenerationParams
+		Restricted, Avoided                                              []string
+	}{
+		strings.ToLower(req.Difficulty), req.Language, req.Model, promptVersion,
+		req.ServingSize,
+		req.KidFriendly, req.BakingMode, req.IncludeImage, req.IncludePairings, req.Grounded,
+		req.Budget,
+		req.Params,
+		slices.Sorted(maps.Keys(restrictedAllergens(req.DietaryRestrictions))),
+		avoidedIngredients(req.AvoidIngredients),
+	})
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// A cache lookup: the request's embedding and variant, kept to store the
+// recipe under if the lookup missed
+type cacheKey struct {
+	vector  []float32
+	variant string
+}
+
+// lookup returns a stored recipe generated for a request like req. On a
+// miss it returns the key to add the new recipe under, or nil when the
+// request couldn't be embedded
+func (c *semanticCache) lookup(ctx context.Context, req *recipeRequest, promptVersion string) (*FoodRecipe, *cacheKey) {
+	if c == nil {
+		return nil, nil
+	}
+	vectors, err := c.index.embed(ctx, req.cacheText())
+	if err != nil {
+		log.Printf("Semantic cache skipped: %v", err)
+		return nil, nil
+	}
+	key := &cacheKey{vector: vectors[0], variant: req.cacheVariant(promptVersion)}
+
+	matches, err := c.store.query(ctx, key.vector, 1, map[string]string{"variant": key.variant})
+	if err != nil {
+		log.Printf("Semantic cache skipped: %v", err)
+		return nil, key
+	}
+	if len(matches) == 1 && matches[0].Score >= c.threshold {
+		if recipe, ok := savedRecipes.get(matches[0].Metadata["recipe"]); ok {
+			semanticCacheCount.Add("hit", 1)
+			recipe.Cached = true
+			return recipe, nil
+		}
+		// The recipe expired, so the entry can't answer anyone
+		c.store.delete(ctx, matches[0].ID)
+	}
+	semanticCacheCount.Add("miss", 1)
+	return nil, key
+}
+
+// add stores a generated recipe under the key of the lookup that missed,
+// dropping the oldest entries beyond the number of recipes kept
+func (c *semanticCache) add(ctx context.Context, key *cacheKey, recipe *FoodRecipe) {
+	if c == nil || key == nil {
+		return
+	}
+	id := newID()
+	err := c.store.upsert(ctx, vectorDoc{ID: id, Vector: key.vector, Metadata: map[string]string{"variant": key.variant, "recipe": recipe.ID}})
+	if err != nil {
+		log.Printf("Failed to cache recipe %s: %v", recipe.ID, err)
+		return
+	}
+
+	c.mu.Lock()
+	c.ids = append(c.ids, id)
+	var expired []string
+	if len(c.ids) > maxRecentRecipes {
+		expired = slices.Clone(c.ids[:len(c.ids)-maxRecentRecipes])
+		c.ids = slices.Delete(c.ids, 0, len(expired))
+	}
+	c.mu.Unlock()
+	if len(expired) > 0 {
+		c.store.delete(ctx, expired...)
+	}
+}
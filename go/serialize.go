@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/goccy/go-yaml"
+)
+
+// A response encoder for one media type. Encoders work from the JSON form of
+// a value so every format uses the same field names
+type responseSerializer struct {
+	contentType string
+	encode      func(w io.Writer, v any) error
+}
+
+// Response serializers keyed by media type
+var responseSerializers = map[string]*responseSerializer{}
+
+// Serializer used when the client accepts anything
+var defaultSerializer = registerSerializer("application/json", "application/json", encodeJSON)
+
+func init() {
+	yamlSerializer := registerSerializer("application/yaml", "application/yaml", encodeYAML)
+	responseSerializers["application/x-yaml"] = yamlSerializer
+	responseSerializers["text/yaml"] = yamlSerializer
+
+	xmlSerializer := registerSerializer("application/xml", "application/xml; charset=utf-8", encodeXML)
+	responseSerializers["text/xml"] = xmlSerializer
+}
+
+// registerSerializer adds an encoder for a media type and returns it
+func registerSerializer(mediaType, contentType string, encode func(io.Writer, any) error) *responseSerializer {
+	s := &responseSerializer{contentType: contentType, encode: encode}
+	responseSerializers[mediaType] = s
+	return s
+}
+
+// negotiateSerializer picks the serializer for an Accept header, preferring
+// higher quality values; it returns false when nothing acceptable is
+// registered
+func negotiateSerializer(accept string) (*responseSerializer, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return defaultSerializer, true
+	}
+
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	for _, c := range candidates {
+		if c.mediaType == "*/*" || c.mediaType == "application/*" {
+			return defaultSerializer, true
+		}
+		if s, ok := responseSerializers[c.mediaType]; ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// writeSerialized encodes v with the serializer as the response body
+func writeSerialized(w http.ResponseWriter, s *responseSerializer, status int, v any) {
+	var buf bytes.Buffer
+	if err := s.encode(&buf, v); err != nil {
+		log.Printf("Error encoding %s response: %v", s.contentType, err)
+		writeError(w, http.StatusInternalServerError, "Encoding Failed", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", s.contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// encodeJSON writes v as JSON, the same way writeJSON does
+func encodeJSON(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// encodeYAML writes v as YAML with its JSON field names and order
+func encodeYAML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	out, err := yaml.JSONToYAML(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// encodeXML writes v as XML with elements named after its JSON fields. The
+// root element is named after the Go type, and array entries are <item>
+// elements
+func encodeXML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	io.WriteString(w, xml.Header)
+	if err := writeXMLValue(enc, dec, xmlRootName(v)); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// xmlRootName lower-cases the first letter of the value's type name
+func xmlRootName(v any) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Name() == "" {
+		return "response"
+	}
+	r, size := utf8.DecodeRuneInString(t.Name())
+	return string(unicode.ToLower(r)) + t.Name()[size:]
+}
+
+// writeXMLValue reads the next JSON value from dec and writes it as an
+// element with the given name
+func writeXMLValue(enc *xml.Encoder, dec *json.Decoder, name string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for dec.More() {
+			child := "item"
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = key.(string)
+			}
+			if err := writeXMLValue(enc, dec, child); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	default:
+		return enc.EncodeElement(fmt.Sprint(t), start)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dinocodesx/genkit-go/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Root context of the process. It is cancelled when shutdown stops waiting,
+// which aborts any generation still running
+var appCtx = context.Background()
+
+// Work still running after its request finished, such as callbacks and dish
+// images, which shutdown waits for along with in-flight requests
+var backgroundWork sync.WaitGroup
+
+// goBackground runs fn once its request has been answered. The context keeps
+// the request's values but not its cancellation; it ends after timeout or
+// when the server gives up draining
+func goBackground(ctx context.Context, timeout time.Duration, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	stop := context.AfterFunc(appCtx, cancel)
+	backgroundWork.Add(1)
+	go func() {
+		defer backgroundWork.Done()
+		defer cancel()
+		defer stop()
+		fn(ctx)
+	}()
+}
+
+// waitBackground waits for background work until ctx is done
+func waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		backgroundWork.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serve runs the server until SIGINT or SIGTERM. It then stops accepting
+// connections and waits up to the shutdown timeout for in-flight requests
+// and background work, before calling cancel to abort whatever is left. A
+// second signal exits immediately
+func serve(cfg *config.Config, handler http.Handler, cancel context.CancelFunc) error {
+	defer cancel()
+
+	listeners, err := listen(cfg.Listeners())
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler: handler,
+		// Requests are cancelled along with the process context
+		BaseContext: func(net.Listener) context.Context { return appCtx },
+	}
+	servers := []*http.Server{srv}
+
+	// With Let's Encrypt certificates the redirect server also answers the
+	// HTTP-01 challenges
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS(cfg.Port))
+	if len(cfg.AutocertDomains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+		}
+		srv.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+	}
+	if cfg.HTTPRedirectPort != 0 {
+		servers = append(servers, &http.Server{
+			Addr:              cfg.RedirectAddr(),
+			Handler:           redirect,
+			ReadHeaderTimeout: 10 * time.Second,
+		})
+	}
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errChan := make(chan error, len(servers)+len(listeners))
+	for _, l := range listeners {
+		log.Printf("Listening on %s %s", l.Addr().Network(), l.Addr())
+		go func() {
+			if cfg.TLS() {
+				// Certificate files are empty with autocert, which supplies them
+				errChan <- srv.ServeTLS(l, cfg.TLSCertFile, cfg.TLSKeyFile)
+			} else {
+				errChan <- srv.Serve(l)
+			}
+		}()
+	}
+	for _, s := range servers[1:] {
+		go func() {
+			errChan <- s.ListenAndServe()
+		}()
+	}
+
+	serverReady.Store(true)
+
+	select {
+	case err := <-errChan:
+		for _, s := range servers {
+			s.Close()
+		}
+		return fmt.Errorf("server error: %w", err)
+	case <-signals.Done():
+	}
+
+	// Restore default signal handling so a second signal kills the process
+	stop()
+	serverReady.Store(false)
+	drainTimeout := time.Duration(cfg.ShutdownTimeout)
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", drainTimeout)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+
+	for _, s := range servers {
+		err = errors.Join(err, s.Shutdown(drainCtx))
+	}
+	if err == nil {
+		err = waitBackground(drainCtx)
+	}
+	if err != nil {
+		cancel()
+		for _, s := range servers {
+			s.Close()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("shutdown deadline of %s exceeded, cancelled remaining requests", drainTimeout)
+		}
+		return fmt.Errorf("failed to shutdown server: %w", err)
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+// listen opens the listeners: TCP for host:port, a Unix socket for
+// unix:/path, and the inherited sockets for "systemd". Listeners opened
+// before a failure are closed
+func listen(addrs []string) (listeners []net.Listener, err error) {
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+		}
+	}()
+	for _, addr := range addrs {
+		switch path, unix := strings.CutPrefix(addr, "unix:"); {
+		case addr == "systemd":
+			inherited, err := systemdListeners()
+			if err != nil {
+				return listeners, err
+			}
+			listeners = append(listeners, inherited...)
+		case unix:
+			// A socket left behind by an unclean exit would block the bind
+			if fi, err := os.Stat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+				os.Remove(path)
+			}
+			l, err := net.Listen("unix", path)
+			if err != nil {
+				return listeners, err
+			}
+			listeners = append(listeners, l)
+		default:
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				return listeners, err
+			}
+			listeners = append(listeners, l)
+		}
+	}
+	return listeners, nil
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation,
+// which start at file descriptor 3
+func systemdListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_PID is not this process)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_FDS is not set)")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var listeners []net.Listener
+	for i := range n {
+		name := "systemd"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd socket %s: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS on
+// the given port
+func redirectToHTTPS(httpsPort int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	}
+}
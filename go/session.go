@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const (
+	// Idle time after which a cook-along session is discarded
+	chatSessionTTL = 2 * time.Hour
+
+	// Most recent messages sent to the model as conversation history
+	maxChatHistory = 20
+)
+
+// A single message in a cook-along conversation
+type ChatTurn struct {
+	Role string `json:"role"`
+	Text string `json:"text"`
+}
+
+// chatSession is the conversation about a single recipe
+type chatSession struct {
+	recipe  FoodRecipe
+	history []ChatTurn
+	updated time.Time
+}
+
+// chatSessionStore keeps cook-along sessions in memory
+type chatSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*chatSession
+}
+
+// Active cook-along sessions
+var chatSessions = &chatSessionStore{sessions: make(map[string]*chatSession)}
+
+// create starts a new session for the recipe and returns its id
+func (s *chatSessionStore) create(recipe FoodRecipe) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if time.Since(session.updated) > chatSessionTTL {
+			delete(s.sessions, id)
+		}
+	}
+
+	id := newID()
+	s.sessions[id] = &chatSession{recipe: recipe, updated: time.Now()}
+	return id
+}
+
+// get returns a copy of the session's recipe and history
+func (s *chatSessionStore) get(id string) (FoodRecipe, []ChatTurn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Since(session.updated) > chatSessionTTL {
+		return FoodRecipe{}, nil, false
+	}
+	return session.recipe, append([]ChatTurn(nil), session.history...), true
+}
+
+// append records new turns in the session's history
+func (s *chatSessionStore) append(id string, turns ...ChatTurn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok {
+		session.history = append(session.history, turns...)
+		session.updated = time.Now()
+	}
+}
+
+// Define input schema for cook-along chat messages
+type ChatMessageInput struct {
+	SessionID string `json:"sessionId" jsonschema:"description=Session returned by POST /api/session,required=true"`
+	Message   string `json:"message" jsonschema:"description=Question about the recipe,required=true"`
+}
+
+// Define output schema for cook-along chat replies
+type ChatReply struct {
+	SessionID string     `json:"sessionId"`
+	Reply     string     `json:"reply"`
+	History   []ChatTurn `json:"history"`
+}
+
+// Define the cook-along chat flow, which answers questions about the
+// session's recipe with the conversation so far as context
+func defineChatFlow(g *genkit.Genkit) *core.Flow[*ChatMessageInput, *ChatReply, struct{}] {
+	return genkit.DefineFlow(g, "cookAlongChatFlow", func(ctx context.Context, input *ChatMessageInput) (*ChatReply, error) {
+		if strings.TrimSpace(input.Message) == "" {
+			return nil, newInputError("message is required")
+		}
+
+		recipe, history, ok := chatSessions.get(input.SessionID)
+		if !ok {
+			return nil, newInputError("session %q does not exist or has expired", input.SessionID)
+		}
+
+		recipeJSON, err := json.Marshal(recipe)
+		if err != nil {
+			return nil, err
+		}
+
+		system := fmt.Sprintf(`You are a friendly cooking assistant helping someone cook the recipe below
+		step by step. Answer follow-up questions about ingredients, substitutions, timing and
+		technique briefly and practically, and say so when a change would affect food safety.
+
+		Recipe: %s`, recipeJSON)
+
+		if len(history) > maxChatHistory {
+			history = history[len(history)-maxChatHistory:]
+		}
+		var messages []*ai.Message
+		for _, turn := range history {
+			if turn.Role == string(ai.RoleModel) {
+				messages = append(messages, ai.NewModelTextMessage(turn.Text))
+			} else {
+				messages = append(messages, ai.NewUserTextMessage(turn.Text))
+			}
+		}
+		messages = append(messages, ai.NewUserTextMessage(input.Message))
+
+		resp, err := genkit.Generate(ctx, g,
+			ai.WithSystem(system),
+			ai.WithMessages(messages...),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to answer message: %w", err)
+		}
+
+		turns := []ChatTurn{
+			{Role: string(ai.RoleUser), Text: input.Message},
+			{Role: string(ai.RoleModel), Text: resp.Text()},
+		}
+		chatSessions.append(input.SessionID, turns...)
+
+		return &ChatReply{
+			SessionID: input.SessionID,
+			Reply:     resp.Text(),
+			History:   append(history, turns...),
+		}, nil
+	})
+}
+
+// Define input schema for starting a cook-along session
+type SessionInput struct {
+	Recipe FoodRecipe `json:"recipe" jsonschema:"description=Recipe as returned by POST /api/recipe,required=true"`
+}
+
+// createSessionHandler starts a cook-along session for a recipe
+func createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input SessionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	if strings.TrimSpace(input.Recipe.Name) == "" {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid Recipe", "recipe name is required")
+		return
+	}
+
+	id := chatSessions.create(input.Recipe)
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"sessionId": id,
+		"recipe":    input.Recipe.Name,
+		"messages":  "/api/session/" + id + "/message",
+	})
+}
+
+// sessionMessageHandler sends a message to the session named in the path
+func sessionMessageHandler(flow *core.Flow[*ChatMessageInput, *ChatReply, struct{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input ChatMessageInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+		input.SessionID = r.PathValue("id")
+
+		reply, err := flow.Run(r.Context(), &input)
+		if err != nil {
+			log.Printf("Error answering session message: %v", err)
+			writeFlowError(w, err, "Message Failed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, reply)
+	}
+}
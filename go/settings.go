@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dinocodesx/genkit-go/config"
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/googlegenai"
+)
+
+// Name of the model the flows generate with. It forwards each request to
+// the model currently selected in the runtime settings
+const runtimeModelName = "app/default"
+
+// Most settings changes kept in the audit log
+const maxSettingsChanges = 100
+
+// Settings that can be changed while the server runs
+type RuntimeSettings struct {
+	Model string `json:"model" jsonschema:"description=Default model\\, as provider/name"`
+
+	// Generation parameters for calls that don't set their own; the model
+	// defaults when unset
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	TopK            *int     `json:"topK,omitempty"`
+	MaxOutputTokens *int     `json:"maxOutputTokens,omitempty"`
+
+	RateLimit      int             `json:"rateLimit" jsonschema:"description=Requests per minute per client\\, 0 to disable"`
+	RateLimitBurst int             `json:"rateLimitBurst"`
+	IdempotencyTTL config.Duration `json:"idempotencyTTL" jsonschema:"type=string"`
+	DeepHealthTTL  config.Duration `json:"deepHealthTTL" jsonschema:"type=string"`
+	Fallbacks      []string        `json:"fallbacks,omitempty" jsonschema:"description=Models tried in order when the chosen one fails with a quota or server error"`
+
+	// While on, most /api routes answer 503 with this message and a
+	// Retry-After of this long
+	Maintenance           bool            `json:"maintenance"`
+	MaintenanceMessage    string          `json:"maintenanceMessage,omitempty"`
+	MaintenanceRetryAfter config.Duration `json:"maintenanceRetryAfter" jsonschema:"type=string"`
+}
+
+// A partial update of the runtime settings; fields left out are unchanged
+type RuntimeSettingsPatch struct {
+	Model           *string  `json:"model,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	TopK            *int     `json:"topK,omitempty"`
+	MaxOutputTokens *int     `json:"maxOutputTokens,omitempty"`
+
+	RateLimit      *int             `json:"rateLimit,omitempty"`
+	RateLimitBurst *int             `json:"rateLimitBurst,omitempty"`
+	IdempotencyTTL *config.Duration `json:"idempotencyTTL,omitempty" jsonschema:"type=string"`
+	DeepHealthTTL  *config.Duration `json:"deepHealthTTL,omitempty" jsonschema:"type=string"`
+	Fallbacks      *[]string        `json:"fallbacks,omitempty"`
+
+	Maintenance           *bool            `json:"maintenance,omitempty"`
+	MaintenanceMessage    *string          `json:"maintenanceMessage,omitempty"`
+	MaintenanceRetryAfter *config.Duration `json:"maintenanceRetryAfter,omitempty" jsonschema:"type=string"`
+}
+
+// One setting's old and new value
+type SettingChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// An audit log entry for a settings update
+type SettingsChange struct {
+	At      time.Time                `json:"at"`
+	Actor   string                   `json:"actor"`
+	Changes map[string]SettingChange `json:"changes"`
+}
+
+var (
+	// The current settings, replaced as a whole so readers never see half
+	// of an update
+	runtimeSettings atomic.Pointer[RuntimeSettings]
+
+	// Serializes updates and guards the audit log
+	settingsMu      sync.Mutex
+	settingsChanges []SettingsChange
+)
+
+// currentSettings returns the settings in effect; callers must not modify them
+func currentSettings() *RuntimeSettings {
+	return runtimeSettings.Load()
+}
+
+// newRuntimeSettings takes the startup values from the config
+func newRuntimeSettings(cfg *config.Config) *RuntimeSettings {
+	return &RuntimeSettings{
+		Model:           cfg.Model,
+		Temperature:     cfg.Temperature,
+		TopP:            cfg.TopP,
+		TopK:            cfg.TopK,
+		MaxOutputTokens: cfg.MaxOutputTokens,
+		RateLimit:       cfg.RateLimit,
+		RateLimitBurst:  cfg.RateLimitBurst,
+		IdempotencyTTL:  cfg.IdempotencyTTL,
+		DeepHealthTTL:   cfg.DeepHealthTTL,
+		Fallbacks:       cfg.ModelFallbacks,
+
+		MaintenanceRetryAfter: config.Duration(5 * time.Minute),
+	}
+}
+
+// apply returns a copy of s with the patch applied, checking the new
+// values; the model must be registered with Genkit
+func (s *RuntimeSettings) apply(g *genkit.Genkit, patch *RuntimeSettingsPatch) (*RuntimeSettings, error) {
+	next := *s
+	var errs []error
+	if patch.Model != nil {
+		if *patch.Model == runtimeModelName || genkit.LookupModel(g, *patch.Model) == nil {
+			errs = append(errs, fmt.Errorf("model %q is not available", *patch.Model))
+		}
+		next.Model = *patch.Model
+	}
+	if patch.Fallbacks != nil {
+		for _, name := range *patch.Fallbacks {
+			if name == runtimeModelName || genkit.LookupModel(g, name) == nil {
+				errs = append(errs, fmt.Errorf("fallback model %q is not available", name))
+			}
+		}
+		next.Fallbacks = *patch.Fallbacks
+	}
+	if patch.Temperature != nil {
+		next.Temperature = patch.Temperature
+	}
+	if patch.TopP != nil {
+		next.TopP = patch.TopP
+	}
+	if patch.TopK != nil {
+		next.TopK = patch.TopK
+	}
+	if patch.MaxOutputTokens != nil {
+		next.MaxOutputTokens = patch.MaxOutputTokens
+	}
+	params := next.generationParams()
+	for _, e := range params.validate() {
+		errs = append(errs, errors.New(e.Field+" "+e.Message))
+	}
+	if patch.RateLimit != nil {
+		if *patch.RateLimit < 0 {
+			errs = append(errs, errors.New("rateLimit must not be negative"))
+		}
+		next.RateLimit = *patch.RateLimit
+	}
+	if patch.RateLimitBurst != nil {
+		next.RateLimitBurst = *patch.RateLimitBurst
+	}
+	if next.RateLimit > 0 && next.RateLimitBurst < 1 {
+		errs = append(errs, errors.New("rateLimitBurst must be at least 1"))
+	}
+	if patch.IdempotencyTTL != nil {
+		if *patch.IdempotencyTTL <= 0 {
+			errs = append(errs, errors.New("idempotencyTTL must be positive"))
+		}
+		next.IdempotencyTTL = *patch.IdempotencyTTL
+	}
+	if patch.DeepHealthTTL != nil {
+		if *patch.DeepHealthTTL <= 0 {
+			errs = append(errs, errors.New("deepHealthTTL must be positive"))
+		}
+		next.DeepHealthTTL = *patch.DeepHealthTTL
+	}
+	if patch.Maintenance != nil {
+		next.Maintenance = *patch.Maintenance
+	}
+	if patch.MaintenanceMessage != nil {
+		next.MaintenanceMessage = *patch.MaintenanceMessage
+	}
+	if patch.MaintenanceRetryAfter != nil {
+		if *patch.MaintenanceRetryAfter < config.Duration(time.Second) {
+			errs = append(errs, errors.New("maintenanceRetryAfter must be at least 1s"))
+		}
+		next.MaintenanceRetryAfter = *patch.MaintenanceRetryAfter
+	}
+	return &next, errors.Join(errs...)
+}
+
+// generationParams returns the default generation parameters
+func (s *RuntimeSettings) generationParams() *GenerationParams {
+	return &GenerationParams{Temperature: s.Temperature, TopP: s.TopP, TopK: s.TopK, MaxOutputTokens: s.MaxOutputTokens}
+}
+
+// settingsDiff lists the settings that differ between old and new, by
+// their JSON names
+func settingsDiff(old, new *RuntimeSettings) map[string]SettingChange {
+	changes := make(map[string]SettingChange)
+	ov, nv := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	for i := range ov.NumField() {
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		name, _, _ := strings.Cut(ov.Type().Field(i).Tag.Get("json"), ",")
+		changes[name] = SettingChange{From: a, To: b}
+	}
+	return changes
+}
+
+// requestActor names who made a request, for the audit log
+func requestActor(ctx context.Context) string {
+	if user, ok := requestUser(ctx); ok {
+		return "user:" + user
+	}
+	if key, ok := requestKey(ctx); ok {
+		return "key:" + key.ID
+	}
+	return "anonymous"
+}
+
+// defineRuntimeModel registers the model the flows use by default. It
+// forwards each request to the model the request asked for or else the
+// one in the runtime settings, with the configured generation parameters
+// where the call doesn't set its own, and falls back along the configured
+// chain
+func defineRuntimeModel(g *genkit.Genkit) ai.Model {
+	supports := googlegenai.Multimodal
+	return genkit.DefineModel(g, runtimeModelName, &ai.ModelOptions{Label: "Runtime default model", Supports: &supports},
+		func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			settings := currentSettings()
+			name := settings.Model
+			if requested, ok := requestedModel(ctx); ok {
+				name = requested
+			}
+			for key, value := range settings.generationParams().config() {
+				req = withDefaultConfig(req, key, value)
+			}
+			if safetySettings != nil {
+				req = withDefaultConfig(req, "safetySettings", safetySettings)
+			}
+			return generateWithFallbacks(ctx, g, modelChain(name, settings.Fallbacks), req, cb)
+		})
+}
+
+// withDefaultConfig returns req with a config value set, unless the request
+// config already has one. Only untyped configs are merged; a typed config
+// belongs to a call that chose its own settings
+func withDefaultConfig(req *ai.ModelRequest, key string, value any) *ai.ModelRequest {
+	cfg, ok := req.Config.(map[string]any)
+	if req.Config != nil && !ok {
+		return req
+	}
+	if _, set := cfg[key]; set {
+		return req
+	}
+	merged := map[string]any{key: value}
+	for k, v := range cfg {
+		merged[k] = v
+	}
+	r := *req
+	r.Config = merged
+	return &r
+}
+
+// requireAdminAPI hides the admin API unless credentials are configured,
+// since without them requireAuth lets every request through
+func requireAdminAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminAPIKey == "" && jwtVerifier == nil {
+			writeError(w, http.StatusForbidden, "Admin API Disabled", "Set ADMIN_API_KEY or JWT_ISSUER to use the admin API")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getSettingsHandler returns the runtime settings in effect
+func getSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, currentSettings())
+}
+
+// updateSettingsHandler changes runtime settings. All changes in a request
+// apply together or not at all, and each update is logged and audited
+func updateSettingsHandler(g *genkit.Genkit) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch RuntimeSettingsPatch
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&patch); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input: "+err.Error())
+			return
+		}
+
+		settingsMu.Lock()
+		defer settingsMu.Unlock()
+
+		old := currentSettings()
+		next, err := old.apply(g, &patch)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "Invalid Settings", err.Error())
+			return
+		}
+		changes := settingsDiff(old, next)
+		if len(changes) > 0 {
+			runtimeSettings.Store(next)
+
+			change := SettingsChange{At: time.Now().UTC(), Actor: requestActor(r.Context()), Changes: changes}
+			settingsChanges = append(settingsChanges, change)
+			if len(settingsChanges) > maxSettingsChanges {
+				settingsChanges = settingsChanges[len(settingsChanges)-maxSettingsChanges:]
+			}
+			audit, _ := json.Marshal(changes)
+			log.Printf("Runtime settings changed by %s: %s", change.Actor, audit)
+		}
+
+		writeJSON(w, http.StatusOK, next)
+	}
+}
+
+// settingsAuditHandler lists recent settings changes, newest first
+func settingsAuditHandler(w http.ResponseWriter, r *http.Request) {
+	settingsMu.Lock()
+	changes := make([]SettingsChange, len(settingsChanges))
+	for i, c := range settingsChanges {
+		changes[len(changes)-1-i] = c
+	}
+	settingsMu.Unlock()
+
+	writeJSON(w, http.StatusOK, changes)
+}
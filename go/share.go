@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR code sizes in pixels
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
+)
+
+// A share link to the HTML page of a recipe
+type ShareLink struct {
+	Slug      string `json:"slug"`
+	RecipeID  string `json:"recipeId"`
+	URL       string `json:"url"`
+	QRCodeURL string `json:"qrCodeUrl"`
+}
+
+// shareStore maps slugs to recipe ids. A recipe keeps one slug, and slugs
+// go when their recipe expires
+type shareStore struct {
+	mu       sync.Mutex
+	slugs    map[string]string
+	byRecipe map[string]string
+}
+
+// Share links created for recipes
+var shareLinks = &shareStore{slugs: make(map[string]string), byRecipe: make(map[string]string)}
+
+// newSlug returns a random 72-bit slug, short enough to type from a printout
+// and too long to guess
+func newSlug() string {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// slugFor returns the recipe's slug, creating it on first use
+func (s *shareStore) slugFor(recipeID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for slug, id := range s.slugs {
+		if _, ok := savedRecipes.get(id); !ok {
+			delete(s.slugs, slug)
+			delete(s.byRecipe, id)
+		}
+	}
+	if slug, ok := s.byRecipe[recipeID]; ok {
+		return slug
+	}
+	slug := newSlug()
+	s.slugs[slug] = recipeID
+	s.byRecipe[recipeID] = slug
+	return slug
+}
+
+// recipe returns the recipe a slug points at
+func (s *shareStore) recipe(slug string) (*FoodRecipe, bool) {
+	s.mu.Lock()
+	id, ok := s.slugs[slug]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return savedRecipes.get(id)
+}
+
+// baseURL returns the scheme and host the client used to reach the server
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// shareRecipeHandler creates (or returns) the share link of a recipe
+func shareRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := savedRecipes.get(id); !ok {
+		writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+		return
+	}
+
+	slug := shareLinks.slugFor(id)
+	link := ShareLink{
+		Slug:      slug,
+		RecipeID:  id,
+		URL:       baseURL(r) + "/s/" + slug,
+		QRCodeURL: baseURL(r) + "/api/share/" + slug + "/qr.png",
+	}
+	w.Header().Set("Location", link.URL)
+	writeJSON(w, http.StatusCreated, link)
+}
+
+// shareQRHandler serves a PNG QR code of a share link's page URL
+func shareQRHandler(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	if _, ok := shareLinks.recipe(slug); !ok {
+		writeError(w, http.StatusNotFound, "Share Link Not Found", "The share link does not exist or its recipe has expired")
+		return
+	}
+
+	size := defaultQRSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			writeError(w, http.StatusBadRequest, "Invalid Size", fmt.Sprintf("size must be between %d and %d pixels", minQRSize, maxQRSize))
+			return
+		}
+		size = n
+	}
+
+	png, err := qrcode.Encode(baseURL(r)+"/s/"+slug, qrcode.Medium, size)
+	if err != nil {
+		log.Printf("Error encoding QR code: %v", err)
+		writeError(w, http.StatusInternalServerError, "QR Code Failed", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Length", strconv.Itoa(len(png)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+// Page shown for a share link, large enough to read on a kitchen tablet.
+// The schema.org JSON-LD lets link previews and search engines read it too
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Recipe.Name}}</title>
+  <meta property="og:title" content="{{.Recipe.Name}}">
+  <meta property="og:description" content="{{.Recipe.Description}}">
+  <script type="application/ld+json">{{.JSONLD}}</script>
+  <style>
+    body { font-family: system-ui, sans-serif; max-width: 46rem; margin: 2rem auto; padding: 0 1rem; font-size: 1.15rem; line-height: 1.6; color: #222; }
+    .facts { background: #f0ece4; padding: .5rem 1rem; border-radius: .4rem; }
+    li { margin-bottom: .4rem; }
+    img { max-width: 100%; border-radius: .4rem; }
+  </style>
+</head>
+<body>
+  <h1>{{.Recipe.Name}}</h1>
+  {{with .Recipe.Description}}<p><em>{{.}}</em></p>{{end}}
+  {{with .Image}}<img src="{{.}}" alt="{{$.Recipe.Name}}">{{end}}
+  <p class="facts">
+    {{with .Recipe.PrepTime}}Prep: {{.}} · {{end}}{{with .Recipe.CookTime}}Cook: {{.}} · {{end}}{{with .Recipe.TotalTime}}Total: {{.}} · {{end}}Serves {{.Recipe.Servings}}
+  </p>
+  {{with .Recipe.Allergens}}<p><strong>Contains:</strong> {{range $i, $a := .}}{{if $i}}, {{end}}{{$a}}{{end}}</p>{{end}}
+  <h2>Ingredients</h2>
+  <ul>{{range .Recipe.Ingredients}}
+    <li>{{.}}</li>{{end}}
+  </ul>
+  <h2>Instructions</h2>
+  <ol>{{range .Recipe.Instructions}}
+    <li>{{.}}</li>{{end}}
+  </ol>
+  {{with .Recipe.Tips}}<h2>Tips</h2>
+  <ul>{{range .}}
+    <li>{{.}}</li>{{end}}
+  </ul>{{end}}
+</body>
+</html>
+`))
+
+// sharePageHandler renders the recipe behind a share link as HTML
+func sharePageHandler(w http.ResponseWriter, r *http.Request) {
+	recipe, ok := shareLinks.recipe(r.PathValue("slug"))
+	if !ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("This recipe link has expired.\n"))
+		return
+	}
+
+	if notModified(w, r, contentETag(recipe, "html")) {
+		return
+	}
+
+	data := struct {
+		Recipe *FoodRecipe
+		JSONLD RecipeJSONLD
+		Image  string
+	}{Recipe: recipe, JSONLD: toJSONLD(recipe)}
+	if recipe.Image != nil && recipe.Image.Status == imageStatusReady {
+		data.Image = recipe.Image.URL
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := sharePage.Execute(w, data); err != nil {
+		log.Printf("Error rendering share page: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Delimited export formats for shopping lists, keyed by file extension
+var shoppingListFormats = map[string]struct {
+	contentType string
+	comma       rune
+}{
+	".csv": {"text/csv; charset=utf-8", ','},
+	".tsv": {"text/tab-separated-values; charset=utf-8", '\t'},
+}
+
+// A leading amount (e.g. "2", "1.5", "1 1/2", "½", "2-3") followed by the rest
+// of the quantity
+var quantityPattern = regexp.MustCompile(`^\s*((?:\d+(?:[.,]\d+)?\s*)?(?:\d+/\d+|[¼½¾⅓⅔⅛])?(?:\s*-\s*\d+(?:[.,]\d+)?)?)\s*(.*)$`)
+
+// splitQuantity separates a free-text quantity such as "2 lb" into its amount
+// and unit. Quantities without a leading amount ("a pinch") are returned as
+// the unit
+func splitQuantity(quantity string) (amount, unit string) {
+	m := quantityPattern.FindStringSubmatch(quantity)
+	if m == nil {
+		return "", strings.TrimSpace(quantity)
+	}
+	return strings.TrimSpace(m[1]), strings.TrimSpace(m[2])
+}
+
+// shoppingListDelimited writes a shopping list as delimited rows of item,
+// quantity, unit and aisle with a header row
+func shoppingListDelimited(list *ShoppingList, comma rune) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = comma
+	w.Write([]string{"item", "quantity", "unit", "aisle"})
+	for _, aisle := range list.Aisles {
+		for _, item := range aisle.Items {
+			amount, unit := splitQuantity(item.Quantity)
+			w.Write([]string{item.Item, amount, unit, aisle.Aisle})
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// shoppingListExportHandler serves a generated shopping list as CSV or TSV,
+// picked by the extension on the id
+func shoppingListExportHandler(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	ext := path.Ext(file)
+	format, ok := shoppingListFormats[ext]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found", "Shopping lists can be exported as .csv or .tsv")
+		return
+	}
+
+	list, ok := recentShoppingLists.get(strings.TrimSuffix(file, ext))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Shopping List Not Found", "The shopping list does not exist or has expired")
+		return
+	}
+
+	if notModified(w, r, contentETag(list, ext)) {
+		return
+	}
+
+	data, err := shoppingListDelimited(list, format.comma)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Export Failed", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="shopping-list-%s%s"`, list.ID, ext))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Store aisles used to group shopping list items, in walking order
+var shoppingAisles = []string{"produce", "meat & seafood", "dairy", "bakery", "frozen", "pantry", "spices", "beverages", "other"}
+
+// Define input schema for shopping list requests
+type ShoppingListInput struct {
+	Recipes []FoodRecipe `json:"recipes" jsonschema:"description=Recipes to shop for,required=true"`
+}
+
+// Items grouped under a single store aisle
+type ShoppingAisle struct {
+	Aisle string             `json:"aisle"`
+	Items []ShoppingListItem `json:"items"`
+}
+
+// Define output schema for shopping list response
+type ShoppingList struct {
+	ID      string          `json:"id,omitempty" jsonschema:"-"`
+	Recipes []string        `json:"recipes"`
+	Aisles  []ShoppingAisle `json:"aisles"`
+}
+
+// The model output before items are grouped by aisle
+type shoppingListItems struct {
+	Items []ShoppingListItem `json:"items"`
+}
+
+// Define the shopping list flow
+func defineShoppingListFlow(g *genkit.Genkit) *core.Flow[*ShoppingListInput, *ShoppingList, struct{}] {
+	return genkit.DefineFlow(g, "shoppingListFlow", func(ctx context.Context, input *ShoppingListInput) (*ShoppingList, error) {
+		if len(input.Recipes) == 0 {
+			return nil, newInputError("at least one recipe is required")
+		}
+
+		var names []string
+		var sb strings.Builder
+		for _, recipe := range input.Recipes {
+			if len(recipe.Ingredients) == 0 {
+				return nil, newInputError("recipe %q has no ingredients", recipe.Name)
+			}
+			names = append(names, recipe.Name)
+			fmt.Fprintf(&sb, "\n\t\t%s (serves %d):\n", recipe.Name, recipe.Servings)
+			for _, ingredient := range recipe.Ingredients {
+				fmt.Fprintf(&sb, "\t\t- %s\n", ingredient)
+			}
+		}
+
+		prompt := fmt.Sprintf(`Convert the ingredients of the following recipes into one consolidated shopping list:
+		%s
+		Please provide:
+		1. One entry per distinct ingredient, merging quantities of duplicate ingredients across recipes
+		2. Quantities in common shopping units (convert units when merging)
+		3. The store aisle for each item, one of: %s
+
+		Leave out water.`,
+			sb.String(), strings.Join(shoppingAisles, ", "))
+
+		result, _, err := genkit.GenerateData[shoppingListItems](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate shopping list: %w", err)
+		}
+
+		list := &ShoppingList{
+			Recipes: names,
+			Aisles:  groupByAisle(result.Items),
+		}
+		list.ID = recentShoppingLists.save(list)
+		return list, nil
+	})
+}
+
+// groupByAisle groups items by aisle in store order, with unknown aisles under "other"
+func groupByAisle(items []ShoppingListItem) []ShoppingAisle {
+	order := make(map[string]int, len(shoppingAisles))
+	for i, aisle := range shoppingAisles {
+		order[aisle] = i
+	}
+
+	grouped := make(map[string][]ShoppingListItem)
+	for _, item := range items {
+		aisle := strings.ToLower(strings.TrimSpace(item.Aisle))
+		if _, ok := order[aisle]; !ok {
+			aisle = "other"
+		}
+		item.Aisle = aisle
+		grouped[aisle] = append(grouped[aisle], item)
+	}
+
+	aisles := make([]ShoppingAisle, 0, len(grouped))
+	for aisle, items := range grouped {
+		sort.Slice(items, func(i, j int) bool {
+			return strings.ToLower(items[i].Item) < strings.ToLower(items[j].Item)
+		})
+		aisles = append(aisles, ShoppingAisle{Aisle: aisle, Items: items})
+	}
+	sort.Slice(aisles, func(i, j int) bool {
+		return order[aisles[i].Aisle] < order[aisles[j].Aisle]
+	})
+	return aisles
+}
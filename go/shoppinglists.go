@@ -0,0 +1,788 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Bounds on saved shopping lists
+const (
+	maxShoppingListName    = 100
+	maxShoppingLists       = 100
+	maxShoppingListItems   = 500
+	maxShoppingListSources = 20
+
+	// Times a change is reapplied when another request changed the list
+	// first
+	shoppingListAttempts = 5
+)
+
+// Counted units an ingredient is bought in. Amounts in the same one add
+// up; they don't convert into anything else
+var countUnits = []string{"bunch", "can", "clove", "dash", "handful", "head", "jar", "package", "piece", "pinch", "slice", "sprig", "stalk", "stick"}
+
+// Recipes and meal plans to add to a shopping list
+type ShoppingListAdditions struct {
+	RecipeIDs   []string `json:"recipeIds,omitempty" jsonschema:"description=Ids of stored recipes to shop for"`
+	MealPlanIDs []string `json:"mealPlanIds,omitempty" jsonschema:"description=Ids of generated meal plans whose shopping list to add"`
+}
+
+// validate checks that something is added, and not too much at once
+func (in *ShoppingListAdditions) validate() []FieldError {
+	var errs []FieldError
+	switch n := len(in.RecipeIDs) + len(in.MealPlanIDs); {
+	case n == 0:
+		errs = append(errs, FieldError{"recipeIds", "give at least one recipe or meal plan"})
+	case n > maxShoppingListSources:
+		errs = append(errs, FieldError{"recipeIds", fmt.Sprintf("at most %d recipes and meal plans can be added at once", maxShoppingListSources)})
+	}
+	return errs
+}
+
+// Body of POST /api/shopping-lists
+type NewShoppingList struct {
+	Name string `json:"name,omitempty" jsonschema:"description=Name of the list (e.g. Weekend groceries)"`
+	ShoppingListAdditions
+}
+
+// validate checks the name and what the list starts with
+func (in *NewShoppingList) validate() []FieldError {
+	errs := validateShoppingListName(in.Name, false)
+	return append(errs, in.ShoppingListAdditions.validate()...)
+}
+
+// Body of PATCH /api/shopping-lists/{id}
+type ShoppingListRename struct {
+	Name string `json:"name" jsonschema:"description=New name of the list,required=true"`
+}
+
+// validate checks the new name
+func (in *ShoppingListRename) validate() []FieldError {
+	return validateShoppingListName(in.Name, true)
+}
+
+// validateShoppingListName checks the length of a list's name, and that
+// there is one when required
+func validateShoppingListName(name string, required bool) []FieldError {
+	switch name = strings.TrimSpace(name); {
+	case name == "" && required:
+		return []FieldError{{"name", "is required"}}
+	case utf8.RuneCountInString(name) > maxShoppingListName:
+		return []FieldError{{"name", fmt.Sprintf("must be at most %d characters", maxShoppingListName)}}
+	}
+	return nil
+}
+
+// A recipe or meal plan added to a shopping list
+type ShoppingListSource struct {
+	Type string `json:"type" jsonschema:"enum=recipe,enum=mealPlan"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// An item to buy. Items are merged across recipes: the same ingredient in
+// units of the same kind is added up, in the largest unit it was given in
+type ShoppingListEntry struct {
+	ID       string   `json:"id"`
+	Item     string   `json:"item"`
+	Amount   float64  `json:"amount,omitempty"`
+	Unit     string   `json:"unit,omitempty"`
+	Quantity string   `json:"quantity,omitempty"`
+	Aisle    string   `json:"aisle,omitempty"`
+	Checked  bool     `json:"checked"`
+	From     []string `json:"from"`
+}
+
+// A shopping list an account keeps, with its items in aisle order
+type SavedShoppingList struct {
+	ID        string               `json:"id"`
+	Name      string               `json:"name"`
+	Sources   []ShoppingListSource `json:"sources"`
+	Items     []ShoppingListEntry  `json:"items"`
+	Version   int                  `json:"version" jsonschema:"description=Incremented on every change; the ETag of the list"`
+	CreatedAt time.Time            `json:"createdAt"`
+	UpdatedAt time.Time            `json:"updatedAt"`
+}
+
+// An account's shopping lists, most recently updated first
+type SavedShoppingLists struct {
+	Lists []SavedShoppingList `json:"lists"`
+}
+
+// An ingredient line or meal plan item read for merging
+type shoppingIngredient struct {
+	name   string
+	amount float64
+	// Canonical name of a unit in units or countUnits, or "" for a plain
+	// count
+	unit string
+	// Whether the quantity has an amount; "salt to taste" doesn't
+	measured bool
+	// The quantity as written, kept when there is no amount
+	text  string
+	aisle string
+}
+
+// parseShoppingQuantity reads a quantity such as "1 1/2 cups" into its
+// amount and unit, returning the rest of the text. Of a range it takes the
+// upper end, which is what to buy
+func parseShoppingQuantity(text string) (amount float64, unitName, rest string, ok bool) {
+	text = strings.TrimSpace(text)
+	m := ingredientAmountPattern.FindStringSubmatchIndex(text)
+	if m == nil {
+		return 0, "", text, false
+	}
+	if amount, ok = parseAmount(text[m[2]:m[3]]); !ok {
+		return 0, "", text, false
+	}
+	if m[4] >= 0 {
+		if high, err := strconv.ParseFloat(text[m[4]:m[5]], 64); err == nil {
+			amount = high
+		}
+	}
+	rest = strings.TrimSpace(text[m[1]:])
+
+	// Two-word units such as "fl oz" before one-word ones
+	fields := strings.Fields(rest)
+	for n := min(2, len(fields)); n > 0; n-- {
+		word := strings.Join(fields[:n], " ")
+		if u, found := parseUnit(word); found && u.kind != unitTemp {
+			return amount, u.name, trimOf(strings.Join(fields[n:], " ")), true
+		}
+		if name, found := parseCountUnit(word); found {
+			return amount, name, trimOf(strings.Join(fields[n:], " ")), true
+		}
+	}
+	return amount, "", rest, true
+}
+
+// parseCountUnit looks up a counted unit, ignoring case, plurals and dots
+func parseCountUnit(word string) (string, bool) {
+	word = strings.TrimSuffix(strings.ToLower(word), ".")
+	for _, name := range countUnits {
+		if word == name || word == name+"s" || word == name+"es" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// trimOf drops the "of" in "2 cups of flour"
+func trimOf(name string) string {
+	if rest, ok := strings.CutPrefix(name, "of "); ok {
+		return rest
+	}
+	return name
+}
+
+// parseIngredientLine reads a recipe's ingredient line such as "2 cups
+// flour, sifted" for merging
+func parseIngredientLine(line string) shoppingIngredient {
+	amount, unitName, rest, ok := parseShoppingQuantity(line)
+	name, _, _ := strings.Cut(rest, ",")
+	// Drop notes such as "(about 2 lemons)"
+	if open := strings.Index(name, "("); open >= 0 {
+		if end := strings.Index(name[open:], ")"); end >= 0 {
+			name = name[:open] + name[open+end+1:]
+		}
+	}
+	ingredient := shoppingIngredient{name: strings.Join(strings.Fields(name), " "), amount: amount, unit: unitName, measured: ok}
+	if plain, found := strings.CutSuffix(ingredient.name, " to taste"); found && !ok {
+		ingredient.name, ingredient.text = plain, "to taste"
+	}
+	if ingredient.name == "" {
+		ingredient.name = strings.TrimSpace(line)
+	}
+	return ingredient
+}
+
+// parseShoppingListItem reads an item of a meal plan's shopping list
+func parseShoppingListItem(item ShoppingListItem) shoppingIngredient {
+	amount, unitName, rest, ok := parseShoppingQuantity(item.Quantity)
+	ingredient := shoppingIngredient{name: strings.TrimSpace(item.Item), amount: amount, unit: unitName, measured: ok, aisle: item.Aisle}
+	switch {
+	case !ok:
+		ingredient.text = rest
+	case unitName == "" && rest != "":
+		// An amount of something the unit list doesn't know, such as
+		// "2 cartons", counts as that
+		ingredient.unit = strings.ToLower(rest)
+	}
+	return ingredient
+}
+
+// ingredientKey is the name items are merged by: normalized, with a plural
+// last word made singular
+func ingredientKey(name string) string {
+	key := strings.Join(strings.Fields(normalizeFoodText(name)), " ")
+	switch {
+	case len(key) <= 3, strings.HasSuffix(key, "ss"):
+		return key
+	case strings.HasSuffix(key, "oes"):
+		return strings.TrimSuffix(key, "es")
+	}
+	return strings.TrimSuffix(key, "s")
+}
+
+// unitKind is what amounts in a unit add up with: volume, mass or the
+// counted unit itself
+func unitKind(name string) string {
+	if u, ok := parseUnit(name); ok && u.kind != unitTemp && name != "" {
+		return u.kind
+	}
+	return "count:" + name
+}
+
+// unitFactor is the size of a unit in milliliters or grams, or 1 for
+// counted units
+func unitFactor(name string) float64 {
+	if u, ok := parseUnit(name); ok && name != "" {
+		return u.factor
+	}
+	return 1
+}
+
+// formatShoppingQuantity writes an amount and unit such as "1 1/2 cups"
+func formatShoppingQuantity(amount float64, unitName string) string {
+	text := formatAmount(amount)
+	switch {
+	case unitName == "":
+		return text
+	case amount > 1 && (unitName == "cup" || unitName == "pint" || unitName == "quart" || unitName == "gallon" || slices.Contains(countUnits, unitName)):
+		if strings.HasSuffix(unitName, "ch") {
+			return text + " " + unitName + "es"
+		}
+		return text + " " + unitName + "s"
+	}
+	return text + " " + unitName
+}
+
+// add merges an ingredient into the list, from the named recipe or meal
+// plan. More of an item that was checked off unchecks it, since the extra
+// still has to be bought
+func (l *SavedShoppingList) add(ingredient shoppingIngredient, from string) {
+	key := ingredientKey(ingredient.name)
+	named := func(entry ShoppingListEntry) bool { return ingredientKey(entry.Item) == key }
+	i := slices.IndexFunc(l.Items, named)
+	switch {
+	case i < 0:
+	case !ingredient.measured:
+		// Nothing to add to an item already on the list
+	default:
+		if same := slices.IndexFunc(l.Items, func(entry ShoppingListEntry) bool {
+			return named(entry) && entry.Amount > 0 && unitKind(entry.Unit) == unitKind(ingredient.unit)
+		}); same >= 0 {
+			entry := &l.Items[same]
+			total := entry.Amount*unitFactor(entry.Unit) + ingredient.amount*unitFactor(ingredient.unit)
+			if unitFactor(ingredient.unit) > unitFactor(entry.Unit) {
+				entry.Unit = ingredient.unit
+			}
+			entry.Amount = round(total/unitFactor(entry.Unit), 3)
+			i = same
+		} else if i = slices.IndexFunc(l.Items, func(entry ShoppingListEntry) bool { return named(entry) && entry.Amount == 0 }); i >= 0 {
+			// A measured amount replaces "to taste"
+			l.Items[i].Amount, l.Items[i].Unit = round(ingredient.amount, 3), ingredient.unit
+		}
+		if i >= 0 {
+			l.Items[i].Quantity = formatShoppingQuantity(l.Items[i].Amount, l.Items[i].Unit)
+			l.Items[i].Checked = false
+		}
+	}
+	if i >= 0 {
+		entry := &l.Items[i]
+		entry.Aisle = cmp.Or(entry.Aisle, ingredient.aisle)
+		if !slices.Contains(entry.From, from) {
+			entry.From = append(entry.From, from)
+		}
+		return
+	}
+
+	entry := ShoppingListEntry{ID: newID(), Item: ingredient.name, Aisle: ingredient.aisle, From: []string{from}}
+	if ingredient.measured {
+		entry.Amount, entry.Unit = round(ingredient.amount, 3), ingredient.unit
+		entry.Quantity = formatShoppingQuantity(entry.Amount, entry.Unit)
+	} else {
+		entry.Quantity = ingredient.text
+	}
+	l.Items = append(l.Items, entry)
+}
+
+// sortItems orders the items by aisle in walking order, items without an
+// aisle last, then by name
+func (l *SavedShoppingList) sortItems() {
+	order := func(aisle string) int {
+		if i := slices.Index(shoppingAisles, strings.ToLower(aisle)); i >= 0 {
+			return i
+		}
+		return len(shoppingAisles)
+	}
+	slices.SortStableFunc(l.Items, func(a, b ShoppingListEntry) int {
+		return cmp.Or(cmp.Compare(order(a.Aisle), order(b.Aisle)), strings.Compare(strings.ToLower(a.Item), strings.ToLower(b.Item)))
+	})
+}
+
+// shoppingListStore keeps each account's shopping lists
+type shoppingListStore interface {
+	// list returns an account's lists, most recently updated first
+	list(account string) []SavedShoppingList
+	// get returns one of an account's lists
+	get(account, id string) (*SavedShoppingList, bool)
+	// put adds a list to an account's lists, or replaces the one with the
+	// list's id if it is still at the list's version, and increments the
+	// version. It reports false when there is no list to replace, and
+	// errShoppingListChanged when it has been changed since it was read
+	put(ctx context.Context, account string, list *SavedShoppingList) (bool, error)
+	// delete removes one of an account's lists, reporting whether there
+	// was one
+	delete(ctx context.Context, account, id string) (bool, error)
+}
+
+// Returned when a shopping list was changed by another request after it
+// was read
+var errShoppingListChanged = errors.New("the shopping list was changed by another request")
+
+// Shopping lists of every account. Set at startup to keep them in the
+// recipe database when recipes are kept in SQL
+var shoppingLists shoppingListStore = newMemoryShoppingListStore()
+
+// newShoppingListStore keeps shopping lists alongside the recipes: in their
+// database for the SQL stores, in memory otherwise
+func newShoppingListStore(recipes recipeStore) shoppingListStore {
+	if s, ok := recipes.(*sqlRecipeStore); ok {
+		return &sqlShoppingListStore{db: s.db}
+	}
+	return newMemoryShoppingListStore()
+}
+
+// compareShoppingLists orders lists most recently updated first
+func compareShoppingLists(a, b SavedShoppingList) int {
+	return cmp.Or(b.UpdatedAt.Compare(a.UpdatedAt), strings.Compare(b.ID, a.ID))
+}
+
+// memoryShoppingListStore keeps shopping lists in memory
+type memoryShoppingListStore struct {
+	mu sync.Mutex
+	// Lists by account and id
+	lists map[string]map[string]SavedShoppingList
+}
+
+// newMemoryShoppingListStore creates an empty in-memory shopping list store
+func newMemoryShoppingListStore() *memoryShoppingListStore {
+	return &memoryShoppingListStore{lists: make(map[string]map[string]SavedShoppingList)}
+}
+
+func (s *memoryShoppingListStore) list(account string) []SavedShoppingList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lists := []SavedShoppingList{}
+	for _, list := range s.lists[account] {
+		lists = append(lists, list)
+	}
+	slices.SortFunc(lists, compareShoppingLists)
+	return lists
+}
+
+func (s *memoryShoppingListStore) get(account, id string) (*SavedShoppingList, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, ok := s.lists[account][id]
+	if !ok {
+		return nil, false
+	}
+	return &list, true
+}
+
+func (s *memoryShoppingListStore) put(ctx context.Context, account string, list *SavedShoppingList) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if list.ID == "" {
+		list.ID, list.CreatedAt = newID(), list.UpdatedAt
+		if s.lists[account] == nil {
+			s.lists[account] = make(map[string]SavedShoppingList)
+		}
+	} else if old, ok := s.lists[account][list.ID]; !ok {
+		return false, nil
+	} else if old.Version != list.Version {
+		return true, errShoppingListChanged
+	} else {
+		list.CreatedAt = old.CreatedAt
+	}
+	list.Version++
+	// Keep a copy the caller can't change
+	stored := *list
+	stored.Sources, stored.Items = slices.Clone(list.Sources), slices.Clone(list.Items)
+	s.lists[account][list.ID] = stored
+	return true, nil
+}
+
+func (s *memoryShoppingListStore) delete(ctx context.Context, account, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.lists[account][id]
+	delete(s.lists[account], id)
+	return ok, nil
+}
+
+// sqlShoppingListStore keeps shopping lists in the shopping_lists table of
+// the recipe database, with the sources and items as JSON
+type sqlShoppingListStore struct {
+	db *sql.DB
+}
+
+// The JSON kept in shopping_lists.list
+type shoppingListContents struct {
+	Sources []ShoppingListSource `json:"sources"`
+	Items   []ShoppingListEntry  `json:"items"`
+}
+
+// Columns scanShoppingList reads
+const shoppingListColumns = `id, name, list, version, created_at, updated_at`
+
+func (s *sqlShoppingListStore) list(account string) []SavedShoppingList {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	lists := []SavedShoppingList{}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+shoppingListColumns+` FROM shopping_lists WHERE account = $1 ORDER BY updated_at DESC, id DESC`, account)
+	if err != nil {
+		log.Printf("Failed to list the shopping lists of %s: %v", account, err)
+		return lists
+	}
+	defer rows.Close()
+	for rows.Next() {
+		list, err := scanShoppingList(rows)
+		if err != nil {
+			log.Printf("Failed to read a shopping list: %v", err)
+			continue
+		}
+		lists = append(lists, list)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list the shopping lists of %s: %v", account, err)
+	}
+	return lists
+}
+
+func (s *sqlShoppingListStore) get(account, id string) (*SavedShoppingList, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	list, err := scanShoppingList(s.db.QueryRowContext(ctx,
+		`SELECT `+shoppingListColumns+` FROM shopping_lists WHERE account = $1 AND id = $2`, account, id))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to read shopping list %s: %v", id, err)
+		}
+		return nil, false
+	}
+	return &list, true
+}
+
+func (s *sqlShoppingListStore) put(ctx context.Context, account string, list *SavedShoppingList) (bool, error) {
+	data, err := json.Marshal(shoppingListContents{Sources: list.Sources, Items: list.Items})
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	if list.ID == "" {
+		list.ID, list.CreatedAt, list.Version = newID(), list.UpdatedAt, 1
+		_, err := s.db.ExecContext(ctx,
+			`INSERT INTO shopping_lists (id, account, name, list, version, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+			list.ID, account, list.Name, string(data), list.Version, list.UpdatedAt.UTC())
+		if err != nil {
+			return false, fmt.Errorf("adding shopping list %s: %w", list.Name, err)
+		}
+		return true, nil
+	}
+
+	// Only the version that was read is replaced, so a change made in
+	// between isn't lost
+	err = s.db.QueryRowContext(ctx,
+		`UPDATE shopping_lists SET name = $1, list = $2, version = version + 1, updated_at = $3
+		WHERE account = $4 AND id = $5 AND version = $6 RETURNING created_at, version`,
+		list.Name, string(data), list.UpdatedAt.UTC(), account, list.ID, list.Version).Scan(&list.CreatedAt, &list.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = s.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM shopping_lists WHERE account = $1 AND id = $2)`, account, list.ID).Scan(&exists)
+		if err == nil && exists {
+			err = errShoppingListChanged
+		}
+		return exists, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("updating shopping list %s: %w", list.ID, err)
+	}
+	return true, nil
+}
+
+func (s *sqlShoppingListStore) delete(ctx context.Context, account, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM shopping_lists WHERE account = $1 AND id = $2`, account, id)
+	if err != nil {
+		return false, fmt.Errorf("deleting shopping list %s: %w", id, err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// scanShoppingList reads a row of shoppingListColumns
+func scanShoppingList(row interface{ Scan(...any) error }) (SavedShoppingList, error) {
+	var list SavedShoppingList
+	var data []byte
+	if err := row.Scan(&list.ID, &list.Name, &data, &list.Version, &list.CreatedAt, &list.UpdatedAt); err != nil {
+		return list, err
+	}
+	var contents shoppingListContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return list, err
+	}
+	list.Sources, list.Items = contents.Sources, contents.Items
+	return list, nil
+}
+
+// addToShoppingList merges the ingredients of the recipes and meal plans
+// into a list, answering 404 or 409 and returning false when one can't be
+// added
+func addToShoppingList(w http.ResponseWriter, list *SavedShoppingList, additions ShoppingListAdditions) bool {
+	for _, id := range additions.RecipeIDs {
+		recipe, ok := savedRecipes.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", fmt.Sprintf("Recipe %s does not exist or has expired", id))
+			return false
+		}
+		list.Sources = append(list.Sources, ShoppingListSource{Type: "recipe", ID: id, Name: recipe.Name})
+		for _, line := range recipe.Ingredients {
+			list.add(parseIngredientLine(line), recipe.Name)
+		}
+	}
+	for _, id := range additions.MealPlanIDs {
+		plan, ok := recentMealPlans.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Meal Plan Not Found", fmt.Sprintf("Meal plan %s does not exist or has expired", id))
+			return false
+		}
+		name := "Meal plan"
+		if plan.StartDate != "" {
+			name += " from " + plan.StartDate
+		}
+		list.Sources = append(list.Sources, ShoppingListSource{Type: "mealPlan", ID: id, Name: name})
+		for _, item := range plan.ShoppingList {
+			list.add(parseShoppingListItem(item), name)
+		}
+	}
+	if len(list.Items) > maxShoppingListItems {
+		writeError(w, http.StatusConflict, "Shopping List Full", fmt.Sprintf("A shopping list holds at most %d items; start another one", maxShoppingListItems))
+		return false
+	}
+	list.sortItems()
+	return true
+}
+
+// shoppingListETag is the ETag of a version of a list
+func shoppingListETag(list *SavedShoppingList) string {
+	return `"` + strconv.Itoa(list.Version) + `"`
+}
+
+// ifMatchShoppingList checks a request's If-Match against the version of a
+// list, answering 412 when the client changed an older version
+func ifMatchShoppingList(w http.ResponseWriter, r *http.Request, list *SavedShoppingList) bool {
+	ifMatch := r.Header.Get("If-Match")
+	etag := shoppingListETag(list)
+	if ifMatch == "" || ifMatches(ifMatch, etag) {
+		return true
+	}
+	w.Header().Set("ETag", etag)
+	shoppingListChanged(w)
+	return false
+}
+
+// shoppingListChanged answers 412 to a change made to an older version of a
+// list
+func shoppingListChanged(w http.ResponseWriter) {
+	writeError(w, http.StatusPreconditionFailed, "Shopping List Changed",
+		"The shopping list was changed since you read it; fetch it again and retry")
+}
+
+// saveShoppingList stores a list of the caller's and answers with it. It
+// reports errShoppingListChanged without answering, so the change can be
+// made again to the current list
+func saveShoppingList(w http.ResponseWriter, r *http.Request, account string, list *SavedShoppingList, status int) error {
+	list.UpdatedAt = time.Now()
+	found, err := shoppingLists.put(r.Context(), account, list)
+	switch {
+	case errors.Is(err, errShoppingListChanged):
+		return err
+	case err != nil:
+		log.Printf("Failed to save shopping list of %s: %v", account, err)
+		writeError(w, http.StatusInternalServerError, "Save Failed", "The shopping list could not be saved; try again later")
+	case !found:
+		writeError(w, http.StatusNotFound, "Shopping List Not Found", "You have no shopping list with this id")
+	default:
+		if status == http.StatusCreated {
+			w.Header().Set("Location", "/api/shopping-lists/"+list.ID)
+		}
+		w.Header().Set("ETag", shoppingListETag(list))
+		writeJSON(w, status, list)
+	}
+	return nil
+}
+
+// updateShoppingList applies a change to one of the caller's lists and
+// saves it. When another request changed the list in between, the change
+// is made again to the new version, unless the request named the version
+// it meant with If-Match, which then gets 412. change answers and returns
+// false when the change can't be made
+func updateShoppingList(w http.ResponseWriter, r *http.Request, change func(list *SavedShoppingList) bool) {
+	for attempt := 1; ; attempt++ {
+		account, list, ok := callerShoppingList(w, r)
+		if !ok || !ifMatchShoppingList(w, r, list) || !change(list) {
+			return
+		}
+		err := saveShoppingList(w, r, account, list, http.StatusOK)
+		if err == nil {
+			return
+		}
+		if r.Header.Get("If-Match") != "" || attempt == shoppingListAttempts {
+			shoppingListChanged(w)
+			return
+		}
+	}
+}
+
+// callerShoppingList returns the caller's account and the list in the
+// path, answering 401 or 404 when there is none
+func callerShoppingList(w http.ResponseWriter, r *http.Request) (string, *SavedShoppingList, bool) {
+	account, ok := requireAccount(w, r, "keep shopping lists")
+	if !ok {
+		return "", nil, false
+	}
+	list, ok := shoppingLists.get(account, r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Shopping List Not Found", "You have no shopping list with this id")
+		return "", nil, false
+	}
+	return account, list, true
+}
+
+// listShoppingListsHandler lists the caller's shopping lists, most recently
+// updated first
+func listShoppingListsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep shopping lists")
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, SavedShoppingLists{Lists: shoppingLists.list(account)})
+}
+
+// createShoppingListHandler starts a shopping list from recipes and meal
+// plans
+func createShoppingListHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep shopping lists")
+	if !ok {
+		return
+	}
+	var input NewShoppingList
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	if len(shoppingLists.list(account)) >= maxShoppingLists {
+		writeError(w, http.StatusConflict, "Too Many Shopping Lists", fmt.Sprintf("You can keep at most %d shopping lists; delete some first", maxShoppingLists))
+		return
+	}
+
+	list := SavedShoppingList{
+		Name:    cmp.Or(strings.TrimSpace(input.Name), "Shopping list"),
+		Sources: []ShoppingListSource{},
+		Items:   []ShoppingListEntry{},
+	}
+	if !addToShoppingList(w, &list, input.ShoppingListAdditions) {
+		return
+	}
+	// A new list can't have been changed by anyone else
+	_ = saveShoppingList(w, r, account, &list, http.StatusCreated)
+}
+
+// getShoppingListHandler serves one of the caller's shopping lists
+func getShoppingListHandler(w http.ResponseWriter, r *http.Request) {
+	if _, list, ok := callerShoppingList(w, r); ok {
+		w.Header().Set("ETag", shoppingListETag(list))
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// renameShoppingListHandler renames one of the caller's shopping lists
+func renameShoppingListHandler(w http.ResponseWriter, r *http.Request) {
+	var input ShoppingListRename
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	updateShoppingList(w, r, func(list *SavedShoppingList) bool {
+		list.Name = strings.TrimSpace(input.Name)
+		return true
+	})
+}
+
+// addShoppingListRecipesHandler merges more recipes and meal plans into one
+// of the caller's shopping lists
+func addShoppingListRecipesHandler(w http.ResponseWriter, r *http.Request) {
+	var input ShoppingListAdditions
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	updateShoppingList(w, r, func(list *SavedShoppingList) bool {
+		return addToShoppingList(w, list, input)
+	})
+}
+
+// checkShoppingItemHandler checks an item off one of the caller's shopping
+// lists, or unchecks it when checked is false. Doing either twice is
+// harmless
+func checkShoppingItemHandler(checked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updateShoppingList(w, r, func(list *SavedShoppingList) bool {
+			i := slices.IndexFunc(list.Items, func(entry ShoppingListEntry) bool { return entry.ID == r.PathValue("item") })
+			if i < 0 {
+				writeError(w, http.StatusNotFound, "Item Not Found", "The shopping list has no item with this id")
+				return false
+			}
+			list.Items[i].Checked = checked
+			return true
+		})
+	}
+}
+
+// deleteShoppingListHandler removes one of the caller's shopping lists
+func deleteShoppingListHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := requireAccount(w, r, "keep shopping lists")
+	if !ok {
+		return
+	}
+	deleted, err := shoppingLists.delete(r.Context(), account, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Failed to delete shopping list of %s: %v", account, err)
+		writeError(w, http.StatusInternalServerError, "Delete Failed", "The shopping list could not be deleted; try again later")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "Shopping List Not Found", "You have no shopping list with this id")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
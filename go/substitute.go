@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for ingredient substitution requests
+type SubstitutionInput struct {
+	Ingredient          string `json:"ingredient" jsonschema:"description=Ingredient to replace,required=true"`
+	RecipeName          string `json:"recipeName,omitempty" jsonschema:"description=Recipe the ingredient is used in"`
+	DietaryRestrictions string `json:"dietaryRestrictions,omitempty" jsonschema:"description=Dietary restriction the substitute must satisfy"`
+}
+
+// A single substitution option
+type Substitution struct {
+	Substitute    string `json:"substitute"`
+	Ratio         string `json:"ratio" jsonschema:"description=Amount of substitute per unit of the original (e.g. 3/4 cup per 1 cup)"`
+	Adjustments   string `json:"adjustments,omitempty" jsonschema:"description=Changes to other ingredients\\, timing or technique"`
+	FlavorImpact  string `json:"flavorImpact"`
+	TextureImpact string `json:"textureImpact"`
+	BestFor       string `json:"bestFor,omitempty"`
+}
+
+// Define output schema for substitution response
+type SubstitutionResult struct {
+	Ingredient    string         `json:"ingredient"`
+	Substitutions []Substitution `json:"substitutions"`
+}
+
+// Define the ingredient substitution flow
+func defineSubstitutionFlow(g *genkit.Genkit) *core.Flow[*SubstitutionInput, *SubstitutionResult, struct{}] {
+	return genkit.DefineFlow(g, "substitutionFlow", func(ctx context.Context, input *SubstitutionInput) (*SubstitutionResult, error) {
+		if strings.TrimSpace(input.Ingredient) == "" {
+			return nil, newInputError("ingredient is required")
+		}
+
+		recipeName := input.RecipeName
+		if recipeName == "" {
+			recipeName = "general cooking"
+		}
+
+		dietaryRestrictions := input.DietaryRestrictions
+		if dietaryRestrictions == "" {
+			dietaryRestrictions = "none"
+		}
+
+		prompt := fmt.Sprintf(`Suggest substitutes for "%s" with the following context:
+
+		Recipe: %s
+		Dietary restrictions: %s
+
+		For each substitute provide:
+		1. The replacement ratio relative to the original ingredient
+		2. Any adjustments needed to other ingredients, cooking time or technique
+		3. The expected impact on flavor and on texture
+		4. What kind of dishes it works best in
+
+		Order the substitutes from closest to furthest match and only include substitutes that satisfy the dietary restrictions.`,
+			input.Ingredient, recipeName, dietaryRestrictions)
+
+		result, _, err := genkit.GenerateData[SubstitutionResult](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate substitutions for %s: %w", input.Ingredient, err)
+		}
+
+		if result.Ingredient == "" {
+			result.Ingredient = input.Ingredient
+		}
+
+		return result, nil
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// The tag categories recipes are classified into, in display order
+var tagCategories = []string{"cuisine", "course", "diet", "occasion"}
+
+// The tags each category may hold. The classifier picks from these, so
+// listings filter on a fixed set rather than whatever the model writes
+var tagVocabulary = map[string][]string{
+	"cuisine": {"american", "british", "caribbean", "chinese", "french", "german", "greek", "indian",
+		"italian", "japanese", "korean", "latin american", "mediterranean", "mexican", "middle eastern",
+		"north african", "spanish", "thai", "vietnamese"},
+	"course": {"breakfast", "brunch", "appetizer", "soup", "salad", "main", "side", "dessert", "snack",
+		"drink", "sauce", "bread"},
+	"diet": {"vegetarian", "vegan", "pescatarian", "gluten-free", "dairy-free", "nut-free", "egg-free",
+		"soy-free", "low-carb", "high-protein"},
+	"occasion": {"weeknight", "meal prep", "party", "holiday", "picnic", "barbecue", "date night",
+		"kids", "comfort food", "game day"},
+}
+
+// Diet tags that a detected allergen rules out
+var dietTagAllergens = map[string][]string{
+	"vegan":       {"dairy", "egg", "shellfish"},
+	"vegetarian":  {"shellfish"},
+	"gluten-free": {"gluten"},
+	"dairy-free":  {"dairy"},
+	"nut-free":    {"nuts"},
+	"egg-free":    {"egg"},
+	"soy-free":    {"soy"},
+}
+
+// The categories a recipe was classified into
+type RecipeCategories struct {
+	Cuisine  []string `json:"cuisine,omitempty" jsonschema:"description=The cuisine or cuisines the dish belongs to"`
+	Course   []string `json:"course,omitempty" jsonschema:"description=The courses the dish is served as"`
+	Diet     []string `json:"diet,omitempty" jsonschema:"description=Diets the dish fits as written"`
+	Occasion []string `json:"occasion,omitempty" jsonschema:"description=Occasions the dish suits"`
+}
+
+// tags returns the tags of one category
+func (c *RecipeCategories) tags(category string) []string {
+	if c == nil {
+		return nil
+	}
+	switch category {
+	case "cuisine":
+		return c.Cuisine
+	case "course":
+		return c.Course
+	case "diet":
+		return c.Diet
+	case "occasion":
+		return c.Occasion
+	}
+	return nil
+}
+
+// normalize keeps the tags that are in the vocabulary, in one form, and
+// drops diet tags the recipe's allergens contradict
+func (c *RecipeCategories) normalize(allergens []string) {
+	keep := func(category string, tags []string) []string {
+		var out []string
+		for _, tag := range normalizeTags(tags) {
+			if slices.Contains(tagVocabulary[category], tag) {
+				out = append(out, tag)
+			}
+		}
+		return out
+	}
+	c.Cuisine = keep("cuisine", c.Cuisine)
+	c.Course = keep("course", c.Course)
+	c.Occasion = keep("occasion", c.Occasion)
+	c.Diet = slices.DeleteFunc(keep("diet", c.Diet), func(tag string) bool {
+		for _, a := range dietTagAllergens[tag] {
+			if slices.Contains(allergens, a) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// classifyRecipe asks the model which cuisine, course, diet and occasion
+// tags fit a recipe, keeping only tags from the vocabulary
+func classifyRecipe(ctx context.Context, g *genkit.Genkit, recipe *FoodRecipe) (*RecipeCategories, error) {
+	var vocabulary strings.Builder
+	for _, category := range tagCategories {
+		fmt.Fprintf(&vocabulary, "%s: %s\n", category, strings.Join(tagVocabulary[category], ", "))
+	}
+
+	prompt := fmt.Sprintf(`Classify the following recipe:
+
+		Dish: %s
+		Description: %s
+		Cuisine: %s
+		Ingredients: %s
+
+		Choose tags for each category only from these lists:
+
+		%s
+		Give one cuisine unless the dish is a clear fusion, one or two courses, every
+		diet the recipe fits exactly as written, and up to three occasions. Leave a
+		category empty when nothing fits.`,
+		recipe.Name, recipe.Description, recipe.Cuisine, strings.Join(recipe.Ingredients, ", "), vocabulary.String())
+
+	categories, _, err := genkit.GenerateData[RecipeCategories](ctx, g,
+		ai.WithPrompt(prompt),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to classify %s: %w", recipe.Name, err)
+	}
+	categories.normalize(recipe.Allergens)
+	return categories, nil
+}
+
+// applyCategories classifies a recipe and adds its category tags to its
+// tags, so the tag filter finds them too. Tagging is best effort: a recipe
+// the model can't classify keeps the tags it was generated with
+func applyCategories(ctx context.Context, g *genkit.Genkit, recipe *FoodRecipe) {
+	categories, err := classifyRecipe(ctx, g, recipe)
+	if err != nil {
+		log.Printf("Auto-tagging skipped: %v", err)
+		return
+	}
+	recipe.Categories = categories
+	for _, category := range tagCategories {
+		recipe.Tags = append(recipe.Tags, categories.tags(category)...)
+	}
+	recipe.Tags = normalizeTags(recipe.Tags)
+}
+
+// How many stored recipes carry a tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// The tags of one category with their counts
+type TagCategory struct {
+	Name string     `json:"name"`
+	Tags []TagCount `json:"tags"`
+}
+
+// The tag vocabulary with how many stored recipes carry each tag
+type TagList struct {
+	Categories []TagCategory `json:"categories"`
+}
+
+// tagsHandler lists the tags recipes are classified into, by category,
+// with how many stored recipes carry each
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	counts := map[string]map[string]int{}
+	for _, stored := range savedRecipes.list() {
+		for _, category := range tagCategories {
+			for _, tag := range stored.item.Categories.tags(category) {
+				if counts[category] == nil {
+					counts[category] = map[string]int{}
+				}
+				counts[category][tag]++
+			}
+		}
+	}
+
+	list := TagList{Categories: make([]TagCategory, 0, len(tagCategories))}
+	for _, category := range tagCategories {
+		entry := TagCategory{Name: category, Tags: make([]TagCount, 0, len(tagVocabulary[category]))}
+		for _, tag := range tagVocabulary[category] {
+			entry.Tags = append(entry.Tags, TagCount{Tag: tag, Count: counts[category][tag]})
+		}
+		list.Categories = append(list.Categories, entry)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
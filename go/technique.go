@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Define input schema for cooking technique requests
+type TechniqueInput struct {
+	Technique  string `json:"technique" jsonschema:"description=Name of the cooking technique (e.g. deglazing\\, tempering chocolate),required=true"`
+	SkillLevel string `json:"skillLevel,omitempty" jsonschema:"description=Cook's experience level (beginner\\, intermediate\\, advanced)"`
+}
+
+// A common mistake and how to avoid it
+type TechniqueMistake struct {
+	Mistake string `json:"mistake"`
+	Fix     string `json:"fix"`
+}
+
+// Define output schema for a technique explanation
+type TechniqueExplanation struct {
+	Technique      string             `json:"technique"`
+	Summary        string             `json:"summary"`
+	WhenToUse      string             `json:"whenToUse"`
+	Equipment      []string           `json:"equipment"`
+	Steps          []string           `json:"steps"`
+	CommonMistakes []TechniqueMistake `json:"commonMistakes"`
+	ExampleDishes  []string           `json:"exampleDishes,omitempty"`
+}
+
+// Define the cooking technique explainer flow
+func defineTechniqueFlow(g *genkit.Genkit) *core.Flow[*TechniqueInput, *TechniqueExplanation, struct{}] {
+	return genkit.DefineFlow(g, "techniqueFlow", func(ctx context.Context, input *TechniqueInput) (*TechniqueExplanation, error) {
+		if strings.TrimSpace(input.Technique) == "" {
+			return nil, newInputError("technique is required")
+		}
+
+		skillLevel := input.SkillLevel
+		if skillLevel == "" {
+			skillLevel = "beginner"
+		}
+
+		prompt := fmt.Sprintf(`Explain the cooking technique "%s" to a %s home cook.
+
+		Please provide:
+		1. A short summary of what the technique is and why it works
+		2. When to use it
+		3. The equipment needed
+		4. Clear step-by-step instructions
+		5. Common mistakes and how to fix or avoid each one
+		6. A few example dishes that use the technique`,
+			input.Technique, skillLevel)
+
+		explanation, _, err := genkit.GenerateData[TechniqueExplanation](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to explain technique %s: %w", input.Technique, err)
+		}
+
+		if explanation.Technique == "" {
+			explanation.Technique = input.Technique
+		}
+
+		return explanation, nil
+	})
+}
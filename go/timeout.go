@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Time allowed per request, and overrides keyed by route pattern (e.g.
+// "POST /api/mealplan"), set from the config at startup. 0 means no limit
+var (
+	requestTimeout = 2 * time.Minute
+	routeTimeouts  = map[string]time.Duration{}
+)
+
+// timeoutWriter replaces the error a handler writes after its deadline has
+// passed, usually a failed model call, with a 504
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	timeout time.Duration
+	wrote   bool
+	swallow bool
+}
+
+func (tw *timeoutWriter) expired() bool {
+	return errors.Is(tw.ctx.Err(), context.DeadlineExceeded)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if tw.wrote {
+		return
+	}
+	tw.wrote = true
+	if status >= 500 && tw.expired() {
+		tw.swallow = true
+		tw.writeTimeout()
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wrote {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.swallow {
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming
+func (tw *timeoutWriter) Flush() {
+	if tw.swallow {
+		return
+	}
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeTimeout sends the 504 in place of the handler's response
+func (tw *timeoutWriter) writeTimeout() {
+	tw.ResponseWriter.Header().Del("Content-Length")
+	writeError(tw.ResponseWriter, http.StatusGatewayTimeout, "Request Timeout",
+		fmt.Sprintf("The request did not finish within %s and was cancelled", tw.timeout))
+}
+
+// withTimeouts gives each request a deadline, from its route's override or
+// the default. The deadline cancels the request context and with it the
+// Gemini call; a request that runs out of time gets a 504 ErrorResponse.
+// WebSocket sessions are long-lived and have no deadline
+func withTimeouts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := requestTimeout
+		if t, ok := routeTimeouts[r.Pattern]; ok {
+			timeout = t
+		}
+		if timeout <= 0 || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{ResponseWriter: w, ctx: ctx, timeout: timeout}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		if !tw.wrote && tw.expired() {
+			tw.writeTimeout()
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Name of the tool the model calls for unit conversions
+const unitsToolName = "convertUnits"
+
+// Kinds of unit; only volume and mass convert into each other, through the
+// ingredient's density
+const (
+	unitVolume = "volume"
+	unitMass   = "mass"
+	unitTemp   = "temperature"
+)
+
+// A unit and its size in milliliters or grams
+type unit struct {
+	name   string
+	kind   string
+	factor float64
+}
+
+// Units by the names and abbreviations recipes use for them, in US
+// customary measures
+var units = func() map[string]unit {
+	m := make(map[string]unit)
+	for _, u := range []struct {
+		names  []string
+		kind   string
+		factor float64
+	}{
+		{[]string{"ml", "milliliter", "millilitre"}, unitVolume, 1},
+		{[]string{"l", "liter", "litre"}, unitVolume, 1000},
+		{[]string{"tsp", "teaspoon"}, unitVolume, 4.92892},
+		{[]string{"tbsp", "tbs", "tablespoon"}, unitVolume, 14.7868},
+		{[]string{"fl oz", "floz", "fluid ounce"}, unitVolume, 29.5735},
+		{[]string{"cup", "c"}, unitVolume, 236.588},
+		{[]string{"pint", "pt"}, unitVolume, 473.176},
+		{[]string{"quart", "qt"}, unitVolume, 946.353},
+		{[]string{"gallon", "gal"}, unitVolume, 3785.41},
+		{[]string{"g", "gram", "gramme"}, unitMass, 1},
+		{[]string{"kg", "kilogram"}, unitMass, 1000},
+		{[]string{"mg", "milligram"}, unitMass, 0.001},
+		{[]string{"oz", "ounce"}, unitMass, 28.3495},
+		{[]string{"lb", "pound"}, unitMass, 453.592},
+		{[]string{"°c", "celsius", "centigrade"}, unitTemp, 0},
+		{[]string{"°f", "fahrenheit"}, unitTemp, 0},
+	} {
+		for _, name := range u.names {
+			m[name] = unit{name: u.names[0], kind: u.kind, factor: u.factor}
+		}
+	}
+	return m
+}()
+
+// parseUnit looks up a unit name, ignoring case, plurals and dots
+func parseUnit(name string) (unit, bool) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	switch name {
+	case "c", "f":
+		// Bare C and F are temperatures more often than cups
+		name = "°" + name
+	}
+	if u, ok := units[name]; ok {
+		return u, true
+	}
+	if u, ok := units[strings.TrimSuffix(name, "s")]; ok {
+		return u, true
+	}
+	if u, ok := units[strings.TrimSuffix(name, "es")]; ok {
+		return u, true
+	}
+	return unit{}, false
+}
+
+// Grams per US cup of common ingredients, for converting between volume and
+// weight. The longest name found in the ingredient wins
+var ingredientDensities = []struct {
+	names     []string
+	gramsPerC float64
+}{
+	{[]string{"flour", "all-purpose flour", "plain flour", "bread flour"}, 125},
+	{[]string{"whole wheat flour"}, 113},
+	{[]string{"cake flour"}, 114},
+	{[]string{"sugar", "granulated sugar", "white sugar", "caster sugar"}, 200},
+	{[]string{"brown sugar"}, 213},
+	{[]string{"powdered sugar", "icing sugar", "confectioners sugar"}, 120},
+	{[]string{"butter"}, 227},
+	{[]string{"water", "stock", "broth"}, 237},
+	{[]string{"milk", "buttermilk"}, 242},
+	{[]string{"cream", "heavy cream", "double cream"}, 238},
+	{[]string{"yogurt", "yoghurt", "sour cream"}, 227},
+	{[]string{"oil", "olive oil", "vegetable oil"}, 218},
+	{[]string{"honey"}, 336},
+	{[]string{"maple syrup"}, 312},
+	{[]string{"rice"}, 195},
+	{[]string{"rolled oats", "oats"}, 89},
+	{[]string{"cocoa", "cocoa powder"}, 85},
+	{[]string{"cornstarch", "corn starch"}, 128},
+	{[]string{"salt", "table salt"}, 292},
+	{[]string{"kosher salt"}, 142},
+	{[]string{"baking powder"}, 192},
+	{[]string{"baking soda"}, 288},
+	{[]string{"chocolate chips"}, 170},
+	{[]string{"almonds", "nuts", "walnuts", "pecans"}, 120},
+	{[]string{"grated parmesan", "parmesan"}, 100},
+	{[]string{"shredded cheese", "cheddar", "mozzarella"}, 113},
+}
+
+// Input of the unit conversion tool
+type UnitConversionInput struct {
+	Amount     float64 `json:"amount"`
+	From       string  `json:"from" jsonschema:"description=Unit to convert from\\, e.g. cup\\, tbsp\\, g\\, oz\\, °F"`
+	To         string  `json:"to" jsonschema:"description=Unit to convert to"`
+	Ingredient string  `json:"ingredient,omitempty" jsonschema:"description=Ingredient being measured; needed between volume and weight (e.g. cups to grams)"`
+}
+
+// Result of a unit conversion. Error explains a conversion that isn't
+// possible, so the model can ask differently
+type UnitConversion struct {
+	Amount float64 `json:"amount"`
+	Unit   string  `json:"unit"`
+	Note   string  `json:"note,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// convertUnits converts an amount between units, using the ingredient's
+// density between volume and weight
+func convertUnits(in UnitConversionInput) UnitConversion {
+	from, ok := parseUnit(in.From)
+	if !ok {
+		return UnitConversion{Error: fmt.Sprintf("unknown unit %q", in.From)}
+	}
+	to, ok := parseUnit(in.To)
+	if !ok {
+		return UnitConversion{Error: fmt.Sprintf("unknown unit %q", in.To)}
+	}
+
+	switch {
+	case from.kind == unitTemp && to.kind == unitTemp:
+		c := in.Amount
+		if from.name == "°f" {
+			c = (in.Amount - 32) * 5 / 9
+		}
+		if to.name == "°f" {
+			return UnitConversion{Amount: round(c*9/5+32, 1), Unit: to.name}
+		}
+		return UnitConversion{Amount: round(c, 1), Unit: to.name}
+
+	case from.kind == to.kind:
+		return UnitConversion{Amount: round(in.Amount*from.factor/to.factor, 2), Unit: to.name}
+
+	case from.kind == unitTemp || to.kind == unitTemp:
+		return UnitConversion{Error: fmt.Sprintf("cannot convert %s to %s", from.kind, to.kind)}
+	}
+
+	// Between volume and mass, through grams per milliliter
+	i, ok := matchIngredient(in.Ingredient, len(ingredientDensities), func(i int) []string { return ingredientDensities[i].names })
+	if !ok {
+		return UnitConversion{Error: fmt.Sprintf("no density known for %q; give the weight directly", in.Ingredient)}
+	}
+	density := ingredientDensities[i].gramsPerC / units["cup"].factor
+	amount := in.Amount * from.factor
+	if from.kind == unitVolume {
+		amount *= density
+	} else {
+		amount /= density
+	}
+	return UnitConversion{
+		Amount: round(amount/to.factor, 2),
+		Unit:   to.name,
+		Note:   fmt.Sprintf("using %g g per cup of %s", ingredientDensities[i].gramsPerC, ingredientDensities[i].names[0]),
+	}
+}
+
+// round rounds v to the given number of decimal places
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+// defineUnitsTool registers the tool the model calls for conversions, so
+// equivalences like cups of flour in grams are computed rather than guessed
+func defineUnitsTool(g *genkit.Genkit) ai.Tool {
+	return genkit.DefineTool(g, unitsToolName,
+		"Converts an amount between cooking units: volume (tsp, tbsp, cup, ml, l, fl oz), weight (g, kg, oz, lb) and oven temperature (°C, °F). Converting between volume and weight needs the ingredient. Use it for every conversion instead of estimating.",
+		func(ctx *ai.ToolContext, input UnitConversionInput) (*UnitConversion, error) {
+			out := convertUnits(input)
+			return &out, nil
+		})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Days of usage kept for the admin usage report
+const usageRetentionDays = 90
+
+// Price of a model's tokens in US dollars per million
+type modelPrice struct {
+	input, output float64
+}
+
+// Published prices of the text models, by name without the provider.
+// Models not listed are counted in tokens only
+var modelPrices = map[string]modelPrice{
+	"gemini-2.0-flash":      {input: 0.10, output: 0.40},
+	"gemini-2.0-flash-lite": {input: 0.075, output: 0.30},
+	"gemini-2.5-flash":      {input: 0.30, output: 2.50},
+	"gemini-2.5-flash-lite": {input: 0.10, output: 0.40},
+	"gemini-2.5-pro":        {input: 1.25, output: 10.00},
+}
+
+// tokenCost estimates what a model call cost in US dollars, or 0 when the
+// model's price isn't known
+func tokenCost(model string, inputTokens, outputTokens int) float64 {
+	_, name, _ := strings.Cut(model, "/")
+	price, ok := modelPrices[name]
+	if !ok {
+		return 0
+	}
+	return (float64(inputTokens)*price.input + float64(outputTokens)*price.output) / 1e6
+}
+
+// Tokens one model used and what they cost
+type ModelUsage struct {
+	Model        string  `json:"model"`
+	Calls        int     `json:"calls"`
+	InputTokens  int     `json:"inputTokens"`
+	OutputTokens int     `json:"outputTokens"`
+	CostUSD      float64 `json:"costUsd" jsonschema:"description=Estimated from published prices; 0 when the model's price isn't known"`
+}
+
+// Tokens used by model calls, in total and by model
+type Usage struct {
+	InputTokens  int          `json:"inputTokens"`
+	OutputTokens int          `json:"outputTokens"`
+	CostUSD      float64      `json:"costUsd"`
+	Models       []ModelUsage `json:"models"`
+}
+
+// usageTally adds up model calls by model
+type usageTally map[string]*ModelUsage
+
+// add counts one model call
+func (t usageTally) add(model string, usage *ai.GenerationUsage) {
+	m, ok := t[model]
+	if !ok {
+		m = &ModelUsage{Model: model}
+		t[model] = m
+	}
+	m.Calls++
+	m.InputTokens += usage.InputTokens
+	m.OutputTokens += usage.OutputTokens
+	m.CostUSD += tokenCost(model, usage.InputTokens, usage.OutputTokens)
+}
+
+// merge adds another tally's calls to this one
+func (t usageTally) merge(other usageTally) {
+	for model, m := range other {
+		sum, ok := t[model]
+		if !ok {
+			sum = &ModelUsage{Model: model}
+			t[model] = sum
+		}
+		sum.Calls += m.Calls
+		sum.InputTokens += m.InputTokens
+		sum.OutputTokens += m.OutputTokens
+		sum.CostUSD += m.CostUSD
+	}
+}
+
+// usage totals the tally, listing models by name
+func (t usageTally) usage() Usage {
+	u := Usage{Models: []ModelUsage{}}
+	for _, model := range slices.Sorted(maps.Keys(t)) {
+		m := *t[model]
+		u.InputTokens += m.InputTokens
+		u.OutputTokens += m.OutputTokens
+		u.CostUSD += m.CostUSD
+		u.Models = append(u.Models, m)
+	}
+	return u
+}
+
+// requestUsage collects the model calls made for one request
+type requestUsage struct {
+	mu    sync.Mutex
+	tally usageTally
+}
+
+// Context key of the request's usage
+type requestUsageContextKey struct{}
+
+// recordUsage counts a model call made for the context's request in the
+// access log, the request's usage and the daily usage of its API key
+func recordUsage(ctx context.Context, model string, usage *ai.GenerationUsage) {
+	accessLogEntry(ctx).addUsage(model, usage)
+	if usage == nil {
+		usage = &ai.GenerationUsage{}
+	}
+	if u, _ := ctx.Value(requestUsageContextKey{}).(*requestUsage); u != nil {
+		u.mu.Lock()
+		u.tally.add(model, usage)
+		u.mu.Unlock()
+	}
+	dailyUsage.add(requestAccount(ctx), model, usage)
+}
+
+// reportUsage tracks the model calls made for each request and, when asked
+// with ?usage=true, adds their tokens and cost to a JSON object response
+// as a "usage" field. Errors and other formats are left alone
+func reportUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := &requestUsage{tally: usageTally{}}
+		r = r.WithContext(context.WithValue(r.Context(), requestUsageContextKey{}, u))
+		if r.URL.Query().Get("usage") != "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fw := &fieldMaskWriter{ResponseWriter: w}
+		next.ServeHTTP(fw, r)
+		if !fw.buffered {
+			return
+		}
+
+		u.mu.Lock()
+		usage := u.tally.usage()
+		u.mu.Unlock()
+		body, err := withUsage(fw.body.Bytes(), usage)
+		if err != nil {
+			log.Printf("Error adding usage to the response: %v", err)
+			body = fw.body.Bytes()
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(fw.status)
+		w.Write(body)
+	})
+}
+
+// withUsage adds a usage field to a JSON object. Other JSON values are
+// returned unchanged
+func withUsage(data []byte, usage Usage) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) < 2 || trimmed[0] != '{' {
+		return data, nil
+	}
+	field, err := json.Marshal(usage)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(trimmed[:len(trimmed)-1])
+	if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"usage":`)
+	buf.Write(field)
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+// One account's usage on one day (UTC)
+type DailyUsage struct {
+	Date    string `json:"date"`
+	Account string `json:"account" jsonschema:"description=API key ID\\, user:<id> for signed-in users\\, or anonymous"`
+	Usage
+}
+
+// Usage over a range of days
+type UsageReport struct {
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Total Usage        `json:"total"`
+	Days  []DailyUsage `json:"days"`
+}
+
+// usageLedger adds up model usage per account per day
+type usageLedger struct {
+	mu   sync.Mutex
+	days map[string]map[string]usageTally
+}
+
+// Usage of the running server, kept in memory for usageRetentionDays
+var dailyUsage = &usageLedger{days: make(map[string]map[string]usageTally)}
+
+// add counts a model call against an account today, dropping days past
+// the retention period
+func (l *usageLedger) add(account, model string, usage *ai.GenerationUsage) {
+	now := time.Now().UTC()
+	today := now.Format(time.DateOnly)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	accounts, ok := l.days[today]
+	if !ok {
+		accounts = make(map[string]usageTally)
+		l.days[today] = accounts
+		oldest := now.AddDate(0, 0, -usageRetentionDays).Format(time.DateOnly)
+		for day := range l.days {
+			if day < oldest {
+				delete(l.days, day)
+			}
+		}
+	}
+	tally, ok := accounts[account]
+	if !ok {
+		tally = usageTally{}
+		accounts[account] = tally
+	}
+	tally.add(model, usage)
+}
+
+// report returns the usage between two dates, inclusive, newest day first,
+// optionally of one account only
+func (l *usageLedger) report(from, to, account string) UsageReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report := UsageReport{From: from, To: to, Days: []DailyUsage{}}
+	total := usageTally{}
+	for day, accounts := range l.days {
+		if day < from || day > to {
+			continue
+		}
+		for name, tally := range accounts {
+			if account != "" && name != account {
+				continue
+			}
+			report.Days = append(report.Days, DailyUsage{Date: day, Account: name, Usage: tally.usage()})
+			total.merge(tally)
+		}
+	}
+	slices.SortFunc(report.Days, func(a, b DailyUsage) int {
+		return cmp.Or(cmp.Compare(b.Date, a.Date), cmp.Compare(a.Account, b.Account))
+	})
+	report.Total = total.usage()
+	return report
+}
+
+// usageHandler reports token usage and cost per API key per day. from and
+// to are dates (YYYY-MM-DD) and default to the last 30 days; key limits the
+// report to one account
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	today := time.Now().UTC()
+	from := cmp.Or(q.Get("from"), today.AddDate(0, 0, -29).Format(time.DateOnly))
+	to := cmp.Or(q.Get("to"), today.Format(time.DateOnly))
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Date", "from and to must be dates like 2025-01-31")
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, dailyUsage.report(from, to, q.Get("key")))
+}
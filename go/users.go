@@ -0,0 +1,286 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A user's profile. Users register by signing in through the OIDC login
+// or by calling the API with a bearer JWT; the preferences fill in recipe
+// requests made while they are authenticated
+type UserProfile struct {
+	ID    string `json:"id"`
+	Email string `json:"email,omitempty"`
+	Name  string `json:"name,omitempty"`
+	UserPreferences
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Body of PUT /api/profile, and the preferences kept with a profile
+type UserPreferences struct {
+	DietaryRestrictions string   `json:"dietaryRestrictions,omitempty" jsonschema:"description=Restrictions added to every recipe request (e.g. vegetarian\\, nut allergy)"`
+	ServingSize         int      `json:"servingSize,omitempty" jsonschema:"description=Servings used when a request doesn't give any"`
+	DislikedIngredients []string `json:"dislikedIngredients,omitempty" jsonschema:"description=Ingredients left out of every recipe"`
+}
+
+// validate checks the preferences against the recipe request bounds
+func (p *UserPreferences) validate() []FieldError {
+	var errs []FieldError
+	if utf8.RuneCountInString(p.DietaryRestrictions) > maxFoodNameLength {
+		errs = append(errs, FieldError{"dietaryRestrictions", fmt.Sprintf("must be at most %d characters", maxFoodNameLength)})
+	}
+	if p.ServingSize < 0 || p.ServingSize > maxServingSize {
+		errs = append(errs, FieldError{"servingSize", fmt.Sprintf("must be between 1 and %d", maxServingSize)})
+	}
+	return append(errs, validateAvoidIngredients("dislikedIngredients", p.DislikedIngredients)...)
+}
+
+// userStore keeps user profiles by user id: the subject of the ID token or
+// JWT the user authenticated with
+type userStore interface {
+	// get returns the profile of a user
+	get(id string) (*UserProfile, bool)
+	// register creates a user's profile if there is none yet and updates
+	// the email and name the provider gave, when it gave them
+	register(ctx context.Context, id, email, name string) (*UserProfile, error)
+	// setPreferences replaces a user's preferences, registering them first
+	// if needed
+	setPreferences(ctx context.Context, id string, prefs UserPreferences) (*UserProfile, error)
+}
+
+// Registered users. Set at startup to keep them in the recipe database
+// when recipes are kept in SQL
+var users userStore = newMemoryUserStore()
+
+// newUserStore keeps users alongside the recipes: in their database for
+// the SQL stores, in memory otherwise
+func newUserStore(recipes recipeStore) userStore {
+	if s, ok := recipes.(*sqlRecipeStore); ok {
+		return &sqlUserStore{db: s.db}
+	}
+	return newMemoryUserStore()
+}
+
+// memoryUserStore keeps profiles in memory
+type memoryUserStore struct {
+	mu       sync.Mutex
+	profiles map[string]UserProfile
+}
+
+// newMemoryUserStore creates an empty in-memory user store
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{profiles: make(map[string]UserProfile)}
+}
+
+func (s *memoryUserStore) get(id string) (*UserProfile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile, ok := s.profiles[id]
+	if !ok {
+		return nil, false
+	}
+	return &profile, true
+}
+
+func (s *memoryUserStore) register(ctx context.Context, id, email, name string) (*UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile := s.load(id)
+	if email != "" || name != "" {
+		profile.Email = cmp.Or(email, profile.Email)
+		profile.Name = cmp.Or(name, profile.Name)
+		profile.UpdatedAt = time.Now()
+	}
+	s.profiles[id] = profile
+	return &profile, nil
+}
+
+func (s *memoryUserStore) setPreferences(ctx context.Context, id string, prefs UserPreferences) (*UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile := s.load(id)
+	profile.UserPreferences = prefs
+	profile.UpdatedAt = time.Now()
+	s.profiles[id] = profile
+	return &profile, nil
+}
+
+// load returns a user's profile, or a new one. The caller holds mu
+func (s *memoryUserStore) load(id string) UserProfile {
+	if profile, ok := s.profiles[id]; ok {
+		return profile
+	}
+	now := time.Now()
+	return UserProfile{ID: id, CreatedAt: now, UpdatedAt: now}
+}
+
+// sqlUserStore keeps profiles in the users table of the recipe database,
+// with the preferences as JSON
+type sqlUserStore struct {
+	db *sql.DB
+}
+
+func (s *sqlUserStore) get(id string) (*UserProfile, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), recipeQueryTimeout)
+	defer cancel()
+	var profile UserProfile
+	var prefs []byte
+	err := s.db.QueryRowContext(ctx, `SELECT id, email, name, preferences, created_at, updated_at FROM users WHERE id = $1`, id).
+		Scan(&profile.ID, &profile.Email, &profile.Name, &prefs, &profile.CreatedAt, &profile.UpdatedAt)
+	if err == nil {
+		err = json.Unmarshal(prefs, &profile.UserPreferences)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to read user %s: %v", id, err)
+		}
+		return nil, false
+	}
+	return &profile, true
+}
+
+func (s *sqlUserStore) register(ctx context.Context, id, email, name string) (*UserProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (id, email, name, preferences, created_at, updated_at) VALUES ($1, $2, $3, '{}', $4, $4)
+		ON CONFLICT (id) DO UPDATE SET
+			email = CASE WHEN excluded.email = '' THEN users.email ELSE excluded.email END,
+			name = CASE WHEN excluded.name = '' THEN users.name ELSE excluded.name END`,
+		id, email, name, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("registering user %s: %w", id, err)
+	}
+	return s.reload(id)
+}
+
+func (s *sqlUserStore) setPreferences(ctx context.Context, id string, prefs UserPreferences) (*UserProfile, error) {
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, recipeQueryTimeout)
+	defer cancel()
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO users (id, email, name, preferences, created_at, updated_at) VALUES ($1, '', '', $2, $3, $3)
+		ON CONFLICT (id) DO UPDATE SET preferences = excluded.preferences, updated_at = excluded.updated_at`,
+		id, string(data), time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("saving the preferences of user %s: %w", id, err)
+	}
+	return s.reload(id)
+}
+
+// reload reads back a profile that was just written
+func (s *sqlUserStore) reload(id string) (*UserProfile, error) {
+	profile, ok := s.get(id)
+	if !ok {
+		return nil, fmt.Errorf("user %s was not saved", id)
+	}
+	return profile, nil
+}
+
+// withProfile fills in a recipe request from the authenticated user's
+// preferences: their dietary restrictions are added to the request's,
+// their serving size is used when it gives none, and their disliked
+// ingredients are left out too. Other callers' requests are unchanged
+func withProfile(ctx context.Context, input *FoodInput) *FoodInput {
+	user, ok := requestUser(ctx)
+	if !ok || input == nil {
+		return input
+	}
+	profile, ok := users.get(user)
+	if !ok {
+		return input
+	}
+
+	applied := *input
+	restrictions := strings.TrimSpace(profile.DietaryRestrictions)
+	switch {
+	case restrictions == "":
+	case strings.TrimSpace(applied.DietaryRestrictions) == "":
+		applied.DietaryRestrictions = restrictions
+	case !strings.Contains(strings.ToLower(applied.DietaryRestrictions), strings.ToLower(restrictions)):
+		applied.DietaryRestrictions += ", " + restrictions
+	}
+	if applied.ServingSize == 0 {
+		applied.ServingSize = profile.ServingSize
+	}
+	// Requests re-run from the history already have them
+	applied.AvoidIngredients = slices.Clone(applied.AvoidIngredients)
+	for _, disliked := range profile.DislikedIngredients {
+		if !slices.ContainsFunc(applied.AvoidIngredients, func(avoid string) bool { return strings.EqualFold(avoid, disliked) }) {
+			applied.AvoidIngredients = append(applied.AvoidIngredients, disliked)
+		}
+	}
+	return &applied
+}
+
+// profileUser returns the user a profile request is for, answering 401 or
+// 403 when it isn't made by a user
+func profileUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user, ok := requestUser(r.Context())
+	if ok {
+		return user, true
+	}
+	if requestAccount(r.Context()) == "anonymous" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "Sign in or use a bearer token to keep a profile")
+	} else {
+		writeError(w, http.StatusForbidden, "Forbidden", "Profiles belong to users; API keys don't have one")
+	}
+	return "", false
+}
+
+// profileHandler returns the caller's profile, registering them on their
+// first visit
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := profileUser(w, r)
+	if !ok {
+		return
+	}
+	profile, ok := users.get(user)
+	if !ok {
+		var err error
+		if profile, err = users.register(r.Context(), user, "", ""); err != nil {
+			log.Printf("Failed to register user %s: %v", user, err)
+			writeError(w, http.StatusInternalServerError, "Registration Failed", "The profile could not be created; try again later")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// updateProfileHandler replaces the caller's preferences
+func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := profileUser(w, r)
+	if !ok {
+		return
+	}
+	var prefs UserPreferences
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+		return
+	}
+	prefs.DietaryRestrictions = strings.TrimSpace(prefs.DietaryRestrictions)
+
+	profile, err := users.setPreferences(r.Context(), user, prefs)
+	if err != nil {
+		log.Printf("Failed to update user %s: %v", user, err)
+		writeError(w, http.StatusInternalServerError, "Update Failed", "The profile could not be saved; try again later")
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
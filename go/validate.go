@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Largest request body accepted by default, set from the config at startup
+var maxBodyBytes int64 = 1 << 20
+
+// Routes that take images or bulk imports, which need more room
+var routeBodyLimits = map[string]int64{
+	"POST /api/recipe/from-image": maxImageBytes * 2,
+	"POST /api/pantry/from-image": maxImageBytes * 2,
+	"POST /recipeFromImageFlow":   maxImageBytes * 2,
+	"POST /pantryFromImageFlow":   maxImageBytes * 2,
+	"POST /api/recipes/import":    maxImportBytes,
+}
+
+// Bounds on recipe requests
+const (
+	maxFoodNameLength = 200
+	maxServingSize    = 100
+
+	maxAvoidIngredients     = 30
+	maxAvoidIngredientChars = 100
+)
+
+// A problem with one field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error response listing every invalid field
+type ValidationErrorResponse struct {
+	ErrorResponse
+	Fields []FieldError `json:"fields"`
+}
+
+// Request bodies that can check their own fields
+type validatable interface {
+	validate() []FieldError
+}
+
+// validate checks the recipe request constraints that don't need the model
+func (in *FoodInput) validate() []FieldError {
+	var errs []FieldError
+	switch name := strings.TrimSpace(in.FoodName); {
+	case name == "":
+		errs = append(errs, FieldError{"foodName", "is required"})
+	case utf8.RuneCountInString(name) > maxFoodNameLength:
+		errs = append(errs, FieldError{"foodName", fmt.Sprintf("must be at most %d characters", maxFoodNameLength)})
+	}
+	if in.ServingSize < 0 || in.ServingSize > maxServingSize {
+		errs = append(errs, FieldError{"servingSize", fmt.Sprintf("must be between 1 and %d", maxServingSize)})
+	}
+	switch strings.ToLower(in.Difficulty) {
+	case "", "easy", "medium", "hard":
+	default:
+		errs = append(errs, FieldError{"difficulty", "must be one of easy, medium or hard"})
+	}
+	if _, err := parseLanguage(in.Language); err != nil {
+		errs = append(errs, FieldError{"language", "must be a BCP 47 language tag such as en-US or fr"})
+	}
+	errs = append(errs, validateAvoidIngredients("avoidIngredients", in.AvoidIngredients)...)
+	if in.MaxBudget < 0 {
+		errs = append(errs, FieldError{"maxBudget", "must be a positive amount"})
+	}
+	if in.Model != "" {
+		if _, ok := resolveModel(in.Model); !ok {
+			errs = append(errs, FieldError{"model", "must be one of " + strings.Join(allowedModels, ", ")})
+		}
+	}
+	if in.Grounded && searchAPIKey == "" {
+		errs = append(errs, FieldError{"grounded", "web search is not configured on this server"})
+	}
+	errs = append(errs, in.GenerationParams.validate()...)
+	if in.CallbackURL != "" {
+		if err := validateCallbackURL(in.CallbackURL); err != nil {
+			errs = append(errs, FieldError{"callbackUrl", "must be an absolute http or https URL"})
+		}
+	}
+	return errs
+}
+
+// validateAvoidIngredients checks a list of ingredients to leave out
+func validateAvoidIngredients(field string, ingredients []string) []FieldError {
+	if len(ingredients) > maxAvoidIngredients {
+		return []FieldError{{field, fmt.Sprintf("must have at most %d ingredients", maxAvoidIngredients)}}
+	}
+	for _, ingredient := range ingredients {
+		if utf8.RuneCountInString(ingredient) > maxAvoidIngredientChars {
+			return []FieldError{{field, fmt.Sprintf("each ingredient must be at most %d characters", maxAvoidIngredientChars)}}
+		}
+	}
+	return nil
+}
+
+// validated decodes the body as T and answers 422 with the invalid fields
+// before next runs, so bad requests never reach the model. The body is
+// restored for next to decode again
+func validated[T any, PT interface {
+	*T
+	validatable
+}](next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		input := PT(new(T))
+		if err := json.Unmarshal(body, input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+		if errs := input.validate(); len(errs) > 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, ValidationErrorResponse{
+				ErrorResponse: ErrorResponse{
+					Error:     "Validation Failed",
+					Message:   fmt.Sprintf("%d field(s) are invalid", len(errs)),
+					RequestID: w.Header().Get(requestIDHeader),
+				},
+				Fields: errs,
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeBodyError reports a body that could not be read
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "Request Too Large", fmt.Sprintf("The request body must be at most %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, http.StatusBadRequest, "Invalid Request", "Could not read the request body")
+}
+
+// limitBodies caps request bodies at the route's limit or the default.
+// Bodies declared too large are refused with 413 before they are read
+func limitBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxBodyBytes
+		if l, ok := routeBodyLimits[r.Pattern]; ok {
+			limit = l
+		}
+		if r.ContentLength > limit {
+			writeBodyError(w, &http.MaxBytesError{Limit: limit})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Cuisines used when the request doesn't name any
+var defaultVariationCuisines = []string{"Thai", "Mexican", "Italian"}
+
+// Define input schema for cuisine variation requests
+type VariationInput struct {
+	Recipe   FoodRecipe `json:"recipe" jsonschema:"description=Base recipe to vary,required=true"`
+	Cuisines []string   `json:"cuisines,omitempty" jsonschema:"description=Cuisines to create variations for (default Thai\\, Mexican\\, Italian)"`
+}
+
+// A replacement of one base ingredient with another
+type IngredientSwap struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// The changes needed to turn the base recipe into a cuisine variant
+type CuisineVariation struct {
+	Cuisine            string           `json:"cuisine"`
+	Name               string           `json:"name"`
+	Summary            string           `json:"summary"`
+	AddedIngredients   []string         `json:"addedIngredients,omitempty"`
+	RemovedIngredients []string         `json:"removedIngredients,omitempty"`
+	SwappedIngredients []IngredientSwap `json:"swappedIngredients,omitempty"`
+	TechniqueChanges   []string         `json:"techniqueChanges,omitempty"`
+}
+
+// Define output schema for cuisine variations
+type RecipeVariations struct {
+	BaseRecipe string             `json:"baseRecipe"`
+	Variations []CuisineVariation `json:"variations"`
+}
+
+// Define the cuisine variation flow
+func defineVariationFlow(g *genkit.Genkit) *core.Flow[*VariationInput, *RecipeVariations, struct{}] {
+	return genkit.DefineFlow(g, "variationFlow", func(ctx context.Context, input *VariationInput) (*RecipeVariations, error) {
+		if strings.TrimSpace(input.Recipe.Name) == "" || len(input.Recipe.Ingredients) == 0 {
+			return nil, newInputError("recipe name and ingredients are required")
+		}
+
+		cuisines := input.Cuisines
+		if len(cuisines) == 0 {
+			cuisines = defaultVariationCuisines
+		}
+
+		prompt := fmt.Sprintf(`Create cuisine variations of the following recipe:
+
+		Recipe: %s
+		Description: %s
+		Ingredients:
+		- %s
+		Instructions:
+		- %s
+
+		Cuisines: %s
+
+		For each cuisine, describe only what changes compared to the base recipe:
+		1. A name and a one-sentence summary of the variant
+		2. Ingredients to add, ingredients to remove, and ingredients to swap (from -> to) with quantities
+		3. Changes to cooking technique or steps
+
+		Do not repeat the unchanged parts of the recipe.`,
+			input.Recipe.Name, input.Recipe.Description,
+			strings.Join(input.Recipe.Ingredients, "\n\t\t- "),
+			strings.Join(input.Recipe.Instructions, "\n\t\t- "),
+			strings.Join(cuisines, ", "))
+
+		result, _, err := genkit.GenerateData[RecipeVariations](ctx, g,
+			ai.WithPrompt(prompt),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate variations for %s: %w", input.Recipe.Name, err)
+		}
+
+		result.BaseRecipe = input.Recipe.Name
+		return result, nil
+	})
+}
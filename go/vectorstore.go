@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+)
+
+// A document in a vector store: its embedding, its text and metadata
+// queries can filter on
+type vectorDoc struct {
+	ID       string
+	Vector   []float32
+	Content  string
+	Metadata map[string]string
+}
+
+// A document found by a similarity query. Score is the cosine similarity
+// to the query, higher is closer
+type vectorMatch struct {
+	ID       string
+	Content  string
+	Metadata map[string]string
+	Score    float64
+}
+
+// vectorStore keeps embedded documents and finds the ones nearest to a
+// vector. The memory store suits a single local server; pgvector and
+// Pinecone keep the index across restarts and replicas
+type vectorStore interface {
+	// upsert adds documents, replacing those with the same IDs
+	upsert(ctx context.Context, docs ...vectorDoc) error
+	// query returns the k documents nearest to vector whose metadata has
+	// every key and value of filter, nearest first
+	query(ctx context.Context, vector []float32, k int, filter map[string]string) ([]vectorMatch, error)
+	// delete removes documents by ID; unknown IDs are ignored
+	delete(ctx context.Context, ids ...string) error
+}
+
+// newVectorStore opens the configured vector store backend
+func newVectorStore(ctx context.Context, backend, url, apiKey string) (vectorStore, error) {
+	switch backend {
+	case "memory":
+		return newMemoryVectorStore(), nil
+	case "pgvector":
+		return newPgvectorStore(ctx, url)
+	case "pinecone":
+		return newPineconeStore(url, apiKey), nil
+	}
+	return nil, fmt.Errorf("unknown vector store %q", backend)
+}
+
+// memoryVectorStore is a vectorStore searched by brute force, fine for the
+// few thousand recipes a single server keeps
+type memoryVectorStore struct {
+	mu   sync.RWMutex
+	docs map[string]vectorDoc
+}
+
+// newMemoryVectorStore creates an empty in-memory vector store
+func newMemoryVectorStore() *memoryVectorStore {
+	return &memoryVectorStore{docs: make(map[string]vectorDoc)}
+}
+
+func (s *memoryVectorStore) upsert(ctx context.Context, docs ...vectorDoc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, doc := range docs {
+		s.docs[doc.ID] = doc
+	}
+	return nil
+}
+
+func (s *memoryVectorStore) query(ctx context.Context, vector []float32, k int, filter map[string]string) ([]vectorMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	matches := []vectorMatch{}
+	for _, doc := range s.docs {
+		if !matchesFilter(doc.Metadata, filter) || len(doc.Vector) != len(vector) {
+			continue
+		}
+		matches = append(matches, vectorMatch{ID: doc.ID, Content: doc.Content, Metadata: doc.Metadata, Score: cosineSimilarity(vector, doc.Vector)})
+	}
+	slices.SortFunc(matches, func(a, b vectorMatch) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.ID, b.ID))
+	})
+	return matches[:min(k, len(matches))], nil
+}
+
+func (s *memoryVectorStore) delete(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.docs, id)
+	}
+	return nil
+}
+
+// matchesFilter reports whether metadata has every key and value of filter
+func matchesFilter(metadata, filter map[string]string) bool {
+	for key, value := range filter {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors of
+// the same length, 0 when either is zero
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
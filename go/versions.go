@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// Recipe payload of API version 1. This is a frozen copy of the recipe as
+// first published; FoodRecipe may evolve, and toRecipeV1 keeps /v1/ responses
+// byte-compatible. Nested types are shared while they are unchanged; copy
+// them here before changing their JSON shape.
+type RecipeV1 struct {
+	ID                  string                `json:"id,omitempty"`
+	Name                string                `json:"name"`
+	Description         string                `json:"description"`
+	Difficulty          string                `json:"difficulty"`
+	PrepTime            string                `json:"prepTime"`
+	CookTime            string                `json:"cookTime"`
+	TotalTime           string                `json:"totalTime"`
+	Servings            int                   `json:"servings"`
+	Ingredients         []string              `json:"ingredients"`
+	Instructions        []string              `json:"instructions"`
+	Tips                []string              `json:"tips,omitempty"`
+	Nutrition           *NutritionFacts       `json:"nutrition,omitempty"`
+	Pairings            *Pairings             `json:"pairings,omitempty"`
+	KidSteps            []KidStep             `json:"kidSteps,omitempty"`
+	EstimatedCost       float64               `json:"estimatedCost,omitempty"`
+	CostPerServing      float64               `json:"costPerServing,omitempty"`
+	Currency            string                `json:"currency,omitempty"`
+	BakingDetails       *BakingDetails        `json:"bakingDetails,omitempty"`
+	Image               *DishImage            `json:"image,omitempty"`
+	Allergens           []string              `json:"allergens"`
+	IngredientAllergens []IngredientAllergens `json:"ingredientAllergens,omitempty"`
+}
+
+// Preparation, cooking and total time of a version 2 recipe
+type RecipeTimeV2 struct {
+	Prep  string `json:"prep"`
+	Cook  string `json:"cook"`
+	Total string `json:"total"`
+}
+
+// An ingredient of a version 2 recipe with its allergens
+type IngredientV2 struct {
+	Text      string   `json:"text"`
+	Allergens []string `json:"allergens"`
+}
+
+// Estimated cost of a version 2 recipe
+type RecipeCostV2 struct {
+	Total      float64 `json:"total"`
+	PerServing float64 `json:"perServing"`
+	Currency   string  `json:"currency"`
+}
+
+// Recipe payload of API version 2, which groups the timing and cost fields
+// and carries allergens on each ingredient
+type RecipeV2 struct {
+	ID            string          `json:"id,omitempty"`
+	Name          string          `json:"name"`
+	Description   string          `json:"description"`
+	Difficulty    string          `json:"difficulty"`
+	Servings      int             `json:"servings"`
+	Time          RecipeTimeV2    `json:"time"`
+	Ingredients   []IngredientV2  `json:"ingredients"`
+	Instructions  []string        `json:"instructions"`
+	Tips          []string        `json:"tips"`
+	Allergens     []string        `json:"allergens"`
+	Nutrition     *NutritionFacts `json:"nutrition,omitempty"`
+	Pairings      *Pairings       `json:"pairings,omitempty"`
+	KidSteps      []KidStep       `json:"kidSteps,omitempty"`
+	Cost          *RecipeCostV2   `json:"cost,omitempty"`
+	BakingDetails *BakingDetails  `json:"bakingDetails,omitempty"`
+	Image         *DishImage      `json:"image,omitempty"`
+}
+
+// toRecipeV1 translates a recipe to the version 1 payload
+func toRecipeV1(r *FoodRecipe) any {
+	return RecipeV1{
+		ID:                  r.ID,
+		Name:                r.Name,
+		Description:         r.Description,
+		Difficulty:          r.Difficulty,
+		PrepTime:            r.PrepTime,
+		CookTime:            r.CookTime,
+		TotalTime:           r.TotalTime,
+		Servings:            r.Servings,
+		Ingredients:         r.Ingredients,
+		Instructions:        r.Instructions,
+		Tips:                r.Tips,
+		Nutrition:           r.Nutrition,
+		Pairings:            r.Pairings,
+		KidSteps:            r.KidSteps,
+		EstimatedCost:       r.EstimatedCost,
+		CostPerServing:      r.CostPerServing,
+		Currency:            r.Currency,
+		BakingDetails:       r.BakingDetails,
+		Image:               r.Image,
+		Allergens:           r.Allergens,
+		IngredientAllergens: r.IngredientAllergens,
+	}
+}
+
+// toRecipeV2 translates a recipe to the version 2 payload
+func toRecipeV2(r *FoodRecipe) any {
+	flagged := make(map[string][]string, len(r.IngredientAllergens))
+	for _, ia := range r.IngredientAllergens {
+		flagged[ia.Ingredient] = ia.Allergens
+	}
+
+	v2 := RecipeV2{
+		ID:            r.ID,
+		Name:          r.Name,
+		Description:   r.Description,
+		Difficulty:    r.Difficulty,
+		Servings:      r.Servings,
+		Time:          RecipeTimeV2{Prep: r.PrepTime, Cook: r.CookTime, Total: r.TotalTime},
+		Ingredients:   make([]IngredientV2, 0, len(r.Ingredients)),
+		Instructions:  r.Instructions,
+		Tips:          r.Tips,
+		Allergens:     r.Allergens,
+		Nutrition:     r.Nutrition,
+		Pairings:      r.Pairings,
+		KidSteps:      r.KidSteps,
+		BakingDetails: r.BakingDetails,
+		Image:         r.Image,
+	}
+	for _, ingredient := range r.Ingredients {
+		allergens := flagged[ingredient]
+		if allergens == nil {
+			allergens = []string{}
+		}
+		v2.Ingredients = append(v2.Ingredients, IngredientV2{Text: ingredient, Allergens: allergens})
+	}
+	if v2.Instructions == nil {
+		v2.Instructions = []string{}
+	}
+	if v2.Tips == nil {
+		v2.Tips = []string{}
+	}
+	if v2.Allergens == nil {
+		v2.Allergens = []string{}
+	}
+	if r.Currency != "" {
+		v2.Cost = &RecipeCostV2{Total: r.EstimatedCost, PerServing: r.CostPerServing, Currency: r.Currency}
+	}
+	return v2
+}
+
+// versionedRecipeHandler generates a recipe and renders it in the schema of
+// one API version
+func versionedRecipeHandler(flow *core.Flow[*FoodInput, *FoodRecipe, struct{}], render func(*FoodRecipe) any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		var input FoodInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON", "Please provide valid JSON input")
+			return
+		}
+
+		// Fall back to the Accept-Language header when no language is given
+		if input.Language == "" {
+			input.Language = acceptLanguage(r.Header.Get("Accept-Language"))
+		}
+
+		recipe, err := flow.Run(r.Context(), &input)
+		if err != nil {
+			log.Printf("Error generating recipe: %v", err)
+			writeFlowError(w, err, "Recipe Generation Failed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, render(recipe))
+	}
+}
+
+// storedVersionedRecipeHandler serves a generated recipe by id in the schema
+// of one API version
+func storedVersionedRecipeHandler(render func(*FoodRecipe) any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recipe, ok := savedRecipes.get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "Recipe Not Found", "The recipe does not exist or has expired")
+			return
+		}
+
+		versioned := render(recipe)
+		if notModified(w, r, contentETag(versioned, "json")) {
+			return
+		}
+		writeJSON(w, http.StatusOK, versioned)
+	}
+}
+
+// versionAlias serves /vN/... paths that have no version-specific handler
+// from the matching /api/... route, since their schema is unchanged
+func versionAlias(version string, mux *http.ServeMux) http.Handler {
+	prefix := "/" + version
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/api" + strings.TrimPrefix(r.URL.Path, prefix)
+		r2.URL.RawPath = ""
+		r2.Pattern = ""
+		mux.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+const (
+	// How long a generation with a callback may run in the background
+	callbackGenerationTimeout = 5 * time.Minute
+
+	// Delivery attempts per callback, and the delay before the first retry
+	// (doubled after each failure)
+	callbackAttempts   = 4
+	callbackRetryDelay = 2 * time.Second
+)
+
+// Secret used to sign callback payloads, set from the config at startup;
+// callbacks are refused without it
+var webhookSecret string
+
+// Client used to deliver callbacks. It only connects to public addresses
+// and doesn't follow redirects, so a callback can't reach services inside
+// the network the server runs in
+var webhookClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// Returned when a callback resolves to an address it may not be sent to
+var errCallbackAddress = errors.New("callbacks to non-public addresses are not allowed")
+
+// isPublicIP reports whether a callback may be delivered to an address
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+// dialPublicOnly refuses connections to non-public addresses. It runs on the
+// resolved address, so a host name can't be pointed at one after the URL
+// was checked
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", errCallbackAddress, host)
+	}
+	return nil
+}
+
+// Inputs that can ask for the result to be delivered to a callback URL
+type callbackInput interface {
+	callback() string
+}
+
+func (in *FoodInput) callback() string     { return in.CallbackURL }
+func (in *MealPlanInput) callback() string { return in.CallbackURL }
+
+// Response to a request whose result will be delivered to its callback
+type WebhookAccepted struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	CallbackURL string `json:"callbackUrl"`
+}
+
+// Body POSTed to a callback URL when generation finishes
+type WebhookPayload struct {
+	ID     string         `json:"id"`
+	Flow   string         `json:"flow"`
+	Status string         `json:"status"`
+	Output any            `json:"output,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+}
+
+// validateCallbackURL checks that a callback is an absolute http(s) URL and
+// not obviously internal. Host names are checked again when delivering
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callbackUrl must be an absolute http or https URL")
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("callbackUrl must point to a public address")
+	}
+	return nil
+}
+
+// acceptWithCallback answers 202 Accepted and runs the flow in the
+// background, POSTing the result to callbackURL when it finishes
+func acceptWithCallback[In, Out any](w http.ResponseWriter, r *http.Request, flow *core.Flow[*In, Out, struct{}], input *In, callbackURL string) {
+	if webhookSecret == "" {
+		writeError(w, http.StatusNotImplemented, "Callbacks Disabled", "Set WEBHOOK_SECRET on the server to enable callbackUrl")
+		return
+	}
+	if err := validateCallbackURL(callbackURL); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid Callback", err.Error())
+		return
+	}
+
+	id := newID()
+
+	// Keep generating after the response has been sent
+	goBackground(r.Context(), callbackGenerationTimeout, func(ctx context.Context) {
+		payload := WebhookPayload{ID: id, Flow: flow.Name(), Status: "completed"}
+		output, err := flow.Run(ctx, input)
+		if err != nil {
+			log.Printf("Error running %s for callback %s: %v", flow.Name(), id, err)
+			payload.Status = "failed"
+			payload.Error = &ErrorResponse{Error: "Generation Failed", Message: err.Error()}
+		} else {
+			payload.Output = output
+		}
+
+		if err := deliverCallback(ctx, callbackURL, payload); err != nil {
+			log.Printf("Error delivering callback %s to %s: %v", id, callbackURL, err)
+		}
+	})
+
+	writeJSON(w, http.StatusAccepted, WebhookAccepted{ID: id, Status: "accepted", CallbackURL: callbackURL})
+}
+
+// signPayload returns the X-Webhook-Signature value for a body sent at the
+// given time: an HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret
+func signPayload(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverCallback POSTs the payload, retrying with backoff on network
+// errors, 429 and 5xx responses
+func deliverCallback(ctx context.Context, callbackURL string, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := callbackRetryDelay
+	for attempt := 1; ; attempt++ {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-ID", payload.ID)
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", signPayload(timestamp, body))
+
+		retry := true
+		resp, err := webhookClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("callback responded %s", resp.Status)
+			retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		} else if errors.Is(err, errCallbackAddress) {
+			retry = false
+		}
+		if !retry || attempt == callbackAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/gorilla/websocket"
+)
+
+// The REST endpoints allow any origin, and so does the WebSocket endpoint
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// A message from a WebSocket client: "generate" starts a recipe with the
+// given input and "cancel" stops the generation with the same requestId
+type wsClientMessage struct {
+	Type      string     `json:"type"`
+	RequestID string     `json:"requestId"`
+	Input     *FoodInput `json:"input,omitempty"`
+}
+
+// A message to a WebSocket client: "partial" carries the recipe as far as
+// the model has written it, "done" the final recipe, and "error" or
+// "cancelled" end a generation early
+type wsServerMessage struct {
+	Type      string      `json:"type"`
+	RequestID string      `json:"requestId,omitempty"`
+	Recipe    *FoodRecipe `json:"recipe,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// wsConn serializes writes to a connection, which gorilla/websocket
+// requires, and tracks the generations running on it
+type wsConn struct {
+	conn *websocket.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// send writes a message to the client
+func (c *wsConn) send(msg wsServerMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+// cancel stops a running generation, reporting whether it existed
+func (c *wsConn) cancel(requestID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cancel, ok := c.running[requestID]
+	if ok {
+		cancel()
+		delete(c.running, requestID)
+	}
+	return ok
+}
+
+// recipeWebSocketHandler upgrades to a WebSocket and streams recipes for
+// each "generate" message until the client cancels or disconnects
+func recipeWebSocketHandler(flow *core.Flow[*FoodInput, *FoodRecipe, *FoodRecipe]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already replied with an error status
+			log.Printf("Error upgrading WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// Wait for generations to notice the cancellation below before closing
+		var wg sync.WaitGroup
+		defer wg.Wait()
+
+		// Generations stop when the connection goes away
+		ctx, cancelAll := context.WithCancel(context.WithoutCancel(r.Context()))
+		defer cancelAll()
+
+		c := &wsConn{conn: conn, running: make(map[string]context.CancelFunc)}
+		language := acceptLanguage(r.Header.Get("Accept-Language"))
+
+		for {
+			var msg wsClientMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					log.Printf("Error reading WebSocket message: %v", err)
+				}
+				return
+			}
+
+			switch msg.Type {
+			case "cancel":
+				if c.cancel(msg.RequestID) {
+					c.send(wsServerMessage{Type: "cancelled", RequestID: msg.RequestID})
+				}
+
+			case "generate":
+				if msg.Input == nil {
+					c.send(wsServerMessage{Type: "error", RequestID: msg.RequestID, Error: "input is required"})
+					continue
+				}
+				if msg.Input.Language == "" {
+					msg.Input.Language = language
+				}
+
+				genCtx, cancel := context.WithCancel(ctx)
+				c.mu.Lock()
+				if _, busy := c.running[msg.RequestID]; busy {
+					c.mu.Unlock()
+					cancel()
+					c.send(wsServerMessage{Type: "error", RequestID: msg.RequestID, Error: "requestId is already in use"})
+					continue
+				}
+				c.running[msg.RequestID] = cancel
+				c.mu.Unlock()
+
+				wg.Add(1)
+				go func(requestID string, input *FoodInput) {
+					defer wg.Done()
+					defer c.cancel(requestID)
+					streamToWebSocket(genCtx, c, flow, requestID, input)
+				}(msg.RequestID, msg.Input)
+
+			default:
+				c.send(wsServerMessage{Type: "error", RequestID: msg.RequestID, Error: "unknown message type " + msg.Type})
+			}
+		}
+	}
+}
+
+// streamToWebSocket runs the streaming recipe flow and forwards its output
+func streamToWebSocket(ctx context.Context, c *wsConn, flow *core.Flow[*FoodInput, *FoodRecipe, *FoodRecipe], requestID string, input *FoodInput) {
+	for value, err := range flow.Stream(ctx, input) {
+		if ctx.Err() != nil {
+			// Cancelled by the client, which has already been told
+			return
+		}
+		if err != nil {
+			log.Printf("Error streaming recipe: %v", err)
+			c.send(wsServerMessage{Type: "error", RequestID: requestID, Error: err.Error()})
+			return
+		}
+		if value.Done {
+			c.send(wsServerMessage{Type: "done", RequestID: requestID, Recipe: value.Output})
+			return
+		}
+		if err := c.send(wsServerMessage{Type: "partial", RequestID: requestID, Recipe: value.Stream}); err != nil {
+			return
+		}
+	}
+}